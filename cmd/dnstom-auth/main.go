@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"dnstom/internal/auth"
+	"dnstom/internal/server"
+)
+
+// dnstom-auth - an authoritative server for a handful of zones.
+//
+//	dnstom-auth -listen 127.0.0.1:5354 -zone example.com=example.com.zone
+//	dig @127.0.0.1 -p 5354 www.example.com
+
+// zoneFlags collects repeated -zone origin=path flags.
+type zoneFlags []string
+
+func (z *zoneFlags) String() string { return strings.Join(*z, ",") }
+
+func (z *zoneFlags) Set(v string) error {
+	if !strings.Contains(v, "=") {
+		return fmt.Errorf("want origin=path, got %q", v)
+	}
+	*z = append(*z, v)
+	return nil
+}
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:5354", "address to serve on, UDP and TCP")
+	var zoneArgs zoneFlags
+	flag.Var(&zoneArgs, "zone", "zone to serve, as origin=path (repeat for more)")
+	flag.Parse()
+
+	if len(zoneArgs) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: dnstom-auth [options] -zone origin=path ...\n\n")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	var zones []*auth.Zone
+	for _, arg := range zoneArgs {
+		origin, path, _ := strings.Cut(arg, "=")
+		z, err := auth.LoadZone(origin, path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		zones = append(zones, z)
+		fmt.Fprintf(os.Stderr, "loaded %s from %s (%d records)\n", z.Origin, path, len(z.Records()))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Fprintf(os.Stderr, "dnstom-auth on %s\n", *listen)
+
+	srv := &server.Server{Handler: auth.New(zones...)}
+	if err := srv.ListenAndServe(ctx, *listen); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -1,54 +1,145 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag" //CLI flags
 	"fmt"  // Strings
 	"log"
 	"os" // Seems like unless it's OS/2 it's probably covered :P
+	"time"
 
+	"dnstom/internal/dnswire"
 	"dnstom/internal/resolver"
 )
 
 func main() {
 	// Print out RFC-style diagrams ?
-	diagram := flag.Bool("diagram", true, "Print RFC-Style diagrams for network packets")
+	diagram := flag.Bool("diagram", true, "Print RFC-Style diagrams of the packets sent and received")
+	hexdump := flag.Bool("hexdump", false, "Print the packets sent and received as hexdumps with every field labelled")
 
-	// We accept a --server flag for future use, even though step 1 ignores it.
-	server := flag.String("server", "system", "DNS server to query")
+	// Which server to send the query to, e.g. 1.1.1.1 or 127.0.0.1:5353.
+	// "system" means whatever /etc/resolv.conf says, search list and all.
+	// "root" means do the whole thing ourselves, starting from the root servers.
+	server := flag.String("server", "system", "DNS server to query, or \"root\" to resolve iteratively")
+	rootHints := flag.String("roothints", "", "root hints file for -server root (default: built in)")
+
+	// Same idea as dig's +time and +tries.
+	timeout := flag.Duration("timeout", 2*time.Second, "How long to wait for each reply")
+	tries := flag.Int("tries", 2, "How many times to try each server")
+	tcp := flag.Bool("tcp", false, "Use TCP only, instead of UDP with TCP fallback")
+
+	// Unlike real dig we look at /etc/hosts first for A/AAAA/PTR, like everything else on the box does.
+	hosts := flag.String("hosts", resolver.HostsPath, "hosts file to check before querying (empty to skip)")
+
+	// For scripts: the reply as RFC 8427 JSON, and nothing else on stdout
+	jsonOut := flag.Bool("json", false, "print the reply as JSON (RFC 8427) instead of dig-style text")
 	flag.Parse()
 
+	// A hexdump is just another way of drawing the diagrams
+	if *hexdump {
+		*diagram = true
+	}
+
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "dnstom-dig - a toy DNS resolver\n")
-		fmt.Fprintf(os.Stderr, "Usage: dnstom-dig [options] <name>\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: dnstom-dig [options] <name> [type]\n\n")
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		flag.PrintDefaults()
 	}
 
-	if flag.NArg() < 1 {
+	if flag.NArg() < 1 || flag.NArg() > 2 {
 		flag.Usage()
 		return
 	}
 
 	name := flag.Arg(0)
 
-	r := resolver.New(*server, *diagram)
+	qtype := dnswire.TypeA
+	if flag.NArg() == 2 {
+		t, ok := dnswire.TypeFromString(flag.Arg(1))
+		if !ok {
+			log.Fatalf("unknown type %q", flag.Arg(1))
+		}
+		qtype = t
+	}
+
+	var r *resolver.Resolver
+
+	if *server == "system" {
+		cfg, err := resolver.LoadResolvConf(resolver.ResolvConfPath)
+		if err != nil {
+			log.Printf("%v - using resolver defaults", err)
+			cfg = resolver.DefaultConfig()
+		}
+		r = resolver.NewFromConfig(cfg, *diagram)
+	} else if *server == "root" {
+		var hints *resolver.RootHints
+		if *rootHints != "" {
+			h, err := resolver.LoadRootHints(*rootHints)
+			if err != nil {
+				log.Fatalf("root hints: %v", err)
+			}
+			hints = h
+		}
+		r = resolver.NewIterative(hints, *diagram)
+	} else {
+		r = resolver.New(*server, *diagram)
+	}
+
+	r.SetHexdump(*hexdump)
+	if *jsonOut {
+		// stdout is the JSON and nothing else
+		r.SetDiagramOutput(os.Stderr)
+	}
+
+	// Only override resolv.conf's timeout/attempts if asked to
+	policy := r.Policy()
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "timeout":
+			policy.Timeout = *timeout
+		case "tries":
+			policy.Attempts = *tries
+		}
+	})
+	policy.TCPOnly = *tcp
+	r.SetPolicy(policy)
 
-	// We're going to be looking up an A record here.
-	// IPv6 might be implemented later, but I don't know how long palliative care will continue
-	// for that dying beast. (Although it keeps SecOps in business)
-	ips, err := r.LookupA(name)
+	if *hosts != "" {
+		r.SetHosts(resolver.NewHosts(*hosts))
+	}
 
+	start := time.Now()
+	reply, info, err := r.Search(context.Background(), name, qtype)
 	if err != nil {
 		log.Fatalf("lookup error: %v", err)
 	}
+	stats := dnswire.Stats{
+		RTT:      time.Since(start),
+		Server:   info.Server,
+		Protocol: info.Protocol,
+		When:     time.Now(),
+		Size:     info.Size,
+	}
+	if info.Hosts != "" && !*jsonOut {
+		fmt.Printf(";; answered from %s\n", info.Hosts)
+	}
 
-	if len(ips) == 0 {
-		fmt.Printf("No IPv4 addresses found for %s\n", name)
+	if *jsonOut {
+		b, err := json.MarshalIndent(reply, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s\n", b)
 		return
 	}
 
-	fmt.Printf("A records for %s:\n", name)
-	for _, ip := range ips {
-		fmt.Printf("  %s\n", ip.String())
+	fmt.Println()
+	if err := dnswire.PrettyPrint(reply, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+	if err := dnswire.PrintStats(os.Stdout, stats); err != nil {
+		log.Fatal(err)
 	}
 }
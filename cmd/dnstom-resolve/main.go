@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"dnstom/internal/resolver"
+	"dnstom/internal/server"
+)
+
+// dnstom-resolve - a caching recursive resolver to point your laptop at.
+//
+//	dnstom-resolve -listen 127.0.0.1:5353
+//	dig @127.0.0.1 -p 5353 www.example.com
+//
+// Without -forward it does the whole job itself from the root servers; with
+// it, it's a caching forwarder. Every query gets logged, since being able to
+// watch what's going on is the point.
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:5353", "address to serve on, UDP and TCP")
+	forward := flag.String("forward", "", "comma separated upstream servers to forward to, instead of resolving from the roots")
+	rootHints := flag.String("roothints", "", "root hints file (default: built in)")
+	allow := flag.String("allow", server.DefaultACL, "comma separated client networks allowed to use us, or \"any\"")
+
+	cacheSize := flag.Int("cache-size", resolver.DefaultCachePolicy().MaxEntries, "most cache entries to keep")
+	stale := flag.Duration("stale", 24*time.Hour, "how long to keep serving expired answers if upstream is down (0 to never)")
+	prefetch := flag.Int("prefetch", 3, "hits that make an entry worth refreshing before it expires (0 to never)")
+
+	timeout := flag.Duration("timeout", 2*time.Second, "How long to wait for each upstream reply")
+	quiet := flag.Bool("quiet", false, "don't log every query")
+	flag.Parse()
+
+	acl, err := server.ParseACL(*allow)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var r *resolver.Resolver
+	if *forward != "" {
+		r = resolver.New("", false)
+		r.SetServers(strings.Split(*forward, ",")...)
+	} else {
+		var hints *resolver.RootHints
+		if *rootHints != "" {
+			if hints, err = resolver.LoadRootHints(*rootHints); err != nil {
+				log.Fatalf("root hints: %v", err)
+			}
+		}
+		r = resolver.NewIterative(hints, false)
+	}
+
+	policy := r.Policy()
+	policy.Timeout = *timeout
+	r.SetPolicy(policy)
+
+	r.SetCache(resolver.NewCache(resolver.CachePolicy{
+		MaxEntries:   *cacheSize,
+		StaleWindow:  *stale,
+		PrefetchHits: *prefetch,
+	}))
+
+	handler := server.NewRecursive(r, acl)
+	if !*quiet {
+		handler.QueryLog = log.New(os.Stderr, "query: ", log.LstdFlags)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	mode := "iterative"
+	if *forward != "" {
+		mode = "forwarding to " + *forward
+	}
+	fmt.Fprintf(os.Stderr, "dnstom-resolve on %s (%s), recursion allowed for %s\n", *listen, mode, acl)
+
+	srv := &server.Server{Handler: handler}
+	if err := srv.ListenAndServe(ctx, *listen); err != nil {
+		log.Fatal(err)
+	}
+}
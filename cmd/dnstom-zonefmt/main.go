@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"dnstom/internal/dnswire"
+	"dnstom/internal/rr"
+	"dnstom/internal/zone"
+)
+
+// dnstom-zonefmt - read a zone file and write it back out in one consistent
+// layout, gofmt style.
+//
+//	dnstom-zonefmt -origin example.com. example.com.zone
+//	dnstom-zonefmt -origin example.com. -w example.com.zone
+//
+// By default names are relative, columns lined up and records in canonical
+// order with the SOA first; the flags turn each of those off. $INCLUDEd
+// files end up inline in the output, and comments don't survive.
+
+func main() {
+	origin := flag.String("origin", ".", "zone origin, for names that aren't absolute")
+	ttl := flag.String("ttl", "", "write a $TTL header with this TTL, and leave it off records that have it")
+	relative := flag.Bool("relative", true, "write names relative to the origin")
+	align := flag.Bool("align", true, "line the columns up")
+	sortRecords := flag.Bool("sort", true, "sort into canonical order")
+	write := flag.Bool("w", false, "write the result back to the file instead of stdout")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: dnstom-zonefmt [options] zonefile\n\n")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	path := flag.Arg(0)
+
+	records, err := zone.ParseFile(path, *origin)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts := zone.WriteOptions{
+		Origin:   *origin,
+		Headers:  true,
+		Relative: *relative,
+		Align:    *align,
+		Sort:     *sortRecords,
+	}
+	if *ttl != "" {
+		if opts.TTL, err = rr.ParseTTL(*ttl); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if !*write {
+		if err := zone.Write(os.Stdout, records, opts); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := writeFile(path, records, opts); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// writeFile replaces the zone file at path. The new one is written next to
+// it and renamed over it, so a failure part way leaves the old one alone.
+func writeFile(path string, records []dnswire.ResourceRecord, opts zone.WriteOptions) error {
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once it's been renamed
+
+	if err := zone.Write(tmp, records, opts); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,309 @@
+package auth
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"dnstom/internal/dnswire"
+	"dnstom/internal/rr"
+)
+
+// Authoritative answers (RFC 1034 4.3.2), for the zones we hold.
+//
+// For a question in one of our zones, walk down from the apex towards the
+// name:
+//
+//   - pass a zone cut (NS records below the apex) and the answer is a
+//     referral: AA clear, the NS records in authority, and addresses for any
+//     of those name servers that live inside the zone as glue
+//   - reach the name and it has records of the type asked for: that's the
+//     answer, with AA set
+//   - it has a CNAME instead: answer with the CNAME and, if the target is
+//     in the same zone, carry on from the target
+//   - it exists but has neither: NODATA - NOERROR, no answers, the SOA in
+//     authority
+//   - it doesn't exist, but a wildcard at its closest encloser does (RFC
+//     4592): answer from the wildcard, with the records renamed to the name
+//     asked for
+//   - otherwise NXDOMAIN, SOA in authority
+//
+// Questions for names outside all our zones are REFUSED.
+
+// maxChase is how many CNAMEs we'll follow inside our own zones.
+const maxChase = 8
+
+// Authority answers queries from a set of zones. It's a server.Handler.
+type Authority struct {
+	zones map[string]*Zone // by origin
+}
+
+// New returns an Authority serving zones.
+func New(zones ...*Zone) *Authority {
+	a := &Authority{zones: make(map[string]*Zone)}
+	for _, z := range zones {
+		a.zones[z.Origin] = z
+	}
+	return a
+}
+
+// zoneFor returns the closest zone containing name, or nil.
+func (a *Authority) zoneFor(name string) *Zone {
+	for n := name; ; n = parent(n) {
+		if z, ok := a.zones[n]; ok {
+			return z
+		}
+		if n == "." {
+			return nil
+		}
+	}
+}
+
+func (a *Authority) ServeDNS(ctx context.Context, client net.Addr, query *dnswire.Message) *dnswire.Message {
+
+	resp := &dnswire.Message{
+		Header: dnswire.Header{
+			ID:     query.Header.ID,
+			QR:     true,
+			Opcode: query.Header.Opcode,
+			RD:     query.Header.RD,
+		},
+		Questions: query.Questions,
+	}
+	if query.OPT != nil {
+		resp.OPT = dnswire.NewOPT()
+	}
+
+	switch {
+	case query.Header.Opcode != dnswire.OpcodeQuery:
+		resp.Header.Rcode = dnswire.RcodeNotImp
+		return resp
+	case len(query.Questions) != 1:
+		resp.Header.Rcode = dnswire.RcodeFormErr
+		return resp
+	}
+
+	q := query.Questions[0]
+	name := strings.ToLower(q.Name)
+
+	z := a.zoneFor(name)
+	if z == nil || q.Class != dnswire.ClassIN || q.Type == dnswire.TypeAXFR || q.Type == dnswire.TypeIXFR {
+		resp.Header.Rcode = dnswire.RcodeRefused
+		return resp
+	}
+
+	z.answer(resp, q.Name, q.Type)
+	return resp
+}
+
+// answer fills in resp for name/qtype, chasing CNAMEs within the zone.
+func (z *Zone) answer(resp *dnswire.Message, name string, qtype uint16) {
+
+	resp.Header.AA = true
+
+	for chased := 0; ; chased++ {
+		target, done := z.answerOne(resp, name, qtype)
+		if done || chased >= maxChase || !inZone(strings.ToLower(target), z.Origin) {
+			return
+		}
+		name = target
+	}
+}
+
+// answerOne answers for one name. If that turns up a CNAME (and we weren't
+// asked for the CNAME itself) it returns the target and done = false.
+func (z *Zone) answerOne(resp *dnswire.Message, name string, qtype uint16) (string, bool) {
+
+	lower := strings.ToLower(name)
+
+	// Zone cuts between the apex and the name. DS lives on the parent side
+	// of the cut, so a DS question for the cut itself isn't a referral.
+	if cut := z.cut(lower, qtype); cut != "" {
+		z.referral(resp, cut)
+		return "", true
+	}
+
+	records, exists := z.names[lower]
+	if !exists {
+		wildcard, ok := z.wildcard(lower)
+		if !ok {
+			resp.Header.Rcode = dnswire.RcodeNXDomain
+			z.addSOA(resp)
+			return "", true
+		}
+		records = synthesize(wildcard, name)
+	}
+
+	var cname *dnswire.ResourceRecord
+	found := false
+	for i, r := range records {
+		switch {
+		case r.Type == qtype || qtype == dnswire.TypeANY:
+			resp.Answers = append(resp.Answers, r)
+			found = true
+		case r.Type == dnswire.TypeCNAME:
+			cname = &records[i]
+		}
+	}
+
+	if found {
+		z.additional(resp, records, qtype)
+		return "", true
+	}
+
+	if cname != nil {
+		resp.Answers = append(resp.Answers, *cname)
+		d, err := rr.Decode(*cname)
+		if err != nil {
+			return "", true
+		}
+		target := d.(*rr.CNAME).Target
+		if aliased(resp.Answers, target) {
+			return "", true // a loop - stop here and let the client sort it out
+		}
+		return target, false
+	}
+
+	// NODATA
+	z.addSOA(resp)
+	return "", true
+}
+
+// cut returns the closest delegation point above (or at) name, if there is
+// one below the apex.
+func (z *Zone) cut(name string, qtype uint16) string {
+
+	var between []string
+	for n := name; n != z.Origin && inZone(n, z.Origin); n = parent(n) {
+		between = append(between, n)
+	}
+
+	// Walk from the apex down, so the highest cut wins - anything below it
+	// belongs to the child zone, even if we have records for it
+	for i := len(between) - 1; i >= 0; i-- {
+		n := between[i]
+		if n == name && qtype == dnswire.TypeDS {
+			return ""
+		}
+		for _, r := range z.names[n] {
+			if r.Type == dnswire.TypeNS {
+				return n
+			}
+		}
+	}
+	return ""
+}
+
+// referral fills in a referral to the child zone at cut: its NS records, and
+// glue for any name server inside our zone (which includes below the cut).
+func (z *Zone) referral(resp *dnswire.Message, cut string) {
+
+	// Unless we got here by following a CNAME we did answer for
+	if len(resp.Answers) == 0 {
+		resp.Header.AA = false
+	}
+
+	for _, r := range z.names[cut] {
+		if r.Type != dnswire.TypeNS {
+			continue
+		}
+		resp.Authority = append(resp.Authority, r)
+		if d, err := rr.Decode(r); err == nil {
+			resp.Additional = append(resp.Additional, z.addresses(d.(*rr.NS).Host)...)
+		}
+	}
+}
+
+// additional adds addresses for the targets of NS, MX and SRV answers
+// (RFC 1035 3.3.x "additional section processing"), where we have them.
+func (z *Zone) additional(resp *dnswire.Message, records []dnswire.ResourceRecord, qtype uint16) {
+
+	for _, r := range records {
+		if r.Type != qtype {
+			continue
+		}
+		d, err := rr.Decode(r)
+		if err != nil {
+			continue
+		}
+		var target string
+		switch d := d.(type) {
+		case *rr.NS:
+			target = d.Host
+		case *rr.MX:
+			target = d.Exchange
+		case *rr.SRV:
+			target = d.Target
+		default:
+			continue
+		}
+		resp.Additional = append(resp.Additional, z.addresses(target)...)
+	}
+}
+
+// addresses returns the A and AAAA records for name, if it's in the zone.
+func (z *Zone) addresses(name string) []dnswire.ResourceRecord {
+	var out []dnswire.ResourceRecord
+	for _, r := range z.names[strings.ToLower(name)] {
+		if r.Type == dnswire.TypeA || r.Type == dnswire.TypeAAAA {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// wildcard finds the wildcard that covers a name that doesn't exist: the
+// "*" child of its closest encloser (the nearest ancestor that does exist).
+// RFC 4592 3.3.1 - only that one wildcard counts, not any further up.
+//
+// ok says the wildcard exists, even if it has no records (an empty
+// non-terminal) - that's still a match, and the answer is NODATA rather
+// than NXDOMAIN.
+func (z *Zone) wildcard(name string) ([]dnswire.ResourceRecord, bool) {
+
+	for n := parent(name); inZone(n, z.Origin); n = parent(n) {
+		if _, exists := z.names[n]; exists {
+			star := "*." + n
+			if n == "." {
+				star = "*."
+			}
+			records, ok := z.names[star]
+			return records, ok
+		}
+		if n == z.Origin {
+			break
+		}
+	}
+	return nil, false
+}
+
+// synthesize copies the wildcard's records with the owner changed to name.
+func synthesize(records []dnswire.ResourceRecord, name string) []dnswire.ResourceRecord {
+	out := make([]dnswire.ResourceRecord, len(records))
+	for i, r := range records {
+		r.Name = name
+		out[i] = r
+	}
+	return out
+}
+
+// addSOA puts the SOA in the authority section of a negative answer, with
+// the TTL the negative answer can be cached for (RFC 2308 3): the smaller of
+// the SOA's TTL and its MINIMUM.
+func (z *Zone) addSOA(resp *dnswire.Message) {
+	soa := z.SOA
+	if d, err := rr.Decode(soa); err == nil {
+		soa.TTL = min(soa.TTL, d.(*rr.SOA).Minimum)
+	}
+	resp.Authority = append(resp.Authority, soa)
+}
+
+// aliased reports whether answers already has a CNAME owned by name.
+func aliased(answers []dnswire.ResourceRecord, name string) bool {
+	for _, a := range answers {
+		if a.Type == dnswire.TypeCNAME && strings.EqualFold(a.Name, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+
+	"dnstom/internal/dnswire"
+	"dnstom/internal/rr"
+)
+
+func loadTestZone(t *testing.T) *Authority {
+	t.Helper()
+	z, err := LoadZone("example.com", "testdata/example.com.zone")
+	if err != nil {
+		t.Fatalf("LoadZone: %v", err)
+	}
+	return New(z)
+}
+
+func ask(a *Authority, name string, qtype uint16) *dnswire.Message {
+	q := &dnswire.Message{
+		Header:    dnswire.Header{ID: 42},
+		Questions: []dnswire.Question{{Name: name, Type: qtype, Class: dnswire.ClassIN}},
+	}
+	return a.ServeDNS(context.Background(), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, q)
+}
+
+// summary is "owner TYPE" for each record, sorted, so tests can compare
+// sections without caring about order.
+func summary(records []dnswire.ResourceRecord) string {
+	var s []string
+	for _, r := range records {
+		s = append(s, fmt.Sprintf("%s %s", r.Name, dnswire.TypeToString(r.Type)))
+	}
+	sort.Strings(s)
+	return strings.Join(s, ", ")
+}
+
+func TestAuthority(t *testing.T) {
+	a := loadTestZone(t)
+
+	tests := []struct {
+		name       string
+		qtype      uint16
+		rcode      uint8
+		aa         bool
+		answer     string
+		authority  string
+		additional string
+	}{
+		// Plain answers, with additional addresses for MX and NS targets
+		{"www.example.com.", dnswire.TypeA, 0, true, "www.example.com. A", "", ""},
+		{"WWW.Example.COM.", dnswire.TypeAAAA, 0, true, "www.example.com. AAAA", "", ""},
+		{"example.com.", dnswire.TypeMX, 0, true, "example.com. MX", "", "mail.example.com. A"},
+		{"example.com.", dnswire.TypeNS, 0, true, "example.com. NS, example.com. NS", "", "ns1.example.com. A"},
+
+		// NODATA and NXDOMAIN
+		{"mail.example.com.", dnswire.TypeAAAA, 0, true, "", "example.com. SOA", ""},
+		{"nope.example.com.", dnswire.TypeA, 3, true, "", "example.com. SOA", ""},
+		{"deep.example.com.", dnswire.TypeA, 0, true, "", "example.com. SOA", ""},   // empty non-terminal
+		{"b.deep.example.com.", dnswire.TypeA, 0, true, "", "example.com. SOA", ""}, // so is this
+		{"c.deep.example.com.", dnswire.TypeA, 3, true, "", "example.com. SOA", ""},
+
+		// CNAMEs: chased inside the zone, not outside, loops cut short
+		{"alias.example.com.", dnswire.TypeA, 0, true, "alias.example.com. CNAME, www.example.com. A", "", ""},
+		{"alias.example.com.", dnswire.TypeCNAME, 0, true, "alias.example.com. CNAME", "", ""},
+		{"external.example.com.", dnswire.TypeA, 0, true, "external.example.com. CNAME", "", ""},
+		{"loop1.example.com.", dnswire.TypeA, 0, true, "loop1.example.com. CNAME, loop2.example.com. CNAME", "", ""},
+
+		// Wildcards
+		{"anything.wild.example.com.", dnswire.TypeA, 0, true, "anything.wild.example.com. A", "", ""},
+		{"anything.wild.example.com.", dnswire.TypeAAAA, 0, true, "", "example.com. SOA", ""},
+		{"sub.wild.example.com.", dnswire.TypeA, 0, true, "", "example.com. SOA", ""},        // exists, so no wildcard
+		{"x.sub.wild.example.com.", dnswire.TypeA, 3, true, "", "example.com. SOA", ""},      // encloser is sub.wild, no * there
+		{"a.b.wild.example.com.", dnswire.TypeA, 0, true, "a.b.wild.example.com. A", "", ""}, // more than one label is fine
+		{"x.cwild.example.com.", dnswire.TypeA, 0, true, "www.example.com. A, x.cwild.example.com. CNAME", "", ""},
+		{"anything.ewild.example.com.", dnswire.TypeA, 0, true, "", "example.com. SOA", ""}, // empty wildcard: NODATA, not NXDOMAIN
+
+		// Referrals
+		{"child.example.com.", dnswire.TypeA, 0, false, "", "child.example.com. NS, child.example.com. NS", "ns1.child.example.com. A"},
+		{"www.child.example.com.", dnswire.TypeA, 0, false, "", "child.example.com. NS, child.example.com. NS", "ns1.child.example.com. A"},
+		{"ns1.child.example.com.", dnswire.TypeA, 0, false, "", "child.example.com. NS, child.example.com. NS", "ns1.child.example.com. A"},
+		{"child.example.com.", dnswire.TypeDS, 0, true, "", "example.com. SOA", ""}, // parent side of the cut
+	}
+
+	for _, tt := range tests {
+		resp := ask(a, tt.name, tt.qtype)
+		label := fmt.Sprintf("%s %s", tt.name, dnswire.TypeToString(tt.qtype))
+
+		if resp.Header.Rcode != tt.rcode || resp.Header.AA != tt.aa || !resp.Header.QR || resp.Header.ID != 42 {
+			t.Errorf("%s: rcode %d AA %v, want rcode %d AA %v", label, resp.Header.Rcode, resp.Header.AA, tt.rcode, tt.aa)
+		}
+		if got := summary(resp.Answers); got != tt.answer {
+			t.Errorf("%s: answer = %q, want %q", label, got, tt.answer)
+		}
+		if got := summary(resp.Authority); got != tt.authority {
+			t.Errorf("%s: authority = %q, want %q", label, got, tt.authority)
+		}
+		if got := summary(resp.Additional); got != tt.additional {
+			t.Errorf("%s: additional = %q, want %q", label, got, tt.additional)
+		}
+	}
+}
+
+func TestAuthority_NegativeTTL(t *testing.T) {
+	a := loadTestZone(t)
+
+	// SOA TTL 3600, MINIMUM 300: the negative answer is good for 300
+	resp := ask(a, "nope.example.com.", dnswire.TypeA)
+	if len(resp.Authority) != 1 || resp.Authority[0].TTL != 300 {
+		t.Fatalf("authority = %+v, want the SOA with TTL 300", resp.Authority)
+	}
+}
+
+func TestAuthority_Refused(t *testing.T) {
+	a := loadTestZone(t)
+
+	for _, tt := range []struct {
+		name  string
+		qtype uint16
+	}{
+		{"www.example.net.", dnswire.TypeA},
+		{"example.com.", dnswire.TypeAXFR},
+	} {
+		if resp := ask(a, tt.name, tt.qtype); resp.Header.Rcode != dnswire.RcodeRefused || resp.Header.AA {
+			t.Errorf("%s %d: rcode %d AA %v, want REFUSED", tt.name, tt.qtype, resp.Header.Rcode, resp.Header.AA)
+		}
+	}
+}
+
+func TestAuthority_WildcardOwner(t *testing.T) {
+	a := loadTestZone(t)
+
+	resp := ask(a, "Some.Wild.example.com.", dnswire.TypeA)
+	if len(resp.Answers) != 1 || resp.Answers[0].Name != "Some.Wild.example.com." {
+		t.Fatalf("answers = %+v, want one A owned by the name asked for", resp.Answers)
+	}
+	d, err := rr.Decode(resp.Answers[0])
+	if err != nil || !d.(*rr.A).Address.Equal(net.ParseIP("192.0.2.99")) {
+		t.Fatalf("answer = %v, %v; want 192.0.2.99", d, err)
+	}
+}
+
+func TestParseZone_Errors(t *testing.T) {
+	soa := "@ 3600 IN SOA ns1 hostmaster 1 2 3 4 5\n"
+
+	tests := []struct {
+		zone string
+		want string
+	}{
+		{soa + "www 3600 IN A\n", "line 2"},
+		{soa + "www 3600 CH A 192.0.2.1\n", "only IN"},
+		{soa + "www 3600 IN BOGUS x\n", "unknown type"},
+		{soa + "www 3600 IN TXT \"unterminated\n", "unterminated"},
+		{soa + "www.example.net. 3600 IN A 192.0.2.1\n", "outside zone"},
+		{"www 3600 IN A 192.0.2.1\n", "0 SOA"},
+		{soa + soa, "2 SOA"},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseZone("example.com.", strings.NewReader(tt.zone))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseZone(%q) error = %v, want one mentioning %q", tt.zone, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"dnstom/internal/dnswire"
+	"dnstom/internal/rr"
+	"dnstom/internal/zone"
+)
+
+// Zone is the data for one zone, indexed by owner name.
+//
+// Every name that exists in the zone has an entry in names - including the
+// empty non-terminals between the apex and deeper names (a.b.example.com.
+// existing means b.example.com. exists too, with no records). That's what
+// tells NODATA apart from NXDOMAIN, and where wildcard matching stops.
+type Zone struct {
+	Origin string // lower case, absolute
+	SOA    dnswire.ResourceRecord
+
+	names   map[string][]dnswire.ResourceRecord // lower case owner -> records
+	records []dnswire.ResourceRecord            // in the order they were given
+}
+
+// NewZone builds a zone from records. There has to be exactly one SOA, at
+// origin, every record has to be at or below origin, and they all have to be
+// class IN.
+func NewZone(origin string, records []dnswire.ResourceRecord) (*Zone, error) {
+
+	z := &Zone{
+		Origin: strings.ToLower(rr.AbsoluteName(origin, ".")),
+		names:  make(map[string][]dnswire.ResourceRecord),
+	}
+
+	soas := 0
+	for _, r := range records {
+		owner := strings.ToLower(r.Name)
+		if !inZone(owner, z.Origin) {
+			return nil, fmt.Errorf("auth: %s is outside zone %s", r.Name, z.Origin)
+		}
+		if r.Class != dnswire.ClassIN {
+			return nil, fmt.Errorf("auth: %s is class %s, only IN is supported", r.Name, dnswire.ClassToString(r.Class))
+		}
+
+		if r.Type == dnswire.TypeSOA {
+			if owner != z.Origin {
+				return nil, fmt.Errorf("auth: SOA at %s, not the zone apex %s", r.Name, z.Origin)
+			}
+			z.SOA = r
+			soas++
+		}
+
+		z.names[owner] = append(z.names[owner], r)
+		z.records = append(z.records, r)
+
+		// Make sure every name between here and the apex exists
+		for n := owner; n != z.Origin; {
+			n = parent(n)
+			if _, ok := z.names[n]; !ok {
+				z.names[n] = nil
+			}
+		}
+	}
+
+	if soas != 1 {
+		return nil, fmt.Errorf("auth: zone %s has %d SOA records, want 1", z.Origin, soas)
+	}
+
+	return z, nil
+}
+
+// Records returns every record in the zone, in the order they were given.
+func (z *Zone) Records() []dnswire.ResourceRecord {
+	return append([]dnswire.ResourceRecord(nil), z.records...)
+}
+
+// LoadZone reads the zone for origin from the master file at path (see
+// internal/zone for the format).
+func LoadZone(origin, path string) (*Zone, error) {
+	records, err := zone.ParseFile(path, origin)
+	if err != nil {
+		return nil, err
+	}
+
+	z, err := NewZone(origin, records)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return z, nil
+}
+
+// ParseZone reads the zone for origin from a master file. $INCLUDEs are
+// relative to the current directory.
+func ParseZone(origin string, r io.Reader) (*Zone, error) {
+	records, err := zone.Parse(r, origin)
+	if err != nil {
+		return nil, err
+	}
+	return NewZone(origin, records)
+}
+
+// inZone reports whether name (lower case, absolute) is origin or below it.
+func inZone(name, origin string) bool {
+	return dnswire.IsSubdomain(name, origin)
+}
+
+// parent drops the first label: "www.example.com." -> "example.com.". A
+// dot inside the label ("a\.b.example.com.") doesn't count.
+func parent(name string) string {
+	for i := 0; i < len(name)-1; i++ {
+		switch name[i] {
+		case '\\':
+			i++
+		case '.':
+			return name[i+1:]
+		}
+	}
+	return "."
+}
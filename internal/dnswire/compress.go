@@ -0,0 +1,146 @@
+package dnswire
+
+import "fmt"
+
+// Name compression (RFC 1035 4.1.4).
+//
+// Every name in a reply tends to end in the same zone ("example.com."), so
+// instead of writing it out again and again the encoder replaces a suffix it
+// has already written with a 2 byte pointer to where it first appeared:
+//
+//	offset 12: 03 77 77 77 07 65 78 61 6d 70 6c 65 03 63 6f 6d 00   www.example.com.
+//	...
+//	           c0 0c                                                www.example.com.
+//	           04 6d 61 69 6c c0 10                                 mail.example.com.
+
+// maxPointerOffset is the highest offset a 14-bit pointer can reach.
+const maxPointerOffset = 0x3FFF
+
+// compressibleTypes are the RR types whose RDATA names may be compressed on
+// the way out. RFC 3597 section 4 freezes the list at the RFC 1035 types;
+// newer types (SRV, DNAME, ...) are always written in full because a
+// resolver that doesn't know the type can't follow pointers inside it.
+var compressibleTypes = map[uint16]bool{
+	TypeNS:    true,
+	TypeMD:    true,
+	TypeMF:    true,
+	TypeCNAME: true,
+	TypeSOA:   true,
+	TypeMB:    true,
+	TypeMG:    true,
+	TypeMR:    true,
+	TypePTR:   true,
+	TypeMINFO: true,
+	TypeMX:    true,
+}
+
+// compressor remembers where each name suffix has been written in the
+// message being built.
+type compressor struct {
+	// uncompressed wire form of a suffix -> offset in the message
+	offsets map[string]int
+}
+
+func newCompressor() *compressor {
+	return &compressor{offsets: make(map[string]int)}
+}
+
+// appendName appends name to msg, replacing the longest suffix that is
+// already in msg with a pointer to it. Matching is exact (case included), so
+// decoding gives back precisely the name that went in.
+func (c *compressor) appendName(msg []byte, name string) ([]byte, error) {
+
+	wire, err := encodeName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the first (i.e. longest) suffix we've seen before
+	match, target := len(wire)-1, -1
+	for i := 0; wire[i] != 0; i += int(wire[i]) + 1 {
+		if off, ok := c.offsets[string(wire[i:])]; ok {
+			match, target = i, off
+			break
+		}
+	}
+
+	// Everything in front of the match is new, so later names can point at it
+	start := len(msg)
+	for i := 0; i < match; i += int(wire[i]) + 1 {
+		if start+i > maxPointerOffset {
+			break
+		}
+		c.offsets[string(wire[i:])] = start + i
+	}
+
+	msg = append(msg, wire[:match]...)
+	if target < 0 {
+		return append(msg, 0x00), nil
+	}
+	return appendUint16(msg, 0xC000|uint16(target)), nil
+}
+
+// appendRData appends uncompressed RDATA, compressing any names in it if
+// the type allows it.
+func (c *compressor) appendRData(msg []byte, rrtype uint16, rdata []byte) ([]byte, error) {
+
+	layout, ok := rdataLayouts[rrtype]
+	if !ok || !compressibleTypes[rrtype] {
+		return append(msg, rdata...), nil
+	}
+
+	pos := 0
+	for _, field := range layout {
+		if field != rdataName {
+			if pos+field > len(rdata) {
+				return nil, fmt.Errorf("type %d rdata: need %d bytes at offset %d, have %d", rrtype, field, pos, len(rdata)-pos)
+			}
+			msg = append(msg, rdata[pos:pos+field]...)
+			pos += field
+			continue
+		}
+
+		name, next, err := decodeName(rdata, pos)
+		if err != nil {
+			return nil, fmt.Errorf("type %d rdata: %w", rrtype, err)
+		}
+		if msg, err = c.appendName(msg, name); err != nil {
+			return nil, err
+		}
+		pos = next
+	}
+
+	return append(msg, rdata[pos:]...), nil
+}
+
+// appendResourceRecord appends one RR. RDLENGTH is worked out from what
+// actually gets written, so rr.RDLength is ignored.
+func (c *compressor) appendResourceRecord(msg []byte, rr ResourceRecord) ([]byte, error) {
+
+	msg, err := c.appendName(msg, rr.Name)
+	if err != nil {
+		return nil, fmt.Errorf("name: %w", err)
+	}
+
+	msg = appendUint16(msg, rr.Type)
+	msg = appendUint16(msg, rr.Class)
+	msg = appendUint32(msg, rr.TTL)
+
+	// RDLENGTH placeholder, filled in once we know how long RDATA came out
+	lenAt := len(msg)
+	msg = appendUint16(msg, 0)
+
+	msg, err = c.appendRData(msg, rr.Type, rr.RData)
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", rr.Name, err)
+	}
+
+	rdlen := len(msg) - lenAt - 2
+	if rdlen > 0xFFFF {
+		return nil, fmt.Errorf("%q: rdata is %d bytes, longer than 65535", rr.Name, rdlen)
+	}
+	msg[lenAt] = byte(rdlen >> 8)
+	msg[lenAt+1] = byte(rdlen)
+
+	return msg, nil
+}
@@ -0,0 +1,159 @@
+package dnswire
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPackMessage_MatchesRealServer(t *testing.T) {
+	// Same reply as TestDecodeReply_ARecord_WithCompressionPointer - the
+	// answer NAME should come out as c00c just like the server sent it.
+	wire := mustHex(t, `
+		db42 8180 0001 0001 0000 0000
+		0377 7777 0c6e 6f72 7468 6561 7374 6572 6e03 6564 7500
+		0001 0001
+		c00c 0001 0001 0000 0258 0004 9b21 1144
+	`)
+
+	m, err := DecodeMessage(wire)
+	if err != nil {
+		t.Fatalf("DecodeMessage error: %v", err)
+	}
+
+	got, err := packMessage(&m)
+	if err != nil {
+		t.Fatalf("packMessage error: %v", err)
+	}
+
+	if !bytes.Equal(got, wire) {
+		t.Fatalf("packMessage =\n%x\nwant\n%x", got, wire)
+	}
+}
+
+func TestPackMessage_CompressesRDataSuffixes(t *testing.T) {
+	mxName, _ := encodeName("mail.example.com.")
+
+	m := Message{
+		Header:    Header{ID: 0xabcd, QR: true, RD: true, RA: true},
+		Questions: []Question{{Name: "example.com.", Type: TypeMX, Class: ClassIN}},
+		Answers: []ResourceRecord{{
+			Name: "example.com.", Type: TypeMX, Class: ClassIN, TTL: 3600,
+			RData: append([]byte{0x00, 0x0a}, mxName...),
+		}},
+	}
+
+	got, err := packMessage(&m)
+	if err != nil {
+		t.Fatalf("packMessage error: %v", err)
+	}
+
+	// Same bytes as the compressed MX fixture in internal/rr
+	want := mustHex(t, `
+		abcd 8180 0001 0001 0000 0000
+		0765 7861 6d70 6c65 0363 6f6d 00
+		000f 0001
+		c00c 000f 0001 0000 0e10 0009 000a 046d 6169 6c c00c
+	`)
+
+	if !bytes.Equal(got, want) {
+		t.Fatalf("packMessage =\n%x\nwant\n%x", got, want)
+	}
+}
+
+func TestPackMessage_SRVTargetNotCompressed(t *testing.T) {
+	target, _ := encodeName("sip.example.com.")
+	rdata := append([]byte{0, 1, 0, 2, 0x13, 0xc4}, target...)
+
+	m := Message{
+		Questions: []Question{{Name: "_sip._udp.example.com.", Type: TypeSRV, Class: ClassIN}},
+		Answers: []ResourceRecord{{
+			Name: "_sip._udp.example.com.", Type: TypeSRV, Class: ClassIN, TTL: 60, RData: rdata,
+		}},
+	}
+
+	got, err := packMessage(&m)
+	if err != nil {
+		t.Fatalf("packMessage error: %v", err)
+	}
+
+	if !bytes.HasSuffix(got, rdata) {
+		t.Fatalf("SRV rdata was rewritten: %x", got)
+	}
+}
+
+func TestPackMessage_RoundTrip(t *testing.T) {
+	// A handful of labels so generated names share plenty of suffixes
+	labels := []string{"www", "mail", "ns1", "ns2", "example", "com", "org", "a", "b-c", "xn--bcher-kva"}
+
+	rng := rand.New(rand.NewSource(1))
+
+	randName := func() string {
+		n := rng.Intn(5)
+		parts := make([]string, n)
+		for i := range parts {
+			parts[i] = labels[rng.Intn(len(labels))]
+		}
+		return strings.Join(parts, ".") + "."
+	}
+
+	randRR := func() ResourceRecord {
+		rr := ResourceRecord{Name: randName(), Class: ClassIN, TTL: rng.Uint32()}
+		switch rng.Intn(5) {
+		case 0:
+			rr.Type, rr.RData = TypeA, []byte{byte(rng.Intn(256)), 0, 2, 1}
+		case 1:
+			rr.Type = TypeCNAME
+			rr.RData, _ = encodeName(randName())
+		case 2:
+			name, _ := encodeName(randName())
+			rr.Type, rr.RData = TypeMX, append([]byte{0, byte(rng.Intn(50))}, name...)
+		case 3:
+			mname, _ := encodeName(randName())
+			rname, _ := encodeName(randName())
+			rr.Type = TypeSOA
+			rr.RData = append(append(mname, rname...), make([]byte, 20)...)
+		case 4:
+			rr.Type, rr.RData = TypeTXT, []byte("\x05hello")
+		}
+		rr.RDLength = uint16(len(rr.RData))
+		return rr
+	}
+
+	for i := 0; i < 500; i++ {
+		m := Message{Header: Header{ID: uint16(rng.Intn(65536)), QR: true, Opcode: 0, RD: true}}
+
+		for n := rng.Intn(3); n > 0; n-- {
+			m.Questions = append(m.Questions, Question{Name: randName(), Type: TypeA, Class: ClassIN})
+		}
+		for n := rng.Intn(6); n > 0; n-- {
+			m.Answers = append(m.Answers, randRR())
+		}
+		for n := rng.Intn(4); n > 0; n-- {
+			m.Authority = append(m.Authority, randRR())
+		}
+		for n := rng.Intn(4); n > 0; n-- {
+			m.Additional = append(m.Additional, randRR())
+		}
+		m.Header.QDCount = uint16(len(m.Questions))
+		m.Header.ANCount = uint16(len(m.Answers))
+		m.Header.NSCount = uint16(len(m.Authority))
+		m.Header.ARCount = uint16(len(m.Additional))
+
+		wire, err := packMessage(&m)
+		if err != nil {
+			t.Fatalf("message %d: packMessage error: %v", i, err)
+		}
+
+		got, err := DecodeMessage(wire)
+		if err != nil {
+			t.Fatalf("message %d: DecodeMessage error: %v\n%x", i, err, wire)
+		}
+
+		if !reflect.DeepEqual(got, m) {
+			t.Fatalf("message %d: round trip mismatch\n got %+v\nwant %+v", i, got, m)
+		}
+	}
+}
@@ -1,14 +1,328 @@
 package dnswire
 
+import (
+	"strconv"
+	"strings"
+)
+
 // Since DNS was built back in the days when memory and bandwidth was at a premium, everything was represented in the smallest amount of bit use possible.
 // I can be a nice flex (and also quite useful to remember what every number is) - but in the intrests of good code we can replace said numbers with const variable names.
 // Since a lot of DNS stuff is fixed, we're pretty safe in how many we use provided we don't make the mistake I did many years back
 // (use the same number for two diferent consts used in the same scope which just happened to pass QA and caused minor issues in a banking system 18 months later).
 
+// Numbers are from the IANA "Domain Name System (DNS) Parameters" registry.
+// Some of these are long dead (MD, MF, the mailbox types, ...) but they still
+// turn up in old zones and test suites, so they get names too.
+
 const (
 	// DNS record types
-	TypeA uint16 = 1 ///0x0001
+	TypeA          uint16 = 1     ///0x0001
+	TypeNS         uint16 = 2     ///0x0002
+	TypeMD         uint16 = 3     ///0x0003
+	TypeMF         uint16 = 4     ///0x0004
+	TypeCNAME      uint16 = 5     ///0x0005
+	TypeSOA        uint16 = 6     ///0x0006
+	TypeMB         uint16 = 7     ///0x0007
+	TypeMG         uint16 = 8     ///0x0008
+	TypeMR         uint16 = 9     ///0x0009
+	TypeNULL       uint16 = 10    ///0x000a
+	TypeWKS        uint16 = 11    ///0x000b
+	TypePTR        uint16 = 12    ///0x000c
+	TypeHINFO      uint16 = 13    ///0x000d
+	TypeMINFO      uint16 = 14    ///0x000e
+	TypeMX         uint16 = 15    ///0x000f
+	TypeTXT        uint16 = 16    ///0x0010
+	TypeRP         uint16 = 17    ///0x0011
+	TypeAFSDB      uint16 = 18    ///0x0012
+	TypeX25        uint16 = 19    ///0x0013
+	TypeISDN       uint16 = 20    ///0x0014
+	TypeRT         uint16 = 21    ///0x0015
+	TypeNSAP       uint16 = 22    ///0x0016
+	TypeNSAPPTR    uint16 = 23    ///0x0017
+	TypeSIG        uint16 = 24    ///0x0018
+	TypeKEY        uint16 = 25    ///0x0019
+	TypePX         uint16 = 26    ///0x001a
+	TypeGPOS       uint16 = 27    ///0x001b
+	TypeAAAA       uint16 = 28    ///0x001c
+	TypeLOC        uint16 = 29    ///0x001d
+	TypeNXT        uint16 = 30    ///0x001e
+	TypeEID        uint16 = 31    ///0x001f
+	TypeNIMLOC     uint16 = 32    ///0x0020
+	TypeSRV        uint16 = 33    ///0x0021
+	TypeATMA       uint16 = 34    ///0x0022
+	TypeNAPTR      uint16 = 35    ///0x0023
+	TypeKX         uint16 = 36    ///0x0024
+	TypeCERT       uint16 = 37    ///0x0025
+	TypeA6         uint16 = 38    ///0x0026
+	TypeDNAME      uint16 = 39    ///0x0027
+	TypeSINK       uint16 = 40    ///0x0028
+	TypeOPT        uint16 = 41    ///0x0029
+	TypeAPL        uint16 = 42    ///0x002a
+	TypeDS         uint16 = 43    ///0x002b
+	TypeSSHFP      uint16 = 44    ///0x002c
+	TypeIPSECKEY   uint16 = 45    ///0x002d
+	TypeRRSIG      uint16 = 46    ///0x002e
+	TypeNSEC       uint16 = 47    ///0x002f
+	TypeDNSKEY     uint16 = 48    ///0x0030
+	TypeDHCID      uint16 = 49    ///0x0031
+	TypeNSEC3      uint16 = 50    ///0x0032
+	TypeNSEC3PARAM uint16 = 51    ///0x0033
+	TypeTLSA       uint16 = 52    ///0x0034
+	TypeSMIMEA     uint16 = 53    ///0x0035
+	TypeHIP        uint16 = 55    ///0x0037
+	TypeNINFO      uint16 = 56    ///0x0038
+	TypeRKEY       uint16 = 57    ///0x0039
+	TypeTALINK     uint16 = 58    ///0x003a
+	TypeCDS        uint16 = 59    ///0x003b
+	TypeCDNSKEY    uint16 = 60    ///0x003c
+	TypeOPENPGPKEY uint16 = 61    ///0x003d
+	TypeCSYNC      uint16 = 62    ///0x003e
+	TypeZONEMD     uint16 = 63    ///0x003f
+	TypeSVCB       uint16 = 64    ///0x0040
+	TypeHTTPS      uint16 = 65    ///0x0041
+	TypeDSYNC      uint16 = 66    ///0x0042
+	TypeSPF        uint16 = 99    ///0x0063
+	TypeUINFO      uint16 = 100   ///0x0064
+	TypeUID        uint16 = 101   ///0x0065
+	TypeGID        uint16 = 102   ///0x0066
+	TypeUNSPEC     uint16 = 103   ///0x0067
+	TypeNID        uint16 = 104   ///0x0068
+	TypeL32        uint16 = 105   ///0x0069
+	TypeL64        uint16 = 106   ///0x006a
+	TypeLP         uint16 = 107   ///0x006b
+	TypeEUI48      uint16 = 108   ///0x006c
+	TypeEUI64      uint16 = 109   ///0x006d
+	TypeNXNAME     uint16 = 128   ///0x0080
+	TypeTKEY       uint16 = 249   ///0x00f9
+	TypeTSIG       uint16 = 250   ///0x00fa
+	TypeIXFR       uint16 = 251   ///0x00fb
+	TypeAXFR       uint16 = 252   ///0x00fc
+	TypeMAILB      uint16 = 253   ///0x00fd
+	TypeMAILA      uint16 = 254   ///0x00fe
+	TypeANY        uint16 = 255   ///0x00ff
+	TypeURI        uint16 = 256   ///0x0100
+	TypeCAA        uint16 = 257   ///0x0101
+	TypeAVC        uint16 = 258   ///0x0102
+	TypeDOA        uint16 = 259   ///0x0103
+	TypeAMTRELAY   uint16 = 260   ///0x0104
+	TypeRESINFO    uint16 = 261   ///0x0105
+	TypeWALLET     uint16 = 262   ///0x0106
+	TypeCLA        uint16 = 263   ///0x0107
+	TypeIPN        uint16 = 264   ///0x0108
+	TypeTA         uint16 = 32768 ///0x8000
+	TypeDLV        uint16 = 32769 ///0x8001
 
 	// DNS class
-	ClassIN uint16 = 1 ///0x0001
+	ClassIN   uint16 = 1   ///0x0001
+	ClassCS   uint16 = 2   ///0x0002
+	ClassCH   uint16 = 3   ///0x0003
+	ClassHS   uint16 = 4   ///0x0004
+	ClassNONE uint16 = 254 ///0x00fe
+	ClassANY  uint16 = 255 ///0x00ff
 )
+
+// Opcodes and response codes, the 4 bit fields in the header. The RCODE
+// can be extended by EDNS - see Message.Rcode.
+const (
+	OpcodeQuery  uint8 = 0
+	OpcodeIQuery uint8 = 1 // obsolete
+	OpcodeStatus uint8 = 2
+	OpcodeNotify uint8 = 4
+	OpcodeUpdate uint8 = 5
+
+	RcodeSuccess  uint8 = 0 // NOERROR
+	RcodeFormErr  uint8 = 1
+	RcodeServFail uint8 = 2
+	RcodeNXDomain uint8 = 3
+	RcodeNotImp   uint8 = 4
+	RcodeRefused  uint8 = 5
+)
+
+// typeNames maps RR types to their mnemonic, as used in zone files and dig output.
+var typeNames = map[uint16]string{
+	TypeA:          "A",
+	TypeNS:         "NS",
+	TypeMD:         "MD",
+	TypeMF:         "MF",
+	TypeCNAME:      "CNAME",
+	TypeSOA:        "SOA",
+	TypeMB:         "MB",
+	TypeMG:         "MG",
+	TypeMR:         "MR",
+	TypeNULL:       "NULL",
+	TypeWKS:        "WKS",
+	TypePTR:        "PTR",
+	TypeHINFO:      "HINFO",
+	TypeMINFO:      "MINFO",
+	TypeMX:         "MX",
+	TypeTXT:        "TXT",
+	TypeRP:         "RP",
+	TypeAFSDB:      "AFSDB",
+	TypeX25:        "X25",
+	TypeISDN:       "ISDN",
+	TypeRT:         "RT",
+	TypeNSAP:       "NSAP",
+	TypeNSAPPTR:    "NSAP-PTR",
+	TypeSIG:        "SIG",
+	TypeKEY:        "KEY",
+	TypePX:         "PX",
+	TypeGPOS:       "GPOS",
+	TypeAAAA:       "AAAA",
+	TypeLOC:        "LOC",
+	TypeNXT:        "NXT",
+	TypeEID:        "EID",
+	TypeNIMLOC:     "NIMLOC",
+	TypeSRV:        "SRV",
+	TypeATMA:       "ATMA",
+	TypeNAPTR:      "NAPTR",
+	TypeKX:         "KX",
+	TypeCERT:       "CERT",
+	TypeA6:         "A6",
+	TypeDNAME:      "DNAME",
+	TypeSINK:       "SINK",
+	TypeOPT:        "OPT",
+	TypeAPL:        "APL",
+	TypeDS:         "DS",
+	TypeSSHFP:      "SSHFP",
+	TypeIPSECKEY:   "IPSECKEY",
+	TypeRRSIG:      "RRSIG",
+	TypeNSEC:       "NSEC",
+	TypeDNSKEY:     "DNSKEY",
+	TypeDHCID:      "DHCID",
+	TypeNSEC3:      "NSEC3",
+	TypeNSEC3PARAM: "NSEC3PARAM",
+	TypeTLSA:       "TLSA",
+	TypeSMIMEA:     "SMIMEA",
+	TypeHIP:        "HIP",
+	TypeNINFO:      "NINFO",
+	TypeRKEY:       "RKEY",
+	TypeTALINK:     "TALINK",
+	TypeCDS:        "CDS",
+	TypeCDNSKEY:    "CDNSKEY",
+	TypeOPENPGPKEY: "OPENPGPKEY",
+	TypeCSYNC:      "CSYNC",
+	TypeZONEMD:     "ZONEMD",
+	TypeSVCB:       "SVCB",
+	TypeHTTPS:      "HTTPS",
+	TypeDSYNC:      "DSYNC",
+	TypeSPF:        "SPF",
+	TypeUINFO:      "UINFO",
+	TypeUID:        "UID",
+	TypeGID:        "GID",
+	TypeUNSPEC:     "UNSPEC",
+	TypeNID:        "NID",
+	TypeL32:        "L32",
+	TypeL64:        "L64",
+	TypeLP:         "LP",
+	TypeEUI48:      "EUI48",
+	TypeEUI64:      "EUI64",
+	TypeNXNAME:     "NXNAME",
+	TypeTKEY:       "TKEY",
+	TypeTSIG:       "TSIG",
+	TypeIXFR:       "IXFR",
+	TypeAXFR:       "AXFR",
+	TypeMAILB:      "MAILB",
+	TypeMAILA:      "MAILA",
+	TypeANY:        "ANY",
+	TypeURI:        "URI",
+	TypeCAA:        "CAA",
+	TypeAVC:        "AVC",
+	TypeDOA:        "DOA",
+	TypeAMTRELAY:   "AMTRELAY",
+	TypeRESINFO:    "RESINFO",
+	TypeWALLET:     "WALLET",
+	TypeCLA:        "CLA",
+	TypeIPN:        "IPN",
+	TypeTA:         "TA",
+	TypeDLV:        "DLV",
+}
+
+// classNames maps classes to their mnemonic.
+var classNames = map[uint16]string{
+	ClassIN:   "IN",
+	ClassCS:   "CS",
+	ClassCH:   "CH",
+	ClassHS:   "HS",
+	ClassNONE: "NONE",
+	ClassANY:  "ANY",
+}
+
+// opcodeNames and rcodeNames are dig's names for them.
+var opcodeNames = map[uint8]string{
+	OpcodeQuery:  "QUERY",
+	OpcodeIQuery: "IQUERY",
+	OpcodeStatus: "STATUS",
+	OpcodeNotify: "NOTIFY",
+	OpcodeUpdate: "UPDATE",
+}
+
+var rcodeNames = map[uint16]string{
+	0:  "NOERROR",
+	1:  "FORMERR",
+	2:  "SERVFAIL",
+	3:  "NXDOMAIN",
+	4:  "NOTIMP",
+	5:  "REFUSED",
+	6:  "YXDOMAIN",
+	7:  "YXRRSET",
+	8:  "NXRRSET",
+	9:  "NOTAUTH",
+	10: "NOTZONE",
+	16: "BADVERS", // only as an extended RCODE; TSIG's BADSIG shares the number
+	23: "BADCOOKIE",
+}
+
+// OpcodeToString returns dig's name for an opcode ("QUERY"), or
+// "RESERVED<n>".
+func OpcodeToString(op uint8) string {
+	if name, ok := opcodeNames[op]; ok {
+		return name
+	}
+	return "RESERVED" + strconv.Itoa(int(op))
+}
+
+// RcodeToString returns dig's name for a response code ("NXDOMAIN"),
+// including the EDNS extended ones (see Message.Rcode), or "RESERVED<n>".
+func RcodeToString(rcode uint16) string {
+	if name, ok := rcodeNames[rcode]; ok {
+		return name
+	}
+	return "RESERVED" + strconv.Itoa(int(rcode))
+}
+
+// TypeToString returns the mnemonic for an RR type ("MX"), or the RFC 3597
+// generic form ("TYPE65280") for one without a name.
+func TypeToString(t uint16) string {
+	return typeToString(t)
+}
+
+// ClassToString returns the mnemonic for a class ("IN"), or "CLASS<n>".
+func ClassToString(c uint16) string {
+	return classToString(c)
+}
+
+// TypeFromString is the reverse of TypeToString: "MX", "mx" and "TYPE15"
+// all give TypeMX.
+func TypeFromString(s string) (uint16, bool) {
+	return fromString(s, "TYPE", typeNames)
+}
+
+// ClassFromString is the reverse of ClassToString.
+func ClassFromString(s string) (uint16, bool) {
+	return fromString(s, "CLASS", classNames)
+}
+
+func fromString(s, generic string, names map[uint16]string) (uint16, bool) {
+	s = strings.ToUpper(s)
+	for v, name := range names {
+		if name == s {
+			return v, true
+		}
+	}
+	if rest, ok := strings.CutPrefix(s, generic); ok {
+		if v, err := strconv.ParseUint(rest, 10, 16); err == nil {
+			return uint16(v), true
+		}
+	}
+	return 0, false
+}
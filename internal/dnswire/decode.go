@@ -6,7 +6,14 @@ import (
 	"strings"
 )
 
-func decodeHeader(msg []byte) (header Header) {
+// headerLen is the fixed size of the DNS header (RFC 1035 4.1.1).
+const headerLen = 12
+
+func decodeHeader(msg []byte) (Header, error) {
+
+	if len(msg) < headerLen {
+		return Header{}, &TruncatedHeaderError{Offset: len(msg)}
+	}
 
 	id := binary.BigEndian.Uint16(msg[0:2])
 	flags := binary.BigEndian.Uint16(msg[2:4])
@@ -21,7 +28,9 @@ func decodeHeader(msg []byte) (header Header) {
 		RD:     (flags>>8)&1 == 1,
 		RA:     (flags>>7)&1 == 1,
 
-		Z:     uint8((flags >> 4) & 0x7),
+		Z:     uint8((flags >> 6) & 1),
+		AD:    (flags>>5)&1 == 1,
+		CD:    (flags>>4)&1 == 1,
 		Rcode: uint8(flags & 0xF),
 
 		QDCount: binary.BigEndian.Uint16(msg[4:6]),
@@ -30,102 +39,261 @@ func decodeHeader(msg []byte) (header Header) {
 		ARCount: binary.BigEndian.Uint16(msg[10:12]),
 	}
 
-	return hdr
+	return hdr, nil
 }
 
-// TODO: functionize this function
-func decodeQuestion(questionBytes []byte) (Question, int) {
-
-	// Block to decode
-	/* 		"7466"+"0100"+"0001"+"0000"+"0000"+"0000"+  // HEADER
-	"03777777"+"057961686f6f"+"03636f6d"+"00"+ // QNAME
-	"0001"+"0001", */ //QTYPE + QCLASS
-
-	//Take off header
-	offset := 12
+// decodeQuestion reads a single question entry starting at offset and
+// returns it along with the offset of the byte straight after it.
+//
+//	"03777777"+"057961686f6f"+"03636f6d"+"00"+ // QNAME
+//	"0001"+"0001"                               // QTYPE + QCLASS
+func decodeQuestion(msg []byte, offset int, sp *spanLog) (Question, int, error) {
 
-	questionName, offset := decodeName(questionBytes, offset)
+	name, offset, err := decodeNameSpans(msg, offset, sp)
+	if err != nil {
+		return Question{}, offset, err
+	}
 
-	fmt.Printf("\n Current offset after decodeName() is: %d \n", offset)
+	// Type (2 bytes) + Class (2 bytes)
+	if offset+4 > len(msg) {
+		return Question{}, offset, &TruncatedError{Offset: offset, Field: "question type/class", Need: 4, Have: len(msg) - offset}
+	}
 
 	q := Question{
-		Name:  questionName,
-		Type:  TypeA,   //need to overwrite this with actual value
-		Class: ClassIN, //need to overwrite this with actual value
+		Name:  name,
+		Type:  binary.BigEndian.Uint16(msg[offset : offset+2]),
+		Class: binary.BigEndian.Uint16(msg[offset+2 : offset+4]),
 	}
+	sp.add(offset, 2, "QTYPE", TypeToString(q.Type))
+	sp.add(offset+2, 2, "QCLASS", ClassToString(q.Class))
 
-	// Type (2 bytes) + Class (2 bytes)
-	offset += 4 // 4 bytes total
-
-	return q, offset
+	return q, offset + 4, nil
 }
 
-// TODO: Fille out this function
-func decodeName(encodedName []byte, offset int) (string, int) {
+// decodeResourceRecord reads one RR (answer, authority or additional - they
+// all share the same layout) starting at offset.
+//
+//	NAME | TYPE (2) | CLASS (2) | TTL (4) | RDLENGTH (2) | RDATA (RDLENGTH)
+func decodeResourceRecord(msg []byte, offset int, sp *spanLog) (ResourceRecord, int, error) {
 
-	printByteArrayAsHex(encodedName)
+	name, offset, err := decodeNameSpans(msg, offset, sp)
+	if err != nil {
+		return ResourceRecord{}, offset, err
+	}
 
-	printByteArrayAsASCII(encodedName)
+	if offset+10 > len(msg) {
+		return ResourceRecord{}, offset, &TruncatedError{Offset: offset, Field: "record type/class/ttl/rdlength", Need: 10, Have: len(msg) - offset}
+	}
 
-	// Decoding
-	// 03 77 77 77 05 79 61 68 6f 6f 03 63 6f 6d 00
-	// Check hex read those bytes and next hex is length again (unless 0x00)
-	var labels []string
+	rr := ResourceRecord{
+		Name:     name,
+		Type:     binary.BigEndian.Uint16(msg[offset : offset+2]),
+		Class:    binary.BigEndian.Uint16(msg[offset+2 : offset+4]),
+		TTL:      binary.BigEndian.Uint32(msg[offset+4 : offset+8]),
+		RDLength: binary.BigEndian.Uint16(msg[offset+8 : offset+10]),
+	}
+	sp.add(offset, 2, "TYPE", TypeToString(rr.Type))
+	if rr.Type == TypeOPT {
+		// OPT borrows these two for EDNS (see edns.go)
+		sp.add(offset+2, 2, "UDP size", fmt.Sprint(rr.Class))
+		sp.add(offset+4, 4, "EDNS flags", fmt.Sprintf("0x%08x", rr.TTL))
+	} else {
+		sp.add(offset+2, 2, "CLASS", ClassToString(rr.Class))
+		sp.add(offset+4, 4, "TTL", fmt.Sprint(rr.TTL))
+	}
+	sp.add(offset+8, 2, "RDLENGTH", fmt.Sprint(rr.RDLength))
+	offset += 10
 
-	fmt.Printf("\nTest - labels = %s", labels)
+	end := offset + int(rr.RDLength)
+	if end > len(msg) {
+		return ResourceRecord{}, offset, &TruncatedError{Offset: offset, Field: "rdata", Need: int(rr.RDLength), Have: len(msg) - offset}
+	}
 
-	for i := offset; i < len(encodedName) && encodedName[i] != 0; {
-		l := int(encodedName[i])
-		i++
-		labels = append(labels, string(encodedName[i:i+l])) // Error thrown here dnstom/internal/dnswire.decodeName({0x140000c2160, 0x1f, 0x1f}) dnstom/internal/dnswire/decode.go:73 +0x244
-		i += l
-		offset++
+	// This is always a copy, so the record doesn't keep the whole packet alive
+	// (or change under us if the caller reuses their read buffer). Names inside
+	// RDATA get decompressed on the way, so RDLength is updated to match.
+	rdata, err := expandRData(msg, offset, end, rr.Type, sp)
+	if err != nil {
+		return ResourceRecord{}, offset, err
 	}
+	rr.RData = rdata
+	rr.RDLength = uint16(len(rdata))
 
-	decodedName := strings.Join(labels, ".")
+	return rr, end, nil
+}
 
-	fmt.Println(decodedName)
+// decodeName reads a (possibly compressed) domain name starting at offset.
+// It returns the name in absolute form ("www.example.com.", with any dots
+// inside labels escaped - see name.go) and the offset of the first byte
+// after the name *where it started* - i.e. once a compression pointer is
+// followed, the returned offset is just past the 2-byte pointer, not
+// wherever the pointer took us.
+//
+//	03 77 77 77 05 79 61 68 6f 6f 03 63 6f 6d 00   (www.yahoo.com.)
+//	c0 0c                                          (pointer to offset 12)
+func decodeName(msg []byte, offset int) (string, int, error) {
+	return decodeNameSpans(msg, offset, nil)
+}
 
-	return decodedName, offset
+// decodeNameSpans is decodeName, also logging the labels and pointer it
+// read in place to sp. What a pointer leads to was logged where it was read
+// the first time.
+func decodeNameSpans(msg []byte, offset int, sp *spanLog) (string, int, error) {
 
+	var labels []string
+	pointer, jumpedAt := -1, 0 // the pointer's span, and how many labels we had by then
+
+	pos := offset
+	next := -1 // offset after the name in the original position, set once we jump
+
+	// Pointers may only point backwards (RFC 1035 4.1.4). On top of that each
+	// jump has to land before the last place we jumped to - landing anywhere
+	// in [last target, this pointer) means we've already read those labels
+	// and will end up back here again.
+	lastTarget := offset
+
+	wireLen := 0
+
+	for {
+		if pos >= len(msg) {
+			return "", offset, &LabelOverrunError{Offset: pos}
+		}
+
+		l := int(msg[pos])
+
+		switch l & 0xC0 {
+		case 0x00:
+			// Ordinary label (or the root label that ends the name)
+			wireLen += l + 1
+			if wireLen > MaxNameLength {
+				return "", offset, &NameTooLongError{Offset: offset, Length: wireLen}
+			}
+			pos++
+			if l == 0 {
+				if next < 0 {
+					sp.add(pos-1, 1, "root label", "")
+					next = pos
+				}
+				if pointer >= 0 {
+					sp.spans[pointer].Value += " " + strings.Join(labels[jumpedAt:], ".") + "."
+				}
+				return strings.Join(labels, ".") + ".", next, nil
+			}
+			if pos+l > len(msg) {
+				return "", offset, &LabelOverrunError{Offset: pos - 1, Length: l}
+			}
+			if next < 0 {
+				sp.add(pos-1, 1, "label length", fmt.Sprint(l))
+				sp.add(pos, l, "label", quote(msg[pos:pos+l]))
+			}
+			labels = append(labels, escapeLabel(msg[pos:pos+l]))
+			pos += l
+
+		case 0xC0:
+			// Compression pointer: 11 + 14-bit offset from the start of the message
+			if pos+2 > len(msg) {
+				return "", offset, &TruncatedError{Offset: pos, Field: "compression pointer", Need: 2, Have: len(msg) - pos}
+			}
+			target := int(binary.BigEndian.Uint16(msg[pos:pos+2]) & 0x3FFF)
+			switch {
+			case target > pos:
+				return "", offset, &PointerForwardError{Offset: pos, Target: target}
+			case target >= lastTarget:
+				return "", offset, &PointerLoopError{Offset: pos, Target: target}
+			}
+			if next < 0 {
+				pointer, jumpedAt = sp.addPointer(pos, target), len(labels)
+				next = pos + 2
+			}
+			lastTarget = target
+			pos = target
+
+		default:
+			// 01 and 10 are reserved (the old EDNS extended label types)
+			return "", offset, &LabelTypeError{Offset: pos, Type: byte(l & 0xC0)}
+		}
+	}
 }
 
+// DecodeName reads a domain name from b starting at offset, following any
+// compression pointers, and returns it in absolute form along with the
+// offset just past it. It's the same decoder DecodeMessage uses, exposed for
+// packages (like internal/rr) that pick apart RDATA.
+func DecodeName(b []byte, offset int) (string, int, error) {
+	return decodeName(b, offset)
+}
+
+// DecodeMessage decodes a complete DNS message: the header, QDCOUNT
+// questions, then ANCOUNT/NSCOUNT/ARCOUNT resource records.
+//
+// Malformed input never panics; the error wraps one of the types in
+// errors.go (use errors.As) along with which section/record it was in.
 func DecodeMessage(encodedMessage []byte) (Message, error) {
+	return decodeMessage(encodedMessage, nil)
+}
 
-	// NOTE: Since the whole message is decoded here, we don't need to worry about the offset.
-	// The offset's relevance finishes at the end of the message.
+// decodeMessage is DecodeMessage, logging what each byte is to sp as it
+// goes (see DecodeMessageSpans).
+func decodeMessage(encodedMessage []byte, sp *spanLog) (Message, error) {
 
-	/* 	The goal will be to return a struct that looks like this:
-	   	type Message struct {
-	   		Header     Header
-	   		Questions  []Question
-	   		Answers    []ResourceRecord
-	   		Authority  []ResourceRecord
-	   		Additional []ResourceRecord
-	   	} */
+	hdr, err := decodeHeader(encodedMessage)
+	if err != nil {
+		return Message{}, err
+	}
+	sp.header(hdr)
 
 	m := Message{
-		Header:     decodeHeader(encodedMessage),
-		Questions:  decodeQuestion(encodedMessage),
-		Authority:  decodeAuthority(encodedMessage),
-		Additional: decodeAdditional(encodedMessage),
+		Header: hdr,
 	}
 
-	return m
-}
+	offset := headerLen
 
-func decodeAdditional(encodedMessage []byte) ResourceRecord {
-	panic("unimplemented")
-}
+	for i := 0; i < int(m.Header.QDCount); i++ {
+		sp.at(fmt.Sprintf("question %d", i+1))
+		q, next, err := decodeQuestion(encodedMessage, offset, sp)
+		if err != nil {
+			return m, fmt.Errorf("question %d: %w", i, err)
+		}
+		m.Questions = append(m.Questions, q)
+		offset = next
+	}
 
-func decodeAuthority(encodedMessage []byte) ResourceRecord {
-	panic("unimplemented")
-}
+	// The three RR sections are the same format, so walk them in order
+	sections := []struct {
+		name  string
+		count uint16
+		dst   *[]ResourceRecord
+	}{
+		{"answer", m.Header.ANCount, &m.Answers},
+		{"authority", m.Header.NSCount, &m.Authority},
+		{"additional", m.Header.ARCount, &m.Additional},
+	}
 
-/*
-	q, off2, err := decodeQuestion(packet []byte, off)
-	if err != nil {
-		t.Fatalf("decodeQuestion error: %v", err)
-	} */
+	for _, s := range sections {
+		for i := 0; i < int(s.count); i++ {
+			sp.at(fmt.Sprintf("%s %d", s.name, i+1))
+			rr, next, err := decodeResourceRecord(encodedMessage, offset, sp)
+			if err != nil {
+				return m, fmt.Errorf("%s %d: %w", s.name, i, err)
+			}
+			offset = next
+
+			// The EDNS pseudo-RR gets pulled out into m.OPT (RDATA isn't
+			// touched by expandRData for OPT, so it ends right at next)
+			if rr.Type == TypeOPT && s.dst == &m.Additional {
+				rdataOffset := next - len(rr.RData)
+				if m.OPT != nil {
+					return m, fmt.Errorf("%s %d: %w", s.name, i, &OPTError{Offset: rdataOffset, Reason: "more than one OPT record"})
+				}
+				if m.OPT, err = decodeOPT(rr, rdataOffset); err != nil {
+					return m, fmt.Errorf("%s %d: %w", s.name, i, err)
+				}
+				continue
+			}
+
+			*s.dst = append(*s.dst, rr)
+		}
+	}
 
-// Data received from www.example.com: Bytes received: 12   -    6caa81010000000000000000
+	return m, nil
+}
@@ -0,0 +1,137 @@
+package dnswire
+
+import (
+	"errors"
+	"testing"
+)
+
+// Hostile / broken packets: every one of these must come back as a typed
+// error with the right offset, never a panic.
+
+func TestDecodeError_TruncatedHeader(t *testing.T) {
+	_, err := DecodeMessage(mustHex(t, `db42 8180 0001`))
+
+	var e *TruncatedHeaderError
+	if !errors.As(err, &e) {
+		t.Fatalf("got %v, want *TruncatedHeaderError", err)
+	}
+	if e.Offset != 6 {
+		t.Fatalf("Offset = %d, want 6", e.Offset)
+	}
+}
+
+func TestDecodeError_LabelOverrun(t *testing.T) {
+	// QNAME label claims 0x3f bytes but the message ends after 3
+	wire := mustHex(t, `
+		db42 0100 0001 0000 0000 0000
+		3f77 7777
+	`)
+	_, err := DecodeMessage(wire)
+
+	var e *LabelOverrunError
+	if !errors.As(err, &e) {
+		t.Fatalf("got %v, want *LabelOverrunError", err)
+	}
+	if e.Offset != 12 || e.Length != 0x3f {
+		t.Fatalf("Offset/Length = %d/%d, want 12/63", e.Offset, e.Length)
+	}
+}
+
+func TestDecodeError_PointerForwardReference(t *testing.T) {
+	// QNAME is a pointer to 0x00ff, which is past the pointer itself
+	wire := mustHex(t, `
+		db42 0100 0001 0000 0000 0000
+		c0ff 0001 0001
+	`)
+	_, err := DecodeMessage(wire)
+
+	var e *PointerForwardError
+	if !errors.As(err, &e) {
+		t.Fatalf("got %v, want *PointerForwardError", err)
+	}
+	if e.Offset != 12 || e.Target != 0xff {
+		t.Fatalf("Offset/Target = %d/%d, want 12/255", e.Offset, e.Target)
+	}
+}
+
+func TestDecodeError_PointerLoop(t *testing.T) {
+	// QNAME "a" then a pointer straight back to the start of QNAME
+	wire := mustHex(t, `
+		db42 0100 0001 0000 0000 0000
+		0161 c00c 0001 0001
+	`)
+	_, err := DecodeMessage(wire)
+
+	var e *PointerLoopError
+	if !errors.As(err, &e) {
+		t.Fatalf("got %v, want *PointerLoopError", err)
+	}
+	if e.Offset != 14 || e.Target != 12 {
+		t.Fatalf("Offset/Target = %d/%d, want 14/12", e.Offset, e.Target)
+	}
+}
+
+func TestDecodeError_PointerSelfReference(t *testing.T) {
+	wire := mustHex(t, `
+		db42 0100 0001 0000 0000 0000
+		c00c 0001 0001
+	`)
+	_, err := DecodeMessage(wire)
+
+	var e *PointerLoopError
+	if !errors.As(err, &e) {
+		t.Fatalf("got %v, want *PointerLoopError", err)
+	}
+}
+
+func TestDecodeError_NameTooLong(t *testing.T) {
+	// Five 63-octet labels = 320 octets, well past 255
+	wire := mustHex(t, `db42 0100 0001 0000 0000 0000`)
+	for i := 0; i < 5; i++ {
+		wire = append(wire, 63)
+		for j := 0; j < 63; j++ {
+			wire = append(wire, 'a')
+		}
+	}
+	wire = append(wire, 0, 0, 1, 0, 1)
+
+	_, err := DecodeMessage(wire)
+
+	var e *NameTooLongError
+	if !errors.As(err, &e) {
+		t.Fatalf("got %v, want *NameTooLongError", err)
+	}
+	if e.Offset != 12 {
+		t.Fatalf("Offset = %d, want 12", e.Offset)
+	}
+}
+
+func TestDecodeError_TruncatedRData(t *testing.T) {
+	// Answer claims 4 bytes of RDATA but only 2 are there
+	wire := mustHex(t, `
+		db42 8180 0000 0001 0000 0000
+		0161 00 0001 0001 0000 0258 0004 9b21
+	`)
+	_, err := DecodeMessage(wire)
+
+	var e *TruncatedError
+	if !errors.As(err, &e) {
+		t.Fatalf("got %v, want *TruncatedError", err)
+	}
+	if e.Offset != 25 || e.Need != 4 || e.Have != 2 {
+		t.Fatalf("Offset/Need/Have = %d/%d/%d, want 25/4/2", e.Offset, e.Need, e.Have)
+	}
+}
+
+func TestDecodeError_ReservedLabelType(t *testing.T) {
+	wire := mustHex(t, `
+		db42 0100 0001 0000 0000 0000
+		4161 00 0001 0001
+	`)
+	_, err := DecodeMessage(wire)
+
+	var e *LabelTypeError
+	if !errors.As(err, &e) {
+		t.Fatalf("got %v, want *LabelTypeError", err)
+	}
+}
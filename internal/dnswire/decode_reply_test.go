@@ -126,3 +126,75 @@ func TestDecodeReply_BadCompressionPointer_OutOfRange_ShouldError(t *testing.T)
 		t.Fatalf("expected error due to invalid compression pointer, got nil")
 	}
 }
+
+func TestDecodeReply_Referral_AllSections(t *testing.T) {
+	// Response for: A example.com, with no answer, an NS in authority and its
+	// glue in additional. The glue owner name is a pointer (c029) into the NS RDATA.
+	wire := mustHex(t, `
+		1234 8100 0001 0000 0001 0001
+		0765 7861 6d70 6c65 0363 6f6d 00
+		0001 0001
+		c00c 0002 0001 0000 0e10 0006 036e 7331 c00c
+		c029 0001 0001 0000 0e10 0004 c000 0201
+	`)
+
+	m, err := DecodeMessage(wire)
+	if err != nil {
+		t.Fatalf("DecodeMessage error: %v", err)
+	}
+
+	if len(m.Questions) != 1 || len(m.Answers) != 0 || len(m.Authority) != 1 || len(m.Additional) != 1 {
+		t.Fatalf("sections: QD=%d AN=%d NS=%d AR=%d want 1,0,1,1",
+			len(m.Questions), len(m.Answers), len(m.Authority), len(m.Additional))
+	}
+
+	ns := m.Authority[0]
+	if ns.Name != "example.com." || ns.Type != 2 || ns.TTL != 3600 {
+		t.Fatalf("authority: got %q type=%d ttl=%d", ns.Name, ns.Type, ns.TTL)
+	}
+
+	glue := m.Additional[0]
+	if glue.Name != "ns1.example.com." {
+		t.Fatalf("glue NAME: got %q want %q", glue.Name, "ns1.example.com.")
+	}
+	if !net.IP(glue.RData).Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Fatalf("glue RDATA: got %v want 192.0.2.1", net.IP(glue.RData))
+	}
+}
+
+func TestDecodeReply_CountsLargerThanMessage_ShouldError(t *testing.T) {
+	// Header claims an answer that isn't there
+	wire := mustHex(t, `
+		db42 8180 0001 0001 0000 0000
+		0377 7777 0c6e 6f72 7468 6561 7374 6572 6e03 6564 7500
+		0001 0001
+	`)
+	_, err := DecodeMessage(wire)
+	if err == nil {
+		t.Fatalf("expected error for missing answer, got nil")
+	}
+}
+
+func TestDecodeReply_CNAME_RDataIsDecompressed(t *testing.T) {
+	// CNAME www.example.com -> example.com, with the target as a pointer (c010)
+	wire := mustHex(t, `
+		0001 8180 0001 0001 0000 0000
+		0377 7777 0765 7861 6d70 6c65 0363 6f6d 00
+		0005 0001
+		c00c 0005 0001 0000 012c 0002 c010
+	`)
+
+	m, err := DecodeMessage(wire)
+	if err != nil {
+		t.Fatalf("DecodeMessage error: %v", err)
+	}
+
+	want := mustHex(t, `0765 7861 6d70 6c65 0363 6f6d 00`)
+	got := m.Answers[0]
+	if string(got.RData) != string(want) {
+		t.Fatalf("RData = %x, want %x", got.RData, want)
+	}
+	if int(got.RDLength) != len(want) {
+		t.Fatalf("RDLength = %d, want %d", got.RDLength, len(want))
+	}
+}
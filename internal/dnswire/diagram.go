@@ -4,6 +4,7 @@ package dnswire // change to your package name
 import (
 	"encoding/hex"
 	"fmt"
+	"io"
 	"strings"
 )
 
@@ -20,6 +21,9 @@ const (
 	cWhite  = "\033[97m"
 	cYellow = "\033[93m"
 	cGreen  = "\033[92m"
+	cRed    = "\033[91m"
+	cBlue   = "\033[94m"
+	cPurple = "\033[95m"
 )
 
 func col(s, color string) string {
@@ -29,169 +33,150 @@ func col(s, color string) string {
 	return color + s + cReset
 }
 
-// =============[ TYPES ]=============
+// =============[ ENTRY POINTS ]=============
 
-type DNSHeader struct {
-	ID      uint16
-	Flags   uint16
-	QDCount uint16
-	ANCount uint16
-	NSCount uint16
-	ARCount uint16
-}
-
-type DNSQuestion struct {
-	Name   string // e.g. "www.example.com."
-	QType  uint16
-	QClass uint16
-}
-
-type DNSResourceRecord struct {
-	Name     string
-	Type     uint16
-	Class    uint16
-	TTL      uint32
-	RDLength uint16
-	RData    []byte
-}
+// PrintDNSMessageDiagram draws m the way RFC 1035 4.1 draws a message, one
+// box per field with the value under it. The counts are the ones
+// EncodeMessage would write, and the OPT record (if any) is drawn at the
+// end of the additional section, where it goes on the wire.
+func PrintDNSMessageDiagram(m *Message, w io.Writer) error {
 
-type DNSMessage struct {
-	Header      DNSHeader
-	Questions   []DNSQuestion
-	Answers     []DNSResourceRecord
-	Authorities []DNSResourceRecord
-	Additionals []DNSResourceRecord
-}
-
-// Public entry point
-func PrintDNSMessageDiagram(msg *DNSMessage) {
-	printHeaderDiagram(&msg.Header)
+	p := &printer{w: w}
 
-	if len(msg.Questions) > 0 {
-		fmt.Println()
-		fmt.Println(col(";; QUESTION SECTION:", cCyan+cBold))
-		for i, q := range msg.Questions {
-			printQuestionDiagram(i, &q)
+	additional := m.Additional
+	if m.OPT != nil {
+		opt, err := m.OPT.resourceRecord()
+		if err != nil {
+			return err
 		}
+		additional = append(additional[:len(additional):len(additional)], opt)
 	}
 
-	if len(msg.Answers) > 0 {
-		fmt.Println()
-		fmt.Println(col(";; ANSWER SECTION:", cCyan+cBold))
-		for i, rr := range msg.Answers {
-			printRRDiagram(i, &rr)
+	h := m.Header
+	h.QDCount, h.ANCount = uint16(len(m.Questions)), uint16(len(m.Answers))
+	h.NSCount, h.ARCount = uint16(len(m.Authority)), uint16(len(additional))
+	printHeaderDiagram(p, &h)
+
+	if len(m.Questions) > 0 {
+		p.printf("\n%s\n", col(";; QUESTION SECTION:", cCyan+cBold))
+		for i, q := range m.Questions {
+			printQuestionDiagram(p, i, &q)
 		}
 	}
 
-	if len(msg.Authorities) > 0 {
-		fmt.Println()
-		fmt.Println(col(";; AUTHORITY SECTION:", cCyan+cBold))
-		for i, rr := range msg.Authorities {
-			printRRDiagram(i, &rr)
+	for _, section := range []struct {
+		name    string
+		records []ResourceRecord
+	}{{"ANSWER", m.Answers}, {"AUTHORITY", m.Authority}, {"ADDITIONAL", additional}} {
+		if len(section.records) == 0 {
+			continue
+		}
+		p.printf("\n%s\n", col(";; "+section.name+" SECTION:", cCyan+cBold))
+		for i, rr := range section.records {
+			printRRDiagram(p, i, &rr)
 		}
 	}
 
-	if len(msg.Additionals) > 0 {
-		fmt.Println()
-		fmt.Println(col(";; ADDITIONAL SECTION:", cCyan+cBold))
-		for i, rr := range msg.Additionals {
-			printRRDiagram(i, &rr)
-		}
+	return p.err
+}
+
+// PrintDNSWireDiagram decodes wire and draws it with PrintDNSMessageDiagram,
+// for showing exactly what went over the network.
+func PrintDNSWireDiagram(wire []byte, w io.Writer) error {
+	m, err := DecodeMessage(wire)
+	if err != nil {
+		return err
 	}
+	return PrintDNSMessageDiagram(&m, w)
 }
 
 // =============[ HEADER ]=============
 
-func printHeaderDiagram(h *DNSHeader) {
-	flags := h.Flags
+func printHeaderDiagram(p *printer, h *Header) {
 
-	qr := (flags >> 15) & 0x1
-	opcode := (flags >> 11) & 0xF
-	aa := (flags >> 10) & 0x1
-	tc := (flags >> 9) & 0x1
-	rd := (flags >> 8) & 0x1
-	ra := (flags >> 7) & 0x1
-	z := (flags >> 6) & 0x1
-	ad := (flags >> 5) & 0x1
-	cd := (flags >> 4) & 0x1
-	rcode := flags & 0xF
+	bit := func(b bool) int {
+		if b {
+			return 1
+		}
+		return 0
+	}
 
 	box := col("+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+", cDim)
-	fmt.Println(col(";; HEADER", cCyan+cBold))
-	fmt.Println(box)
-	fmt.Println(col("|                      ID                       |", cWhite))
-	fmt.Printf("%s\n", col(fmt.Sprintf("|                   0x%04x                     |", h.ID), cYellow))
-	fmt.Println(box)
-	fmt.Println(col("|QR|   Opcode  |AA|TC|RD|RA| Z|AD|CD|   RCODE   |", cWhite))
-	fmt.Printf("%s\n", col(
+	p.printf("%s\n", col(";; HEADER", cCyan+cBold))
+	p.printf("%s\n", box)
+	p.printf("%s\n", col("|                      ID                       |", cWhite))
+	p.printf("%s\n", col(fmt.Sprintf("|                   0x%04x                     |", h.ID), cYellow))
+	p.printf("%s\n", box)
+	p.printf("%s\n", col("|QR|   Opcode  |AA|TC|RD|RA| Z|AD|CD|   RCODE   |", cWhite))
+	p.printf("%s\n", col(
 		fmt.Sprintf("| %d|   %4d   | %d| %d| %d| %d| %d| %d| %d|   %4d   |",
-			qr, opcode, aa, tc, rd, ra, z, ad, cd, rcode),
+			bit(h.QR), h.Opcode, bit(h.AA), bit(h.TC), bit(h.RD), bit(h.RA), h.Z&1, bit(h.AD), bit(h.CD), h.Rcode),
 		cYellow,
 	))
-	fmt.Println(box)
-
-	fmt.Println(col("|                    QDCOUNT                    |", cWhite))
-	fmt.Printf("%s\n", col(fmt.Sprintf("|                   %10d                 |", h.QDCount), cYellow))
-	fmt.Println(box)
-
-	fmt.Println(col("|                    ANCOUNT                    |", cWhite))
-	fmt.Printf("%s\n", col(fmt.Sprintf("|                   %10d                 |", h.ANCount), cYellow))
-	fmt.Println(box)
-
-	fmt.Println(col("|                    NSCOUNT                    |", cWhite))
-	fmt.Printf("%s\n", col(fmt.Sprintf("|                   %10d                 |", h.NSCount), cYellow))
-	fmt.Println(box)
-
-	fmt.Println(col("|                    ARCOUNT                    |", cWhite))
-	fmt.Printf("%s\n", col(fmt.Sprintf("|                   %10d                 |", h.ARCount), cYellow))
-	fmt.Println(box)
+	p.printf("%s\n", box)
+
+	for _, c := range []struct {
+		label string
+		n     uint16
+	}{
+		{"|                    QDCOUNT                    |", h.QDCount},
+		{"|                    ANCOUNT                    |", h.ANCount},
+		{"|                    NSCOUNT                    |", h.NSCount},
+		{"|                    ARCOUNT                    |", h.ARCount},
+	} {
+		p.printf("%s\n", col(c.label, cWhite))
+		p.printf("%s\n", col(fmt.Sprintf("|                   %10d                 |", c.n), cYellow))
+		p.printf("%s\n", box)
+	}
 }
 
 // =============[ QUESTION SECTION ]=============
 
-func printQuestionDiagram(index int, q *DNSQuestion) {
+func printQuestionDiagram(p *printer, index int, q *Question) {
 	box := col("+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+", cDim)
 
-	fmt.Println(box)
-	fmt.Println(col(fmt.Sprintf(";; Question %d", index+1), cCyan+cBold))
-	fmt.Println(col("|                     QNAME                     |", cWhite))
-	fmt.Printf("%s\n", col("| "+padRight(q.Name, 45), cYellow))
-	fmt.Println(box)
-	fmt.Println(col("|                    QTYPE                      |", cWhite))
-	fmt.Printf("%s\n", col(fmt.Sprintf("| %-5d (%s)", q.QType, typeToString(q.QType)), cYellow))
-	fmt.Println(box)
-	fmt.Println(col("|                    QCLASS                     |", cWhite))
-	fmt.Printf("%s\n", col(fmt.Sprintf("| %-5d (%s)", q.QClass, classToString(q.QClass)), cYellow))
-	fmt.Println(box)
+	p.printf("%s\n", box)
+	p.printf("%s\n", col(fmt.Sprintf(";; Question %d", index+1), cCyan+cBold))
+	p.printf("%s\n", col("|                     QNAME                     |", cWhite))
+	p.printf("%s\n", col("| "+padRight(q.Name, 45), cYellow))
+	p.printf("%s\n", box)
+	p.printf("%s\n", col("|                    QTYPE                      |", cWhite))
+	p.printf("%s\n", col(fmt.Sprintf("| %-5d (%s)", q.Type, typeToString(q.Type)), cYellow))
+	p.printf("%s\n", box)
+	p.printf("%s\n", col("|                    QCLASS                     |", cWhite))
+	p.printf("%s\n", col(fmt.Sprintf("| %-5d (%s)", q.Class, classToString(q.Class)), cYellow))
+	p.printf("%s\n", box)
 }
 
 // =============[ RESOURCE RECORDS ]=============
 
-func printRRDiagram(index int, rr *DNSResourceRecord) {
+func printRRDiagram(p *printer, index int, rr *ResourceRecord) {
 	box := col("+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+", cDim)
 
-	fmt.Println(box)
-	fmt.Println(col(fmt.Sprintf(";; RR %d", index+1), cCyan+cBold))
-	fmt.Println(col("|                     NAME                      |", cWhite))
-	fmt.Printf("%s\n", col("| "+padRight(rr.Name, 45), cYellow))
-	fmt.Println(box)
-	fmt.Println(col("|      TYPE       |      CLASS      |    TTL    |", cWhite))
-	fmt.Printf("%s\n", col(
+	p.printf("%s\n", box)
+	p.printf("%s\n", col(fmt.Sprintf(";; RR %d", index+1), cCyan+cBold))
+	p.printf("%s\n", col("|                     NAME                      |", cWhite))
+	p.printf("%s\n", col("| "+padRight(rr.Name, 45), cYellow))
+	p.printf("%s\n", box)
+	p.printf("%s\n", col("|      TYPE       |      CLASS      |    TTL    |", cWhite))
+	p.printf("%s\n", col(
 		fmt.Sprintf("| %-5d (%-6s)| %-5d (%-6s)| %9d |",
 			rr.Type, typeToString(rr.Type),
 			rr.Class, classToString(rr.Class),
 			rr.TTL),
 		cYellow,
 	))
-	fmt.Println(box)
-	fmt.Println(col("|                  RDLENGTH                     |", cWhite))
-	fmt.Printf("%s\n", col(fmt.Sprintf("| %10d", rr.RDLength), cYellow))
-	fmt.Println(box)
-	fmt.Println(col("|                     RDATA                     |", cWhite))
+	p.printf("%s\n", box)
+	// RDATA is kept with its names expanded, so this is its length as
+	// EncodeMessage would write it (RDLength isn't set on records built in code)
+	p.printf("%s\n", col("|                  RDLENGTH                     |", cWhite))
+	p.printf("%s\n", col(fmt.Sprintf("| %10d", len(rr.RData)), cYellow))
+	p.printf("%s\n", box)
+	p.printf("%s\n", col("|                     RDATA                     |", cWhite))
 
 	hexData := strings.ToUpper(hex.EncodeToString(rr.RData))
 	if len(hexData) == 0 {
-		fmt.Println(col("| (empty)", cDim))
+		p.printf("%s\n", col("| (empty)", cDim))
 	} else {
 		for len(hexData) > 0 {
 			chunk := hexData
@@ -201,10 +186,10 @@ func printRRDiagram(index int, rr *DNSResourceRecord) {
 			} else {
 				hexData = ""
 			}
-			fmt.Printf("%s\n", col("| "+chunk, cGreen))
+			p.printf("%s\n", col("| "+chunk, cGreen))
 		}
 	}
-	fmt.Println(box)
+	p.printf("%s\n", box)
 }
 
 // =============[ HELPERS ]=============
@@ -217,37 +202,15 @@ func padRight(s string, width int) string {
 }
 
 func typeToString(t uint16) string {
-	switch t {
-	case 1:
-		return "A"
-	case 2:
-		return "NS"
-	case 5:
-		return "CNAME"
-	case 6:
-		return "SOA"
-	case 12:
-		return "PTR"
-	case 15:
-		return "MX"
-	case 16:
-		return "TXT"
-	case 28:
-		return "AAAA"
-	default:
-		return "TYPE" + fmt.Sprint(t)
+	if name, ok := typeNames[t]; ok {
+		return name
 	}
+	return "TYPE" + fmt.Sprint(t)
 }
 
 func classToString(c uint16) string {
-	switch c {
-	case 1:
-		return "IN"
-	case 3:
-		return "CH"
-	case 4:
-		return "HS"
-	default:
-		return "CLASS" + fmt.Sprint(c)
+	if name, ok := classNames[c]; ok {
+		return name
 	}
+	return "CLASS" + fmt.Sprint(c)
 }
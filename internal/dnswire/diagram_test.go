@@ -1,46 +1,76 @@
 package dnswire
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 )
 
-// TestPrintDNSDiagram creates a fully-populated DNSMessage and prints it.
-// It does NOT validate correctness — it's for visual debug + development.
 func TestPrintDNSDiagram(t *testing.T) {
 
-	msg := DNSMessage{
-		Header: DNSHeader{
-			ID:      0x1234,
-			Flags:   0x8180, // Standard "no error" DNS response
-			QDCount: 1,
-			ANCount: 1,
-			NSCount: 0,
-			ARCount: 0,
+	UseColor = false
+	defer func() { UseColor = true }()
+
+	msg := Message{
+		Header: Header{
+			ID: 0x1234,
+			QR: true, RD: true, RA: true, AD: true, CD: true, // a validated reply
 		},
 
-		Questions: []DNSQuestion{
+		Questions: []Question{
 			{
-				Name:   "www.example.com.",
-				QType:  1, // A
-				QClass: 1, // IN
+				Name:  "www.example.com.",
+				Type:  1, // A
+				Class: 1, // IN
 			},
 		},
 
-		Answers: []DNSResourceRecord{
+		Answers: []ResourceRecord{
 			{
-				Name:     "www.example.com.",
-				Type:     1,                        // A
-				Class:    1,                        // IN
-				TTL:      3600,                     // 1 hour
-				RDLength: 4,                        // IPv4
-				RData:    []byte{93, 184, 216, 34}, // 93.184.216.34
+				Name:  "www.example.com.",
+				Type:  1,                        // A
+				Class: 1,                        // IN
+				TTL:   3600,                     // 1 hour
+				RData: []byte{93, 184, 216, 34}, // 93.184.216.34
 			},
 		},
 
-		Authorities: []DNSResourceRecord{}, // none
-		Additionals: []DNSResourceRecord{}, // none
+		OPT: &OPT{UDPSize: 1232, DO: true},
+	}
+
+	var buf bytes.Buffer
+	if err := PrintDNSMessageDiagram(&msg, &buf); err != nil {
+		t.Fatalf("PrintDNSMessageDiagram error: %v", err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"|                   0x1234                     |",
+		"| 1|      0   | 0| 0| 1| 1| 0| 1| 1|      0   |",  // AD and CD come from the header
+		"|                            1                 |", // ANCOUNT
+		";; ADDITIONAL SECTION:",                           // the OPT
+		"| 41    (OPT   )| 1232  (CLASS1232)|     32768 |",
+		"| 5DB8D822",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("diagram missing %q:\n%s", want, out)
+		}
 	}
 
-	// This calls your RFC-style diagram printer.
-	PrintDNSMessageDiagram(&msg)
+	// The same thing off the wire comes out the same
+	wire, err := EncodeMessage(&msg)
+	if err != nil {
+		t.Fatalf("EncodeMessage error: %v", err)
+	}
+	buf.Reset()
+	if err := PrintDNSWireDiagram(wire, &buf); err != nil {
+		t.Fatalf("PrintDNSWireDiagram error: %v", err)
+	}
+	if buf.String() != out {
+		t.Errorf("PrintDNSWireDiagram =\n%s\nwant\n%s", buf.String(), out)
+	}
+
+	if err := PrintDNSWireDiagram(wire[:5], &buf); err == nil {
+		t.Errorf("PrintDNSWireDiagram of 5 bytes: want error")
+	}
 }
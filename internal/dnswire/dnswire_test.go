@@ -3,7 +3,7 @@ package dnswire
 import (
 	"bytes"
 	"encoding/hex"
-	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -81,10 +81,42 @@ func TestDecodeName_WWWYahooCom(t *testing.T) {
 		0x00,
 	}
 	// pffset 0 because there's no header
-	name, offset := decodeName(encoded, 0)
+	name, offset, err := decodeName(encoded, 0)
+	if err != nil {
+		t.Fatalf("decodeName returned an error: %v", err)
+	}
 
-	if name != "www.yahoo.com" {
-		t.Fatalf("decodeName name = %q, want %q, offset = %d", name, "www.yahoo.com", offset)
+	if name != "www.yahoo.com." {
+		t.Fatalf("decodeName name = %q, want %q, offset = %d", name, "www.yahoo.com.", offset)
+	}
+	if offset != len(encoded) {
+		t.Fatalf("decodeName offset = %d, want %d", offset, len(encoded))
+	}
+}
+
+// A dot or backslash inside a label is escaped in the name, and is just
+// another byte on the wire.
+func TestName_EscapedLabels(t *testing.T) {
+	name := `a\.b.c\\d.example.`
+	wire := []byte("\x03a.b\x03c\\d\x07example\x00")
+
+	got, err := encodeName(name)
+	if err != nil || !bytes.Equal(got, wire) {
+		t.Fatalf("encodeName(%q) = %q, %v; want %q", name, got, err, wire)
+	}
+	back, _, err := decodeName(wire, 0)
+	if err != nil || back != name {
+		t.Fatalf("decodeName(%q) = %q, %v; want %q", wire, back, err, name)
+	}
+
+	if labels := Labels(name); len(labels) != 3 || labels[0] != "a.b" || labels[1] != `c\d` {
+		t.Errorf("Labels(%q) = %q", name, labels)
+	}
+	if IsAbsolute(`a\.`) || !IsAbsolute(`a\\.`) {
+		t.Errorf(`IsAbsolute got "a\." or "a\\." wrong`)
+	}
+	if IsSubdomain(`a\.example.com.`, "example.com.") || !IsSubdomain(`a\.b.example.com.`, "EXAMPLE.com.") {
+		t.Errorf("IsSubdomain counted an escaped dot as a label break")
 	}
 }
 
@@ -131,7 +163,10 @@ func TestDecodeHeader_BasicQuery(t *testing.T) {
 	packet := mustHexDecode(t, "7466"+"0100"+"0001"+"0000"+"0000"+"0000")
 
 	// 0 offset because it's the header
-	h := decodeHeader(packet)
+	h, err := decodeHeader(packet)
+	if err != nil {
+		t.Fatalf("decodeHeader returned an error: %v", err)
+	}
 
 	if h.ID != 0x7466 {
 		t.Errorf("ID = 0x%04x, want 0x7466", h.ID)
@@ -225,7 +260,10 @@ func TestDecodeFullQuery_WWWYahooCom_A_IN(t *testing.T) {
 			"0001"+"0001",
 	)
 
-	h := decodeHeader(packet)
+	h, err := decodeHeader(packet)
+	if err != nil {
+		t.Fatalf("decodeHeader returned an error: %v", err)
+	}
 
 	if h.ID != 0x7466 {
 		t.Errorf("ID = 0x%04x, want 0x7466", h.ID)
@@ -237,12 +275,13 @@ func TestDecodeFullQuery_WWWYahooCom_A_IN(t *testing.T) {
 		t.Fatalf("QDCount = %d, want 1", h.QDCount)
 	}
 
-	fmt.Printf(" \n Test -packet length = %d \n", len(packet))
-
-	q, offset := decodeQuestion(packet)
+	q, offset, err := decodeQuestion(packet, 12, nil)
+	if err != nil {
+		t.Fatalf("decodeQuestion returned an error: %v", err)
+	}
 
-	if q.Name != "www.yahoo.com" {
-		t.Errorf("Question.Name = %q, want %q", q.Name, "www.yahoo.com")
+	if q.Name != "www.yahoo.com." {
+		t.Errorf("Question.Name = %q, want %q", q.Name, "www.yahoo.com.")
 	}
 	if q.Type != TypeA {
 		t.Errorf("Question.Type = %d, want %d", q.Type, TypeA)
@@ -251,9 +290,110 @@ func TestDecodeFullQuery_WWWYahooCom_A_IN(t *testing.T) {
 		t.Errorf("Question.Class = %d, want %d", q.Class, ClassIN)
 	}
 
-	// Offset should be 12 +
+	// Offset should be 12 + QNAME (one more byte than the dotted name) + type + class
 	if offset != (12 + len(q.Name) + 1 + 2 + 2) {
-		t.Errorf("Offset = %d, want %d", offset, (len(q.Name) + 4))
+		t.Errorf("Offset = %d, want %d", offset, (12 + len(q.Name) + 1 + 2 + 2))
+	}
+
+}
+
+// ---------- qtype / qclass ----------
+
+func TestEncodeQuestion_HonoursTypeAndClass(t *testing.T) {
+	q := Question{Name: "version.bind.", Type: TypeTXT, Class: ClassCH}
+
+	got, err := encodeQuestion(q)
+	if err != nil {
+		t.Fatalf("encodeQuestion returned an error: %v", err)
+	}
+
+	want := mustHexDecode(t, "0776657273696f6e"+"0462696e64"+"00"+"0010"+"0003")
+	if !bytes.Equal(got, want) {
+		t.Fatalf("encodeQuestion(%+v) = %x, want %x", q, got, want)
+	}
+}
+
+func TestEncodeQuery_AAAA(t *testing.T) {
+	got, err := EncodeQuery("www.yahoo.com", TypeAAAA)
+	if err != nil {
+		t.Fatalf("EncodeQuery returned an error: %v", err)
+	}
+
+	m, err := DecodeMessage(got)
+	if err != nil {
+		t.Fatalf("DecodeMessage returned an error: %v", err)
+	}
+
+	if !m.Header.RD || m.Header.QR || m.Header.QDCount != 1 {
+		t.Errorf("header = %+v, want RD query with one question", m.Header)
+	}
+	q := m.Questions[0]
+	if q.Name != "www.yahoo.com." || q.Type != TypeAAAA || q.Class != ClassIN {
+		t.Errorf("question = %+v, want www.yahoo.com. AAAA IN", q)
+	}
+}
+
+func TestEncodeQueryClass_Chaos(t *testing.T) {
+	got, err := EncodeQueryClass("version.bind.", TypeTXT, ClassCH)
+	if err != nil {
+		t.Fatalf("EncodeQueryClass returned an error: %v", err)
+	}
+
+	// Everything after the header
+	want := mustHexDecode(t, "0776657273696f6e"+"0462696e64"+"00"+"0010"+"0003")
+	if !bytes.Equal(got[12:], want) {
+		t.Fatalf("question = %x, want %x", got[12:], want)
+	}
+}
+
+func TestTypeAndClassNames(t *testing.T) {
+	types := map[uint16]string{
+		TypeA: "A", TypeAAAA: "AAAA", TypeNSAPPTR: "NSAP-PTR", TypeANY: "ANY",
+		TypeHTTPS: "HTTPS", TypeCAA: "CAA", 65280: "TYPE65280",
+	}
+	for v, want := range types {
+		if got := typeToString(v); got != want {
+			t.Errorf("typeToString(%d) = %q, want %q", v, got, want)
+		}
+	}
+
+	classes := map[uint16]string{ClassIN: "IN", ClassCH: "CH", ClassANY: "ANY", 42: "CLASS42"}
+	for v, want := range classes {
+		if got := classToString(v); got != want {
+			t.Errorf("classToString(%d) = %q, want %q", v, got, want)
+		}
 	}
 
+	// And back again, case-insensitively
+	for v, want := range types {
+		if got, ok := TypeFromString(strings.ToLower(want)); !ok || got != v {
+			t.Errorf("TypeFromString(%q) = %d, %v; want %d", strings.ToLower(want), got, ok, v)
+		}
+	}
+	for v, want := range classes {
+		if got, ok := ClassFromString(want); !ok || got != v {
+			t.Errorf("ClassFromString(%q) = %d, %v; want %d", want, got, ok, v)
+		}
+	}
+	for _, bad := range []string{"", "BOGUS", "TYPE", "TYPE65536", "CLASS-1"} {
+		if v, ok := TypeFromString(bad); ok {
+			t.Errorf("TypeFromString(%q) = %d, want no match", bad, v)
+		}
+	}
+}
+
+func TestOpcodeAndRcodeNames(t *testing.T) {
+	opcodes := map[uint8]string{0: "QUERY", 4: "NOTIFY", 5: "UPDATE", 3: "RESERVED3"}
+	for v, want := range opcodes {
+		if got := OpcodeToString(v); got != want {
+			t.Errorf("OpcodeToString(%d) = %q, want %q", v, got, want)
+		}
+	}
+
+	rcodes := map[uint16]string{0: "NOERROR", 2: "SERVFAIL", 3: "NXDOMAIN", 16: "BADVERS", 23: "BADCOOKIE", 12: "RESERVED12"}
+	for v, want := range rcodes {
+		if got := RcodeToString(v); got != want {
+			t.Errorf("RcodeToString(%d) = %q, want %q", v, got, want)
+		}
+	}
 }
@@ -0,0 +1,162 @@
+package dnswire
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// EDNS(0) (RFC 6891).
+//
+// EDNS hangs off a pseudo-RR of type OPT in the additional section. It isn't
+// real data - the usual RR fields are reused for something else entirely:
+//
+//	NAME     always the root (00)
+//	TYPE     41 (OPT)
+//	CLASS    requestor's UDP payload size
+//	TTL      extended RCODE (8) | version (8) | DO (1) | Z (15)
+//	RDATA    zero or more options: CODE (2) | LENGTH (2) | DATA (LENGTH)
+//
+// so rather than leave it sitting in Message.Additional with a "class" of
+// 1232, DecodeMessage lifts it out into Message.OPT and EncodeMessage puts it
+// back on the end of the additional section.
+
+// DefaultUDPSize is the payload size we advertise unless told otherwise. 1232
+// is the DNS Flag Day 2020 recommendation: big enough for most answers, small
+// enough not to fragment on any sane path.
+const DefaultUDPSize = 1232
+
+// OPT is the decoded EDNS(0) pseudo-RR.
+type OPT struct {
+	UDPSize       uint16 // largest UDP reply the sender can take
+	ExtendedRcode uint8  // upper 8 bits of the 12-bit RCODE (see Message.Rcode)
+	Version       uint8  // EDNS version, 0 is the only one there is
+	DO            bool   // DNSSEC OK
+	Z             uint16 // the other 15 flag bits, must be zero for now
+	Options       []EDNSOption
+}
+
+// EDNSOption is one option from the OPT RDATA.
+type EDNSOption interface {
+	// Code is the option code (e.g. 3 for NSID).
+	Code() uint16
+
+	// Pack returns the option data, without the code/length prefix.
+	Pack() ([]byte, error)
+
+	// String renders the option the way dig's OPT PSEUDOSECTION does.
+	String() string
+}
+
+// GenericOption carries any option we don't have a type for, as raw bytes.
+type GenericOption struct {
+	OptionCode uint16
+	Data       []byte
+}
+
+func (o *GenericOption) Code() uint16          { return o.OptionCode }
+func (o *GenericOption) Pack() ([]byte, error) { return append([]byte(nil), o.Data...), nil }
+
+// String is the "; EDNS:" line from dig's OPT PSEUDOSECTION.
+func (o *OPT) String() string {
+	flags := ""
+	if o.DO {
+		flags = " do"
+	}
+	if o.Z != 0 {
+		flags += fmt.Sprintf(" MBZ: 0x%04x", o.Z)
+	}
+	return fmt.Sprintf("EDNS: version: %d, flags:%s; udp: %d", o.Version, flags, o.UDPSize)
+}
+
+// NewOPT returns an OPT advertising DefaultUDPSize with no options.
+func NewOPT() *OPT {
+	return &OPT{UDPSize: DefaultUDPSize}
+}
+
+// Rcode returns the full response code: the 4 bits in the header plus, if
+// there's an OPT record, the 8 extended bits above them (RFC 6891 6.1.3).
+func (m *Message) Rcode() uint16 {
+	rcode := uint16(m.Header.Rcode)
+	if m.OPT != nil {
+		rcode |= uint16(m.OPT.ExtendedRcode) << 4
+	}
+	return rcode
+}
+
+// decodeOPT turns the OPT pseudo-RR into an *OPT. rdataOffset is where its
+// RDATA started in the message, so errors point at the right byte.
+func decodeOPT(rr ResourceRecord, rdataOffset int) (*OPT, error) {
+
+	if rr.Name != "." {
+		return nil, &OPTError{Offset: rdataOffset, Reason: fmt.Sprintf("owner name is %q, must be the root", rr.Name)}
+	}
+
+	opt := &OPT{
+		UDPSize:       rr.Class,
+		ExtendedRcode: uint8(rr.TTL >> 24),
+		Version:       uint8(rr.TTL >> 16),
+		DO:            (rr.TTL>>15)&1 == 1,
+		Z:             uint16(rr.TTL & 0x7FFF),
+	}
+
+	data := rr.RData
+	for pos := 0; pos < len(data); {
+		if pos+4 > len(data) {
+			return nil, &TruncatedError{Offset: rdataOffset + pos, Field: "edns option header", Need: 4, Have: len(data) - pos}
+		}
+		code := binary.BigEndian.Uint16(data[pos : pos+2])
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		pos += 4
+
+		if pos+length > len(data) {
+			return nil, &TruncatedError{Offset: rdataOffset + pos, Field: "edns option data", Need: length, Have: len(data) - pos}
+		}
+
+		// A mangled cookie or subnet (middleboxes do this) isn't worth
+		// losing the whole reply over - keep the bytes and carry on
+		o, err := decodeEDNSOption(code, data[pos:pos+length])
+		if err != nil {
+			o = &GenericOption{OptionCode: code, Data: append([]byte(nil), data[pos:pos+length]...)}
+		}
+		opt.Options = append(opt.Options, o)
+		pos += length
+	}
+
+	return opt, nil
+}
+
+// resourceRecord turns the OPT back into the pseudo-RR that goes on the wire.
+func (o *OPT) resourceRecord() (ResourceRecord, error) {
+
+	if o.Z > 0x7FFF {
+		return ResourceRecord{}, fmt.Errorf("OPT Z %#x does not fit in 15 bits", o.Z)
+	}
+
+	ttl := uint32(o.ExtendedRcode)<<24 | uint32(o.Version)<<16 | uint32(o.Z)
+	if o.DO {
+		ttl |= 1 << 15
+	}
+
+	var rdata []byte
+	for _, opt := range o.Options {
+		data, err := opt.Pack()
+		if err != nil {
+			return ResourceRecord{}, fmt.Errorf("edns option %d: %w", opt.Code(), err)
+		}
+		if len(data) > 0xFFFF {
+			return ResourceRecord{}, fmt.Errorf("edns option %d: %d bytes, longer than 65535", opt.Code(), len(data))
+		}
+		rdata = appendUint16(rdata, opt.Code())
+		rdata = appendUint16(rdata, uint16(len(data)))
+		rdata = append(rdata, data...)
+	}
+
+	return ResourceRecord{
+		Name:     ".",
+		Type:     TypeOPT,
+		Class:    o.UDPSize,
+		TTL:      ttl,
+		RDLength: uint16(len(rdata)),
+		RData:    rdata,
+	}, nil
+}
@@ -0,0 +1,326 @@
+package dnswire
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// EDNS option codes (IANA "DNS EDNS0 Option Codes (OPT)").
+const (
+	OptionNSID          uint16 = 3  // RFC 5001
+	OptionClientSubnet  uint16 = 8  // RFC 7871
+	OptionCookie        uint16 = 10 // RFC 7873
+	OptionTCPKeepalive  uint16 = 11 // RFC 7828
+	OptionPadding       uint16 = 12 // RFC 7830
+	OptionExtendedError uint16 = 15 // RFC 8914
+)
+
+// decodeEDNSOption picks the type for an option code. Codes we don't know
+// come back as a GenericOption; a known option that doesn't parse is an
+// error (decodeOPT then keeps it as a GenericOption anyway).
+func decodeEDNSOption(code uint16, data []byte) (EDNSOption, error) {
+
+	switch code {
+	case OptionNSID:
+		return &NSID{Data: append([]byte(nil), data...)}, nil
+	case OptionClientSubnet:
+		return decodeClientSubnet(data)
+	case OptionCookie:
+		return decodeCookie(data)
+	case OptionTCPKeepalive:
+		return decodeTCPKeepalive(data)
+	case OptionPadding:
+		return &Padding{Length: len(data)}, nil
+	case OptionExtendedError:
+		return decodeExtendedError(data)
+	default:
+		return &GenericOption{OptionCode: code, Data: append([]byte(nil), data...)}, nil
+	}
+}
+
+// ---------- NSID (RFC 5001) ----------
+
+// NSID is the name server identifier. Send it empty in a query to ask for it;
+// the reply carries whatever the server operator chose (often a hostname).
+type NSID struct {
+	Data []byte
+}
+
+func (o *NSID) Code() uint16          { return OptionNSID }
+func (o *NSID) Pack() ([]byte, error) { return append([]byte(nil), o.Data...), nil }
+
+// String is dig's format: hex bytes, then the printable version in quotes.
+func (o *NSID) String() string {
+	return "NSID: " + hexAndText(o.Data)
+}
+
+// ---------- Client Subnet (RFC 7871) ----------
+
+// ClientSubnet is EDNS Client Subnet: which network the original client is
+// in, so a CDN can answer with something close to it.
+type ClientSubnet struct {
+	Family       uint16 // 1 = IPv4, 2 = IPv6
+	SourcePrefix uint8  // how much of Address the sender is telling us about
+	ScopePrefix  uint8  // how much of it the answer depends on (0 in queries)
+	Address      net.IP
+}
+
+func (o *ClientSubnet) Code() uint16 { return OptionClientSubnet }
+
+// Pack writes just enough of the address to cover SourcePrefix, with the
+// bits after the prefix zeroed as the RFC requires.
+func (o *ClientSubnet) Pack() ([]byte, error) {
+
+	var addr net.IP
+	switch o.Family {
+	case 1:
+		addr = o.Address.To4()
+	case 2:
+		addr = o.Address.To16()
+	default:
+		return nil, fmt.Errorf("client subnet: unknown family %d", o.Family)
+	}
+	if addr == nil {
+		return nil, fmt.Errorf("client subnet: %v is not a family %d address", o.Address, o.Family)
+	}
+	if int(o.SourcePrefix) > len(addr)*8 || int(o.ScopePrefix) > len(addr)*8 {
+		return nil, fmt.Errorf("client subnet: prefix /%d/%d too long for family %d", o.SourcePrefix, o.ScopePrefix, o.Family)
+	}
+
+	masked := addr.Mask(net.CIDRMask(int(o.SourcePrefix), len(addr)*8))
+
+	b := binary.BigEndian.AppendUint16(nil, o.Family)
+	b = append(b, o.SourcePrefix, o.ScopePrefix)
+	return append(b, masked[:(int(o.SourcePrefix)+7)/8]...), nil
+}
+
+// String is dig's format, e.g. "CLIENT-SUBNET: 192.0.2.0/24/0".
+func (o *ClientSubnet) String() string {
+	return fmt.Sprintf("CLIENT-SUBNET: %s/%d/%d", o.Address, o.SourcePrefix, o.ScopePrefix)
+}
+
+func decodeClientSubnet(data []byte) (*ClientSubnet, error) {
+
+	if len(data) < 4 {
+		return nil, fmt.Errorf("client subnet: %d bytes, need at least 4", len(data))
+	}
+
+	o := &ClientSubnet{
+		Family:       binary.BigEndian.Uint16(data[0:2]),
+		SourcePrefix: data[2],
+		ScopePrefix:  data[3],
+	}
+
+	var size int
+	switch o.Family {
+	case 1:
+		size = net.IPv4len
+	case 2:
+		size = net.IPv6len
+	default:
+		return nil, fmt.Errorf("client subnet: unknown family %d", o.Family)
+	}
+
+	addr := data[4:]
+	if len(addr) > size || len(addr) != (int(o.SourcePrefix)+7)/8 {
+		return nil, fmt.Errorf("client subnet: %d address bytes for /%d in family %d", len(addr), o.SourcePrefix, o.Family)
+	}
+
+	o.Address = make(net.IP, size)
+	copy(o.Address, addr)
+
+	return o, nil
+}
+
+// ---------- Cookie (RFC 7873) ----------
+
+// Cookie is a DNS cookie: an 8 byte client cookie, plus (in replies, and in
+// later queries to the same server) an 8-32 byte server cookie.
+type Cookie struct {
+	Client []byte
+	Server []byte
+}
+
+func (o *Cookie) Code() uint16 { return OptionCookie }
+
+func (o *Cookie) Pack() ([]byte, error) {
+	if len(o.Client) != 8 {
+		return nil, fmt.Errorf("cookie: client cookie is %d bytes, must be 8", len(o.Client))
+	}
+	if len(o.Server) != 0 && (len(o.Server) < 8 || len(o.Server) > 32) {
+		return nil, fmt.Errorf("cookie: server cookie is %d bytes, must be 8-32", len(o.Server))
+	}
+	return append(append([]byte(nil), o.Client...), o.Server...), nil
+}
+
+// String is dig's format: client then server cookie as one hex string.
+func (o *Cookie) String() string {
+	return "COOKIE: " + hex.EncodeToString(o.Client) + hex.EncodeToString(o.Server)
+}
+
+func decodeCookie(data []byte) (*Cookie, error) {
+	if len(data) != 8 && (len(data) < 16 || len(data) > 40) {
+		return nil, fmt.Errorf("cookie: %d bytes, must be 8 or 16-40", len(data))
+	}
+	o := &Cookie{Client: append([]byte(nil), data[:8]...)}
+	if len(data) > 8 {
+		o.Server = append([]byte(nil), data[8:]...)
+	}
+	return o, nil
+}
+
+// ---------- TCP keepalive (RFC 7828) ----------
+
+// TCPKeepalive asks for (empty, in a query) or grants (in a reply) an idle
+// timeout for the TCP connection, in units of 100 milliseconds.
+type TCPKeepalive struct {
+	HasTimeout bool
+	Timeout    uint16
+}
+
+func (o *TCPKeepalive) Code() uint16 { return OptionTCPKeepalive }
+
+func (o *TCPKeepalive) Pack() ([]byte, error) {
+	if !o.HasTimeout {
+		return nil, nil
+	}
+	return binary.BigEndian.AppendUint16(nil, o.Timeout), nil
+}
+
+func (o *TCPKeepalive) String() string {
+	if !o.HasTimeout {
+		return "TCP-KEEPALIVE"
+	}
+	return fmt.Sprintf("TCP-KEEPALIVE: %d.%d secs", o.Timeout/10, o.Timeout%10)
+}
+
+func decodeTCPKeepalive(data []byte) (*TCPKeepalive, error) {
+	switch len(data) {
+	case 0:
+		return &TCPKeepalive{}, nil
+	case 2:
+		return &TCPKeepalive{HasTimeout: true, Timeout: binary.BigEndian.Uint16(data)}, nil
+	default:
+		return nil, fmt.Errorf("tcp keepalive: %d bytes, must be 0 or 2", len(data))
+	}
+}
+
+// ---------- Padding (RFC 7830) ----------
+
+// Padding is filler to hide the real size of an encrypted message. The
+// contents are meant to be zeros and nobody should care, so we only keep
+// the length.
+type Padding struct {
+	Length int
+}
+
+func (o *Padding) Code() uint16          { return OptionPadding }
+func (o *Padding) Pack() ([]byte, error) { return make([]byte, o.Length), nil }
+
+func (o *Padding) String() string {
+	return fmt.Sprintf("PAD: (%d bytes)", o.Length)
+}
+
+// ---------- Extended DNS Errors (RFC 8914) ----------
+
+// ExtendedError says *why* a server gave the answer it did - "Blocked",
+// "DNSSEC Bogus", "Stale Answer" and so on - with optional free text.
+type ExtendedError struct {
+	InfoCode  uint16
+	ExtraText string
+}
+
+// extendedErrorNames are the INFO-CODEs registered at IANA.
+var extendedErrorNames = map[uint16]string{
+	0:  "Other",
+	1:  "Unsupported DNSKEY Algorithm",
+	2:  "Unsupported DS Digest Type",
+	3:  "Stale Answer",
+	4:  "Forged Answer",
+	5:  "DNSSEC Indeterminate",
+	6:  "DNSSEC Bogus",
+	7:  "Signature Expired",
+	8:  "Signature Not Yet Valid",
+	9:  "DNSKEY Missing",
+	10: "RRSIGs Missing",
+	11: "No Zone Key Bit Set",
+	12: "NSEC Missing",
+	13: "Cached Error",
+	14: "Not Ready",
+	15: "Blocked",
+	16: "Censored",
+	17: "Filtered",
+	18: "Prohibited",
+	19: "Stale NXDOMAIN Answer",
+	20: "Not Authoritative",
+	21: "Not Supported",
+	22: "No Reachable Authority",
+	23: "Network Error",
+	24: "Invalid Data",
+	25: "Signature Expired before Valid",
+	26: "Too Early",
+	27: "Unsupported NSEC3 Iterations Value",
+	28: "Unable to conform to policy",
+	29: "Synthesized",
+}
+
+func (o *ExtendedError) Code() uint16 { return OptionExtendedError }
+
+func (o *ExtendedError) Pack() ([]byte, error) {
+	return append(binary.BigEndian.AppendUint16(nil, o.InfoCode), o.ExtraText...), nil
+}
+
+// String is dig's format, e.g. `EDE: 18 (Prohibited): (blocked by policy)`.
+func (o *ExtendedError) String() string {
+	s := fmt.Sprintf("EDE: %d", o.InfoCode)
+	if name, ok := extendedErrorNames[o.InfoCode]; ok {
+		s += " (" + name + ")"
+	}
+	if o.ExtraText != "" {
+		s += ": (" + o.ExtraText + ")"
+	}
+	return s
+}
+
+func decodeExtendedError(data []byte) (*ExtendedError, error) {
+	if len(data) < 2 {
+		return nil, fmt.Errorf("extended error: %d bytes, need at least 2", len(data))
+	}
+	return &ExtendedError{
+		InfoCode: binary.BigEndian.Uint16(data),
+		// The RFC says the text is UTF-8 and *not* NUL terminated, but some
+		// servers send one anyway
+		ExtraText: strings.TrimRight(string(data[2:]), "\x00"),
+	}, nil
+}
+
+// ---------- Generic ----------
+
+// String is dig's format for options it doesn't know, e.g. `OPT=65001: 01 02 ("..")`.
+func (o *GenericOption) String() string {
+	return fmt.Sprintf("OPT=%d: %s", o.OptionCode, hexAndText(o.Data))
+}
+
+// hexAndText renders b as space separated hex followed by the printable
+// ASCII version, the way dig shows NSID.
+func hexAndText(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+
+	hexParts := make([]string, len(b))
+	text := make([]byte, len(b))
+	for i, c := range b {
+		hexParts[i] = fmt.Sprintf("%02x", c)
+		if c >= 32 && c <= 126 {
+			text[i] = c
+		} else {
+			text[i] = '.'
+		}
+	}
+
+	return strings.Join(hexParts, " ") + ` ("` + string(text) + `")`
+}
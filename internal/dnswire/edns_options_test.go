@@ -0,0 +1,117 @@
+package dnswire
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEDNSOptions_RoundTrip(t *testing.T) {
+	opts := []EDNSOption{
+		&NSID{Data: []byte("gpdns-ams")},
+		&ClientSubnet{Family: 1, SourcePrefix: 24, ScopePrefix: 0, Address: net.IPv4(192, 0, 2, 0).To4()},
+		&ClientSubnet{Family: 2, SourcePrefix: 56, ScopePrefix: 48, Address: net.ParseIP("2001:db8:1:2300::")},
+		&Cookie{Client: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		&Cookie{Client: []byte{1, 2, 3, 4, 5, 6, 7, 8}, Server: bytes.Repeat([]byte{9}, 16)},
+		&TCPKeepalive{},
+		&TCPKeepalive{HasTimeout: true, Timeout: 1200},
+		&Padding{Length: 37},
+		&ExtendedError{InfoCode: 18, ExtraText: "blocked by policy"},
+		&GenericOption{OptionCode: 65001, Data: []byte{1, 2}},
+	}
+
+	m := &Message{Header: Header{ID: 9, QR: true}, OPT: &OPT{UDPSize: 1232, Options: opts}}
+
+	wire, err := EncodeMessage(m)
+	if err != nil {
+		t.Fatalf("EncodeMessage error: %v", err)
+	}
+
+	got, err := DecodeMessage(wire)
+	if err != nil {
+		t.Fatalf("DecodeMessage error: %v", err)
+	}
+
+	if len(got.OPT.Options) != len(opts) {
+		t.Fatalf("got %d options, want %d", len(got.OPT.Options), len(opts))
+	}
+	for i, want := range opts {
+		if !reflect.DeepEqual(got.OPT.Options[i], want) {
+			t.Errorf("option %d: got %+v want %+v", i, got.OPT.Options[i], want)
+		}
+	}
+}
+
+func TestClientSubnet_PackMasksAndTruncates(t *testing.T) {
+	o := &ClientSubnet{Family: 1, SourcePrefix: 20, Address: net.IPv4(198, 51, 100, 77)}
+
+	got, err := o.Pack()
+	if err != nil {
+		t.Fatalf("Pack error: %v", err)
+	}
+
+	// family 1, /20, scope 0, then 3 bytes with the last 4 bits cleared
+	want := []byte{0, 1, 20, 0, 198, 51, 96}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("Pack = %x, want %x", got, want)
+	}
+}
+
+func TestEDNSOptions_Malformed(t *testing.T) {
+	tests := []struct {
+		name string
+		code uint16
+		data []byte
+	}{
+		{"ecs too short", OptionClientSubnet, []byte{0, 1, 24}},
+		{"ecs bad family", OptionClientSubnet, []byte{0, 9, 0, 0}},
+		{"ecs address longer than prefix", OptionClientSubnet, []byte{0, 1, 8, 0, 10, 0}},
+		{"cookie 12 bytes", OptionCookie, make([]byte, 12)},
+		{"keepalive 1 byte", OptionTCPKeepalive, []byte{1}},
+		{"ede 1 byte", OptionExtendedError, []byte{1}},
+	}
+
+	for _, tt := range tests {
+		if _, err := decodeEDNSOption(tt.code, tt.data); err == nil {
+			t.Errorf("%s: expected error, got nil", tt.name)
+		}
+	}
+}
+
+func TestEDNSOptions_String(t *testing.T) {
+	tests := []struct {
+		opt  EDNSOption
+		want string
+	}{
+		{&NSID{Data: []byte("gpdns")}, `NSID: 67 70 64 6e 73 ("gpdns")`},
+		{&ClientSubnet{Family: 1, SourcePrefix: 24, Address: net.IPv4(192, 0, 2, 0)}, "CLIENT-SUBNET: 192.0.2.0/24/0"},
+		{&Cookie{Client: []byte{1, 2, 3, 4, 5, 6, 7, 8}}, "COOKIE: 0102030405060708"},
+		{&TCPKeepalive{HasTimeout: true, Timeout: 25}, "TCP-KEEPALIVE: 2.5 secs"},
+		{&Padding{Length: 4}, "PAD: (4 bytes)"},
+		{&ExtendedError{InfoCode: 18, ExtraText: "nope"}, "EDE: 18 (Prohibited): (nope)"},
+		{&ExtendedError{InfoCode: 999}, "EDE: 999"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.opt.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestPrettyPrint_OPTPseudosection(t *testing.T) {
+	m := &Message{OPT: &OPT{UDPSize: 1232, DO: true, Options: []EDNSOption{&NSID{Data: []byte("a")}}}}
+
+	var buf bytes.Buffer
+	if err := PrettyPrint(m, &buf); err != nil {
+		t.Fatalf("PrettyPrint error: %v", err)
+	}
+
+	for _, want := range []string{"; EDNS: version: 0, flags: do; udp: 1232", `; NSID: 61 ("a")`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("PrettyPrint output missing %q:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package dnswire
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeMessage_OPT(t *testing.T) {
+	// Query for A example.com with EDNS: 1232 bytes, DO=1, one option (code 65001, 8 bytes)
+	wire := mustHex(t, `
+		1234 0120 0001 0000 0000 0001
+		0765 7861 6d70 6c65 0363 6f6d 00 0001 0001
+		00 0029 04d0 0000 8000 000c fde9 0008 0102 0304 0506 0708
+	`)
+
+	m, err := DecodeMessage(wire)
+	if err != nil {
+		t.Fatalf("DecodeMessage error: %v", err)
+	}
+
+	if len(m.Additional) != 0 {
+		t.Fatalf("Additional: got %d records, want the OPT lifted out", len(m.Additional))
+	}
+	if m.Header.ARCount != 1 {
+		t.Fatalf("ARCount: got %d want 1 (as on the wire)", m.Header.ARCount)
+	}
+	if m.OPT == nil {
+		t.Fatalf("OPT: got nil")
+	}
+
+	want := &OPT{
+		UDPSize: 1232,
+		DO:      true,
+		Options: []EDNSOption{&GenericOption{OptionCode: 65001, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}},
+	}
+	if !reflect.DeepEqual(m.OPT, want) {
+		t.Fatalf("OPT = %+v, want %+v", m.OPT, want)
+	}
+
+	// And back again, byte for byte
+	got, err := EncodeMessage(&m)
+	if err != nil {
+		t.Fatalf("EncodeMessage error: %v", err)
+	}
+	if !bytes.Equal(got, wire) {
+		t.Fatalf("EncodeMessage =\n%x\nwant\n%x", got, wire)
+	}
+}
+
+func TestEncodeMessage_OPTGoesLast(t *testing.T) {
+	m := &Message{
+		Header: Header{ID: 7},
+		Additional: []ResourceRecord{
+			{Name: "ns1.example.", Type: TypeA, Class: ClassIN, TTL: 60, RData: []byte{192, 0, 2, 1}},
+		},
+		OPT: NewOPT(),
+	}
+
+	wire, err := EncodeMessage(m)
+	if err != nil {
+		t.Fatalf("EncodeMessage error: %v", err)
+	}
+
+	if !bytes.HasSuffix(wire, mustHex(t, `00 0029 04d0 0000 0000 0000`)) {
+		t.Fatalf("OPT not last: %x", wire)
+	}
+
+	got, err := DecodeMessage(wire)
+	if err != nil {
+		t.Fatalf("DecodeMessage error: %v", err)
+	}
+	if got.Header.ARCount != 2 || len(got.Additional) != 1 || got.OPT == nil || got.OPT.UDPSize != DefaultUDPSize {
+		t.Fatalf("got ARCount=%d additional=%d OPT=%+v", got.Header.ARCount, len(got.Additional), got.OPT)
+	}
+
+	// Encoding mustn't have appended to the caller's slice
+	if len(m.Additional) != 1 {
+		t.Fatalf("caller's Additional grew to %d", len(m.Additional))
+	}
+}
+
+func TestMessageRcode_Extended(t *testing.T) {
+	// BADVERS is 16: 0 in the header, 1 in the OPT extended RCODE
+	m := &Message{Header: Header{Rcode: 0}, OPT: &OPT{ExtendedRcode: 1}}
+	if got := m.Rcode(); got != 16 {
+		t.Fatalf("Rcode() = %d, want 16", got)
+	}
+
+	m = &Message{Header: Header{Rcode: 3}}
+	if got := m.Rcode(); got != 3 {
+		t.Fatalf("Rcode() without OPT = %d, want 3", got)
+	}
+}
+
+func TestDecodeMessage_BadOPT(t *testing.T) {
+	tests := []struct {
+		name string
+		wire string
+	}{
+		{"two OPTs", `
+			1234 0100 0000 0000 0000 0002
+			00 0029 04d0 0000 0000 0000
+			00 0029 04d0 0000 0000 0000`},
+		{"not at root", `
+			1234 0100 0000 0000 0000 0001
+			0161 00 0029 04d0 0000 0000 0000`},
+	}
+
+	for _, tt := range tests {
+		_, err := DecodeMessage(mustHex(t, tt.wire))
+		var e *OPTError
+		if !errors.As(err, &e) {
+			t.Errorf("%s: got %v, want *OPTError", tt.name, err)
+		}
+	}
+
+	// Option claims 8 bytes, only 2 there
+	wire := mustHex(t, `
+		1234 0100 0000 0000 0000 0001
+		00 0029 04d0 0000 0000 0006 000a 0008 0102`)
+	_, err := DecodeMessage(wire)
+	var e *TruncatedError
+	if !errors.As(err, &e) {
+		t.Fatalf("short option: got %v, want *TruncatedError", err)
+	}
+	if e.Offset != 27 {
+		t.Fatalf("short option: Offset = %d, want 27", e.Offset)
+	}
+}
+
+func TestDecodeMessage_MalformedOptionKeptRaw(t *testing.T) {
+	// A 12 byte cookie is no good (client cookies are 8), but the rest of
+	// the reply is fine and shouldn't be thrown away over it
+	wire := mustHex(t, `
+		1234 8100 0000 0000 0000 0001
+		00 0029 04d0 0000 0000 0010 000a 000c 0102 0304 0506 0708 090a 0b0c`)
+
+	m, err := DecodeMessage(wire)
+	if err != nil {
+		t.Fatalf("DecodeMessage error: %v", err)
+	}
+	want := []EDNSOption{&GenericOption{OptionCode: OptionCookie, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}}}
+	if m.OPT == nil || !reflect.DeepEqual(m.OPT.Options, want) {
+		t.Fatalf("OPT = %+v, want the cookie as a GenericOption", m.OPT)
+	}
+}
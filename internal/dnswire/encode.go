@@ -1,11 +1,9 @@
 package dnswire
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"math/rand"
-	"strings"
 )
 
 // Header, Question, ResourceRecord, Message, PrettyPrint stay as you already have them.
@@ -18,11 +16,11 @@ func appendUint16(b []byte, v uint16) []byte {
 	return append(b, byte(v>>8), byte(v))
 }
 
-/* func appendUint32(b []byte, v uint32) []byte {
+func appendUint32(b []byte, v uint32) []byte {
 	return append(b,
 		byte(v>>24), byte(v>>16), byte(v>>8), byte(v),
 	)
-} */
+}
 
 func encodeHeader(h Header) ([]byte, error) {
 	// DNS header is always 12 bytes.
@@ -32,9 +30,18 @@ func encodeHeader(h Header) ([]byte, error) {
 	binary.BigEndian.PutUint16(buf[0:2], h.ID)
 
 	// Flags: QR (1) | Opcode (4) | AA (1) | TC (1) | RD (1)
-	//        RA (1) | Z (3)      | RCODE (4)
+	//        RA (1) | Z (1) | AD (1) | CD (1) | RCODE (4)
 	var flags uint16 = 0
 
+	// Only 4 bits each on the wire - anything bigger would silently turn into
+	// a different opcode/rcode. (Extended RCODEs live in the OPT record.)
+	if h.Opcode > 0xF {
+		return nil, fmt.Errorf("opcode %d does not fit in 4 bits", h.Opcode)
+	}
+	if h.Rcode > 0xF {
+		return nil, fmt.Errorf("rcode %d does not fit in 4 bits", h.Rcode)
+	}
+
 	if h.QR {
 		flags |= 1 << 15
 	}
@@ -51,7 +58,13 @@ func encodeHeader(h Header) ([]byte, error) {
 	if h.RA {
 		flags |= 1 << 7
 	}
-	flags |= uint16(h.Z&0x7) << 4
+	flags |= uint16(h.Z&0x1) << 6
+	if h.AD {
+		flags |= 1 << 5
+	}
+	if h.CD {
+		flags |= 1 << 4
+	}
 	flags |= uint16(h.Rcode & 0xF)
 
 	binary.BigEndian.PutUint16(buf[2:4], flags)
@@ -65,13 +78,25 @@ func encodeHeader(h Header) ([]byte, error) {
 
 // NAME - test : got := encodeName(name)
 
-func encodeName(name string) ([]byte, error) {
+// maxLabelLength is the longest a single label can be - the top two bits of
+// the length octet are taken by the compression pointer flag.
+const maxLabelLength = 63
 
-	labels := strings.Split(name, ".")
+// encodeName writes name as an uncompressed label sequence. The trailing dot
+// is optional ("www.yahoo.com" and "www.yahoo.com." encode the same), and
+// "." or "" is the root. "\." is a dot inside a label (see name.go).
+func encodeName(name string) ([]byte, error) {
 
 	var encodedName []byte
 
-	for _, label := range labels {
+	for _, label := range Labels(name) {
+
+		if len(label) == 0 {
+			return nil, fmt.Errorf("encode name %q: empty label", name)
+		}
+		if len(label) > maxLabelLength {
+			return nil, fmt.Errorf("encode name %q: label %q is longer than %d octets", name, label, maxLabelLength)
+		}
 
 		encodedName = append(encodedName, byte(len(label))) //The byte length of the label coming up
 
@@ -82,56 +107,52 @@ func encodeName(name string) ([]byte, error) {
 	//Finish off the QNAME with 0x00:
 	encodedName = append(encodedName, 0x00)
 
+	if len(encodedName) > MaxNameLength {
+		return nil, fmt.Errorf("encode name %q: %d octets, longer than %d", name, len(encodedName), MaxNameLength)
+	}
+
 	return encodedName, nil
 }
 
-func encodeQuestion(q Question) ([]byte, error) {
-
-	labels := strings.Split(q.Name, ".")
-
-	var qname []byte
-
-	for _, label := range labels {
-
-		qname = append(qname, byte(len(label))) // The byte length of the label coming up
+// EncodeName is encodeName for other packages: name as an uncompressed label
+// sequence, the form used inside ResourceRecord.RData.
+func EncodeName(name string) ([]byte, error) {
+	return encodeName(name)
+}
 
-		qname = append(qname, []byte(label)...) // The label itself.
+func encodeQuestion(q Question) ([]byte, error) {
 
+	qname, err := encodeName(q.Name)
+	if err != nil {
+		return nil, err
 	}
 
-	//Finish off the QNAME with 0x00:
-	qname = append(qname, 0x00)
-
 	//Now we have the question, we just add the QTYPE and QCLASS
 	question := qname
 
 	// QTYPE
-	question = appendUint16(question, TypeA)
+	question = appendUint16(question, q.Type)
 
 	// QCLASS
-	question = appendUint16(question, ClassIN)
+	question = appendUint16(question, q.Class)
 
 	return question, nil //return offset
 }
 
 // Header and question sections built here
 func EncodeQuery(name string, qtype uint16) ([]byte, error) {
+	return EncodeQueryClass(name, qtype, ClassIN)
+}
 
-	/* 	type Message struct {
-		Header     Header
-		Questions  []Question
-		Answers    []ResourceRecord //Ignored in query (for now no EDNS)
-		Authority  []ResourceRecord //Ignored in query (for now no EDNS)
-		Additional []ResourceRecord //Ignored in query (for now no EDNS)
-	}	 */
-
-	// So we can keep track of the variable QNAME length
-	offset := 0
+// EncodeQueryClass is EncodeQuery for classes other than IN, e.g.
+//
+//	EncodeQueryClass("version.bind.", TypeTXT, ClassCH)
+func EncodeQueryClass(name string, qtype, qclass uint16) ([]byte, error) {
 
 	var h Header
 
 	// ID: random 16-bit value (used to match response with query).
-	h.ID = uint16(rand.Intn(65535))
+	h.ID = uint16(rand.Intn(65536))
 
 	// This is a query (not a response).
 	h.QR = false
@@ -142,47 +163,109 @@ func EncodeQuery(name string, qtype uint16) ([]byte, error) {
 	// Recursion desired: ask the upstream resolver to recurse for us.
 	h.RD = true
 
-	// One question in this message.
-	h.QDCount = 1
+	m := Message{
+		Header: h,
+		Questions: []Question{{
+			Name:  name,
+			Type:  qtype,
+			Class: qclass,
+		}},
+	}
 
-	headerBytes, err := encodeHeader(h)
+	query, err := EncodeMessage(&m)
 	if err != nil {
-		return nil, fmt.Errorf("encode header: %w", err)
+		return nil, fmt.Errorf("encode query: %w", err)
 	}
 
-	offset += 12 //Size of header
-
-	var buf bytes.Buffer
+	return query, nil
+}
 
-	// Write the 12-byte header first.
-	if _, err := buf.Write(headerBytes); err != nil {
-		return nil, fmt.Errorf("write header: %w", err)
+// EncodeMessage serializes m to wire format: every header flag as given,
+// then all of its questions, answers, authority and additional records, with
+// names compressed. QDCOUNT/ANCOUNT/NSCOUNT/ARCOUNT are set from the slices,
+// whatever m.Header says. If m.OPT is set it's written as the last
+// additional record.
+//
+// Use it for anything EncodeQuery doesn't cover - responses, NOTIFY, UPDATE,
+// test fixtures:
+//
+//	m := &dnswire.Message{
+//		Header:    dnswire.Header{ID: 0x1234, Opcode: 4, AA: true}, // NOTIFY
+//		Questions: []dnswire.Question{{Name: "example.com.", Type: dnswire.TypeSOA, Class: dnswire.ClassIN}},
+//	}
+//	wire, err := dnswire.EncodeMessage(m)
+func EncodeMessage(m *Message) ([]byte, error) {
+	if m == nil {
+		return nil, fmt.Errorf("encode message: nil message")
 	}
+	return packMessage(m)
+}
 
-	var q Question
+// packMessage encodes a whole message - header, questions and all three RR
+// sections - compressing names as it goes. The section counts in the header
+// are taken from the slices, so they can't disagree with what's written.
+func packMessage(m *Message) ([]byte, error) {
+
+	h := m.Header
+
+	// EDNS goes on the end of the additional section
+	additional := m.Additional
+	if m.OPT != nil {
+		opt, err := m.OPT.resourceRecord()
+		if err != nil {
+			return nil, err
+		}
+		additional = append(additional[:len(additional):len(additional)], opt)
+	}
 
-	/* We need to set name, type and class
+	counts := []struct {
+		name string
+		n    int
+		dst  *uint16
+	}{
+		{"questions", len(m.Questions), &h.QDCount},
+		{"answers", len(m.Answers), &h.ANCount},
+		{"authority records", len(m.Authority), &h.NSCount},
+		{"additional records", len(additional), &h.ARCount},
+	}
+	for _, c := range counts {
+		if c.n > 0xFFFF {
+			return nil, fmt.Errorf("too many %s: %d", c.name, c.n)
+		}
+		*c.dst = uint16(c.n)
+	}
 
-	type Question struct {
-		Name  string
-		Type  uint16
-		Class uint16
-	} */
+	msg, err := encodeHeader(h)
+	if err != nil {
+		return nil, fmt.Errorf("encode header: %w", err)
+	}
 
-	q.Name = name
-	q.Type = qtype
-	q.Class = ClassIN
+	c := newCompressor()
 
-	questionBytes, err := encodeQuestion(q)
+	for i, q := range m.Questions {
+		if msg, err = c.appendName(msg, q.Name); err != nil {
+			return nil, fmt.Errorf("question %d: %w", i, err)
+		}
+		msg = appendUint16(msg, q.Type)
+		msg = appendUint16(msg, q.Class)
+	}
 
-	if err != nil {
-		return nil, fmt.Errorf("encode question: %w", err)
+	sections := []struct {
+		name string
+		rrs  []ResourceRecord
+	}{
+		{"answer", m.Answers},
+		{"authority", m.Authority},
+		{"additional", additional},
 	}
 
-	// Write the question section
-	if _, err := buf.Write(questionBytes); err != nil {
-		return nil, fmt.Errorf("write question: %w", err)
+	for _, s := range sections {
+		for i, rr := range s.rrs {
+			if msg, err = c.appendResourceRecord(msg, rr); err != nil {
+				return nil, fmt.Errorf("%s %d: %w", s.name, i, err)
+			}
+		}
 	}
 
-	return buf.Bytes(), nil
+	return msg, nil
 }
@@ -0,0 +1,102 @@
+package dnswire
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeMessage_AllHeaderFlags(t *testing.T) {
+	m := &Message{
+		Header: Header{
+			ID: 0xbeef, QR: true, Opcode: 2, AA: true, TC: true, RD: true, RA: true,
+			Z: 1, AD: true, CD: true, Rcode: 5,
+		},
+	}
+
+	got, err := EncodeMessage(m)
+	if err != nil {
+		t.Fatalf("EncodeMessage error: %v", err)
+	}
+
+	// 1 0010 1 1 1 1 1 1 1 0101 = 0x97f5
+	want := mustHex(t, `beef 97f5 0000 0000 0000 0000`)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("EncodeMessage = %x, want %x", got, want)
+	}
+
+	back, err := DecodeMessage(got)
+	if err != nil {
+		t.Fatalf("DecodeMessage error: %v", err)
+	}
+	if back.Header != m.Header {
+		t.Fatalf("header round trip: got %+v want %+v", back.Header, m.Header)
+	}
+}
+
+func TestEncodeMessage_Notify(t *testing.T) {
+	// RFC 1996 NOTIFY: opcode 4, AA set, SOA question, current SOA in answer
+	mname, _ := encodeName("ns1.example.com.")
+	rname, _ := encodeName("hostmaster.example.com.")
+	soa := append(append(mname, rname...), mustHex(t, `7848 3a01 0000 0e10 0000 0384 0012 7500 0000 0e10`)...)
+
+	m := &Message{
+		Header:    Header{ID: 0x0101, Opcode: 4, AA: true},
+		Questions: []Question{{Name: "example.com.", Type: TypeSOA, Class: ClassIN}},
+		Answers: []ResourceRecord{{
+			Name: "example.com.", Type: TypeSOA, Class: ClassIN, TTL: 3600, RData: soa,
+		}},
+	}
+
+	wire, err := EncodeMessage(m)
+	if err != nil {
+		t.Fatalf("EncodeMessage error: %v", err)
+	}
+
+	got, err := DecodeMessage(wire)
+	if err != nil {
+		t.Fatalf("DecodeMessage error: %v", err)
+	}
+
+	if got.Header.Opcode != 4 || !got.Header.AA || got.Header.QR {
+		t.Fatalf("header: got %+v", got.Header)
+	}
+	if got.Header.QDCount != 1 || got.Header.ANCount != 1 {
+		t.Fatalf("counts: QD=%d AN=%d want 1,1", got.Header.QDCount, got.Header.ANCount)
+	}
+	if !bytes.Equal(got.Answers[0].RData, soa) {
+		t.Fatalf("SOA rdata: got %x want %x", got.Answers[0].RData, soa)
+	}
+}
+
+func TestEncodeMessage_CountsComeFromSlices(t *testing.T) {
+	m := &Message{
+		// Deliberately wrong counts
+		Header:    Header{ID: 1, QDCount: 7, ANCount: 3},
+		Questions: []Question{{Name: "a.example.", Type: TypeA, Class: ClassIN}},
+	}
+
+	wire, err := EncodeMessage(m)
+	if err != nil {
+		t.Fatalf("EncodeMessage error: %v", err)
+	}
+
+	if !bytes.Equal(wire[4:12], mustHex(t, `0001 0000 0000 0000`)) {
+		t.Fatalf("counts = %x, want 0001 0000 0000 0000", wire[4:12])
+	}
+}
+
+func TestEncodeMessage_Errors(t *testing.T) {
+	if _, err := EncodeMessage(nil); err == nil {
+		t.Errorf("nil message: expected error")
+	}
+	if _, err := EncodeMessage(&Message{Header: Header{Opcode: 16}}); err == nil {
+		t.Errorf("opcode 16: expected error")
+	}
+	if _, err := EncodeMessage(&Message{Header: Header{Rcode: 16}}); err == nil {
+		t.Errorf("rcode 16: expected error")
+	}
+	bad := &Message{Questions: []Question{{Name: "a..example."}}}
+	if _, err := EncodeMessage(bad); err == nil {
+		t.Errorf("empty label: expected error")
+	}
+}
@@ -0,0 +1,109 @@
+package dnswire
+
+import "fmt"
+
+// Decode errors.
+//
+// Anything off the network can be short, corrupt or built by someone trying to
+// knock us over, so the decoder never indexes past what it has checked. When a
+// message doesn't hold up it returns one of the types below - each carries the
+// byte offset (from the start of the message) where things went wrong so it
+// can be logged or lined up against a hexdump:
+//
+//	var loop *dnswire.PointerLoopError
+//	if errors.As(err, &loop) {
+//		log.Printf("pointer loop at byte %d", loop.Offset)
+//	}
+
+// MaxNameLength is the longest a domain name can be on the wire, counting
+// the length octets and the terminating root label (RFC 1035 2.3.4).
+const MaxNameLength = 255
+
+// TruncatedHeaderError means the message is too short to hold the 12 byte header.
+type TruncatedHeaderError struct {
+	Offset int // where the message ran out, i.e. its length
+}
+
+func (e *TruncatedHeaderError) Error() string {
+	return fmt.Sprintf("dnswire: truncated header: message ends at offset %d, header needs %d bytes", e.Offset, headerLen)
+}
+
+// TruncatedError means a fixed-size field (question type/class, RR
+// type/class/TTL/RDLENGTH, or RDATA) runs past the end of the message.
+type TruncatedError struct {
+	Offset int    // where the field starts
+	Field  string // what we were trying to read, e.g. "rdata"
+	Need   int    // bytes the field needs
+	Have   int    // bytes left in the message
+}
+
+func (e *TruncatedError) Error() string {
+	return fmt.Sprintf("dnswire: truncated %s at offset %d: need %d bytes, have %d", e.Field, e.Offset, e.Need, e.Have)
+}
+
+// LabelOverrunError means a label's length octet claims more bytes than the
+// message has left (or the name never reaches its root label).
+type LabelOverrunError struct {
+	Offset int // offset of the length octet
+	Length int // label length it claimed
+}
+
+func (e *LabelOverrunError) Error() string {
+	return fmt.Sprintf("dnswire: label at offset %d (length %d) runs past end of message", e.Offset, e.Length)
+}
+
+// PointerLoopError means following compression pointers would never reach the
+// end of the name.
+type PointerLoopError struct {
+	Offset int // offset of the pointer that closes the loop
+	Target int // where it points
+}
+
+func (e *PointerLoopError) Error() string {
+	return fmt.Sprintf("dnswire: compression pointer loop at offset %d (points to %d)", e.Offset, e.Target)
+}
+
+// PointerForwardError means a compression pointer points past itself (one
+// that points at itself is a PointerLoopError).
+// RFC 1035 4.1.4 only allows pointers to a *prior* occurrence of a name.
+type PointerForwardError struct {
+	Offset int // offset of the pointer
+	Target int // where it points
+}
+
+func (e *PointerForwardError) Error() string {
+	return fmt.Sprintf("dnswire: compression pointer at offset %d points forward to %d", e.Offset, e.Target)
+}
+
+// NameTooLongError means a name is longer than MaxNameLength octets once all
+// its labels (including the ones reached through pointers) are counted.
+type NameTooLongError struct {
+	Offset int // offset where the name starts
+	Length int // wire length we had reached when we gave up
+}
+
+func (e *NameTooLongError) Error() string {
+	return fmt.Sprintf("dnswire: name at offset %d is longer than %d octets (%d)", e.Offset, MaxNameLength, e.Length)
+}
+
+// LabelTypeError means a length octet starts with the reserved 01 or 10 bits.
+type LabelTypeError struct {
+	Offset int  // offset of the length octet
+	Type   byte // top two bits, 0x40 or 0x80
+}
+
+func (e *LabelTypeError) Error() string {
+	return fmt.Sprintf("dnswire: unsupported label type 0x%02x at offset %d", e.Type, e.Offset)
+}
+
+// OPTError means the EDNS OPT record is there but broken - not at the root,
+// or more than one of them. (An option that doesn't parse isn't an error;
+// it's kept as a GenericOption.)
+type OPTError struct {
+	Offset int // offset of the OPT RDATA (or the option within it)
+	Reason string
+}
+
+func (e *OPTError) Error() string {
+	return fmt.Sprintf("dnswire: bad OPT record at offset %d: %s", e.Offset, e.Reason)
+}
@@ -0,0 +1,215 @@
+package dnswire
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The hexdump diagram: the packet as raw bytes, sixteen to a row, with
+// every field coloured and listed underneath by offset.
+//
+//	0000  8d 30 85 00 00 01 00 01  00 00 00 02 07 65 78 61   .0...........exa
+//	0010  6d 70 6c 65 03 63 6f 6d  00 00 0f 00 01 c0 0c 00   mple.com........
+//	...
+//
+//	0000-0001  header        ID            0x8d30
+//	0002-0003  header        flags         opcode QUERY, status NOERROR qr aa rd
+//	...
+//	000c       question 1    label length  7
+//	000d-0013  question 1    label         "example"
+//	...
+//	001d-001e  answer 1      pointer       -> 000c example.com.
+//
+// What the bytes are comes from the decoder itself (DecodeMessageSpans), so
+// this shows exactly how it read the packet - and how far it got, if it
+// couldn't read all of it.
+
+// A Span is a run of bytes in a message and what the decoder made of them.
+type Span struct {
+	Offset int
+	Length int
+	Where  string // "header", "question 1", "answer 2", ...
+	Field  string // "ID", "label", "pointer", "TYPE", "RDATA", ...
+	Value  string // what it says, e.g. "0x8d30", `"example"`, "A"
+
+	// Target is where a compression pointer points; it's only set for
+	// Field "pointer".
+	Target int
+}
+
+// DecodeMessageSpans is DecodeMessage, also returning what each byte it
+// read is, in order. Bytes a compression pointer leads to aren't listed
+// again - they belong to whatever was there first. If decoding fails the
+// spans up to that point are still returned.
+func DecodeMessageSpans(wire []byte) (Message, []Span, error) {
+	sp := &spanLog{}
+	m, err := decodeMessage(wire, sp)
+	return m, sp.spans, err
+}
+
+// spanLog collects spans as the decoder goes. The decoder's methods take a
+// nil *spanLog when nobody's asking, and then all of these do nothing.
+type spanLog struct {
+	spans []Span
+	where string // the part of the message we're in
+}
+
+func (l *spanLog) at(where string) {
+	if l != nil {
+		l.where = where
+	}
+}
+
+func (l *spanLog) add(offset, length int, field, value string) {
+	if l == nil || length <= 0 {
+		return
+	}
+	l.spans = append(l.spans, Span{Offset: offset, Length: length, Where: l.where, Field: field, Value: value})
+}
+
+// addPointer logs a compression pointer at offset and returns its index,
+// so decodeNameSpans can add the name it leads to once it knows it.
+func (l *spanLog) addPointer(offset, target int) int {
+	if l == nil {
+		return -1
+	}
+	l.add(offset, 2, "pointer", fmt.Sprintf("-> %04x", target))
+	l.spans[len(l.spans)-1].Target = target
+	return len(l.spans) - 1
+}
+
+func (l *spanLog) header(h Header) {
+	if l == nil {
+		return
+	}
+
+	flags := fmt.Sprintf("opcode %s, status %s", OpcodeToString(h.Opcode), RcodeToString(uint16(h.Rcode)))
+	for _, f := range []struct {
+		set  bool
+		name string
+	}{{h.QR, "qr"}, {h.AA, "aa"}, {h.TC, "tc"}, {h.RD, "rd"}, {h.RA, "ra"}, {h.Z != 0, "z"}, {h.AD, "ad"}, {h.CD, "cd"}} {
+		if f.set {
+			flags += " " + f.name
+		}
+	}
+
+	l.at("header")
+	l.add(0, 2, "ID", fmt.Sprintf("0x%04x", h.ID))
+	l.add(2, 2, "flags", flags)
+	l.add(4, 2, "QDCOUNT", fmt.Sprint(h.QDCount))
+	l.add(6, 2, "ANCOUNT", fmt.Sprint(h.ANCount))
+	l.add(8, 2, "NSCOUNT", fmt.Sprint(h.NSCount))
+	l.add(10, 2, "ARCOUNT", fmt.Sprint(h.ARCount))
+}
+
+// rdata logs fixed size RDATA fields (the parts around any names) as
+// numbers where that's likely to be what they are: MX's preference, SRV's
+// priority/weight/port, SOA's serial and timers.
+func (l *spanLog) rdata(offset int, b []byte) {
+	if l == nil {
+		return
+	}
+
+	var values []string
+	switch {
+	case len(b)%4 == 0:
+		for i := 0; i < len(b); i += 4 {
+			values = append(values, fmt.Sprint(binary.BigEndian.Uint32(b[i:])))
+		}
+	case len(b)%2 == 0 && len(b) <= 6:
+		for i := 0; i < len(b); i += 2 {
+			values = append(values, fmt.Sprint(binary.BigEndian.Uint16(b[i:])))
+		}
+	default:
+		values = append(values, fmt.Sprintf("% x", b))
+	}
+	l.add(offset, len(b), "RDATA", strings.Join(values, " "))
+}
+
+// spanColours go round in turn, so neighbouring fields look different.
+// Pointers always get cRed.
+var spanColours = []string{cYellow, cGreen, cCyan, cPurple, cBlue}
+
+// PrintHexdumpDiagram writes wire as a hexdump with each field coloured and
+// labelled. It draws whatever it can: if the decoder gives up part way,
+// that's noted at the end and the rest of the bytes are left unlabelled, so
+// the error itself isn't returned - only errors writing to w are.
+func PrintHexdumpDiagram(wire []byte, w io.Writer) error {
+
+	p := &printer{w: w}
+	_, spans, decodeErr := DecodeMessageSpans(wire)
+
+	// The colour for each byte, "" for ones no span covers
+	colours := make([]string, len(wire))
+	spanColour := make([]string, len(spans))
+	for i, s := range spans {
+		c := spanColours[i%len(spanColours)]
+		if s.Field == "pointer" {
+			c = cRed + cBold
+		}
+		spanColour[i] = c
+		for j := s.Offset; j < s.Offset+s.Length && j < len(wire); j++ {
+			colours[j] = c
+		}
+	}
+
+	p.printf("%s\n", col(fmt.Sprintf(";; HEXDUMP, %d bytes", len(wire)), cCyan+cBold))
+	for row := 0; row < len(wire); row += 16 {
+		end := min(row+16, len(wire))
+
+		var hexPart, textPart strings.Builder
+		for i := row; i < row+16; i++ {
+			if i == row+8 {
+				hexPart.WriteByte(' ')
+			}
+			if i >= end {
+				hexPart.WriteString("   ")
+				continue
+			}
+			hexPart.WriteString(byteColour(fmt.Sprintf("%02x", wire[i]), colours[i]) + " ")
+			textPart.WriteString(byteColour(string(asciiByte(wire[i])), colours[i]))
+		}
+		p.printf("%04x  %s  %s\n", row, hexPart.String(), textPart.String())
+	}
+	p.printf("\n")
+
+	covered := 0
+	for i, s := range spans {
+		where := fmt.Sprintf("%04x     ", s.Offset)
+		if s.Length > 1 {
+			where = fmt.Sprintf("%04x-%04x", s.Offset, s.Offset+s.Length-1)
+		}
+		line := fmt.Sprintf("%-13s %-13s %s", s.Where, s.Field, s.Value)
+		p.printf("%s  %s\n", col(where, spanColour[i]), strings.TrimRight(line, " "))
+		covered = max(covered, s.Offset+s.Length)
+	}
+
+	switch {
+	case decodeErr != nil:
+		p.printf("%s\n", col(fmt.Sprintf(";; decoding stopped: %v", decodeErr), cRed))
+	case covered < len(wire):
+		p.printf("%s\n", col(fmt.Sprintf(";; %d bytes after the end of the message", len(wire)-covered), cDim))
+	}
+
+	return p.err
+}
+
+// byteColour is col for one byte of the dump, dimming the bytes no span
+// covers.
+func byteColour(s, colour string) string {
+	if colour == "" {
+		return col(s, cDim)
+	}
+	return col(s, colour)
+}
+
+// asciiByte is b as it shows in the text column of a hexdump: itself if
+// it's printable, otherwise a dot.
+func asciiByte(b byte) byte {
+	if b >= 32 && b <= 126 {
+		return b
+	}
+	return '.'
+}
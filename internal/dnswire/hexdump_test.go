@@ -0,0 +1,126 @@
+package dnswire
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// hexdumpReply is an MX reply with a compressed name in the answer, one
+// inside the MX RDATA, one pointing at that, and an OPT on the end.
+func hexdumpReply(t *testing.T) []byte {
+	t.Helper()
+	return mustHex(t, `
+		8d30 8500 0001 0001 0000 0002
+		07 6578616d706c65 03 636f6d 00 000f 0001
+		c00c 000f 0001 00000e10 0009 000a 04 6d61696c c00c
+		c02b 0001 0001 00000e10 0004 c0000219
+		00 0029 04d0 00000000 0000
+	`)
+}
+
+func TestDecodeMessageSpans(t *testing.T) {
+	wire := hexdumpReply(t)
+
+	m, spans, err := DecodeMessageSpans(wire)
+	if err != nil {
+		t.Fatalf("DecodeMessageSpans error: %v", err)
+	}
+
+	// Same message as the plain decoder gives
+	plain, err := DecodeMessage(wire)
+	if err != nil {
+		t.Fatalf("DecodeMessage error: %v", err)
+	}
+	if !reflect.DeepEqual(m, plain) {
+		t.Errorf("DecodeMessageSpans message = %+v, want %+v", m, plain)
+	}
+
+	// Every byte once, in order
+	next := 0
+	for _, s := range spans {
+		if s.Offset != next {
+			t.Fatalf("span %+v starts at %d, want %d", s, s.Offset, next)
+		}
+		next += s.Length
+	}
+	if next != len(wire) {
+		t.Fatalf("spans end at %d, want %d", next, len(wire))
+	}
+
+	for _, want := range []Span{
+		{Offset: 0, Length: 2, Where: "header", Field: "ID", Value: "0x8d30"},
+		{Offset: 2, Length: 2, Where: "header", Field: "flags", Value: "opcode QUERY, status NOERROR qr aa rd"},
+		{Offset: 13, Length: 7, Where: "question 1", Field: "label", Value: `"example"`},
+		{Offset: 29, Length: 2, Where: "answer 1", Field: "pointer", Value: "-> 000c example.com.", Target: 12},
+		{Offset: 41, Length: 2, Where: "answer 1", Field: "RDATA", Value: "10"},
+		{Offset: 50, Length: 2, Where: "additional 1", Field: "pointer", Value: "-> 002b mail.example.com.", Target: 43},
+		{Offset: 62, Length: 4, Where: "additional 1", Field: "RDATA", Value: "192.0.2.25"},
+		{Offset: 69, Length: 2, Where: "additional 2", Field: "UDP size", Value: "1232"},
+	} {
+		found := false
+		for _, s := range spans {
+			if s == want {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("no span %+v in\n%+v", want, spans)
+		}
+	}
+
+	// Cut off part way, the spans so far still come back
+	_, spans, err = DecodeMessageSpans(wire[:40])
+	if err == nil {
+		t.Fatalf("DecodeMessageSpans of 40 bytes: want error")
+	}
+	if last := spans[len(spans)-1]; last.Field != "pointer" || last.Offset != 29 {
+		t.Errorf("last span before the error = %+v, want the answer's pointer", last)
+	}
+}
+
+func TestPrintHexdumpDiagram(t *testing.T) {
+
+	UseColor = false
+	defer func() { UseColor = true }()
+
+	wire := hexdumpReply(t)
+
+	var buf bytes.Buffer
+	if err := PrintHexdumpDiagram(wire, &buf); err != nil {
+		t.Fatalf("PrintHexdumpDiagram error: %v", err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		";; HEXDUMP, 77 bytes\n",
+		"0000  8d 30 85 00 00 01 00 01  00 00 00 02 07 65 78 61   .0...........exa\n",
+		"0040  02 19 00 00 29 04 d0 00  00 00 00 00 00            ....)........\n",
+		"000c       question 1    label length  7\n",
+		"0018       question 1    root label\n",
+		"001d-001e  answer 1      pointer       -> 000c example.com.\n",
+		"0047-004a  additional 2  EDNS flags    0x00000000\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("hexdump missing %q:\n%s", want, out)
+		}
+	}
+
+	// What's left over after a broken or short message gets a note
+	for _, tt := range []struct {
+		wire []byte
+		want string
+	}{
+		{wire[:40], ";; decoding stopped: answer 0: dnswire: truncated record type/class/ttl/rdlength"},
+		{append(wire[:len(wire):len(wire)], 0xff, 0xff), ";; 2 bytes after the end of the message"},
+	} {
+		buf.Reset()
+		if err := PrintHexdumpDiagram(tt.wire, &buf); err != nil {
+			t.Fatalf("PrintHexdumpDiagram error: %v", err)
+		}
+		if !strings.Contains(buf.String(), tt.want) {
+			t.Errorf("hexdump of %d bytes missing %q:\n%s", len(tt.wire), tt.want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package dnswire
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// JSON for DNS messages, as RFC 8427 has it. There are two forms. The
+// field-by-field one spells out the header and every record:
+//
+//	{"ID": 41025, "QR": true, "Opcode": 0, ..., "RCODE": 0,
+//	 "QDCOUNT": 1, "ANCOUNT": 1, "NSCOUNT": 0, "ARCOUNT": 0,
+//	 "QNAME": "example.com.", "QTYPE": 1, "QTYPEname": "A", ...,
+//	 "questionRRs": [{"NAME": "example.com.", "TYPE": 1, ...}],
+//	 "answerRRs": [{"NAME": "example.com.", "TYPE": 1, "TYPEname": "A",
+//	                "CLASS": 1, "CLASSname": "IN", "TTL": 3600, "RDLENGTH": 4,
+//	                "RDATAHEX": "5DB8D822", "rdataA": "93.184.216.34"}]}
+//
+// and the other is just the wire bytes, {"messageOctetsHEX": "A0410100..."}.
+//
+// Going in, either form is fine. Booleans can be true/false or 1/0 (the
+// RFC's examples use numbers), names don't need the trailing dot, and a
+// record's type and class can be given by name instead of number. RDATA
+// has to come as RDATAHEX: the rdata<TYPE> members are only there for
+// people to read, parsing presentation format is internal/rr's job.
+//
+// The OPT record goes in additionalRRs like any other, the way it is on the
+// wire. The Z bit isn't one of the RFC's members, so it doesn't survive the
+// field-by-field form.
+
+type jsonMessage struct {
+	ID      uint16   `json:"ID"`
+	QR      jsonBool `json:"QR"`
+	Opcode  uint8    `json:"Opcode"`
+	AA      jsonBool `json:"AA"`
+	TC      jsonBool `json:"TC"`
+	RD      jsonBool `json:"RD"`
+	RA      jsonBool `json:"RA"`
+	AD      jsonBool `json:"AD"`
+	CD      jsonBool `json:"CD"`
+	RCODE   uint8    `json:"RCODE"`
+	QDCOUNT uint16   `json:"QDCOUNT"`
+	ANCOUNT uint16   `json:"ANCOUNT"`
+	NSCOUNT uint16   `json:"NSCOUNT"`
+	ARCOUNT uint16   `json:"ARCOUNT"`
+
+	// The first question again, at the top level
+	QNAME      string  `json:"QNAME,omitempty"`
+	QTYPE      *uint16 `json:"QTYPE,omitempty"`
+	QTYPEname  string  `json:"QTYPEname,omitempty"`
+	QCLASS     *uint16 `json:"QCLASS,omitempty"`
+	QCLASSname string  `json:"QCLASSname,omitempty"`
+
+	QuestionRRs   []jsonRR `json:"questionRRs,omitempty"`
+	AnswerRRs     []jsonRR `json:"answerRRs,omitempty"`
+	AuthorityRRs  []jsonRR `json:"authorityRRs,omitempty"`
+	AdditionalRRs []jsonRR `json:"additionalRRs,omitempty"`
+
+	MessageOctetsHEX string `json:"messageOctetsHEX,omitempty"`
+}
+
+// jsonRR is a question or a resource record - questions just don't have
+// the TTL and RDATA.
+type jsonRR struct {
+	NAME      string  `json:"NAME"`
+	TYPE      *uint16 `json:"TYPE,omitempty"`
+	TYPEname  string  `json:"TYPEname,omitempty"`
+	CLASS     *uint16 `json:"CLASS,omitempty"`
+	CLASSname string  `json:"CLASSname,omitempty"`
+	TTL       *uint32 `json:"TTL,omitempty"`
+	RDLENGTH  *uint16 `json:"RDLENGTH,omitempty"`
+	RDATAHEX  *string `json:"RDATAHEX,omitempty"`
+
+	// rdata is the presentation format RDATA, written as "rdata" plus the
+	// type name (see MarshalJSON). It's never read back.
+	rdata string
+}
+
+// jsonBool is a bool that reads 0 and 1 as well as false and true.
+type jsonBool bool
+
+func (b *jsonBool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", "1":
+		*b = true
+	case "false", "0":
+		*b = false
+	default:
+		return fmt.Errorf("json: %s is not a boolean", data)
+	}
+	return nil
+}
+
+func (r jsonRR) MarshalJSON() ([]byte, error) {
+
+	// Marshal everything else the normal way (plain has the fields but not
+	// this method, so it doesn't recurse), then add the rdata member on the end
+	type plain jsonRR
+	b, err := json.Marshal(plain(r))
+	if err != nil || r.rdata == "" {
+		return b, err
+	}
+	value, err := json.Marshal(r.rdata)
+	if err != nil {
+		return nil, err
+	}
+
+	b = b[:len(b)-1]
+	b = append(b, `,"rdata`+r.TYPEname+`":`...)
+	b = append(b, value...)
+	return append(b, '}'), nil
+}
+
+// MarshalJSON writes m in RFC 8427's field-by-field form. As with
+// EncodeMessage, the counts are taken from the slices, not the header.
+func (m Message) MarshalJSON() ([]byte, error) {
+
+	h := m.Header
+	j := jsonMessage{
+		ID: h.ID, QR: jsonBool(h.QR), Opcode: h.Opcode, AA: jsonBool(h.AA), TC: jsonBool(h.TC),
+		RD: jsonBool(h.RD), RA: jsonBool(h.RA), AD: jsonBool(h.AD), CD: jsonBool(h.CD), RCODE: h.Rcode,
+	}
+
+	if len(m.Questions) > 0 {
+		q := m.Questions[0]
+		j.QNAME, j.QTYPE, j.QTYPEname, j.QCLASS, j.QCLASSname = q.Name, &q.Type, TypeToString(q.Type), &q.Class, ClassToString(q.Class)
+	}
+	for _, q := range m.Questions {
+		j.QuestionRRs = append(j.QuestionRRs, jsonRR{
+			NAME: q.Name, TYPE: &q.Type, TYPEname: TypeToString(q.Type), CLASS: &q.Class, CLASSname: ClassToString(q.Class),
+		})
+	}
+
+	additional := m.Additional
+	if m.OPT != nil {
+		opt, err := m.OPT.resourceRecord()
+		if err != nil {
+			return nil, err
+		}
+		additional = append(additional[:len(additional):len(additional)], opt)
+	}
+
+	sections := []struct {
+		records []ResourceRecord
+		dst     *[]jsonRR
+	}{
+		{m.Answers, &j.AnswerRRs},
+		{m.Authority, &j.AuthorityRRs},
+		{additional, &j.AdditionalRRs},
+	}
+	for _, s := range sections {
+		for _, rr := range s.records {
+			*s.dst = append(*s.dst, recordToJSON(rr))
+		}
+	}
+
+	j.QDCOUNT = uint16(len(j.QuestionRRs))
+	j.ANCOUNT = uint16(len(j.AnswerRRs))
+	j.NSCOUNT = uint16(len(j.AuthorityRRs))
+	j.ARCOUNT = uint16(len(j.AdditionalRRs))
+
+	return json.Marshal(j)
+}
+
+func recordToJSON(rr ResourceRecord) jsonRR {
+
+	length := uint16(len(rr.RData))
+	data := strings.ToUpper(hex.EncodeToString(rr.RData))
+	j := jsonRR{
+		NAME: rr.Name, TYPE: &rr.Type, TYPEname: TypeToString(rr.Type),
+		CLASS: &rr.Class, TTL: &rr.TTL, RDLENGTH: &length, RDATAHEX: &data,
+	}
+
+	// OPT's class and TTL aren't really a class and a TTL, and its RDATA
+	// has no presentation format
+	if rr.Type == TypeOPT {
+		return j
+	}
+	j.CLASSname = ClassToString(rr.Class)
+	if s, ok := formatKnown(rr.Type, rr.RData, ""); ok {
+		j.rdata = s
+	}
+	return j
+}
+
+// MarshalOctetsJSON writes m in RFC 8427's other form, the whole message
+// as hex: {"messageOctetsHEX": "..."}.
+func MarshalOctetsJSON(m *Message) ([]byte, error) {
+	wire, err := EncodeMessage(m)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		MessageOctetsHEX string `json:"messageOctetsHEX"`
+	}{strings.ToUpper(hex.EncodeToString(wire))})
+}
+
+// UnmarshalJSON reads either of RFC 8427's forms. If messageOctetsHEX is
+// there it's decoded with DecodeMessage and the other members are ignored.
+func (m *Message) UnmarshalJSON(data []byte) error {
+
+	var j jsonMessage
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+
+	if j.MessageOctetsHEX != "" {
+		wire, err := hex.DecodeString(j.MessageOctetsHEX)
+		if err != nil {
+			return fmt.Errorf("json: messageOctetsHEX: %w", err)
+		}
+		msg, err := DecodeMessage(wire)
+		if err != nil {
+			return fmt.Errorf("json: messageOctetsHEX: %w", err)
+		}
+		*m = msg
+		return nil
+	}
+
+	if j.Opcode > 15 || j.RCODE > 15 {
+		return fmt.Errorf("json: Opcode %d or RCODE %d does not fit in 4 bits", j.Opcode, j.RCODE)
+	}
+	msg := Message{Header: Header{
+		ID: j.ID, QR: bool(j.QR), Opcode: j.Opcode, AA: bool(j.AA), TC: bool(j.TC),
+		RD: bool(j.RD), RA: bool(j.RA), AD: bool(j.AD), CD: bool(j.CD), Rcode: j.RCODE,
+	}}
+
+	// No questionRRs but a QNAME is the short way of writing a query
+	questions := j.QuestionRRs
+	if len(questions) == 0 && j.QNAME != "" {
+		questions = []jsonRR{{NAME: j.QNAME, TYPE: j.QTYPE, TYPEname: j.QTYPEname, CLASS: j.QCLASS, CLASSname: j.QCLASSname}}
+	}
+	for i, jq := range questions {
+		rr, err := recordFromJSON(jq, false)
+		if err != nil {
+			return fmt.Errorf("json: questionRRs %d: %w", i, err)
+		}
+		msg.Questions = append(msg.Questions, Question{Name: rr.Name, Type: rr.Type, Class: rr.Class})
+	}
+
+	sections := []struct {
+		name    string
+		records []jsonRR
+		dst     *[]ResourceRecord
+	}{
+		{"answerRRs", j.AnswerRRs, &msg.Answers},
+		{"authorityRRs", j.AuthorityRRs, &msg.Authority},
+		{"additionalRRs", j.AdditionalRRs, &msg.Additional},
+	}
+	for _, s := range sections {
+		for i, jr := range s.records {
+			rr, err := recordFromJSON(jr, true)
+			if err != nil {
+				return fmt.Errorf("json: %s %d: %w", s.name, i, err)
+			}
+
+			// Same as DecodeMessage: EDNS goes in msg.OPT
+			if rr.Type == TypeOPT && s.dst == &msg.Additional {
+				if msg.OPT != nil {
+					return fmt.Errorf("json: %s %d: more than one OPT record", s.name, i)
+				}
+				if msg.OPT, err = decodeOPT(rr, 0); err != nil {
+					return fmt.Errorf("json: %s %d: %w", s.name, i, err)
+				}
+				continue
+			}
+			*s.dst = append(*s.dst, rr)
+		}
+	}
+
+	msg.Header.QDCount = uint16(len(msg.Questions))
+	msg.Header.ANCount = uint16(len(msg.Answers))
+	msg.Header.NSCount = uint16(len(msg.Authority))
+	msg.Header.ARCount = uint16(len(msg.Additional))
+	if msg.OPT != nil {
+		msg.Header.ARCount++
+	}
+
+	*m = msg
+	return nil
+}
+
+// recordFromJSON reads a question (withData false) or a resource record.
+func recordFromJSON(j jsonRR, withData bool) (ResourceRecord, error) {
+
+	name := j.NAME
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	if _, err := EncodeName(name); err != nil {
+		return ResourceRecord{}, err
+	}
+	rr := ResourceRecord{Name: name}
+
+	switch {
+	case j.TYPE != nil:
+		rr.Type = *j.TYPE
+	case j.TYPEname != "":
+		t, ok := TypeFromString(j.TYPEname)
+		if !ok {
+			return ResourceRecord{}, fmt.Errorf("unknown TYPEname %q", j.TYPEname)
+		}
+		rr.Type = t
+	default:
+		return ResourceRecord{}, fmt.Errorf("no TYPE")
+	}
+
+	switch {
+	case j.CLASS != nil:
+		rr.Class = *j.CLASS
+	case j.CLASSname != "":
+		c, ok := ClassFromString(j.CLASSname)
+		if !ok {
+			return ResourceRecord{}, fmt.Errorf("unknown CLASSname %q", j.CLASSname)
+		}
+		rr.Class = c
+	default:
+		return ResourceRecord{}, fmt.Errorf("no CLASS")
+	}
+
+	if !withData {
+		return rr, nil
+	}
+
+	if j.TTL != nil {
+		rr.TTL = *j.TTL
+	}
+	if j.RDATAHEX != nil {
+		rdata, err := hex.DecodeString(*j.RDATAHEX)
+		if err != nil {
+			return ResourceRecord{}, fmt.Errorf("RDATAHEX: %w", err)
+		}
+		if len(rdata) > 0xFFFF {
+			return ResourceRecord{}, fmt.Errorf("RDATAHEX is %d bytes, longer than 65535", len(rdata))
+		}
+		rr.RData = rdata
+	}
+	if j.RDLENGTH != nil && int(*j.RDLENGTH) != len(rr.RData) {
+		return ResourceRecord{}, fmt.Errorf("RDLENGTH is %d but there are %d bytes of RDATAHEX", *j.RDLENGTH, len(rr.RData))
+	}
+	rr.RDLength = uint16(len(rr.RData))
+
+	return rr, nil
+}
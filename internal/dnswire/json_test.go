@@ -0,0 +1,154 @@
+package dnswire
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMessageJSON(t *testing.T) {
+	m := Message{
+		Header:    Header{ID: 41025, QR: true, RD: true, RA: true},
+		Questions: []Question{{Name: "example.com.", Type: TypeA, Class: ClassIN}},
+		Answers:   []ResourceRecord{{Name: "example.com.", Type: TypeA, Class: ClassIN, TTL: 3600, RData: []byte{93, 184, 216, 34}}},
+		OPT:       &OPT{UDPSize: 1232, DO: true},
+	}
+
+	got, err := json.Marshal(m)
+	if err != nil {
+		t.Fatalf("Marshal error: %v", err)
+	}
+
+	want := `{"ID":41025,"QR":true,"Opcode":0,"AA":false,"TC":false,"RD":true,"RA":true,"AD":false,"CD":false,"RCODE":0,` +
+		`"QDCOUNT":1,"ANCOUNT":1,"NSCOUNT":0,"ARCOUNT":1,` +
+		`"QNAME":"example.com.","QTYPE":1,"QTYPEname":"A","QCLASS":1,"QCLASSname":"IN",` +
+		`"questionRRs":[{"NAME":"example.com.","TYPE":1,"TYPEname":"A","CLASS":1,"CLASSname":"IN"}],` +
+		`"answerRRs":[{"NAME":"example.com.","TYPE":1,"TYPEname":"A","CLASS":1,"CLASSname":"IN","TTL":3600,"RDLENGTH":4,"RDATAHEX":"5DB8D822","rdataA":"93.184.216.34"}],` +
+		`"additionalRRs":[{"NAME":".","TYPE":41,"TYPEname":"OPT","CLASS":1232,"TTL":32768,"RDLENGTH":0,"RDATAHEX":""}]}`
+	if string(got) != want {
+		t.Fatalf("Marshal =\n%s\nwant\n%s", got, want)
+	}
+
+	// And back again - a pointer marshals the same way
+	if again, _ := json.Marshal(&m); !bytes.Equal(again, got) {
+		t.Errorf("Marshal(&m) =\n%s\nwant\n%s", again, got)
+	}
+
+	var back Message
+	if err := json.Unmarshal(got, &back); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	m.Header.QDCount, m.Header.ANCount, m.Header.ARCount = 1, 1, 1
+	m.Answers[0].RDLength = 4
+	if !reflect.DeepEqual(back, m) {
+		t.Fatalf("Unmarshal =\n%+v\nwant\n%+v", back, m)
+	}
+}
+
+func TestMessageJSON_RoundTripsWire(t *testing.T) {
+	// A reply with an SOA in authority and an EDNS option, through JSON and
+	// back onto the wire
+	in := &Message{
+		Header:    Header{ID: 7, QR: true, AA: true, Rcode: 3},
+		Questions: []Question{{Name: "nope.example.com.", Type: TypeMX, Class: ClassIN}},
+		Authority: []ResourceRecord{{
+			Name: "example.com.", Type: TypeSOA, Class: ClassIN, TTL: 300,
+			RData: mustHex(t, "036e7331076578616d706c6503636f6d00 0a686f73746d6173746572076578616d706c6503636f6d00 78a3f175 00001c20 00000e10 00127500 0000012c"),
+		}},
+		OPT: &OPT{UDPSize: 4096, Options: []EDNSOption{&NSID{Data: []byte("ns1")}}},
+	}
+	wire, err := EncodeMessage(in)
+	if err != nil {
+		t.Fatalf("EncodeMessage error: %v", err)
+	}
+
+	for _, form := range []string{"fields", "octets"} {
+		var b []byte
+		if form == "fields" {
+			b, err = json.Marshal(in)
+		} else {
+			b, err = MarshalOctetsJSON(in)
+		}
+		if err != nil {
+			t.Fatalf("%s: marshal error: %v", form, err)
+		}
+
+		var m Message
+		if err := json.Unmarshal(b, &m); err != nil {
+			t.Fatalf("%s: Unmarshal error: %v\n%s", form, err, b)
+		}
+		got, err := EncodeMessage(&m)
+		if err != nil {
+			t.Fatalf("%s: EncodeMessage error: %v", form, err)
+		}
+		if !bytes.Equal(got, wire) {
+			t.Errorf("%s: round trip =\n%x\nwant\n%x", form, got, wire)
+		}
+	}
+
+	b, _ := MarshalOctetsJSON(&Message{Header: Header{ID: 0xabcd, RD: true}})
+	if want := `{"messageOctetsHEX":"ABCD01000000000000000000"}`; string(b) != want {
+		t.Errorf("MarshalOctetsJSON = %s, want %s", b, want)
+	}
+}
+
+func TestMessageJSON_Unmarshal(t *testing.T) {
+	// Close to RFC 8427's example query: numbers for booleans, no trailing
+	// dot, and only the top level Q* members
+	in := `{ "ID": 19678, "QR": 0, "Opcode": 0,
+		"AA": 0, "TC": 0, "RD": 1, "RA": 0, "AD": 0, "CD": 0, "RCODE": 0,
+		"QDCOUNT": 1, "ANCOUNT": 0, "NSCOUNT": 0, "ARCOUNT": 0,
+		"QNAME": "example.com", "QTYPE": 1, "QCLASS": 1 }`
+
+	var m Message
+	if err := json.Unmarshal([]byte(in), &m); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	want := Message{
+		Header:    Header{ID: 19678, RD: true, QDCount: 1},
+		Questions: []Question{{Name: "example.com.", Type: TypeA, Class: ClassIN}},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Fatalf("Unmarshal = %+v, want %+v", m, want)
+	}
+
+	// Types and classes by name
+	in = `{"answerRRs": [{"NAME": "www.example.com.", "TYPEname": "aaaa", "CLASSname": "IN", "TTL": 60,
+		"RDATAHEX": "20010db8000000000000000000000001"}]}`
+	m = Message{}
+	if err := json.Unmarshal([]byte(in), &m); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	if len(m.Answers) != 1 || m.Answers[0].String() != "www.example.com.\t60\tIN\tAAAA\t2001:db8::1" {
+		t.Fatalf("Unmarshal answers = %+v", m.Answers)
+	}
+}
+
+func TestMessageJSON_UnmarshalErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"not JSON", `{"ID":`},
+		{"bad boolean", `{"QR": 2}`},
+		{"big opcode", `{"Opcode": 16}`},
+		{"no type", `{"questionRRs": [{"NAME": "example.com.", "CLASS": 1}]}`},
+		{"unknown type name", `{"questionRRs": [{"NAME": "example.com.", "TYPEname": "BOGUS", "CLASS": 1}]}`},
+		{"no class", `{"answerRRs": [{"NAME": "example.com.", "TYPE": 1, "RDATAHEX": "01020304"}]}`},
+		{"bad name", `{"answerRRs": [{"NAME": "a..b.", "TYPE": 1, "CLASS": 1, "RDATAHEX": "01020304"}]}`},
+		{"bad RDATAHEX", `{"answerRRs": [{"NAME": "a.", "TYPE": 1, "CLASS": 1, "RDATAHEX": "0g"}]}`},
+		{"RDLENGTH mismatch", `{"answerRRs": [{"NAME": "a.", "TYPE": 1, "CLASS": 1, "RDLENGTH": 5, "RDATAHEX": "01020304"}]}`},
+		{"two OPTs", `{"additionalRRs": [{"NAME": ".", "TYPE": 41, "CLASS": 512}, {"NAME": ".", "TYPE": 41, "CLASS": 512}]}`},
+		{"OPT not at root", `{"additionalRRs": [{"NAME": "a.", "TYPE": 41, "CLASS": 512}]}`},
+		{"bad octets", `{"messageOctetsHEX": "xyz"}`},
+		{"short octets", `{"messageOctetsHEX": "0001"}`},
+	}
+
+	for _, tt := range tests {
+		var m Message
+		if err := json.Unmarshal([]byte(tt.in), &m); err == nil {
+			t.Errorf("%s: Unmarshal(%s) = %+v, want error", tt.name, tt.in, m)
+		}
+	}
+}
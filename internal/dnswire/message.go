@@ -3,6 +3,10 @@ package dnswire
 import (
 	"fmt"
 	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Header struct {
@@ -13,7 +17,9 @@ type Header struct {
 	TC      bool
 	RD      bool
 	RA      bool
-	Z       uint8 // usually 0
+	Z       uint8 // reserved bit, usually 0
+	AD      bool  // authentic data (RFC 4035)
+	CD      bool  // checking disabled (RFC 4035)
 	Rcode   uint8
 	QDCount uint16
 	ANCount uint16
@@ -36,34 +42,168 @@ type ResourceRecord struct {
 	RData    []byte // you'll later decode into typed RRs using internal/rr
 }
 
+// String is the record in presentation format, the way it'd be written in
+// a zone file or shown by dig:
+//
+//	www.example.com.	3600	IN	A	192.0.2.1
+func (r ResourceRecord) String() string {
+	return fmt.Sprintf("%s\t%d\t%s\t%s\t%s", FormatName(r.Name, ""), r.TTL,
+		ClassToString(r.Class), TypeToString(r.Type), FormatRData(r.Type, r.RData, ""))
+}
+
 type Message struct {
 	Header     Header
 	Questions  []Question
 	Answers    []ResourceRecord
 	Authority  []ResourceRecord
 	Additional []ResourceRecord
+
+	// OPT is the EDNS(0) record, if any. It's kept out of Additional - see edns.go.
+	OPT *OPT
 }
 
-// PrettyPrint is a placeholder for now; you'll make this nicer later.
+// PrettyPrint writes m the way dig shows a reply:
+//
+//	;; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 41025
+//	;; flags: qr rd ra; QUERY: 1, ANSWER: 1, AUTHORITY: 0, ADDITIONAL: 1
+//
+//	;; OPT PSEUDOSECTION:
+//	; EDNS: version: 0, flags:; udp: 1232
+//	;; QUESTION SECTION:
+//	;example.com.			IN	A
+//
+//	;; ANSWER SECTION:
+//	example.com.		3600	IN	A	93.184.216.34
+//
+// Like dig, sections with nothing in them are left out and ADDITIONAL counts
+// the OPT record. The counts are of what's in m rather than the header's
+// count fields, so a Message built in code prints right too. PrintStats does
+// the footer.
 func PrettyPrint(m *Message, w io.Writer) error {
-	_, err := fmt.Fprintf(w, ";; dnstom raw message\n;; ID: %d, QD: %d, AN: %d, NS: %d, AR: %d\n",
-		m.Header.ID, m.Header.QDCount, m.Header.ANCount, m.Header.NSCount, m.Header.ARCount)
-	if err != nil {
-		return err
+
+	p := &printer{w: w}
+	h := m.Header
+
+	p.printf(";; ->>HEADER<<- opcode: %s, status: %s, id: %d\n", OpcodeToString(h.Opcode), RcodeToString(m.Rcode()), h.ID)
+
+	flags := ""
+	for _, f := range []struct {
+		set  bool
+		name string
+	}{{h.QR, "qr"}, {h.AA, "aa"}, {h.TC, "tc"}, {h.RD, "rd"}, {h.RA, "ra"}, {h.AD, "ad"}, {h.CD, "cd"}} {
+		if f.set {
+			flags += " " + f.name
+		}
+	}
+	if h.Z != 0 {
+		flags += fmt.Sprintf("; MBZ: 0x%x", h.Z)
+	}
+	additional := len(m.Additional)
+	if m.OPT != nil {
+		additional++
+	}
+	p.printf(";; flags:%s; QUERY: %d, ANSWER: %d, AUTHORITY: %d, ADDITIONAL: %d\n",
+		flags, len(m.Questions), len(m.Answers), len(m.Authority), additional)
+	if h.QR && h.RD && !h.RA {
+		p.printf(";; WARNING: recursion requested but not available\n")
+	}
+	p.printf("\n")
+
+	if m.OPT != nil {
+		p.printf(";; OPT PSEUDOSECTION:\n; %s\n", m.OPT)
+		for _, o := range m.OPT.Options {
+			p.printf("; %s\n", o)
+		}
 	}
 
-	for _, q := range m.Questions {
-		if _, err := fmt.Fprintf(w, ";; QUESTION: %s type=%d class=%d\n", q.Name, q.Type, q.Class); err != nil {
-			return err
+	if len(m.Questions) > 0 {
+		p.printf(";; QUESTION SECTION:\n")
+		for _, q := range m.Questions {
+			p.printf("%s%s%s\n", column(";"+FormatName(q.Name, ""), 32), column(ClassToString(q.Class), 8), TypeToString(q.Type))
+		}
+		p.printf("\n")
+	}
+
+	for _, section := range []struct {
+		name    string
+		records []ResourceRecord
+	}{{"ANSWER", m.Answers}, {"AUTHORITY", m.Authority}, {"ADDITIONAL", m.Additional}} {
+		if len(section.records) == 0 {
+			continue
+		}
+		p.printf(";; %s SECTION:\n", section.name)
+		for _, r := range section.records {
+			p.printf("%s%s%s%s%s\n", column(FormatName(r.Name, ""), 24), column(strconv.FormatUint(uint64(r.TTL), 10), 8),
+				column(ClassToString(r.Class), 8), column(TypeToString(r.Type), 8), FormatRData(r.Type, r.RData, ""))
 		}
+		p.printf("\n")
 	}
 
-	for _, rr := range m.Answers {
-		if _, err := fmt.Fprintf(w, "ANSWER: %s TTL=%d type=%d class=%d\n",
-			rr.Name, rr.TTL, rr.Type, rr.Class); err != nil {
-			return err
+	return p.err
+}
+
+// Stats is what dig prints under a reply: how long it took, who answered,
+// when, and how big the reply was. Anything left at its zero value isn't
+// printed.
+type Stats struct {
+	RTT      time.Duration
+	Server   string // host:port
+	Protocol string // "UDP" or "TCP"
+	When     time.Time
+	Size     int // bytes received
+}
+
+// PrintStats writes s the way dig does:
+//
+//	;; Query time: 12 msec
+//	;; SERVER: 192.0.2.53#53(192.0.2.53) (UDP)
+//	;; WHEN: Sun Oct 18 10:00:00 UTC 2026
+//	;; MSG SIZE  rcvd: 56
+func PrintStats(w io.Writer, s Stats) error {
+
+	p := &printer{w: w}
+
+	p.printf(";; Query time: %d msec\n", s.RTT.Milliseconds())
+	if s.Server != "" {
+		server := s.Server
+		if host, port, err := net.SplitHostPort(s.Server); err == nil {
+			server = fmt.Sprintf("%s#%s(%s)", host, port, host)
+		}
+		if s.Protocol != "" {
+			server += " (" + s.Protocol + ")"
 		}
+		p.printf(";; SERVER: %s\n", server)
+	}
+	if !s.When.IsZero() {
+		p.printf(";; WHEN: %s\n", s.When.Format("Mon Jan 02 15:04:05 MST 2006"))
+	}
+	if s.Size > 0 {
+		p.printf(";; MSG SIZE  rcvd: %d\n", s.Size)
 	}
+	p.printf("\n")
 
-	return nil
+	return p.err
+}
+
+// column pads s with tabs out to the next tab stop at or after width, with
+// at least one tab - dig lines its columns up this way.
+func column(s string, width int) string {
+	n := 1
+	for pos := (len(s)/8 + 1) * 8; pos < width; pos += 8 {
+		n++
+	}
+	return s + strings.Repeat("\t", n)
+}
+
+// printer keeps the first write error, so PrettyPrint doesn't have to check
+// every line.
+type printer struct {
+	w   io.Writer
+	err error
+}
+
+func (p *printer) printf(format string, args ...any) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, format, args...)
+	}
 }
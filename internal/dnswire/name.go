@@ -0,0 +1,88 @@
+package dnswire
+
+import "strings"
+
+// Names are strings all the way through dnstom, with dots between the
+// labels: "www.example.com.". A label on the wire can have any byte in it,
+// dots included, so inside a label a dot is written "\." and a backslash
+// "\\" - the same as a zone file would:
+//
+//	03 61 2e 62 07 65 78 61 6d 70 6c 65 00   a\.b.example.
+//
+// Everything else is left as it is, unprintable bytes and all; escaping
+// those is FormatName's job.
+
+// Labels splits name into its labels as they go on the wire, escapes
+// resolved, without the empty root label at the end. The root itself ("."
+// or "") has none.
+func Labels(name string) []string {
+
+	if name == "." || name == "" {
+		return nil
+	}
+
+	var labels []string
+	var label []byte
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; {
+		case c == '\\' && i+1 < len(name):
+			i++
+			label = append(label, name[i])
+		case c == '.':
+			labels = append(labels, string(label))
+			label = label[:0]
+		default:
+			label = append(label, c)
+		}
+	}
+	if !IsAbsolute(name) {
+		labels = append(labels, string(label))
+	}
+	return labels
+}
+
+// IsAbsolute reports whether name ends in a dot that isn't part of its
+// last label.
+func IsAbsolute(name string) bool {
+	return strings.HasSuffix(name, ".") && !escaped(name, len(name)-1)
+}
+
+// IsSubdomain reports whether child is parent or below it, ignoring case.
+// Both should be absolute.
+func IsSubdomain(child, parent string) bool {
+	switch {
+	case parent == ".":
+		return true
+	case len(child) == len(parent):
+		return strings.EqualFold(child, parent)
+	case len(child) < len(parent):
+		return false
+	}
+	dot := len(child) - len(parent) - 1
+	return child[dot] == '.' && !escaped(child, dot) && strings.EqualFold(child[dot+1:], parent)
+}
+
+// escapeLabel is a label off the wire in the form names take here.
+func escapeLabel(label []byte) string {
+	if !strings.ContainsAny(string(label), `.\`) {
+		return string(label)
+	}
+	var b strings.Builder
+	for _, c := range label {
+		if c == '.' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// escaped reports whether name[i] is escaped: there's an odd number of
+// backslashes in front of it.
+func escaped(name string, i int) bool {
+	n := 0
+	for i--; i >= 0 && name[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
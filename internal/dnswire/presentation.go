@@ -0,0 +1,303 @@
+package dnswire
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Presentation format (RFC 1035 5.1) - RDATA the way it's written in a zone
+// file or shown by dig:
+//
+//	MX    0a 04 6d 61 69 6c 00    ->  10 mail.
+//	TXT   05 68 65 6c 6c 6f       ->  "hello"
+//
+// This works straight off the wire bytes rather than going through
+// internal/rr (which imports us). Types we don't know how to show, and
+// RDATA that doesn't parse as its type, come out in the RFC 3597 generic
+// form, "\# <length> <hex>", which anything that reads zone files can take
+// back in.
+
+// FormatRData renders rdata (uncompressed, as in ResourceRecord.RData) of
+// type rrtype in presentation format. If origin isn't "", names at or below
+// it are written relative to it (see FormatName).
+func FormatRData(rrtype uint16, rdata []byte, origin string) string {
+	if s, ok := formatKnown(rrtype, rdata, origin); ok {
+		return s
+	}
+	return formatGeneric(rdata)
+}
+
+// FormatName writes name in presentation format, escaping anything in a
+// label that would otherwise be read as zone file syntax. If origin isn't ""
+// and name is at or below it, the result is relative: origin itself is "@",
+// "www.example.com." with origin "example.com." is "www".
+func FormatName(name, origin string) string {
+
+	if origin != "" && origin != "." {
+		switch {
+		case strings.EqualFold(name, origin):
+			return "@"
+		case IsSubdomain(name, origin):
+			name = name[:len(name)-len(origin)-1]
+		}
+	}
+	if name == "." {
+		return name
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c == '\\' && i+1 < len(name) && (name[i+1] == '.' || name[i+1] == '\\'):
+			// already escaped (see name.go)
+			b.WriteString(name[i : i+2])
+			i++
+		case c == '.':
+			b.WriteByte(c)
+		case strings.IndexByte(`"();\@$`, c) >= 0:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c <= ' ' || c >= 0x7f:
+			fmt.Fprintf(&b, "\\%03d", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// formatKnown does the types we know. ok is false if we don't know the type
+// or the RDATA is malformed.
+func formatKnown(rrtype uint16, rdata []byte, origin string) (string, bool) {
+
+	r := &rdataReader{b: rdata}
+	var parts []string
+
+	switch rrtype {
+	case TypeA:
+		if len(rdata) != net.IPv4len {
+			return "", false
+		}
+		return net.IP(rdata).String(), true
+
+	case TypeAAAA:
+		if len(rdata) != net.IPv6len {
+			return "", false
+		}
+		// Not net.IP, which shows ::ffff:192.0.2.1 as plain 192.0.2.1
+		return netip.AddrFrom16([16]byte(rdata)).String(), true
+
+	case TypeNS, TypeCNAME, TypePTR, TypeDNAME:
+		parts = append(parts, r.name(origin))
+
+	case TypeRP:
+		parts = append(parts, r.name(origin), r.name(origin))
+
+	case TypeMX, TypeAFSDB:
+		parts = append(parts, r.u16(), r.name(origin))
+
+	case TypeHINFO:
+		parts = append(parts, r.text(), r.text())
+
+	case TypeSOA:
+		parts = append(parts, r.name(origin), r.name(origin), r.u32(), r.u32(), r.u32(), r.u32(), r.u32())
+
+	case TypeTXT, TypeSPF:
+		parts = append(parts, r.text())
+		for !r.failed && r.off < len(r.b) {
+			parts = append(parts, r.text())
+		}
+
+	case TypeSRV:
+		parts = append(parts, r.u16(), r.u16(), r.u16(), r.name(origin))
+
+	case TypeCAA:
+		flags, tagLen := r.u8(), r.byte()
+		tag := string(r.bytes(int(tagLen)))
+		parts = append(parts, flags, tag, quote(r.bytes(len(r.b)-r.off)))
+
+	case TypeDS:
+		parts = append(parts, r.u16(), r.u8(), r.u8(), r.hex())
+
+	case TypeSSHFP:
+		parts = append(parts, r.u8(), r.u8(), r.hex())
+
+	case TypeTLSA:
+		parts = append(parts, r.u8(), r.u8(), r.u8(), r.hex())
+
+	case TypeNAPTR:
+		parts = append(parts, r.u16(), r.u16(), r.text(), r.text(), r.text(), r.name(origin))
+
+	case TypeURI:
+		parts = append(parts, r.u16(), r.u16(), quote(r.bytes(len(r.b)-r.off)))
+
+	case TypeDNSKEY:
+		parts = append(parts, r.u16(), r.u8(), r.u8(), r.base64())
+
+	case TypeRRSIG:
+		parts = append(parts, TypeToString(r.uint16()), r.u8(), r.u8(), r.u32(), r.time(), r.time(), r.u16(),
+			r.name(origin), r.base64())
+
+	case TypeNSEC:
+		parts = append(parts, r.name(origin))
+		parts = append(parts, r.types()...)
+
+	default:
+		return "", false
+	}
+
+	if r.failed || r.off != len(r.b) {
+		return "", false
+	}
+	return strings.Join(parts, " "), true
+}
+
+// formatGeneric is RFC 3597 5: "\# 4 C0000201".
+func formatGeneric(rdata []byte) string {
+	if len(rdata) == 0 {
+		return `\# 0`
+	}
+	return fmt.Sprintf(`\# %d %s`, len(rdata), strings.ToUpper(hex.EncodeToString(rdata)))
+}
+
+// quote writes a <character-string> in double quotes, with " and \ escaped
+// and anything unprintable as \DDD.
+func quote(s []byte) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, c := range s {
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c >= 0x7f:
+			fmt.Fprintf(&b, "\\%03d", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// rdataReader reads fields off the front of some RDATA. Running off the end
+// sets failed, and from then on everything returns zero values.
+type rdataReader struct {
+	b      []byte
+	off    int
+	failed bool
+}
+
+func (r *rdataReader) bytes(n int) []byte {
+	if r.failed || n < 0 || r.off+n > len(r.b) {
+		r.failed = true
+		return nil
+	}
+	b := r.b[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *rdataReader) byte() byte {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *rdataReader) u8() string { return strconv.Itoa(int(r.byte())) }
+
+func (r *rdataReader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *rdataReader) uint32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *rdataReader) u16() string { return strconv.Itoa(int(r.uint16())) }
+func (r *rdataReader) u32() string { return strconv.FormatUint(uint64(r.uint32()), 10) }
+
+// time is an RRSIG timestamp, YYYYMMDDHHmmSS in UTC (RFC 4034 3.2).
+func (r *rdataReader) time() string {
+	return time.Unix(int64(r.uint32()), 0).UTC().Format("20060102150405")
+}
+
+// base64 is the rest of the RDATA, for keys and signatures.
+func (r *rdataReader) base64() string {
+	b := r.bytes(len(r.b) - r.off)
+	if len(b) == 0 {
+		r.failed = true
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// types is an NSEC type bitmap (RFC 4034 4.1.2) - windows of up to 256
+// types, each a window number, a length and that many bytes of bits.
+func (r *rdataReader) types() []string {
+	var types []string
+	last := -1
+	for !r.failed && r.off < len(r.b) {
+		window, length := int(r.byte()), int(r.byte())
+		if window <= last || length < 1 || length > 32 {
+			r.failed = true
+			break
+		}
+		last = window
+		for i, bits := range r.bytes(length) {
+			for bit := range 8 {
+				if bits&(0x80>>bit) != 0 {
+					types = append(types, TypeToString(uint16(window<<8|i*8+bit)))
+				}
+			}
+		}
+	}
+	return types
+}
+
+func (r *rdataReader) name(origin string) string {
+	if r.failed {
+		return ""
+	}
+	// RDATA is uncompressed, so no pointers to follow - only look at what's
+	// left of this RDATA
+	name, next, err := decodeName(r.b, r.off)
+	if err != nil {
+		r.failed = true
+		return ""
+	}
+	r.off = next
+	return FormatName(name, origin)
+}
+
+func (r *rdataReader) text() string {
+	n := r.byte()
+	return quote(r.bytes(int(n)))
+}
+
+// hex is the rest of the RDATA as upper case hex, the way BIND and dig show
+// digests.
+func (r *rdataReader) hex() string {
+	b := r.bytes(len(r.b) - r.off)
+	if len(b) == 0 {
+		// an empty digest has no presentation form
+		r.failed = true
+		return ""
+	}
+	return strings.ToUpper(hex.EncodeToString(b))
+}
@@ -0,0 +1,219 @@
+package dnswire
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestFormatRData(t *testing.T) {
+	name := func(s string) []byte {
+		b, err := encodeName(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	cat := func(parts ...[]byte) []byte {
+		var out []byte
+		for _, p := range parts {
+			out = append(out, p...)
+		}
+		return out
+	}
+
+	tests := []struct {
+		rrtype uint16
+		rdata  []byte
+		origin string
+		want   string
+	}{
+		{TypeA, []byte{192, 0, 2, 1}, "", "192.0.2.1"},
+		{TypeAAAA, mustHex(t, "20010db8000000000000000000000001"), "", "2001:db8::1"},
+		{TypeAAAA, mustHex(t, "00000000000000000000ffffc0000201"), "", "::ffff:192.0.2.1"},
+		{TypeNS, name("ns1.example.com."), "", "ns1.example.com."},
+		{TypeNS, name("ns1.example.com."), "example.com.", "ns1"},
+		{TypeCNAME, name("example.com."), "example.com.", "@"},
+		{TypeCNAME, name("www.example.net."), "example.com.", "www.example.net."},
+		{TypeMX, cat([]byte{0, 10}, name("mail.example.com.")), "", "10 mail.example.com."},
+		{TypeSOA, cat(name("ns1.example.com."), name("hostmaster.example.com."), mustHex(t, "78a3f175 00001c20 00000e10 00127500 0000012c")),
+			"example.com.", "ns1 hostmaster 2024010101 7200 3600 1209600 300"},
+		{TypeTXT, []byte("\x0bhello world\x00\x04a\"\\\x01"), "", `"hello world" "" "a\"\\\001"`},
+		{TypeSRV, cat([]byte{0, 10, 0, 60, 0x13, 0xc4}, name("sip.example.com.")), "", "10 60 5060 sip.example.com."},
+		{TypeCAA, []byte("\x00\x05issueletsencrypt.org"), "", `0 issue "letsencrypt.org"`},
+		{TypeDS, mustHex(t, "4f66 08 02 e06d44b8"), "", "20326 8 2 E06D44B8"},
+		{TypeSSHFP, mustHex(t, "04 02 deadbeef"), "", "4 2 DEADBEEF"},
+		{TypeTLSA, mustHex(t, "03 01 01 0102"), "", "3 1 1 0102"},
+
+		{TypeHINFO, []byte("\x07RFC8482\x00"), "", `"RFC8482" ""`},
+		{TypeNAPTR, cat(mustHex(t, "0064 000a"), []byte("\x01U\x07E2U+sip\x04!x!y"), name(".")), "",
+			`100 10 "U" "E2U+sip" "!x!y" .`},
+		{TypeURI, []byte("\x00\x0a\x00\x01https://example.com/"), "", `10 1 "https://example.com/"`},
+		{TypeDNSKEY, mustHex(t, "0101 03 0d ff"), "", "257 3 13 /w=="},
+		{TypeRRSIG, cat(mustHex(t, "0001 0d 02 0000012c 67748580 65920080 0001"), name("example."), []byte{0xff}), "",
+			"A 13 2 300 20250101000000 20240101000000 1 example. /w=="},
+		{TypeNSEC, cat(name("b.example."), mustHex(t, "0006 40010000 0003")), "", "b.example. A MX RRSIG NSEC"},
+		{TypeNSEC, cat(name("b.example."), mustHex(t, "0001 40 0001 40")), "", `\# 17 0162076578616D706C6500000140000140`}, // windows out of order
+
+		// Names with characters that mean something in a zone file
+		{TypePTR, name("a b;c.example."), "", `a\032b\;c.example.`},
+
+		// Generic: a type we don't know, and ones that don't parse
+		{TypeLOC, []byte{1, 2, 3}, "", `\# 3 010203`},
+		{TypeNULL, nil, "", `\# 0`},
+		{TypeA, []byte{1, 2, 3}, "", `\# 3 010203`},
+		{TypeMX, []byte{0, 10, 3, 'c', 'o'}, "", `\# 5 000A03636F`},
+		{TypeNS, cat(name("example.com."), []byte{0}), "", `\# 14 076578616D706C6503636F6D0000`},
+		{TypeDS, mustHex(t, "4f66 08 02"), "", `\# 4 4F660802`},
+	}
+
+	for _, tt := range tests {
+		if got := FormatRData(tt.rrtype, tt.rdata, tt.origin); got != tt.want {
+			t.Errorf("FormatRData(%s, % x, %q) = %s, want %s", TypeToString(tt.rrtype), tt.rdata, tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestResourceRecordString(t *testing.T) {
+	r := ResourceRecord{Name: "www.example.com.", Type: TypeA, Class: ClassIN, TTL: 3600, RData: []byte{192, 0, 2, 1}}
+	if got, want := r.String(), "www.example.com.\t3600\tIN\tA\t192.0.2.1"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	r = ResourceRecord{Name: "x.example.", Type: 65280, Class: 42, TTL: 0, RData: []byte{1}}
+	if got, want := r.String(), "x.example.\t0\tCLASS42\tTYPE65280\t\\# 1 01"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestFormatName(t *testing.T) {
+	tests := []struct{ name, origin, want string }{
+		{"www.example.com.", "", "www.example.com."},
+		{"www.example.com.", "example.com.", "www"},
+		{"WWW.Example.COM.", "example.com.", "WWW"},
+		{"example.com.", "example.com.", "@"},
+		{"badexample.com.", "example.com.", "badexample.com."},
+		{".", "", "."},
+		{"com.", ".", "com."},
+		{"$x.@.example.", "", `\$x.\@.example.`},
+		{`a\.b.example.com.`, "example.com.", `a\.b`},
+		{`a\.example.com.`, "example.com.", `a\.example.com.`}, // "a.example" isn't below example.com.
+		{`back\\slash.example.`, "", `back\\slash.example.`},
+	}
+	for _, tt := range tests {
+		if got := FormatName(tt.name, tt.origin); got != tt.want {
+			t.Errorf("FormatName(%q, %q) = %q, want %q", tt.name, tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestPrettyPrint(t *testing.T) {
+	m := &Message{
+		Header:    Header{ID: 41025, QR: true, RD: true, RA: true, Rcode: 3},
+		Questions: []Question{{Name: "nope.example.com.", Type: TypeA, Class: ClassIN}},
+		Authority: []ResourceRecord{{
+			Name: "example.com.", Type: TypeSOA, Class: ClassIN, TTL: 300,
+			RData: mustHex(t, "036e7331076578616d706c6503636f6d00 0a686f73746d6173746572076578616d706c6503636f6d00 78a3f175 00001c20 00000e10 00127500 0000012c"),
+		}},
+		OPT: &OPT{UDPSize: 1232},
+	}
+
+	var buf bytes.Buffer
+	if err := PrettyPrint(m, &buf); err != nil {
+		t.Fatalf("PrettyPrint error: %v", err)
+	}
+
+	want := `;; ->>HEADER<<- opcode: QUERY, status: NXDOMAIN, id: 41025
+;; flags: qr rd ra; QUERY: 1, ANSWER: 0, AUTHORITY: 1, ADDITIONAL: 1
+
+;; OPT PSEUDOSECTION:
+; EDNS: version: 0, flags:; udp: 1232
+;; QUESTION SECTION:
+;nope.example.com.		IN	A
+
+;; AUTHORITY SECTION:
+example.com.		300	IN	SOA	ns1.example.com. hostmaster.example.com. 2024010101 7200 3600 1209600 300
+
+`
+	if buf.String() != want {
+		t.Errorf("PrettyPrint =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestPrettyPrint_Flags(t *testing.T) {
+	tests := []struct {
+		h    Header
+		want string
+	}{
+		{Header{}, ";; flags:; QUERY: 0"},
+		{Header{QR: true, AA: true, TC: true, AD: true, CD: true}, ";; flags: qr aa tc ad cd; QUERY: 0"},
+		{Header{Z: 1}, ";; flags:; MBZ: 0x1; QUERY: 0"},
+		{Header{QR: true, RD: true}, ";; WARNING: recursion requested but not available"},
+		{Header{Opcode: 4, Rcode: 5}, "opcode: NOTIFY, status: REFUSED"},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := PrettyPrint(&Message{Header: tt.h}, &buf); err != nil {
+			t.Fatalf("PrettyPrint error: %v", err)
+		}
+		if !bytes.Contains(buf.Bytes(), []byte(tt.want)) {
+			t.Errorf("%+v: output missing %q:\n%s", tt.h, tt.want, buf.String())
+		}
+	}
+}
+
+func TestPrintStats(t *testing.T) {
+	s := Stats{
+		RTT:      12500 * time.Microsecond,
+		Server:   "[2001:db8::53]:53",
+		Protocol: "TCP",
+		When:     time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC),
+		Size:     56,
+	}
+
+	var buf bytes.Buffer
+	if err := PrintStats(&buf, s); err != nil {
+		t.Fatalf("PrintStats error: %v", err)
+	}
+
+	want := `;; Query time: 12 msec
+;; SERVER: 2001:db8::53#53(2001:db8::53) (TCP)
+;; WHEN: Sun Oct 18 10:00:00 UTC 2026
+;; MSG SIZE  rcvd: 56
+
+`
+	if buf.String() != want {
+		t.Errorf("PrintStats =\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	// Nothing but the query time when that's all we know
+	buf.Reset()
+	if err := PrintStats(&buf, Stats{}); err != nil {
+		t.Fatalf("PrintStats error: %v", err)
+	}
+	if buf.String() != ";; Query time: 0 msec\n\n" {
+		t.Errorf("PrintStats(Stats{}) = %q", buf.String())
+	}
+}
+
+func TestColumn(t *testing.T) {
+	tests := []struct {
+		s     string
+		width int
+		want  string
+	}{
+		{"a.", 24, "a.\t\t\t"},
+		{"example.com.", 24, "example.com.\t\t"},
+		{"mail.example.com.", 24, "mail.example.com.\t"},
+		{"a-very-long-name.example.com.", 24, "a-very-long-name.example.com.\t"},
+		{"IN", 8, "IN\t"},
+		{"TYPE65280", 8, "TYPE65280\t"},
+	}
+
+	for _, tt := range tests {
+		if got := column(tt.s, tt.width); got != tt.want {
+			t.Errorf("column(%q, %d) = %q, want %q", tt.s, tt.width, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package dnswire
+
+// Some RDATA formats carry domain names, and the older ones are allowed to be
+// compressed on the wire (RFC 3597 section 4). A pointer inside RDATA only
+// means something relative to the message it came in, so the decoder expands
+// them and ResourceRecord.RData is always self-contained, uncompressed wire
+// format - it can be copied into another message or handed to internal/rr
+// without dragging the original packet along.
+
+// rdataName marks a domain name in an rdataLayouts entry; any other value is
+// a run of that many fixed bytes.
+const rdataName = -1
+
+// rdataLayouts describes where the names are in each RDATA format we know
+// about. Anything after the last entry (e.g. SOA's serial/timers) is copied
+// through as-is.
+var rdataLayouts = map[uint16][]int{
+	TypeNS:    {rdataName},
+	TypeMD:    {rdataName},
+	TypeMF:    {rdataName},
+	TypeCNAME: {rdataName},
+	TypeSOA:   {rdataName, rdataName},
+	TypeMB:    {rdataName},
+	TypeMG:    {rdataName},
+	TypeMR:    {rdataName},
+	TypePTR:   {rdataName},
+	TypeMINFO: {rdataName, rdataName},
+	TypeMX:    {2, rdataName},
+	TypeRP:    {rdataName, rdataName},
+	TypeAFSDB: {2, rdataName},
+	TypeRT:    {2, rdataName},
+	TypePX:    {2, rdataName, rdataName},
+	TypeSRV:   {6, rdataName},
+	TypeDNAME: {rdataName},
+}
+
+// expandRData returns the RDATA at msg[offset:end] with any compressed names
+// written out in full, logging its parts to sp.
+func expandRData(msg []byte, offset, end int, rrtype uint16, sp *spanLog) ([]byte, error) {
+
+	layout, ok := rdataLayouts[rrtype]
+	if !ok {
+		rdata := append([]byte(nil), msg[offset:end]...)
+		sp.add(offset, end-offset, "RDATA", FormatRData(rrtype, rdata, ""))
+		return rdata, nil
+	}
+
+	out := make([]byte, 0, end-offset)
+	pos := offset
+
+	for _, field := range layout {
+		if field != rdataName {
+			if pos+field > end {
+				return nil, &TruncatedError{Offset: pos, Field: "rdata", Need: field, Have: end - pos}
+			}
+			out = append(out, msg[pos:pos+field]...)
+			sp.rdata(pos, msg[pos:pos+field])
+			pos += field
+			continue
+		}
+
+		// Only look at the message up to the end of this RDATA, so a name
+		// can't quietly run on into the next record.
+		name, next, err := decodeNameSpans(msg[:end], pos, sp)
+		if err != nil {
+			return nil, err
+		}
+		wire, err := encodeName(name)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, wire...)
+		pos = next
+	}
+
+	sp.rdata(pos, msg[pos:end])
+	return append(out, msg[pos:end]...), nil
+}
@@ -0,0 +1,45 @@
+package dnswire
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// DNS over TCP (RFC 1035 4.2.2, RFC 7766): the same message as UDP, but
+// with a 2 byte big-endian length in front so the reader knows where it
+// ends.
+//
+//	00 1d | 74 66 01 00 00 01 ...
+
+// WriteTCPMessage writes msg to w with its length prefix. Prefix and message
+// go out in a single Write so they end up in the same segment.
+func WriteTCPMessage(w io.Writer, msg []byte) error {
+	if len(msg) > 0xFFFF {
+		return fmt.Errorf("tcp message is %d bytes, longer than 65535", len(msg))
+	}
+
+	framed := make([]byte, 0, 2+len(msg))
+	framed = appendUint16(framed, uint16(len(msg)))
+	framed = append(framed, msg...)
+
+	_, err := w.Write(framed)
+	return err
+}
+
+// ReadTCPMessage reads one length-prefixed message from r.
+func ReadTCPMessage(r io.Reader) ([]byte, error) {
+	var prefix [2]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+
+	msg := make([]byte, binary.BigEndian.Uint16(prefix[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,49 @@
+package dnswire
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestTCPFraming_RoundTrip(t *testing.T) {
+	query, err := EncodeQuery("www.yahoo.com", TypeA)
+	if err != nil {
+		t.Fatalf("EncodeQuery error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteTCPMessage(&buf, query); err != nil {
+		t.Fatalf("WriteTCPMessage error: %v", err)
+	}
+	if err := WriteTCPMessage(&buf, query); err != nil {
+		t.Fatalf("WriteTCPMessage error: %v", err)
+	}
+
+	if got := buf.Bytes()[:2]; !bytes.Equal(got, []byte{0, byte(len(query))}) {
+		t.Fatalf("length prefix = %x, want 00%02x", got, len(query))
+	}
+
+	// Two messages back to back on the same stream
+	for i := 0; i < 2; i++ {
+		got, err := ReadTCPMessage(&buf)
+		if err != nil {
+			t.Fatalf("ReadTCPMessage %d error: %v", i, err)
+		}
+		if !bytes.Equal(got, query) {
+			t.Fatalf("ReadTCPMessage %d = %x, want %x", i, got, query)
+		}
+	}
+
+	if _, err := ReadTCPMessage(&buf); err != io.EOF {
+		t.Fatalf("ReadTCPMessage at end = %v, want io.EOF", err)
+	}
+}
+
+func TestReadTCPMessage_Short(t *testing.T) {
+	_, err := ReadTCPMessage(bytes.NewReader([]byte{0x00, 0x10, 0x01, 0x02}))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("got %v, want io.ErrUnexpectedEOF", err)
+	}
+}
@@ -17,11 +17,7 @@ func printByteArrayAsHex(data []byte) {
 func printByteArrayAsASCII(data []byte) {
 
 	for _, b := range data {
-		if b >= 32 && b <= 126 {
-			fmt.Printf("%c", b)
-		} else {
-			fmt.Print(".")
-		}
+		fmt.Printf("%c", asciiByte(b))
 	}
 	fmt.Println()
 
@@ -0,0 +1,491 @@
+package resolver
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"dnstom/internal/dnswire"
+	"dnstom/internal/rr"
+)
+
+// Response cache.
+//
+// Entries are keyed by question (name, type, class) and hold the answer
+// RRset along with any CNAMEs that led to it, so a hit gives back exactly
+// what Resolve would have. Negative answers are cached too (RFC 2308):
+// NXDOMAIN and NODATA replies are kept for the SOA's negative TTL - the
+// smaller of its own TTL and its MINIMUM field - and a negative reply with
+// no SOA isn't cached at all, since there's no way to know how long it's
+// good for.
+//
+// TTLs count down while a record sits in the cache: a record stored with
+// TTL 300 comes back 60 seconds later with TTL 240.
+//
+// Two things keep popular names answering when upstream has problems:
+//
+//   - Serve-stale (RFC 8767): expired entries are kept for StaleWindow
+//     longer, and if refreshing one fails outright (timeouts, SERVFAIL)
+//     the old answer is given out with a short TTL instead of an error.
+//   - Prefetch: a hit on a popular entry (PrefetchHits or more) in the
+//     last 10% of its TTL refreshes it in the background, so the next
+//     client doesn't have to wait for the round trip.
+//
+// Iterative resolution also keeps the delegations it's been given along
+// the way (the NS records and glue from each referral, for as long as the
+// shortest of their TTLs), so the next lookup under example.com. can go
+// straight to example.com.'s servers rather than starting at the roots.
+//
+// A miss for something another client is already waiting on doesn't go
+// upstream again: everyone after the same question waits for the one
+// lookup under way.
+
+// CachePolicy controls how big the cache gets and how long things stay.
+type CachePolicy struct {
+	MaxEntries     int           // least recently used entries go first once we're over this
+	MaxTTL         time.Duration // nobody gets to be cached for a week
+	MaxNegativeTTL time.Duration // RFC 2308 suggests 1-3 hours
+
+	// StaleWindow is how long past expiry an entry can still be served if
+	// upstream can't be reached. RFC 8767 suggests 1-3 days; zero turns
+	// serve-stale off.
+	StaleWindow time.Duration
+
+	// PrefetchHits is how many hits make an entry worth refreshing early.
+	// Zero turns prefetch off.
+	PrefetchHits int
+}
+
+// staleTTL is the TTL stale answers go out with (RFC 8767 section 4), so
+// clients come back soon to see if things are better.
+const staleTTL = 30
+
+// How a cache lookup went.
+type cacheState int
+
+const (
+	cacheMiss     cacheState = iota
+	cacheFresh               // within its TTL
+	cachePrefetch            // within its TTL, but time to refresh it
+	cacheStale               // expired, but inside the stale window
+)
+
+// DefaultCachePolicy is roughly what BIND ships with (max-cache-ttl 1 day,
+// max-ncache-ttl 3 hours).
+func DefaultCachePolicy() CachePolicy {
+	return CachePolicy{
+		MaxEntries:     10000,
+		MaxTTL:         24 * time.Hour,
+		MaxNegativeTTL: 3 * time.Hour,
+	}
+}
+
+// withDefaults fills in anything left at zero.
+func (p CachePolicy) withDefaults() CachePolicy {
+	d := DefaultCachePolicy()
+	if p.MaxEntries <= 0 {
+		p.MaxEntries = d.MaxEntries
+	}
+	if p.MaxTTL <= 0 {
+		p.MaxTTL = d.MaxTTL
+	}
+	if p.MaxNegativeTTL <= 0 {
+		p.MaxNegativeTTL = d.MaxNegativeTTL
+	}
+	return p
+}
+
+type cacheKey struct {
+	name  string // lower case, absolute
+	qtype uint16
+	class uint16
+}
+
+type cacheEntry struct {
+	key       cacheKey
+	rcode     uint8
+	answers   []dnswire.ResourceRecord
+	authority []dnswire.ResourceRecord // the SOA, for negative entries
+	stored    time.Time
+	expires   time.Time
+	ttl       time.Duration // what expires was worked out from
+
+	hits        int
+	prefetching bool // a refresh has already been started
+}
+
+// Cache is an LRU cache of resolver replies. It's safe for concurrent use.
+type Cache struct {
+	policy CachePolicy
+	now    func() time.Time // swapped out by tests
+
+	mu      sync.Mutex
+	entries map[cacheKey]*list.Element
+	lru     *list.List // of *cacheEntry, most recently used at the front
+	flights map[cacheKey]*flight
+	cuts    map[string]cut // by zone, lower case
+}
+
+// cut is a delegation from a referral: the servers for zone.
+type cut struct {
+	zone    string
+	servers []NameServer
+	expires time.Time
+}
+
+// flight is a lookup under way for something the cache didn't have.
+type flight struct {
+	done  chan struct{} // closed once reply and err are set
+	reply *dnswire.Message
+	err   error
+}
+
+// NewCache returns an empty cache. Zero fields in p get the defaults.
+func NewCache(p CachePolicy) *Cache {
+	return &Cache{
+		policy:  p.withDefaults(),
+		now:     time.Now,
+		entries: make(map[cacheKey]*list.Element),
+		lru:     list.New(),
+		flights: make(map[cacheKey]*flight),
+		cuts:    make(map[string]cut),
+	}
+}
+
+// SetCache puts c in front of r: Resolve (and so all the Lookup methods)
+// answers from it when it can and fills it when it can't. Several
+// resolvers can share one cache. nil turns caching off.
+func (r *Resolver) SetCache(c *Cache) {
+	r.cache = c
+}
+
+// Cached returns what Resolve would answer for name/qtype without asking
+// anyone - a fresh reply from the cache, if there's a cache and it has one.
+// It's for queries that say not to recurse (RD=0).
+func (r *Resolver) Cached(name string, qtype uint16) (*dnswire.Message, bool) {
+	if r.cache == nil {
+		return nil, false
+	}
+	return r.cache.Get(name, qtype, dnswire.ClassIN)
+}
+
+// Len returns the number of entries in the cache, expired or not.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Get returns a cached reply for name/qtype/class, with TTLs reduced by
+// the time it has spent in the cache, if there's one that hasn't expired.
+func (c *Cache) Get(name string, qtype, class uint16) (*dnswire.Message, bool) {
+	reply, state := c.lookup(name, qtype, class, false)
+	return reply, state == cacheFresh
+}
+
+// GetStale returns an expired reply for name/qtype/class, if it's still
+// inside the stale window, with every TTL set to 30 seconds. It's for
+// when fetching a fresh one has failed.
+func (c *Cache) GetStale(name string, qtype, class uint16) (*dnswire.Message, bool) {
+	reply, state := c.lookup(name, qtype, class, false)
+	if state != cacheStale {
+		return nil, false
+	}
+	for _, section := range [][]dnswire.ResourceRecord{reply.Answers, reply.Authority} {
+		for i := range section {
+			section[i].TTL = staleTTL
+		}
+	}
+	return reply, true
+}
+
+// lookup finds the entry for name/qtype/class and says what state it's in.
+// A fresh hit counts towards prefetch; if the caller can prefetch, the first
+// one that should trigger a refresh comes back as cachePrefetch, and the
+// caller is expected to do it.
+func (c *Cache) lookup(name string, qtype, class uint16, canPrefetch bool) (*dnswire.Message, cacheState) {
+
+	key := cacheKey{name: strings.ToLower(fqdn(name)), qtype: qtype, class: class}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, cacheMiss
+	}
+	e := el.Value.(*cacheEntry)
+
+	now := c.now()
+	if !now.Before(e.expires) {
+		if now.Before(e.expires.Add(c.policy.StaleWindow)) {
+			return e.reply(fqdn(name), now), cacheStale
+		}
+		c.remove(el)
+		return nil, cacheMiss
+	}
+	c.lru.MoveToFront(el)
+	e.hits++
+
+	state := cacheFresh
+	if canPrefetch && c.policy.PrefetchHits > 0 && e.hits >= c.policy.PrefetchHits && !e.prefetching && e.expires.Sub(now) <= e.ttl/10 {
+		e.prefetching = true
+		state = cachePrefetch
+	}
+
+	return e.reply(fqdn(name), now), state
+}
+
+// share runs fetch for name/qtype/class - unless a fetch for the same
+// thing is already running, in which case it waits for that one instead.
+// If that one gave up because its own context ran out, we have a go.
+func (c *Cache) share(ctx context.Context, name string, qtype, class uint16, fetch func() (*dnswire.Message, error)) (*dnswire.Message, error) {
+
+	key := cacheKey{name: strings.ToLower(fqdn(name)), qtype: qtype, class: class}
+
+	c.mu.Lock()
+	f, running := c.flights[key]
+	if !running {
+		f = &flight{done: make(chan struct{})}
+		c.flights[key] = f
+	}
+	c.mu.Unlock()
+
+	if !running {
+		defer func() {
+			c.mu.Lock()
+			delete(c.flights, key)
+			c.mu.Unlock()
+			close(f.done)
+		}()
+		f.reply, f.err = fetch()
+		return f.reply, f.err
+	}
+
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("resolve %s: %w", name, ctx.Err())
+	}
+
+	if errors.Is(f.err, context.Canceled) || errors.Is(f.err, context.DeadlineExceeded) {
+		return fetch()
+	}
+	// Our own copy, TTLs and all, if it went in the cache
+	if reply, ok := c.Get(name, qtype, class); ok {
+		return reply, nil
+	}
+	return f.reply, f.err
+}
+
+// prefetchFailed lets a later hit on name/qtype/class try the refresh
+// again - lookup only hands out one prefetch per entry, and a Put is what
+// normally replaces it.
+func (c *Cache) prefetchFailed(name string, qtype, class uint16) {
+
+	key := cacheKey{name: strings.ToLower(fqdn(name)), qtype: qtype, class: class}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*cacheEntry).prefetching = false
+	}
+}
+
+// putCut remembers that servers are where to ask about zone, for ttl.
+// There's no LRU for these: when there are MaxEntries of them already,
+// expired ones are cleared out, and failing that any one goes.
+func (c *Cache) putCut(zone string, servers []NameServer, ttl time.Duration) {
+
+	ttl = min(ttl, c.policy.MaxTTL)
+	if ttl <= 0 || len(servers) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if len(c.cuts) >= c.policy.MaxEntries {
+		for key, cut := range c.cuts {
+			if !now.Before(cut.expires) {
+				delete(c.cuts, key)
+			}
+		}
+	}
+	if len(c.cuts) >= c.policy.MaxEntries {
+		for key := range c.cuts {
+			delete(c.cuts, key)
+			break
+		}
+	}
+
+	c.cuts[strings.ToLower(zone)] = cut{zone: zone, servers: servers, expires: now.Add(ttl)}
+}
+
+// closestCut returns the closest delegation we know of at or above name,
+// or false if there's none short of the root.
+func (c *Cache) closestCut(name string) (string, []NameServer, bool) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for zone := strings.ToLower(fqdn(name)); zone != "."; zone = parentZone(zone) {
+		cut, ok := c.cuts[zone]
+		if !ok {
+			continue
+		}
+		if now.Before(cut.expires) {
+			return cut.zone, cut.servers, true
+		}
+		delete(c.cuts, zone)
+	}
+	return "", nil, false
+}
+
+// parentZone drops the first label: "www.example.com." -> "example.com.".
+// A dot inside the label ("a\.b.example.com.") doesn't count.
+func parentZone(name string) string {
+	for i := 0; i < len(name)-1; i++ {
+		switch name[i] {
+		case '\\':
+			i++
+		case '.':
+			return name[i+1:]
+		}
+	}
+	return "."
+}
+
+// Put stores reply, the answer to its first question. Only NOERROR and
+// NXDOMAIN replies are cached - a SERVFAIL says nothing about the name.
+func (c *Cache) Put(reply *dnswire.Message) {
+
+	if len(reply.Questions) == 0 {
+		return
+	}
+	q := reply.Questions[0]
+
+	rcode := reply.Rcode()
+	if rcode != 0 && rcode != 3 {
+		return
+	}
+
+	ttl, ok := c.ttl(reply, q)
+	if !ok || ttl <= 0 {
+		return
+	}
+
+	// The negative limit is for the SOA that came with an NXDOMAIN/NODATA;
+	// the NS records with a positive answer are ordinary records
+	authorityLimit := c.policy.MaxTTL
+	if negative(reply, q) {
+		authorityLimit = c.policy.MaxNegativeTTL
+	}
+
+	now := c.now()
+	e := &cacheEntry{
+		key:       cacheKey{name: strings.ToLower(q.Name), qtype: q.Type, class: q.Class},
+		rcode:     uint8(rcode),
+		answers:   capTTLs(reply.Answers, c.policy.MaxTTL),
+		authority: capTTLs(reply.Authority, authorityLimit),
+		stored:    now,
+		expires:   now.Add(ttl),
+		ttl:       ttl,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[e.key]; ok {
+		c.remove(el)
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+
+	for c.lru.Len() > c.policy.MaxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+// ttl works out how long reply can be cached: the lowest TTL in the
+// answer, and for a negative answer (NXDOMAIN, or NODATA at the end of
+// any CNAMEs) the SOA's negative TTL as well. ok is false if it's a
+// negative answer without an SOA.
+func (c *Cache) ttl(reply *dnswire.Message, q dnswire.Question) (time.Duration, bool) {
+
+	ttl := c.policy.MaxTTL
+	for _, a := range reply.Answers {
+		ttl = min(ttl, time.Duration(a.TTL)*time.Second)
+	}
+
+	if !negative(reply, q) {
+		return ttl, true
+	}
+
+	for _, a := range reply.Authority {
+		if a.Type != dnswire.TypeSOA {
+			continue
+		}
+		d, err := rr.Decode(a)
+		if err != nil {
+			return 0, false
+		}
+		neg := time.Duration(min(a.TTL, d.(*rr.SOA).Minimum)) * time.Second
+		return min(ttl, neg, c.policy.MaxNegativeTTL), true
+	}
+
+	return 0, false
+}
+
+// negative reports whether reply is NXDOMAIN, or NODATA at the end of any
+// CNAMEs.
+func negative(reply *dnswire.Message, q dnswire.Question) bool {
+	return reply.Rcode() == 3 || len(answersFor(reply, q.Name, q.Type)) == 0
+}
+
+// remove drops el from the cache. Called with c.mu held.
+func (c *Cache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).key)
+}
+
+// reply rebuilds a reply from e as of now.
+func (e *cacheEntry) reply(name string, now time.Time) *dnswire.Message {
+
+	elapsed := uint32(now.Sub(e.stored) / time.Second)
+
+	return &dnswire.Message{
+		Header:    dnswire.Header{QR: true, RD: true, RA: true, Rcode: e.rcode},
+		Questions: []dnswire.Question{{Name: name, Type: e.key.qtype, Class: e.key.class}},
+		Answers:   ageTTLs(e.answers, elapsed),
+		Authority: ageTTLs(e.authority, elapsed),
+	}
+}
+
+// capTTLs copies records, with no TTL above limit.
+func capTTLs(records []dnswire.ResourceRecord, limit time.Duration) []dnswire.ResourceRecord {
+	out := append([]dnswire.ResourceRecord(nil), records...)
+	for i := range out {
+		out[i].TTL = min(out[i].TTL, uint32(limit/time.Second))
+	}
+	return out
+}
+
+// ageTTLs copies records with elapsed seconds taken off their TTLs.
+func ageTTLs(records []dnswire.ResourceRecord, elapsed uint32) []dnswire.ResourceRecord {
+	out := append([]dnswire.ResourceRecord(nil), records...)
+	for i := range out {
+		if out[i].TTL > elapsed {
+			out[i].TTL -= elapsed
+		} else {
+			out[i].TTL = 0
+		}
+	}
+	return out
+}
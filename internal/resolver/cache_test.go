@@ -0,0 +1,510 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"dnstom/internal/dnswire"
+	"dnstom/internal/rr"
+)
+
+// fakeClock is a time.Now the test moves by hand.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestCache(p CachePolicy) (*Cache, *fakeClock) {
+	c := NewCache(p)
+	clock := newFakeClock()
+	c.now = clock.Now
+	return c, clock
+}
+
+// cachedReply builds a reply for name/qtype with the given answers and
+// authority section.
+func cachedReply(name string, qtype uint16, rcode uint8, answers, authority []dnswire.ResourceRecord) *dnswire.Message {
+	return &dnswire.Message{
+		Header:    dnswire.Header{QR: true, RA: true, Rcode: rcode},
+		Questions: []dnswire.Question{{Name: name, Type: qtype, Class: dnswire.ClassIN}},
+		Answers:   answers,
+		Authority: authority,
+	}
+}
+
+func withTTL(r dnswire.ResourceRecord, ttl uint32) dnswire.ResourceRecord {
+	r.TTL = ttl
+	return r
+}
+
+func TestCache_PositiveTTL(t *testing.T) {
+	c, clock := newTestCache(CachePolicy{})
+
+	a := withTTL(mustRR(t, "www.example.com.", &rr.A{Address: net.ParseIP("192.0.2.1")}), 300)
+	cname := withTTL(mustRR(t, "alias.example.com.", &rr.CNAME{Target: "www.example.com."}), 60)
+
+	c.Put(cachedReply("www.example.com.", dnswire.TypeA, 0, []dnswire.ResourceRecord{a}, nil))
+	c.Put(cachedReply("alias.example.com.", dnswire.TypeA, 0, []dnswire.ResourceRecord{cname, a}, nil))
+
+	clock.Advance(40 * time.Second)
+
+	// Case doesn't matter, and the name as asked is what comes back
+	got, ok := c.Get("WWW.example.com", dnswire.TypeA, dnswire.ClassIN)
+	if !ok {
+		t.Fatalf("Get(www) missed")
+	}
+	if got.Questions[0].Name != "WWW.example.com." || len(got.Answers) != 1 || got.Answers[0].TTL != 260 {
+		t.Fatalf("Get(www) = %+v, want one answer with TTL 260", got)
+	}
+
+	if _, ok := c.Get("www.example.com", dnswire.TypeAAAA, dnswire.ClassIN); ok {
+		t.Fatalf("Get(www AAAA) hit, want miss")
+	}
+
+	// The CNAME's TTL is the lowest, so it decides when the entry goes
+	clock.Advance(20 * time.Second)
+	if _, ok := c.Get("alias.example.com", dnswire.TypeA, dnswire.ClassIN); ok {
+		t.Fatalf("Get(alias) hit after 60s, want it expired")
+	}
+	if _, ok := c.Get("www.example.com", dnswire.TypeA, dnswire.ClassIN); !ok {
+		t.Fatalf("Get(www) missed after 60s, want hit")
+	}
+	if c.Len() != 1 {
+		t.Errorf("Len = %d, want the expired entry gone", c.Len())
+	}
+}
+
+func TestCache_MaxTTL(t *testing.T) {
+	c, clock := newTestCache(CachePolicy{MaxTTL: time.Hour})
+
+	a := withTTL(mustRR(t, "www.example.com.", &rr.A{Address: net.ParseIP("192.0.2.1")}), 7*24*3600)
+	c.Put(cachedReply("www.example.com.", dnswire.TypeA, 0, []dnswire.ResourceRecord{a}, nil))
+
+	got, ok := c.Get("www.example.com", dnswire.TypeA, dnswire.ClassIN)
+	if !ok || got.Answers[0].TTL != 3600 {
+		t.Fatalf("Get = %+v, %v; want TTL capped to 3600", got, ok)
+	}
+
+	clock.Advance(time.Hour)
+	if _, ok := c.Get("www.example.com", dnswire.TypeA, dnswire.ClassIN); ok {
+		t.Fatalf("Get hit after MaxTTL, want miss")
+	}
+}
+
+func TestCache_PositiveAuthorityTTL(t *testing.T) {
+	c, _ := newTestCache(CachePolicy{MaxTTL: time.Hour, MaxNegativeTTL: time.Minute})
+
+	// a positive answer's NS records are only capped by MaxTTL, not the
+	// negative cap
+	a := withTTL(mustRR(t, "www.example.com.", &rr.A{Address: net.ParseIP("192.0.2.1")}), 1800)
+	ns := withTTL(mustRR(t, "example.com.", &rr.NS{Host: "ns1.example.com."}), 1800)
+	c.Put(cachedReply("www.example.com.", dnswire.TypeA, 0, []dnswire.ResourceRecord{a}, []dnswire.ResourceRecord{ns}))
+
+	got, ok := c.Get("www.example.com", dnswire.TypeA, dnswire.ClassIN)
+	if !ok || got.Authority[0].TTL != 1800 {
+		t.Fatalf("Get = %+v, %v; want the authority NS left at 1800", got, ok)
+	}
+}
+
+func TestCache_Negative(t *testing.T) {
+	c, clock := newTestCache(CachePolicy{})
+
+	// SOA TTL 3600 but MINIMUM 120 - the negative TTL is the smaller
+	soa := withTTL(mustRR(t, "example.com.", &rr.SOA{MName: "ns1.example.com.", RName: "hostmaster.example.com.", Serial: 1, Minimum: 120}), 3600)
+	cname := withTTL(mustRR(t, "alias.example.com.", &rr.CNAME{Target: "www.example.com."}), 600)
+	soaList := []dnswire.ResourceRecord{soa}
+
+	c.Put(cachedReply("nope.example.com.", dnswire.TypeA, 3, nil, soaList))                                 // NXDOMAIN
+	c.Put(cachedReply("www.example.com.", dnswire.TypeAAAA, 0, nil, soaList))                               // NODATA
+	c.Put(cachedReply("alias.example.com.", dnswire.TypeAAAA, 0, []dnswire.ResourceRecord{cname}, soaList)) // NODATA after a CNAME
+	c.Put(cachedReply("nosoa.example.com.", dnswire.TypeA, 3, nil, nil))                                    // can't tell how long
+	c.Put(cachedReply("broken.example.com.", dnswire.TypeA, 2, nil, soaList))                               // SERVFAIL
+
+	got, ok := c.Get("nope.example.com", dnswire.TypeA, dnswire.ClassIN)
+	if !ok || got.Rcode() != 3 || len(got.Authority) != 1 {
+		t.Fatalf("Get(nope) = %+v, %v; want cached NXDOMAIN with the SOA", got, ok)
+	}
+	for _, name := range []string{"www.example.com", "alias.example.com"} {
+		if got, ok := c.Get(name, dnswire.TypeAAAA, dnswire.ClassIN); !ok || got.Rcode() != 0 {
+			t.Fatalf("Get(%s AAAA) = %+v, %v; want cached NODATA", name, got, ok)
+		}
+	}
+	for _, name := range []string{"nosoa.example.com", "broken.example.com"} {
+		if _, ok := c.Get(name, dnswire.TypeA, dnswire.ClassIN); ok {
+			t.Errorf("Get(%s) hit, want it never cached", name)
+		}
+	}
+
+	clock.Advance(120 * time.Second)
+	if _, ok := c.Get("nope.example.com", dnswire.TypeA, dnswire.ClassIN); ok {
+		t.Errorf("Get(nope) hit after the SOA minimum, want miss")
+	}
+}
+
+func TestCache_LRU(t *testing.T) {
+	c, _ := newTestCache(CachePolicy{MaxEntries: 2})
+
+	put := func(name string) {
+		a := mustRR(t, name, &rr.A{Address: net.ParseIP("192.0.2.1")})
+		c.Put(cachedReply(name, dnswire.TypeA, 0, []dnswire.ResourceRecord{a}, nil))
+	}
+	has := func(name string) bool {
+		_, ok := c.Get(name, dnswire.TypeA, dnswire.ClassIN)
+		return ok
+	}
+
+	put("a.example.")
+	put("b.example.")
+	has("a.example.") // a is now the most recently used
+	put("c.example.")
+
+	if !has("a.example.") || has("b.example.") || !has("c.example.") {
+		t.Fatalf("after eviction: a=%v b=%v c=%v, want b evicted", has("a.example."), has("b.example."), has("c.example."))
+	}
+	if c.Len() != 2 {
+		t.Fatalf("Len = %d, want 2", c.Len())
+	}
+}
+
+func TestCache_Concurrent(t *testing.T) {
+	c, clock := newTestCache(CachePolicy{MaxEntries: 50})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				name := fmt.Sprintf("host%d.example.", (g*7+i)%80)
+				if _, ok := c.Get(name, dnswire.TypeA, dnswire.ClassIN); !ok {
+					a := mustRR(t, name, &rr.A{Address: net.ParseIP("192.0.2.1")})
+					c.Put(cachedReply(name, dnswire.TypeA, 0, []dnswire.ResourceRecord{a}, nil))
+				}
+				if i%50 == 0 {
+					clock.Advance(time.Second)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if c.Len() > 50 {
+		t.Fatalf("Len = %d, want at most 50", c.Len())
+	}
+}
+
+func TestResolver_Cache(t *testing.T) {
+	var queries atomic.Int32
+	server := fakeServer(t, func(q *dnswire.Message) []*dnswire.Message {
+		queries.Add(1)
+		if q.Questions[0].Name == "nope.example.com." {
+			m := reply(q)
+			m.Header.Rcode = 3
+			m.Authority = []dnswire.ResourceRecord{mustRR(t, "example.com.", &rr.SOA{MName: "ns1.example.com.", RName: "hostmaster.example.com.", Minimum: 60})}
+			return []*dnswire.Message{m}
+		}
+		return []*dnswire.Message{reply(q, mustRR(t, q.Questions[0].Name, &rr.A{Address: net.ParseIP("192.0.2.1")}))}
+	})
+
+	r := New(server, false)
+	r.SetCache(NewCache(CachePolicy{}))
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if ips, err := r.LookupA(ctx, "www.example.com"); err != nil || len(ips) != 1 {
+			t.Fatalf("LookupA = %v, %v", ips, err)
+		}
+		var rcodeErr *RcodeError
+		if _, err := r.LookupA(ctx, "nope.example.com"); !errors.As(err, &rcodeErr) || rcodeErr.Rcode != 3 {
+			t.Fatalf("LookupA(nope) error = %v, want NXDOMAIN", err)
+		}
+	}
+
+	if n := queries.Load(); n != 2 {
+		t.Fatalf("sent %d queries, want 2 (one per name)", n)
+	}
+}
+
+// Clients asking for the same thing at once share one upstream query.
+func TestResolver_SharedMiss(t *testing.T) {
+	var queries atomic.Int32
+	server := fakeServer(t, func(q *dnswire.Message) []*dnswire.Message {
+		queries.Add(1)
+		time.Sleep(100 * time.Millisecond)
+		return []*dnswire.Message{reply(q, mustRR(t, q.Questions[0].Name, &rr.A{Address: net.ParseIP("192.0.2.1")}))}
+	})
+
+	r := New(server, false)
+	r.SetCache(NewCache(CachePolicy{}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ips, err := r.LookupA(context.Background(), "www.example.com"); err != nil || len(ips) != 1 {
+				t.Errorf("LookupA = %v, %v", ips, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := queries.Load(); n != 1 {
+		t.Fatalf("sent %d queries, want 1", n)
+	}
+}
+
+func TestCache_Stale(t *testing.T) {
+	c, clock := newTestCache(CachePolicy{StaleWindow: time.Hour})
+
+	a := withTTL(mustRR(t, "www.example.com.", &rr.A{Address: net.ParseIP("192.0.2.1")}), 60)
+	c.Put(cachedReply("www.example.com.", dnswire.TypeA, 0, []dnswire.ResourceRecord{a}, nil))
+
+	if _, ok := c.GetStale("www.example.com", dnswire.TypeA, dnswire.ClassIN); ok {
+		t.Fatalf("GetStale hit on a fresh entry, want miss")
+	}
+
+	clock.Advance(61 * time.Second)
+	if _, ok := c.Get("www.example.com", dnswire.TypeA, dnswire.ClassIN); ok {
+		t.Fatalf("Get hit after expiry, want miss")
+	}
+	got, ok := c.GetStale("www.example.com", dnswire.TypeA, dnswire.ClassIN)
+	if !ok || got.Answers[0].TTL != staleTTL {
+		t.Fatalf("GetStale = %+v, %v; want the old answer with TTL %d", got, ok, staleTTL)
+	}
+
+	clock.Advance(time.Hour)
+	if _, ok := c.GetStale("www.example.com", dnswire.TypeA, dnswire.ClassIN); ok {
+		t.Fatalf("GetStale hit after the stale window, want miss")
+	}
+	if c.Len() != 0 {
+		t.Fatalf("Len = %d, want the entry gone", c.Len())
+	}
+}
+
+func TestResolver_ServeStale(t *testing.T) {
+	var down atomic.Value // "", "servfail" or "silent"
+	down.Store("")
+
+	server := fakeServer(t, func(q *dnswire.Message) []*dnswire.Message {
+		switch down.Load() {
+		case "servfail":
+			m := reply(q)
+			m.Header.Rcode = 2
+			return []*dnswire.Message{m}
+		case "silent":
+			return nil
+		}
+		return []*dnswire.Message{reply(q, mustRR(t, q.Questions[0].Name, &rr.A{Address: net.ParseIP("192.0.2.1")}))}
+	})
+
+	for _, window := range []time.Duration{0, time.Hour} {
+		for _, how := range []string{"servfail", "silent"} {
+			down.Store("")
+
+			r := New(server, false)
+			r.SetPolicy(Policy{Timeout: 50 * time.Millisecond, Attempts: 1})
+			c, clock := newTestCache(CachePolicy{StaleWindow: window})
+			r.SetCache(c)
+
+			if _, err := r.LookupA(context.Background(), "www.example.com"); err != nil {
+				t.Fatalf("LookupA while up: %v", err)
+			}
+
+			down.Store(how)
+			clock.Advance(10 * time.Minute)
+
+			ips, err := r.LookupA(context.Background(), "www.example.com")
+			if window == 0 {
+				if err == nil {
+					t.Errorf("upstream %s, no stale window: LookupA = %v, want an error", how, ips)
+				}
+				continue
+			}
+			if err != nil || len(ips) != 1 || !ips[0].Equal(net.ParseIP("192.0.2.1")) {
+				t.Errorf("upstream %s, stale window: LookupA = %v, %v; want the stale answer", how, ips, err)
+			}
+		}
+	}
+}
+
+func TestResolver_Prefetch(t *testing.T) {
+	var queries atomic.Int32
+	server := fakeServer(t, func(q *dnswire.Message) []*dnswire.Message {
+		queries.Add(1)
+		return []*dnswire.Message{reply(q, mustRR(t, q.Questions[0].Name, &rr.A{Address: net.ParseIP("192.0.2.1")}))}
+	})
+
+	r := New(server, false)
+	c, clock := newTestCache(CachePolicy{PrefetchHits: 2})
+	r.SetCache(c)
+	ctx := context.Background()
+
+	lookup := func() {
+		t.Helper()
+		if ips, err := r.LookupA(ctx, "www.example.com"); err != nil || len(ips) != 1 {
+			t.Fatalf("LookupA = %v, %v", ips, err)
+		}
+	}
+
+	lookup() // miss, TTL 300
+
+	// Into the last 10% of the TTL. One hit isn't popular enough yet...
+	clock.Advance(280 * time.Second)
+	lookup()
+	time.Sleep(50 * time.Millisecond)
+	if n := queries.Load(); n != 1 {
+		t.Fatalf("sent %d queries after the first late hit, want 1", n)
+	}
+
+	// ...two is, and it comes back from the cache while the refresh happens
+	lookup()
+	deadline := time.Now().Add(2 * time.Second)
+	for queries.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := queries.Load(); n != 2 {
+		t.Fatalf("sent %d queries, want the prefetch to make it 2", n)
+	}
+
+	// The refreshed entry lands just after the query is answered
+	for time.Now().Before(deadline) {
+		if reply, ok := c.Get("www.example.com", dnswire.TypeA, dnswire.ClassIN); ok && reply.Answers[0].TTL == 300 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Past the original expiry, still no need to ask
+	clock.Advance(40 * time.Second)
+	lookup()
+	if n := queries.Load(); n != 2 {
+		t.Fatalf("sent %d queries after expiry, want the prefetched entry used", n)
+	}
+}
+
+func TestResolver_PrefetchFailure(t *testing.T) {
+	var queries atomic.Int32
+	server := fakeServer(t, func(q *dnswire.Message) []*dnswire.Message {
+		m := reply(q, mustRR(t, q.Questions[0].Name, &rr.A{Address: net.ParseIP("192.0.2.1")}))
+		if queries.Add(1) == 2 {
+			m.Answers = nil
+			m.Header.Rcode = 2 // the first prefetch gets SERVFAIL
+		}
+		return []*dnswire.Message{m}
+	})
+
+	r := New(server, false)
+	r.SetPolicy(Policy{Timeout: time.Second, Attempts: 1})
+	c, clock := newTestCache(CachePolicy{PrefetchHits: 1})
+	r.SetCache(c)
+	ctx := context.Background()
+
+	waitFor := func(n int32) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for queries.Load() < n && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if got := queries.Load(); got != n {
+			t.Fatalf("sent %d queries, want %d", got, n)
+		}
+	}
+
+	if _, err := r.LookupA(ctx, "www.example.com"); err != nil {
+		t.Fatalf("LookupA: %v", err)
+	}
+	clock.Advance(280 * time.Second)
+
+	// The failed prefetch mustn't stop the next hit trying again
+	if _, err := r.LookupA(ctx, "www.example.com"); err != nil {
+		t.Fatalf("LookupA: %v", err)
+	}
+	waitFor(2)
+	time.Sleep(50 * time.Millisecond)
+	if _, err := r.LookupA(ctx, "www.example.com"); err != nil {
+		t.Fatalf("LookupA: %v", err)
+	}
+	waitFor(3)
+}
+
+// lockedBuffer is a bytes.Buffer a background goroutine can write to while
+// the test reads it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Len()
+}
+
+func TestResolver_PrefetchDrawsNothing(t *testing.T) {
+	var queries atomic.Int32
+	server := fakeServer(t, func(q *dnswire.Message) []*dnswire.Message {
+		queries.Add(1)
+		return []*dnswire.Message{reply(q, mustRR(t, q.Questions[0].Name, &rr.A{Address: net.ParseIP("192.0.2.1")}))}
+	})
+
+	var out lockedBuffer
+	r := New(server, true)
+	r.SetDiagramOutput(&out)
+	c, clock := newTestCache(CachePolicy{PrefetchHits: 1})
+	r.SetCache(c)
+	ctx := context.Background()
+
+	if _, err := r.LookupA(ctx, "www.example.com"); err != nil {
+		t.Fatalf("LookupA: %v", err)
+	}
+	drawn := out.Len()
+	if drawn == 0 {
+		t.Fatalf("nothing drawn for the first lookup")
+	}
+
+	clock.Advance(280 * time.Second)
+	if _, err := r.LookupA(ctx, "www.example.com"); err != nil {
+		t.Fatalf("LookupA: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for queries.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := queries.Load(); n != 2 {
+		t.Fatalf("sent %d queries, want the prefetch to make it 2", n)
+	}
+	if out.Len() != drawn {
+		t.Fatalf("the prefetch drew its packets too")
+	}
+}
@@ -0,0 +1,181 @@
+package resolver
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"dnstom/internal/dnswire"
+	"dnstom/internal/rr"
+)
+
+// hosts(5) - static name/address overrides, checked before the network.
+//
+//	127.0.0.1   localhost
+//	192.0.2.10  api.dev.example.com api   # comments are fine
+//	2001:db8::a api.dev.example.com
+//
+// The file is re-read whenever its modification time or size changes, so
+// editing /etc/hosts takes effect on the next lookup without a restart.
+
+// HostsPath is the system hosts file.
+const HostsPath = "/etc/hosts"
+
+// Hosts answers A/AAAA/PTR lookups from a hosts-format file.
+type Hosts struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	byName  map[string][]net.IP // lower case, absolute name -> addresses, in file order
+	byAddr  map[string][]string // net.IP.String() -> names, in file order
+}
+
+// NewHosts returns a Hosts backed by the file at path. The file doesn't have
+// to exist (yet) - a missing file just has no entries.
+func NewHosts(path string) *Hosts {
+	return &Hosts{path: path}
+}
+
+// SetHosts makes the resolver check h before sending A, AAAA or PTR
+// queries. Pass nil to turn it off again.
+func (r *Resolver) SetHosts(h *Hosts) {
+	r.hosts = h
+}
+
+// hostsTTL is the TTL on records made from the hosts file. The file can
+// change whenever (it's re-read when it does), so they're not for keeping.
+const hostsTTL = 0
+
+// reply answers name/qtype from the file the way a server would - A and
+// AAAA by name, PTR by an in-addr.arpa or ip6.arpa name - or returns nil if
+// the file has nothing for it. It's what LookupA, LookupAAAA and LookupPTR
+// see, as a whole message for Search.
+func (h *Hosts) reply(name string, qtype uint16) *dnswire.Message {
+
+	name = fqdn(name)
+
+	var found []rr.RData
+	switch qtype {
+	case dnswire.TypeA:
+		for _, ip := range h.LookupHost(name, true) {
+			found = append(found, &rr.A{Address: ip})
+		}
+	case dnswire.TypeAAAA:
+		for _, ip := range h.LookupHost(name, false) {
+			found = append(found, &rr.AAAA{Address: ip})
+		}
+	case dnswire.TypePTR:
+		if addr := reverseAddr(name); addr != "" {
+			for _, target := range h.LookupAddr(addr) {
+				found = append(found, &rr.PTR{Target: target})
+			}
+		}
+	}
+	if len(found) == 0 {
+		return nil
+	}
+
+	m := &dnswire.Message{
+		Header:    dnswire.Header{QR: true, RD: true, RA: true},
+		Questions: []dnswire.Question{{Name: name, Type: qtype, Class: dnswire.ClassIN}},
+	}
+	for _, d := range found {
+		answer, err := rr.New(name, hostsTTL, d)
+		if err != nil {
+			return nil
+		}
+		m.Answers = append(m.Answers, answer)
+	}
+	return m
+}
+
+// LookupHost returns the addresses for name from the file, IPv4 or IPv6
+// depending on want4.
+func (h *Hosts) LookupHost(name string, want4 bool) []net.IP {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.reloadIfChanged()
+
+	var ips []net.IP
+	for _, ip := range h.byName[strings.ToLower(fqdn(name))] {
+		if (ip.To4() != nil) == want4 {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// LookupAddr returns the names for addr from the file, each with a trailing dot.
+func (h *Hosts) LookupAddr(addr string) []string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.reloadIfChanged()
+
+	return append([]string(nil), h.byAddr[ip.String()]...)
+}
+
+// reloadIfChanged re-reads the file if it looks different from last time.
+// Called with h.mu held.
+func (h *Hosts) reloadIfChanged() {
+
+	info, err := os.Stat(h.path)
+	if err != nil {
+		// Gone (or never there): forget what we had
+		h.byName, h.byAddr = nil, nil
+		h.modTime, h.size = time.Time{}, 0
+		return
+	}
+
+	if h.byName != nil && info.ModTime().Equal(h.modTime) && info.Size() == h.size {
+		return
+	}
+
+	f, err := os.Open(h.path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	byName := make(map[string][]net.IP)
+	byAddr := make(map[string][]string)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		// fe80::1%lo0 - the zone doesn't matter for a DNS answer
+		ip := net.ParseIP(strings.SplitN(fields[0], "%", 2)[0])
+		if ip == nil {
+			continue
+		}
+
+		for _, name := range fields[1:] {
+			key := strings.ToLower(fqdn(name))
+			byName[key] = append(byName[key], ip)
+			byAddr[ip.String()] = append(byAddr[ip.String()], fqdn(name))
+		}
+	}
+
+	h.byName, h.byAddr = byName, byAddr
+	h.modTime, h.size = info.ModTime(), info.Size()
+}
@@ -0,0 +1,166 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"dnstom/internal/dnswire"
+	"dnstom/internal/rr"
+)
+
+func TestHosts_Fixture(t *testing.T) {
+	h := NewHosts("testdata/hosts")
+
+	tests := []struct {
+		name  string
+		want4 bool
+		want  []string
+	}{
+		{"localhost", true, []string{"127.0.0.1"}},
+		{"localhost.", false, []string{"::1"}},
+		{"API.DEV.EXAMPLE.COM", true, []string{"192.0.2.10", "192.0.2.11"}},
+		{"api.dev.example.com", false, []string{"2001:db8::a"}},
+		{"api", true, []string{"192.0.2.10"}},
+		{"api", false, nil},
+		{"linklocal", false, []string{"fe80::1"}},
+		{"ignored.example.com", true, nil},
+		{"nowhere.example.com", true, nil},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, ip := range h.LookupHost(tt.name, tt.want4) {
+			got = append(got, ip.String())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("LookupHost(%q, %v) = %q, want %q", tt.name, tt.want4, got, tt.want)
+		}
+	}
+
+	if got, want := h.LookupAddr("192.0.2.10"), []string{"api.dev.example.com.", "api."}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LookupAddr(192.0.2.10) = %q, want %q", got, want)
+	}
+	if got := h.LookupAddr("192.0.2.99"); got != nil {
+		t.Errorf("LookupAddr(192.0.2.99) = %q, want nothing for a line with no names", got)
+	}
+}
+
+func TestHosts_ReloadOnChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	h := NewHosts(path)
+
+	// Missing file is just empty
+	if got := h.LookupHost("box.example.com", true); got != nil {
+		t.Fatalf("LookupHost with no file = %v, want nothing", got)
+	}
+
+	write := func(content string, mtime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// Set the mtime explicitly so we don't depend on filesystem granularity
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	base := time.Now().Add(-time.Hour)
+	write("192.0.2.1 box.example.com\n", base)
+	if got := h.LookupHost("box.example.com", true); len(got) != 1 || !got[0].Equal(net.ParseIP("192.0.2.1")) {
+		t.Fatalf("LookupHost = %v, want [192.0.2.1]", got)
+	}
+
+	// Same size, new mtime
+	write("192.0.2.2 box.example.com\n", base.Add(time.Second))
+	if got := h.LookupHost("box.example.com", true); len(got) != 1 || !got[0].Equal(net.ParseIP("192.0.2.2")) {
+		t.Fatalf("LookupHost after edit = %v, want [192.0.2.2]", got)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := h.LookupHost("box.example.com", true); got != nil {
+		t.Fatalf("LookupHost after remove = %v, want nothing", got)
+	}
+}
+
+func TestResolver_HostsBeforeNetwork(t *testing.T) {
+	var queries atomic.Int32
+	addr := fakeServer(t, func(q *dnswire.Message) []*dnswire.Message {
+		queries.Add(1)
+		return []*dnswire.Message{reply(q, mustRR(t, q.Questions[0].Name, &rr.A{Address: net.ParseIP("198.51.100.1")}))}
+	})
+
+	r := New(addr, false)
+	r.SetHosts(NewHosts("testdata/hosts"))
+	ctx := context.Background()
+
+	ips, err := r.LookupA(ctx, "api.dev.example.com")
+	if err != nil || len(ips) != 2 || !ips[0].Equal(net.ParseIP("192.0.2.10")) {
+		t.Fatalf("LookupA from hosts = %v, %v", ips, err)
+	}
+
+	names, err := r.LookupPTR(ctx, "2001:db8::a")
+	if err != nil || !reflect.DeepEqual(names, []string{"api.dev.example.com."}) {
+		t.Fatalf("LookupPTR from hosts = %q, %v", names, err)
+	}
+
+	if n := queries.Load(); n != 0 {
+		t.Fatalf("hosts answers still sent %d queries to the network", n)
+	}
+
+	// Not in the file, so it goes upstream
+	ips, err = r.LookupA(ctx, "www.example.com")
+	if err != nil || len(ips) != 1 || !ips[0].Equal(net.ParseIP("198.51.100.1")) {
+		t.Fatalf("LookupA from network = %v, %v", ips, err)
+	}
+	if n := queries.Load(); n != 1 {
+		t.Fatalf("sent %d queries, want 1", n)
+	}
+}
+
+func TestResolver_SearchHosts(t *testing.T) {
+	var queries atomic.Int32
+	addr := fakeServer(t, func(q *dnswire.Message) []*dnswire.Message {
+		queries.Add(1)
+		return []*dnswire.Message{reply(q)}
+	})
+
+	r := New(addr, false)
+	r.SetHosts(NewHosts("testdata/hosts"))
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		qtype  uint16
+		answer string
+	}{
+		{"api.dev.example.com", dnswire.TypeA, "192.0.2.10"},
+		{"api.dev.example.com", dnswire.TypeAAAA, "2001:db8::a"},
+		{"a.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa", dnswire.TypePTR, "api.dev.example.com."},
+	}
+	for _, tt := range tests {
+		reply, info, err := r.Search(ctx, tt.name, tt.qtype)
+		if err != nil || info.Hosts != "testdata/hosts" || info.Server != "" || len(reply.Answers) == 0 {
+			t.Fatalf("Search(%s %s) = %+v, %+v, %v; want an answer from the hosts file", tt.name, dnswire.TypeToString(tt.qtype), reply, info, err)
+		}
+		if got := dnswire.FormatRData(tt.qtype, reply.Answers[0].RData, ""); got != tt.answer {
+			t.Errorf("Search(%s %s) answer = %s, want %s", tt.name, dnswire.TypeToString(tt.qtype), got, tt.answer)
+		}
+	}
+	if n := queries.Load(); n != 0 {
+		t.Fatalf("hosts answers still sent %d queries to the network", n)
+	}
+
+	// The file has nothing for MX, so that's a question for the network
+	if _, info, err := r.Search(ctx, "api.dev.example.com", dnswire.TypeMX); err != nil || info.Server != addr || info.Hosts != "" {
+		t.Fatalf("Search(MX) = %+v, %v; want it asked of %s", info, err, addr)
+	}
+}
@@ -0,0 +1,485 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"dnstom/internal/dnswire"
+	"dnstom/internal/rr"
+)
+
+// Iterative resolution - being our own recursive resolver rather than
+// asking someone else's to do the work.
+//
+// Start at the roots and ask for the name without RD. Each server either
+// answers (it's authoritative), or refers us to the servers for a zone
+// closer to the name (NS records in the authority section, usually with
+// their addresses as glue in the additional section). Follow referrals
+// until someone answers:
+//
+//	. -> com. -> example.com. -> www.example.com. A 192.0.2.80
+//
+// If a referral comes without glue (the name servers live in some other
+// zone), resolve the name servers' addresses from the roots first. If the
+// answer is a CNAME (or a DNAME covering the name) start again with the
+// target.
+//
+// An answer is only believed for names inside the zone of the servers that
+// gave it. The example.com. servers can say www.example.com. is a CNAME for
+// www.victim.test., but any www.victim.test. A they put next to it is
+// dropped and the target looked up from the roots like any other name.
+
+// Limits on how much work one lookup can cause - a broken or hostile set
+// of zones can otherwise keep us busy forever.
+const (
+	maxReferrals   = 16  // referrals followed for one name
+	maxIterDepth   = 4   // nested lookups for glueless name server addresses
+	maxIterQueries = 100 // queries sent in total for one lookup
+)
+
+// ErrResolutionLimit means iterative resolution gave up: too many
+// referrals, CNAMEs, nested name server lookups or queries, or a
+// delegation that doesn't lead anywhere.
+var ErrResolutionLimit = errors.New("resolution limit exceeded")
+
+// NewIterative returns a Resolver that does iterative resolution from
+// hints (DefaultRootHints if nil) instead of forwarding to a server.
+func NewIterative(hints *RootHints, diagram bool) *Resolver {
+	if hints == nil {
+		hints = DefaultRootHints()
+	}
+	r := New("", diagram)
+	r.SetServers()
+	r.SetRootHints(hints)
+	return r
+}
+
+// SetRootHints switches r to iterative resolution starting from hints, or
+// back to forwarding to its servers if hints is nil.
+func (r *Resolver) SetRootHints(hints *RootHints) {
+	if hints == nil {
+		r.roots = nil
+		return
+	}
+	r.roots = hints.Servers
+}
+
+// iteration is the running total for one lookup, shared by any nested
+// lookups it needs.
+type iteration struct {
+	queries int
+}
+
+// resolveIterative finds name/qtype starting from the roots, and returns
+// a reply assembled from what the authoritative servers said: every
+// CNAME/DNAME on the way plus the final answer in the answer section, the
+// final server's authority section (the SOA, for a negative answer) and
+// its RCODE.
+func (r *Resolver) resolveIterative(ctx context.Context, name string, qtype uint16) (*dnswire.Message, error) {
+	return r.iterate(ctx, &iteration{}, fqdn(name), qtype, 0)
+}
+
+func (r *Resolver) iterate(ctx context.Context, it *iteration, name string, qtype uint16, depth int) (*dnswire.Message, error) {
+
+	if depth > maxIterDepth {
+		return nil, fmt.Errorf("resolve %s: name server lookups nested too deep: %w", name, ErrResolutionLimit)
+	}
+
+	result := &dnswire.Message{
+		Header:    dnswire.Header{QR: true, RD: true, RA: true},
+		Questions: []dnswire.Question{{Name: name, Type: qtype, Class: dnswire.ClassIN}},
+	}
+
+	current := name
+
+	for hops := 0; ; hops++ {
+		if hops > maxCNAMEChain {
+			return nil, fmt.Errorf("resolve %s: CNAME chain longer than %d: %w", name, maxCNAMEChain, ErrResolutionLimit)
+		}
+
+		reply, zone, err := r.iterateOne(ctx, it, current, qtype, depth)
+		if err != nil {
+			return nil, err
+		}
+
+		answers := inBailiwick(reply.Answers, zone)
+		result.Answers = append(result.Answers, answers...)
+		result.Authority = reply.Authority
+		result.Header.Rcode = reply.Header.Rcode
+
+		if reply.Rcode() != 0 || qtype == dnswire.TypeCNAME || qtype == dnswire.TypeDNAME {
+			return result, nil
+		}
+
+		// Follow any aliases this server gave us as far as they go. One
+		// leading out of the zone stops there, since nothing the server
+		// said about the target was kept.
+		target, synthesized := chaseAliases(answers, current, qtype)
+		result.Answers = append(result.Answers, synthesized...)
+
+		if target == current || hasRecords(answers, target, qtype) {
+			// Either the answer is here, or the name has no aliases and no
+			// qtype records - NODATA
+			return result, nil
+		}
+
+		if aliasLoop(result.Answers, name, target) {
+			return nil, fmt.Errorf("resolve %s: CNAME loop through %s: %w", name, target, ErrResolutionLimit)
+		}
+		current = target
+	}
+}
+
+// iterateOne follows referrals down from the closest delegation in the
+// cache (or the roots) until a server gives an answer (positive or
+// negative) for name/qtype, and returns it along with the zone of the
+// servers that gave it.
+func (r *Resolver) iterateOne(ctx context.Context, it *iteration, name string, qtype uint16, depth int) (*dnswire.Message, string, error) {
+
+	zone := "."
+	servers := r.roots
+	if r.cache != nil {
+		if cached, ns, ok := r.cache.closestCut(name); ok {
+			zone, servers = cached, ns
+		}
+	}
+
+	for referrals := 0; referrals <= maxReferrals; referrals++ {
+
+		reply, err := r.queryZone(ctx, it, zone, servers, name, qtype, depth)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if reply.Rcode() != 0 || len(reply.Answers) > 0 || reply.Header.AA {
+			return reply, zone, nil
+		}
+
+		child, ns := referral(reply, name)
+		if child == "" {
+			// No answer and nowhere else to go: a NODATA without AA
+			return reply, zone, nil
+		}
+
+		// A referral has to get closer to the name, otherwise we're being
+		// sent round in circles (or back up the tree by a lame server)
+		if !isSubdomain(child, zone) || strings.EqualFold(child, zone) {
+			return nil, "", fmt.Errorf("resolve %s: %s servers referred us to %s: %w", name, zone, child, ErrResolutionLimit)
+		}
+
+		servers = glue(reply, ns, zone)
+		if r.cache != nil {
+			r.cache.putCut(child, servers, cutTTL(reply, child, servers))
+		}
+		zone = child
+	}
+
+	return nil, "", fmt.Errorf("resolve %s: more than %d referrals: %w", name, maxReferrals, ErrResolutionLimit)
+}
+
+// queryZone asks the servers for zone about name/qtype, one at a time,
+// until one gives a usable reply. Servers we don't have an address for
+// are looked up (from the roots) only once the ones we do have are used up.
+func (r *Resolver) queryZone(ctx context.Context, it *iteration, zone string, servers []NameServer, name string, qtype uint16, depth int) (*dnswire.Message, error) {
+
+	var lastErr error
+
+	try := func(ips []net.IP) *dnswire.Message {
+		for _, ip := range ips {
+			if it.queries >= maxIterQueries {
+				lastErr = fmt.Errorf("resolve %s: more than %d queries: %w", name, maxIterQueries, ErrResolutionLimit)
+				return nil
+			}
+			it.queries++
+
+			q := &dnswire.Message{
+				Header:    dnswire.Header{ID: uint16(rand.Intn(65536))},
+				Questions: []dnswire.Question{{Name: name, Type: qtype, Class: dnswire.ClassIN}},
+			}
+			if r.edns {
+				q.OPT = dnswire.NewOPT()
+			}
+
+			reply, err := r.iterExchange(ctx, net.JoinHostPort(ip.String(), "53"), q)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			if retryable(reply) {
+				lastErr = fmt.Errorf("resolve %s: %s said rcode %d", name, ip, reply.Rcode())
+				continue
+			}
+			return reply
+		}
+		return nil
+	}
+
+	for _, ns := range servers {
+		if reply := try(ns.Addrs); reply != nil {
+			return reply, nil
+		}
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("resolve %s: %w", name, ctx.Err())
+		}
+	}
+
+	// Glueless: find out where the name servers are, then ask them
+	for _, ns := range servers {
+		if len(ns.Addrs) > 0 {
+			continue
+		}
+		if errors.Is(lastErr, ErrResolutionLimit) {
+			break
+		}
+
+		ips, err := r.lookupNameServer(ctx, it, ns.Name, depth+1)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if reply := try(ips); reply != nil {
+			return reply, nil
+		}
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("resolve %s: %w", name, ctx.Err())
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no addresses for any %s name server", zone)
+	}
+	return nil, fmt.Errorf("resolve %s: %w", name, lastErr)
+}
+
+// lookupNameServer resolves the addresses of a name server that came
+// without glue - IPv4 first, then IPv6 if there's no IPv4.
+func (r *Resolver) lookupNameServer(ctx context.Context, it *iteration, name string, depth int) ([]net.IP, error) {
+
+	var lastErr error
+	for _, qtype := range []uint16{dnswire.TypeA, dnswire.TypeAAAA} {
+		reply, err := r.iterate(ctx, it, name, qtype, depth)
+		if err != nil {
+			// Running out of budget is final; anything else, try AAAA
+			if errors.Is(err, ErrResolutionLimit) {
+				return nil, err
+			}
+			lastErr = err
+			continue
+		}
+
+		var ips []net.IP
+		for _, a := range answersFor(reply, name, qtype) {
+			if d, err := rr.Decode(a); err == nil {
+				switch d := d.(type) {
+				case *rr.A:
+					ips = append(ips, d.Address)
+				case *rr.AAAA:
+					ips = append(ips, d.Address)
+				}
+			}
+		}
+		if len(ips) > 0 {
+			return ips, nil
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("name server %s has no addresses", name)
+	}
+	return nil, lastErr
+}
+
+// iterExchange sends one non-recursive query to one server. Tests swap
+// r.exchangeHook in to send the query somewhere else.
+func (r *Resolver) iterExchange(ctx context.Context, server string, m *dnswire.Message) (*dnswire.Message, error) {
+	if r.exchangeHook != nil {
+		return r.exchangeHook(ctx, server, m)
+	}
+	reply, info, err := r.exchangeOnce(ctx, r.policy.withDefaults(), server, m)
+	if err == nil {
+		noteReply(ctx, info)
+	}
+	return reply, err
+}
+
+// referral picks the delegation out of a non-authoritative reply: the
+// zone named by the NS records in the authority section (which has to
+// contain name) and the name servers for it.
+func referral(reply *dnswire.Message, name string) (string, []string) {
+
+	var zone string
+	var ns []string
+
+	for _, a := range reply.Authority {
+		if a.Type != dnswire.TypeNS || !isSubdomain(name, a.Name) {
+			continue
+		}
+		if zone != "" && !strings.EqualFold(a.Name, zone) {
+			continue
+		}
+		d, err := rr.Decode(a)
+		if err != nil {
+			continue
+		}
+		zone = a.Name
+		ns = append(ns, d.(*rr.NS).Host)
+	}
+
+	return zone, ns
+}
+
+// glue pairs each name server with any addresses for it in the additional
+// section. Only addresses inside zone (the zone of the servers that sent
+// the referral) are believed - they have no business telling us where
+// anything else lives, and that's the classic cache poisoning trick.
+func glue(reply *dnswire.Message, names []string, zone string) []NameServer {
+
+	servers := make([]NameServer, 0, len(names))
+
+	for _, name := range names {
+		ns := NameServer{Name: name}
+		if isSubdomain(name, zone) {
+			for _, a := range reply.Additional {
+				if !strings.EqualFold(a.Name, name) || (a.Type != dnswire.TypeA && a.Type != dnswire.TypeAAAA) {
+					continue
+				}
+				if d, err := rr.Decode(a); err == nil {
+					switch d := d.(type) {
+					case *rr.A:
+						ns.Addrs = append(ns.Addrs, d.Address)
+					case *rr.AAAA:
+						ns.Addrs = append(ns.Addrs, d.Address)
+					}
+				}
+			}
+		}
+		servers = append(servers, ns)
+	}
+
+	return servers
+}
+
+// inBailiwick keeps the answers owned by names in zone.
+func inBailiwick(answers []dnswire.ResourceRecord, zone string) []dnswire.ResourceRecord {
+	var kept []dnswire.ResourceRecord
+	for _, a := range answers {
+		if isSubdomain(a.Name, zone) {
+			kept = append(kept, a)
+		}
+	}
+	return kept
+}
+
+// cutTTL is how long the delegation to zone in reply is good for: the
+// shortest TTL of its NS records and the glue that was kept.
+func cutTTL(reply *dnswire.Message, zone string, servers []NameServer) time.Duration {
+
+	ttl := uint32(math.MaxUint32)
+	for _, a := range reply.Authority {
+		if a.Type == dnswire.TypeNS && strings.EqualFold(a.Name, zone) {
+			ttl = min(ttl, a.TTL)
+		}
+	}
+	for _, ns := range servers {
+		if len(ns.Addrs) == 0 {
+			continue
+		}
+		for _, a := range reply.Additional {
+			if (a.Type == dnswire.TypeA || a.Type == dnswire.TypeAAAA) && strings.EqualFold(a.Name, ns.Name) {
+				ttl = min(ttl, a.TTL)
+			}
+		}
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+// chaseAliases follows CNAMEs (and DNAMEs) from name through answers and
+// returns where they lead. A DNAME without the CNAME a server is supposed
+// to synthesize alongside it gets its CNAME made up here, so the assembled
+// answer always reads as a plain CNAME chain.
+func chaseAliases(answers []dnswire.ResourceRecord, name string, qtype uint16) (string, []dnswire.ResourceRecord) {
+
+	current := name
+	var synthesized []dnswire.ResourceRecord
+
+	for hops := 0; hops <= maxCNAMEChain; hops++ {
+		if hasRecords(answers, current, qtype) {
+			break
+		}
+
+		next := ""
+		for _, a := range answers {
+			if a.Type == dnswire.TypeCNAME && strings.EqualFold(a.Name, current) {
+				if d, err := rr.Decode(a); err == nil {
+					next = d.(*rr.CNAME).Target
+				}
+				break
+			}
+		}
+
+		if next == "" {
+			for _, a := range answers {
+				if a.Type != dnswire.TypeDNAME || strings.EqualFold(a.Name, current) || !isSubdomain(current, a.Name) {
+					continue
+				}
+				target, _, err := dnswire.DecodeName(a.RData, 0)
+				if err != nil {
+					continue
+				}
+				// www.old.example. with old.example. DNAME new.example. -> www.new.example.
+				next = current[:len(current)-len(a.Name)] + target
+				if target == "." {
+					next = current[:len(current)-len(a.Name)]
+				}
+				if cname, err := rr.New(current, a.TTL, &rr.CNAME{Target: next}); err == nil {
+					synthesized = append(synthesized, cname)
+				}
+				break
+			}
+		}
+
+		if next == "" {
+			break
+		}
+		current = next
+	}
+
+	return current, synthesized
+}
+
+// hasRecords reports whether answers has a qtype record for name.
+func hasRecords(answers []dnswire.ResourceRecord, name string, qtype uint16) bool {
+	for _, a := range answers {
+		if a.Type == qtype && strings.EqualFold(a.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// aliasLoop reports whether target is a name we've already been sent away
+// from - the start, or the owner of one of the CNAMEs collected so far.
+func aliasLoop(answers []dnswire.ResourceRecord, start, target string) bool {
+	if strings.EqualFold(start, target) {
+		return true
+	}
+	for _, a := range answers {
+		if a.Type == dnswire.TypeCNAME && strings.EqualFold(a.Name, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSubdomain reports whether child is parent or somewhere below it.
+// Both are absolute names; case doesn't matter, and neither does a dot
+// escaped inside a label (evil\.example.com. isn't in example.com.).
+func isSubdomain(child, parent string) bool {
+	return dnswire.IsSubdomain(child, parent)
+}
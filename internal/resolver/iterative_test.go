@@ -0,0 +1,352 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"dnstom/internal/dnswire"
+	"dnstom/internal/rr"
+)
+
+// fakeAuth is a (very) small authoritative server for zone: referrals for
+// NS records below the apex (with any glue it has), answers with AA for
+// names it owns, DNAME for names under one, and NXDOMAIN/NODATA with the
+// SOA otherwise. No CNAME chasing - the resolver has to do that.
+func fakeAuth(t *testing.T, zone string, records ...dnswire.ResourceRecord) string {
+	t.Helper()
+
+	return fakeServer(t, func(q *dnswire.Message) []*dnswire.Message {
+		name, qtype := q.Questions[0].Name, q.Questions[0].Type
+		resp := &dnswire.Message{
+			Header:    dnswire.Header{ID: q.Header.ID, QR: true},
+			Questions: q.Questions,
+		}
+
+		cut := ""
+		for _, rec := range records {
+			if rec.Type == dnswire.TypeNS && !strings.EqualFold(rec.Name, zone) && isSubdomain(name, rec.Name) {
+				cut = rec.Name
+			}
+		}
+		if cut != "" {
+			for _, rec := range records {
+				if rec.Type == dnswire.TypeNS && rec.Name == cut {
+					resp.Authority = append(resp.Authority, rec)
+					host, _, _ := dnswire.DecodeName(rec.RData, 0)
+					for _, g := range records {
+						if g.Name == host && (g.Type == dnswire.TypeA || g.Type == dnswire.TypeAAAA) {
+							resp.Additional = append(resp.Additional, g)
+						}
+					}
+				}
+			}
+			return []*dnswire.Message{resp}
+		}
+
+		resp.Header.AA = true
+		exists := false
+		for _, rec := range records {
+			if strings.EqualFold(rec.Name, name) {
+				exists = true
+				if rec.Type == qtype || rec.Type == dnswire.TypeCNAME {
+					resp.Answers = append(resp.Answers, rec)
+				}
+			}
+		}
+		if len(resp.Answers) == 0 {
+			for _, rec := range records {
+				if rec.Type == dnswire.TypeDNAME && rec.Name != name && isSubdomain(name, rec.Name) {
+					resp.Answers = append(resp.Answers, rec)
+				}
+			}
+		}
+		if len(resp.Answers) == 0 {
+			for _, rec := range records {
+				if rec.Type == dnswire.TypeSOA {
+					resp.Authority = append(resp.Authority, rec)
+				}
+			}
+			if !exists {
+				resp.Header.Rcode = 3
+			}
+		}
+		return []*dnswire.Message{resp}
+	})
+}
+
+// iterativeTestResolver builds a little delegation tree out of fake
+// servers and returns an iterative resolver pointed at it:
+//
+//	.              192.0.2.1  com. and net. delegated, with glue
+//	com.           192.0.2.2  example.com. (glue), other.com. (glueless, plus
+//	                          some out-of-bailiwick "glue" that must be ignored),
+//	                          lame.com. (back to itself)
+//	net.           192.0.2.4  ns.hosting.net.
+//	example.com.   192.0.2.3  www, aliases, a DNAME and a CNAME loop
+//	other.com.     192.0.2.5  www
+func iterativeTestResolver(t *testing.T) *Resolver {
+	t.Helper()
+
+	a := func(ip string) rr.RData { return &rr.A{Address: net.ParseIP(ip)} }
+	ns := func(host string) rr.RData { return &rr.NS{Host: host} }
+	soa := func(zone string) dnswire.ResourceRecord {
+		return mustRR(t, zone, &rr.SOA{MName: "ns." + strings.TrimPrefix(zone, "."), RName: "hostmaster.test.", Serial: 1, Minimum: 300})
+	}
+	dname := func(owner, target string) dnswire.ResourceRecord {
+		data, err := dnswire.EncodeName(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mustRR(t, owner, &rr.Unknown{RRType: dnswire.TypeDNAME, Data: data})
+	}
+
+	addrs := map[string]string{
+		"192.0.2.1": fakeAuth(t, ".",
+			soa("."),
+			mustRR(t, ".", ns("a.root.test.")),
+			mustRR(t, "com.", ns("ns1.com.")),
+			mustRR(t, "ns1.com.", a("192.0.2.2")),
+			mustRR(t, "net.", ns("ns1.net.")),
+			mustRR(t, "ns1.net.", a("192.0.2.4")),
+		),
+		"192.0.2.2": fakeAuth(t, "com.",
+			soa("com."),
+			mustRR(t, "com.", ns("ns1.com.")),
+			mustRR(t, "ns1.com.", a("192.0.2.2")),
+			mustRR(t, "example.com.", ns("ns1.example.com.")),
+			mustRR(t, "ns1.example.com.", a("192.0.2.3")),
+			mustRR(t, "other.com.", ns("ns.hosting.net.")),
+			mustRR(t, "ns.hosting.net.", a("203.0.113.66")),
+			mustRR(t, "lame.com.", ns("ns1.com.")),
+		),
+		"192.0.2.4": fakeAuth(t, "net.",
+			soa("net."),
+			mustRR(t, "net.", ns("ns1.net.")),
+			mustRR(t, "ns.hosting.net.", a("192.0.2.5")),
+		),
+		"192.0.2.3": fakeAuth(t, "example.com.",
+			soa("example.com."),
+			mustRR(t, "example.com.", ns("ns1.example.com.")),
+			mustRR(t, "www.example.com.", a("192.0.2.80")),
+			mustRR(t, "mail.example.com.", a("192.0.2.25")),
+			mustRR(t, "alias.example.com.", &rr.CNAME{Target: "www.other.com."}),
+			mustRR(t, "loop1.example.com.", &rr.CNAME{Target: "loop2.example.com."}),
+			mustRR(t, "loop2.example.com.", &rr.CNAME{Target: "loop1.example.com."}),
+			dname("old.example.com.", "example.com."),
+		),
+		"192.0.2.5": fakeAuth(t, "other.com.",
+			soa("other.com."),
+			mustRR(t, "other.com.", ns("ns.hosting.net.")),
+			mustRR(t, "www.other.com.", a("192.0.2.81")),
+		),
+	}
+
+	return fakeTree(t, addrs)
+}
+
+// fakeTree returns an iterative resolver whose root is 192.0.2.1 and whose
+// queries for an address in addrs go to the fake server there instead.
+func fakeTree(t *testing.T, addrs map[string]string) *Resolver {
+	t.Helper()
+
+	r := NewIterative(&RootHints{Servers: []NameServer{{Name: "a.root.test.", Addrs: []net.IP{net.ParseIP("192.0.2.1")}}}}, false)
+	r.exchangeHook = func(ctx context.Context, server string, m *dnswire.Message) (*dnswire.Message, error) {
+		host, _, _ := net.SplitHostPort(server)
+		addr, ok := addrs[host]
+		if !ok {
+			t.Errorf("query for %s sent to %s, which isn't one of ours", m.Questions[0].Name, server)
+			return nil, fmt.Errorf("no such server %s", server)
+		}
+		if m.Header.RD {
+			t.Errorf("iterative query for %s has RD set", m.Questions[0].Name)
+		}
+		reply, _, err := r.exchangeOnce(ctx, r.policy.withDefaults(), addr, m)
+		return reply, err
+	}
+
+	return r
+}
+
+func TestIterative_FollowsReferrals(t *testing.T) {
+	r := iterativeTestResolver(t)
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"www.example.com", "192.0.2.80"},
+		// CNAME into a zone whose NS has no glue - and poisoned "glue" from com.
+		{"alias.example.com", "192.0.2.81"},
+		// DNAME with no synthesized CNAME from the server
+		{"www.old.example.com", "192.0.2.80"},
+	}
+
+	for _, tt := range tests {
+		ips, err := r.LookupA(context.Background(), tt.name)
+		if err != nil {
+			t.Errorf("LookupA(%s) error: %v", tt.name, err)
+			continue
+		}
+		if len(ips) != 1 || ips[0].String() != tt.want {
+			t.Errorf("LookupA(%s) = %v, want [%s]", tt.name, ips, tt.want)
+		}
+	}
+}
+
+// A server's answer is only good for its own zone: the forged victim.test.
+// A next to the CNAME is dropped and the real one fetched from test.
+func TestIterative_Bailiwick(t *testing.T) {
+	a := func(ip string) rr.RData { return &rr.A{Address: net.ParseIP(ip)} }
+	ns := func(host string) rr.RData { return &rr.NS{Host: host} }
+
+	forger := fakeServer(t, func(q *dnswire.Message) []*dnswire.Message {
+		resp := reply(q,
+			mustRR(t, "a.example.", &rr.CNAME{Target: "victim.test."}),
+			mustRR(t, "victim.test.", a("203.0.113.66")),
+		)
+		resp.Header.AA = true
+		return []*dnswire.Message{resp}
+	})
+
+	r := fakeTree(t, map[string]string{
+		"192.0.2.1": fakeAuth(t, ".",
+			mustRR(t, "example.", ns("ns.example.")),
+			mustRR(t, "ns.example.", a("192.0.2.10")),
+			mustRR(t, "test.", ns("ns.test.")),
+			mustRR(t, "ns.test.", a("192.0.2.11")),
+		),
+		"192.0.2.10": forger,
+		"192.0.2.11": fakeAuth(t, "test.",
+			mustRR(t, "test.", ns("ns.test.")),
+			mustRR(t, "victim.test.", a("192.0.2.99")),
+		),
+	})
+
+	msg, err := r.Resolve(context.Background(), "a.example", dnswire.TypeA)
+	if err != nil {
+		t.Fatalf("Resolve error: %v", err)
+	}
+	var got []string
+	for _, ans := range msg.Answers {
+		d, err := rr.Decode(ans)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, ans.Name+" "+d.String())
+	}
+	want := "a.example. victim.test.|victim.test. 192.0.2.99"
+	if strings.Join(got, "|") != want {
+		t.Errorf("answers = %q, want %q", strings.Join(got, "|"), want)
+	}
+}
+
+func TestIterative_AssembledReply(t *testing.T) {
+	r := iterativeTestResolver(t)
+
+	reply, err := r.Resolve(context.Background(), "www.old.example.com", dnswire.TypeA)
+	if err != nil {
+		t.Fatalf("Resolve error: %v", err)
+	}
+
+	var got []string
+	for _, a := range reply.Answers {
+		got = append(got, fmt.Sprintf("%s %d", a.Name, a.Type))
+	}
+	want := "old.example.com. 39|www.old.example.com. 5|www.example.com. 1" // DNAME, CNAME, A
+	if strings.Join(got, "|") != want {
+		t.Errorf("answers = %q, want %q", strings.Join(got, "|"), want)
+	}
+}
+
+// Referrals are cached: once we've been sent to example.com.'s servers,
+// the next name under it goes straight there - until the NS TTL is up.
+func TestIterative_CachedDelegation(t *testing.T) {
+	r := iterativeTestResolver(t)
+	c, clock := newTestCache(CachePolicy{})
+	r.SetCache(c)
+
+	var asked []string
+	hook := r.exchangeHook
+	r.exchangeHook = func(ctx context.Context, server string, m *dnswire.Message) (*dnswire.Message, error) {
+		host, _, _ := net.SplitHostPort(server)
+		asked = append(asked, host)
+		return hook(ctx, server, m)
+	}
+
+	lookup := func(name, want string) {
+		t.Helper()
+		asked = nil
+		if _, err := r.LookupA(context.Background(), name); err != nil {
+			t.Fatalf("LookupA(%s) error: %v", name, err)
+		}
+		if got := strings.Join(asked, " "); got != want {
+			t.Errorf("LookupA(%s) asked %s, want %s", name, got, want)
+		}
+	}
+
+	lookup("www.example.com", "192.0.2.1 192.0.2.2 192.0.2.3")
+	lookup("mail.example.com", "192.0.2.3")
+
+	clock.Advance(301 * time.Second)
+	lookup("www.example.com", "192.0.2.1 192.0.2.2 192.0.2.3")
+}
+
+func TestIterative_Negative(t *testing.T) {
+	r := iterativeTestResolver(t)
+	ctx := context.Background()
+
+	reply, err := r.Resolve(ctx, "nope.example.com", dnswire.TypeA)
+	if err != nil {
+		t.Fatalf("Resolve error: %v", err)
+	}
+	if reply.Rcode() != 3 || len(reply.Authority) != 1 || reply.Authority[0].Type != dnswire.TypeSOA {
+		t.Fatalf("NXDOMAIN reply = rcode %d, authority %+v; want rcode 3 with the SOA", reply.Rcode(), reply.Authority)
+	}
+
+	var rcodeErr *RcodeError
+	if _, err := r.LookupA(ctx, "nope.example.com"); !errors.As(err, &rcodeErr) || rcodeErr.Rcode != 3 {
+		t.Errorf("LookupA(nope) error = %v, want NXDOMAIN", err)
+	}
+
+	// NODATA
+	ips, err := r.LookupAAAA(ctx, "mail.example.com")
+	if err != nil || len(ips) != 0 {
+		t.Errorf("LookupAAAA(mail) = %v, %v; want nothing, no error", ips, err)
+	}
+}
+
+func TestIterative_Limits(t *testing.T) {
+	r := iterativeTestResolver(t)
+
+	for _, name := range []string{"loop1.example.com", "www.lame.com"} {
+		_, err := r.LookupA(context.Background(), name)
+		if !errors.Is(err, ErrResolutionLimit) {
+			t.Errorf("LookupA(%s) error = %v, want ErrResolutionLimit", name, err)
+		}
+	}
+}
+
+func TestRootHints(t *testing.T) {
+	h := DefaultRootHints()
+	if len(h.Servers) != 13 {
+		t.Fatalf("DefaultRootHints has %d servers, want 13", len(h.Servers))
+	}
+	if s := h.Servers[0]; s.Name != "a.root-servers.net." || len(s.Addrs) != 2 || s.Addrs[0].String() != "198.41.0.4" {
+		t.Errorf("first root = %+v", s)
+	}
+
+	_, err := ParseRootHints(strings.NewReader("; nothing here\n.  3600000  NS  A.ROOT-SERVERS.NET.\n"))
+	if err == nil {
+		t.Errorf("ParseRootHints without addresses succeeded, want error")
+	}
+
+	_, err = ParseRootHints(strings.NewReader("A.ROOT-SERVERS.NET.  3600000  A  2001:db8::1\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("ParseRootHints with an IPv6 A record error = %v, want a line 1 error", err)
+	}
+}
@@ -0,0 +1,235 @@
+package resolver
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"dnstom/internal/dnswire"
+	"dnstom/internal/rr"
+)
+
+// Typed lookups, roughly mirroring net.Resolver. They all go through
+// Exchange, follow CNAMEs in the answer, and return an *RcodeError for
+// NXDOMAIN/SERVFAIL/etc. A name with no records of the type gives an empty
+// result and no error.
+
+// LookupAAAA returns the IPv6 addresses for name, from the hosts file if
+// it has any.
+func (r *Resolver) LookupAAAA(ctx context.Context, name string) ([]net.IP, error) {
+
+	if r.hosts != nil {
+		if ips := r.hosts.LookupHost(name, false); len(ips) > 0 {
+			return ips, nil
+		}
+	}
+
+	records, err := r.lookup(ctx, name, dnswire.TypeAAAA)
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	for _, d := range records {
+		ips = append(ips, d.(*rr.AAAA).Address)
+	}
+	return ips, nil
+}
+
+// LookupMX returns the mail exchangers for name, lowest preference first.
+func (r *Resolver) LookupMX(ctx context.Context, name string) ([]*rr.MX, error) {
+
+	records, err := r.lookup(ctx, name, dnswire.TypeMX)
+	if err != nil {
+		return nil, err
+	}
+
+	var mxs []*rr.MX
+	for _, d := range records {
+		mxs = append(mxs, d.(*rr.MX))
+	}
+	sort.SliceStable(mxs, func(i, j int) bool { return mxs[i].Preference < mxs[j].Preference })
+	return mxs, nil
+}
+
+// LookupTXT returns the TXT records for name. Each record's strings are
+// joined together, the same as net.LookupTXT (so a long SPF record split
+// into 255 byte chunks comes back whole).
+func (r *Resolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+
+	records, err := r.lookup(ctx, name, dnswire.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+
+	var txts []string
+	for _, d := range records {
+		txts = append(txts, strings.Join(d.(*rr.TXT).Strings, ""))
+	}
+	return txts, nil
+}
+
+// LookupNS returns the name servers for name.
+func (r *Resolver) LookupNS(ctx context.Context, name string) ([]string, error) {
+
+	records, err := r.lookup(ctx, name, dnswire.TypeNS)
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []string
+	for _, d := range records {
+		hosts = append(hosts, d.(*rr.NS).Host)
+	}
+	return hosts, nil
+}
+
+// LookupSRV returns the SRV records for name (e.g. "_sip._udp.example.com"),
+// ordered by priority and then by weight, heaviest first.
+func (r *Resolver) LookupSRV(ctx context.Context, name string) ([]*rr.SRV, error) {
+
+	records, err := r.lookup(ctx, name, dnswire.TypeSRV)
+	if err != nil {
+		return nil, err
+	}
+
+	var srvs []*rr.SRV
+	for _, d := range records {
+		srvs = append(srvs, d.(*rr.SRV))
+	}
+	sort.SliceStable(srvs, func(i, j int) bool {
+		if srvs[i].Priority != srvs[j].Priority {
+			return srvs[i].Priority < srvs[j].Priority
+		}
+		return srvs[i].Weight > srvs[j].Weight
+	})
+	return srvs, nil
+}
+
+// LookupPTR does a reverse lookup of addr (an IPv4 or IPv6 address) and
+// returns the names it maps to, from the hosts file if it has any.
+func (r *Resolver) LookupPTR(ctx context.Context, addr string) ([]string, error) {
+
+	if r.hosts != nil {
+		if names := r.hosts.LookupAddr(addr); len(names) > 0 {
+			return names, nil
+		}
+	}
+
+	name, err := reverseName(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := r.lookup(ctx, name, dnswire.TypePTR)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, d := range records {
+		names = append(names, d.(*rr.PTR).Target)
+	}
+	return names, nil
+}
+
+// LookupCNAME returns the canonical name for name: the end of its CNAME
+// chain, or name itself if it isn't an alias.
+func (r *Resolver) LookupCNAME(ctx context.Context, name string) (string, error) {
+
+	// Ask for A rather than CNAME: a recursive server chases the chain for
+	// an A query, but only returns the first hop for a CNAME one.
+	reply, found, _, err := r.search(ctx, name, dnswire.TypeA)
+	if err != nil {
+		return "", err
+	}
+
+	return canonicalName(reply, found), nil
+}
+
+// canonicalName follows the CNAME chain for name through the answer
+// section and returns where it ends.
+func canonicalName(m *dnswire.Message, name string) string {
+
+	current := fqdn(name)
+
+	for hops := 0; hops < maxCNAMEChain; hops++ {
+		target := ""
+		for _, a := range m.Answers {
+			if a.Type == dnswire.TypeCNAME && strings.EqualFold(a.Name, current) {
+				if d, err := rr.Decode(a); err == nil {
+					target = d.(*rr.CNAME).Target
+				}
+				break
+			}
+		}
+		if target == "" {
+			break
+		}
+		current = target
+	}
+
+	return current
+}
+
+// reverseName turns an address into its in-addr.arpa / ip6.arpa name.
+//
+//	192.0.2.1   -> 1.2.0.192.in-addr.arpa.
+//	2001:db8::1 -> 1.0.0.0. ... .8.b.d.0.1.0.0.2.ip6.arpa.
+func reverseName(addr string) (string, error) {
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return "", fmt.Errorf("reverse lookup: %q is not an IP address", addr)
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", ip4[3], ip4[2], ip4[1], ip4[0]), nil
+	}
+
+	const hexDigits = "0123456789abcdef"
+	var b strings.Builder
+	for i := len(ip) - 1; i >= 0; i-- {
+		b.WriteByte(hexDigits[ip[i]&0xF])
+		b.WriteByte('.')
+		b.WriteByte(hexDigits[ip[i]>>4])
+		b.WriteByte('.')
+	}
+	b.WriteString("ip6.arpa.")
+	return b.String(), nil
+}
+
+// reverseAddr is reverseName the other way round: the address an
+// in-addr.arpa or ip6.arpa name is for, or "" if name isn't one.
+func reverseAddr(name string) string {
+
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(fqdn(name), ".")), ".")
+	n := len(labels)
+
+	switch {
+	case n == 6 && labels[4] == "in-addr" && labels[5] == "arpa":
+		ip := net.ParseIP(labels[3] + "." + labels[2] + "." + labels[1] + "." + labels[0])
+		if ip == nil || ip.To4() == nil {
+			return ""
+		}
+		return ip.String()
+
+	case n == 34 && labels[32] == "ip6" && labels[33] == "arpa":
+		var hexDigits strings.Builder
+		for i := 31; i >= 0; i-- {
+			if len(labels[i]) != 1 {
+				return ""
+			}
+			hexDigits.WriteString(labels[i])
+		}
+		b, err := hex.DecodeString(hexDigits.String())
+		if err != nil {
+			return ""
+		}
+		return net.IP(b).String()
+	}
+	return ""
+}
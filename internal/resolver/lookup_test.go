@@ -0,0 +1,165 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"dnstom/internal/dnswire"
+	"dnstom/internal/rr"
+)
+
+// zoneServer answers from a fixed set of records, keyed by question type,
+// chasing the www CNAME like a recursive server would.
+func zoneServer(t *testing.T) string {
+	t.Helper()
+
+	records := map[uint16][]rr.RData{
+		dnswire.TypeAAAA: {&rr.AAAA{Address: net.ParseIP("2001:db8::1")}},
+		dnswire.TypeMX: {
+			&rr.MX{Preference: 20, Exchange: "backup.example.com."},
+			&rr.MX{Preference: 10, Exchange: "mail.example.com."},
+		},
+		dnswire.TypeTXT: {&rr.TXT{Strings: []string{"v=spf1 ", "-all"}}},
+		dnswire.TypeNS:  {&rr.NS{Host: "ns1.example.com."}, &rr.NS{Host: "ns2.example.com."}},
+		dnswire.TypeSRV: {
+			&rr.SRV{Priority: 20, Weight: 0, Port: 5060, Target: "sip3.example.com."},
+			&rr.SRV{Priority: 10, Weight: 10, Port: 5060, Target: "sip2.example.com."},
+			&rr.SRV{Priority: 10, Weight: 60, Port: 5060, Target: "sip1.example.com."},
+		},
+		dnswire.TypePTR: {&rr.PTR{Target: "host.example.com."}},
+	}
+
+	return fakeServer(t, func(q *dnswire.Message) []*dnswire.Message {
+		question := q.Questions[0]
+
+		if question.Name == "www.example.com." {
+			return []*dnswire.Message{reply(q,
+				mustRR(t, "www.example.com.", &rr.CNAME{Target: "web.example.net."}),
+				mustRR(t, "web.example.net.", &rr.A{Address: net.IPv4(192, 0, 2, 80)}),
+			)}
+		}
+
+		m := reply(q)
+		for _, d := range records[question.Type] {
+			m.Answers = append(m.Answers, mustRR(t, question.Name, d))
+		}
+		return []*dnswire.Message{m}
+	})
+}
+
+func TestTypedLookups(t *testing.T) {
+	r := New(zoneServer(t), false)
+
+	aaaa, err := r.LookupAAAA(context.Background(), "example.com")
+	if err != nil || len(aaaa) != 1 || !aaaa[0].Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("LookupAAAA = %v, %v", aaaa, err)
+	}
+
+	mx, err := r.LookupMX(context.Background(), "example.com")
+	if err != nil || len(mx) != 2 || mx[0].Exchange != "mail.example.com." || mx[1].Preference != 20 {
+		t.Errorf("LookupMX = %+v, %v; want sorted by preference", mx, err)
+	}
+
+	txt, err := r.LookupTXT(context.Background(), "example.com")
+	if err != nil || !reflect.DeepEqual(txt, []string{"v=spf1 -all"}) {
+		t.Errorf("LookupTXT = %q, %v", txt, err)
+	}
+
+	ns, err := r.LookupNS(context.Background(), "example.com")
+	if err != nil || !reflect.DeepEqual(ns, []string{"ns1.example.com.", "ns2.example.com."}) {
+		t.Errorf("LookupNS = %q, %v", ns, err)
+	}
+
+	srv, err := r.LookupSRV(context.Background(), "_sip._udp.example.com")
+	if err != nil || len(srv) != 3 ||
+		srv[0].Target != "sip1.example.com." || srv[1].Target != "sip2.example.com." || srv[2].Target != "sip3.example.com." {
+		t.Errorf("LookupSRV = %+v, %v; want priority then weight order", srv, err)
+	}
+
+	ptr, err := r.LookupPTR(context.Background(), "192.0.2.1")
+	if err != nil || !reflect.DeepEqual(ptr, []string{"host.example.com."}) {
+		t.Errorf("LookupPTR = %q, %v", ptr, err)
+	}
+
+	cname, err := r.LookupCNAME(context.Background(), "www.example.com")
+	if err != nil || cname != "web.example.net." {
+		t.Errorf("LookupCNAME = %q, %v; want web.example.net.", cname, err)
+	}
+
+	cname, err = r.LookupCNAME(context.Background(), "example.com")
+	if err != nil || cname != "example.com." {
+		t.Errorf("LookupCNAME (not an alias) = %q, %v; want example.com.", cname, err)
+	}
+}
+
+func TestExchange_ReturnsNXDOMAINAsReply(t *testing.T) {
+	server := fakeServer(t, func(q *dnswire.Message) []*dnswire.Message {
+		m := reply(q)
+		m.Header.Rcode = 3
+		return []*dnswire.Message{m}
+	})
+
+	q := &dnswire.Message{
+		Header:    dnswire.Header{ID: 42, RD: true},
+		Questions: []dnswire.Question{{Name: "nope.example.", Type: dnswire.TypeA, Class: dnswire.ClassIN}},
+	}
+
+	got, err := New(server, false).Exchange(context.Background(), q)
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if got.Header.ID != 42 || got.Rcode() != 3 {
+		t.Fatalf("Exchange = %+v, want ID 42 rcode 3", got.Header)
+	}
+}
+
+func TestExchange_ContextCancelled(t *testing.T) {
+	// Never answers
+	server := fakeServer(t, func(q *dnswire.Message) []*dnswire.Message { return nil })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	q := &dnswire.Message{
+		Questions: []dnswire.Question{{Name: "slow.example.", Type: dnswire.TypeA, Class: dnswire.ClassIN}},
+	}
+
+	start := time.Now()
+	_, err := New(server, false).Exchange(ctx, q)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Exchange error = %v, want context.Canceled", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("Exchange took %v to notice the cancel", time.Since(start))
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.1":   "1.2.0.192.in-addr.arpa.",
+		"2001:db8::1": "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+	}
+	for in, want := range tests {
+		got, err := reverseName(in)
+		if err != nil || got != want {
+			t.Errorf("reverseName(%q) = %q, %v; want %q", in, got, err, want)
+		}
+		if back := reverseAddr(strings.ToUpper(got)); back != in {
+			t.Errorf("reverseAddr(%q) = %q, want %q", got, back, in)
+		}
+	}
+	for _, name := range []string{"www.example.com.", "1.2.0.in-addr.arpa.", "300.2.0.192.in-addr.arpa.", "x.ip6.arpa."} {
+		if got := reverseAddr(name); got != "" {
+			t.Errorf("reverseAddr(%q) = %q, want nothing", name, got)
+		}
+	}
+
+	if _, err := reverseName("not-an-ip"); err == nil {
+		t.Errorf("reverseName(not-an-ip): expected error")
+	}
+}
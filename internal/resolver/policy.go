@@ -0,0 +1,86 @@
+package resolver
+
+import (
+	"context"
+	"time"
+
+	"dnstom/internal/dnswire"
+)
+
+// Policy controls how hard the resolver tries before giving up.
+//
+// One attempt is a pass over every server (starting from a different one
+// each time if Rotate is set), each getting Timeout to answer. Between
+// passes we wait Backoff, doubling each time. So with the defaults, two
+// servers and nobody home, a lookup gives up after roughly
+// 2 * (2 + 2) seconds + 100ms.
+//
+// The context passed to each call still has the last word: cancel it, or
+// give it a deadline, and the lookup stops there.
+type Policy struct {
+	Timeout  time.Duration // per query, per server
+	Attempts int           // passes over the server list
+	Backoff  time.Duration // pause before the second pass, doubled after that
+	Rotate   bool          // round robin the starting server (resolv.conf "options rotate")
+
+	// TCPOnly skips UDP altogether (dig +tcp). Without it, TCP is only used
+	// when a UDP reply comes back with TC set.
+	TCPOnly bool
+}
+
+// DefaultPolicy is close to glibc's defaults (timeout:5 attempts:2), with a
+// shorter timeout since nobody waits 5 seconds for DNS any more.
+func DefaultPolicy() Policy {
+	return Policy{
+		Timeout:  2 * time.Second,
+		Attempts: 2,
+		Backoff:  100 * time.Millisecond,
+	}
+}
+
+// withDefaults fills in anything left at zero, so Policy{Rotate: true} works.
+func (p Policy) withDefaults() Policy {
+	d := DefaultPolicy()
+	if p.Timeout <= 0 {
+		p.Timeout = d.Timeout
+	}
+	if p.Attempts <= 0 {
+		p.Attempts = 1
+	}
+	if p.Backoff < 0 {
+		p.Backoff = 0
+	}
+	return p
+}
+
+// budget is about how long giving up takes under p with this many servers
+// (the sum in the Policy comment), for lookups nobody set a deadline on.
+func (p Policy) budget(servers int) time.Duration {
+	d := time.Duration(p.Attempts*max(servers, 1)) * p.Timeout
+	for i, backoff := 1, p.Backoff; i < p.Attempts; i, backoff = i+1, backoff*2 {
+		d += backoff
+	}
+	return d
+}
+
+// retryable reports whether reply means "ask someone else": the server is
+// broken (SERVFAIL), doesn't do that (NOTIMP) or won't talk to us (REFUSED).
+func retryable(reply *dnswire.Message) bool {
+	switch reply.Rcode() {
+	case 2, 4, 5: // SERVFAIL, NOTIMP, REFUSED
+		return true
+	}
+	return false
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
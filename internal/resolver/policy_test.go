@@ -0,0 +1,137 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"dnstom/internal/dnswire"
+	"dnstom/internal/rr"
+)
+
+// countingServer answers every query with the given rcode (and an A record
+// if it's NOERROR), counting how many it has seen.
+func countingServer(t *testing.T, rcode uint8, hits *atomic.Int32) string {
+	t.Helper()
+	return fakeServer(t, func(q *dnswire.Message) []*dnswire.Message {
+		hits.Add(1)
+		m := reply(q)
+		m.Header.Rcode = rcode
+		if rcode == 0 {
+			m.Answers = append(m.Answers, mustRR(t, q.Questions[0].Name, &rr.A{Address: net.IPv4(192, 0, 2, 1)}))
+		}
+		return []*dnswire.Message{m}
+	})
+}
+
+// silentServer never answers.
+func silentServer(t *testing.T, hits *atomic.Int32) string {
+	t.Helper()
+	return fakeServer(t, func(q *dnswire.Message) []*dnswire.Message {
+		hits.Add(1)
+		return nil
+	})
+}
+
+func TestPolicy_FallsThroughToNextServer(t *testing.T) {
+	var silent, servfail, good atomic.Int32
+
+	r := New("", false)
+	r.SetServers(silentServer(t, &silent), countingServer(t, 2, &servfail), countingServer(t, 0, &good))
+	r.SetPolicy(Policy{Timeout: 100 * time.Millisecond, Attempts: 1})
+
+	ips, err := r.LookupA(context.Background(), "www.example.com")
+	if err != nil || len(ips) != 1 {
+		t.Fatalf("LookupA = %v, %v", ips, err)
+	}
+	if silent.Load() != 1 || servfail.Load() != 1 || good.Load() != 1 {
+		t.Fatalf("hits silent/servfail/good = %d/%d/%d, want 1/1/1", silent.Load(), servfail.Load(), good.Load())
+	}
+}
+
+func TestPolicy_AttemptsAndBackoff(t *testing.T) {
+	var hits atomic.Int32
+
+	r := New(silentServer(t, &hits), false)
+	r.SetPolicy(Policy{Timeout: 50 * time.Millisecond, Attempts: 3, Backoff: 20 * time.Millisecond})
+
+	start := time.Now()
+	_, err := r.LookupA(context.Background(), "www.example.com")
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatalf("expected error from a silent server")
+	}
+	if hits.Load() != 3 {
+		t.Fatalf("server saw %d queries, want 3", hits.Load())
+	}
+	// 3 x 50ms timeouts + 20ms + 40ms backoff
+	if elapsed < 210*time.Millisecond {
+		t.Fatalf("gave up after %v, expected at least 210ms", elapsed)
+	}
+}
+
+func TestPolicy_AllServersServfail(t *testing.T) {
+	var a, b atomic.Int32
+
+	r := New("", false)
+	r.SetServers(countingServer(t, 2, &a), countingServer(t, 5, &b))
+	r.SetPolicy(Policy{Timeout: time.Second, Attempts: 2})
+
+	_, err := r.LookupA(context.Background(), "www.example.com")
+
+	var rcodeErr *RcodeError
+	if !errors.As(err, &rcodeErr) || rcodeErr.Rcode != 5 {
+		t.Fatalf("got %v, want the last server's REFUSED", err)
+	}
+	if a.Load() != 2 || b.Load() != 2 {
+		t.Fatalf("hits = %d/%d, want 2/2", a.Load(), b.Load())
+	}
+}
+
+func TestPolicy_Rotate(t *testing.T) {
+	var a, b atomic.Int32
+
+	r := New("", false)
+	r.SetServers(countingServer(t, 0, &a), countingServer(t, 0, &b))
+	r.SetPolicy(Policy{Timeout: time.Second, Attempts: 1, Rotate: true})
+
+	for i := 0; i < 4; i++ {
+		if _, err := r.LookupA(context.Background(), "www.example.com"); err != nil {
+			t.Fatalf("LookupA: %v", err)
+		}
+	}
+	if a.Load() != 2 || b.Load() != 2 {
+		t.Fatalf("hits = %d/%d, want 2/2 with rotate", a.Load(), b.Load())
+	}
+}
+
+func TestPolicy_ContextDeadlineWins(t *testing.T) {
+	var hits atomic.Int32
+
+	r := New(silentServer(t, &hits), false)
+	r.SetPolicy(Policy{Timeout: 5 * time.Second, Attempts: 5})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := r.LookupA(ctx, "www.example.com")
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("took %v, the context should have stopped it at 100ms", time.Since(start))
+	}
+}
+
+func TestPolicy_Budget(t *testing.T) {
+	p := DefaultPolicy()
+	if got, want := p.budget(2), 2*(2+2)*time.Second+100*time.Millisecond; got != want {
+		t.Errorf("budget(2) = %v, want %v", got, want)
+	}
+}
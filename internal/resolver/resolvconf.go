@@ -0,0 +1,205 @@
+package resolver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// resolv.conf(5) - the host's stub resolver config. We only read the bits
+// that change what goes on the wire:
+//
+//	nameserver 192.0.2.53          up to 3 of them, tried in order
+//	search eng.example.com ...     domains to try short names in
+//	domain example.com             old form of a one-entry search list
+//	options ndots:2 timeout:3 attempts:4 rotate edns0
+//
+// Anything else (sortlist, unknown options) is ignored, same as glibc.
+
+// ResolvConfPath is where the system config lives on anything Unix-ish.
+const ResolvConfPath = "/etc/resolv.conf"
+
+// glibc limits (resolv.h), so a config behaves the same here as it does there.
+const (
+	maxNameservers = 3
+	maxNdots       = 15
+	maxTimeout     = 30
+	maxAttempts    = 5
+)
+
+// Config is a parsed resolv.conf.
+type Config struct {
+	Servers  []string // host:port, ready to dial
+	Search   []string // absolute, with the trailing dot
+	Ndots    int      // names with fewer dots than this try the search list first
+	Timeout  time.Duration
+	Attempts int
+	Rotate   bool
+	EDNS0    bool
+}
+
+// DefaultConfig is what glibc uses when resolv.conf is missing or empty:
+// a server on localhost, ndots:1, timeout:5, attempts:2.
+func DefaultConfig() *Config {
+	return &Config{
+		Servers:  []string{"127.0.0.1:53"},
+		Ndots:    1,
+		Timeout:  5 * time.Second,
+		Attempts: 2,
+	}
+}
+
+// LoadResolvConf reads and parses the file at path.
+func LoadResolvConf(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg, err := ParseResolvConf(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ParseResolvConf parses resolv.conf syntax. Lines it doesn't understand
+// are skipped rather than treated as errors - a stub resolver that refuses
+// to start over a typo isn't much use.
+func ParseResolvConf(r io.Reader) (*Config, error) {
+
+	cfg := DefaultConfig()
+	var servers []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// Comments start with # or ; (and glibc allows them mid-line too)
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "nameserver":
+			if len(fields) < 2 || len(servers) >= maxNameservers {
+				continue
+			}
+			// Ignore any IPv6 zone ("fe80::1%eth0") when checking it is an address, but keep it for dialling
+			addr := fields[1]
+			if net.ParseIP(strings.SplitN(addr, "%", 2)[0]) == nil {
+				continue
+			}
+			servers = append(servers, net.JoinHostPort(addr, "53"))
+
+		case "domain":
+			// domain and search overwrite each other - whichever is last wins
+			if len(fields) >= 2 {
+				cfg.Search = []string{fqdn(fields[1])}
+			}
+
+		case "search":
+			cfg.Search = nil
+			for _, d := range fields[1:] {
+				if d == "." {
+					continue
+				}
+				cfg.Search = append(cfg.Search, fqdn(d))
+			}
+
+		case "options":
+			for _, opt := range fields[1:] {
+				parseResolvOption(cfg, opt)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(servers) > 0 {
+		cfg.Servers = servers
+	}
+
+	return cfg, nil
+}
+
+// parseResolvOption applies one "options" word.
+func parseResolvOption(cfg *Config, opt string) {
+
+	name, value, _ := strings.Cut(opt, ":")
+	n, err := strconv.Atoi(value)
+	hasNumber := err == nil && n >= 0
+
+	switch name {
+	case "ndots":
+		if hasNumber {
+			cfg.Ndots = min(n, maxNdots)
+		}
+	case "timeout":
+		if hasNumber && n > 0 {
+			cfg.Timeout = time.Duration(min(n, maxTimeout)) * time.Second
+		}
+	case "attempts":
+		if hasNumber && n > 0 {
+			cfg.Attempts = min(n, maxAttempts)
+		}
+	case "rotate":
+		cfg.Rotate = true
+	case "edns0":
+		cfg.EDNS0 = true
+	}
+}
+
+// NameList returns the names to try, in order, for name - the search list
+// and ndots logic from resolv.conf(5):
+//
+//   - a name ending in "." is absolute and is only ever tried as-is
+//   - a name with at least Ndots dots is tried as-is first, then with each
+//     search domain appended
+//   - anything shorter tries the search domains first and itself last
+func (c *Config) NameList(name string) []string {
+
+	if strings.HasSuffix(name, ".") {
+		return []string{name}
+	}
+
+	var searched []string
+	for _, d := range c.Search {
+		searched = append(searched, name+"."+d)
+	}
+
+	if strings.Count(name, ".") >= c.Ndots {
+		return append([]string{name + "."}, searched...)
+	}
+	return append(searched, name+".")
+}
+
+// NewFromConfig builds a Resolver that behaves like the host's stub
+// resolver: its servers, search list, timeouts and EDNS setting.
+func NewFromConfig(cfg *Config, diagram bool) *Resolver {
+
+	r := New("", diagram)
+	r.SetServers(cfg.Servers...)
+	r.SetPolicy(Policy{
+		Timeout:  cfg.Timeout,
+		Attempts: cfg.Attempts,
+		Backoff:  DefaultPolicy().Backoff,
+		Rotate:   cfg.Rotate,
+	})
+	r.config = cfg
+	r.edns = cfg.EDNS0
+
+	return r
+}
@@ -0,0 +1,169 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"dnstom/internal/dnswire"
+	"dnstom/internal/rr"
+)
+
+func TestLoadResolvConf_Fixture(t *testing.T) {
+	cfg, err := LoadResolvConf("testdata/resolv.conf")
+	if err != nil {
+		t.Fatalf("LoadResolvConf error: %v", err)
+	}
+
+	want := &Config{
+		// Only the first 3 nameservers count
+		Servers:  []string{"192.0.2.53:53", "[2001:db8::53]:53", "198.51.100.53:53"},
+		Search:   []string{"eng.example.com.", "example.com."},
+		Ndots:    2,
+		Timeout:  3 * time.Second,
+		Attempts: 4,
+		Rotate:   true,
+		EDNS0:    true,
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("LoadResolvConf =\n%+v\nwant\n%+v", cfg, want)
+	}
+}
+
+func TestParseResolvConf_DefaultsAndLimits(t *testing.T) {
+	cfg, err := ParseResolvConf(strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("ParseResolvConf error: %v", err)
+	}
+	if !reflect.DeepEqual(cfg, DefaultConfig()) {
+		t.Fatalf("empty file = %+v, want defaults", cfg)
+	}
+
+	cfg, err = ParseResolvConf(strings.NewReader(`
+search a.example
+domain b.example
+nameserver not-an-ip
+options ndots:99 timeout:600 attempts:0 bogus
+`))
+	if err != nil {
+		t.Fatalf("ParseResolvConf error: %v", err)
+	}
+	if !reflect.DeepEqual(cfg.Search, []string{"b.example."}) {
+		t.Errorf("Search = %q, want the later domain line to win", cfg.Search)
+	}
+	if !reflect.DeepEqual(cfg.Servers, DefaultConfig().Servers) {
+		t.Errorf("Servers = %q, want the default when none are valid", cfg.Servers)
+	}
+	if cfg.Ndots != 15 || cfg.Timeout != 30*time.Second || cfg.Attempts != 2 {
+		t.Errorf("ndots/timeout/attempts = %d/%v/%d, want 15/30s/2", cfg.Ndots, cfg.Timeout, cfg.Attempts)
+	}
+}
+
+func TestConfig_NameList(t *testing.T) {
+	cfg := &Config{Search: []string{"eng.example.com.", "example.com."}, Ndots: 2}
+
+	tests := []struct {
+		name string
+		want []string
+	}{
+		// Fewer dots than ndots: search list first
+		{"www", []string{"www.eng.example.com.", "www.example.com.", "www."}},
+		{"db.prod", []string{"db.prod.eng.example.com.", "db.prod.example.com.", "db.prod."}},
+		// Enough dots: as-is first
+		{"www.example.org", []string{"www.example.org.", "www.example.org.eng.example.com.", "www.example.org.example.com."}},
+		// Absolute: never searched
+		{"www.", []string{"www."}},
+	}
+
+	for _, tt := range tests {
+		if got := cfg.NameList(tt.name); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("NameList(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewFromConfig_SearchesAndSkipsEDNS(t *testing.T) {
+	var mu sync.Mutex
+	var sawOPT bool
+	var asked []string
+
+	server := fakeServer(t, func(q *dnswire.Message) []*dnswire.Message {
+		mu.Lock()
+		asked = append(asked, q.Questions[0].Name)
+		sawOPT = sawOPT || q.OPT != nil
+		mu.Unlock()
+
+		m := reply(q)
+		if q.Questions[0].Name != "db.example.com." {
+			m.Header.Rcode = 3 // NXDOMAIN
+			return []*dnswire.Message{m}
+		}
+		m.Answers = append(m.Answers, mustRR(t, "db.example.com.", &rr.A{Address: net.IPv4(192, 0, 2, 5)}))
+		return []*dnswire.Message{m}
+	})
+
+	cfg := &Config{
+		Servers:  []string{server},
+		Search:   []string{"eng.example.com.", "example.com."},
+		Ndots:    1,
+		Timeout:  time.Second,
+		Attempts: 1,
+	}
+
+	ips, err := NewFromConfig(cfg, false).LookupA(context.Background(), "db")
+	if err != nil {
+		t.Fatalf("LookupA error: %v", err)
+	}
+	if len(ips) != 1 || !ips[0].Equal(net.IPv4(192, 0, 2, 5)) {
+		t.Fatalf("LookupA = %v, want [192.0.2.5]", ips)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"db.eng.example.com.", "db.example.com."}; !reflect.DeepEqual(asked, want) {
+		t.Fatalf("asked for %q, want %q", asked, want)
+	}
+	if sawOPT {
+		t.Fatalf("sent EDNS without options edns0")
+	}
+}
+
+func TestSearch_SearchListAndReplyInfo(t *testing.T) {
+	server := fakeServer(t, func(q *dnswire.Message) []*dnswire.Message {
+		m := reply(q)
+		if q.Questions[0].Name != "db.example.com." {
+			m.Header.Rcode = 3 // NXDOMAIN
+			return []*dnswire.Message{m}
+		}
+		m.Answers = append(m.Answers, mustRR(t, "db.example.com.", &rr.A{Address: net.IPv4(192, 0, 2, 5)}))
+		return []*dnswire.Message{m}
+	})
+
+	cfg := &Config{
+		Servers:  []string{server},
+		Search:   []string{"eng.example.com.", "example.com."},
+		Ndots:    1,
+		Timeout:  time.Second,
+		Attempts: 1,
+	}
+	r := NewFromConfig(cfg, false)
+
+	reply, info, err := r.Search(context.Background(), "db", dnswire.TypeA)
+	if err != nil || len(reply.Answers) != 1 || reply.Questions[0].Name != "db.example.com." {
+		t.Fatalf("Search(db) = %+v, %v; want the answer for db.example.com.", reply, err)
+	}
+	wire, _ := dnswire.EncodeMessage(reply)
+	if info.Server != server || info.Protocol != "UDP" || info.Size != len(wire) {
+		t.Fatalf("Search(db) info = %+v, want %s over UDP, %d bytes", info, server, len(wire))
+	}
+
+	// Nothing on the list exists: the last NXDOMAIN (for the name as it
+	// is, tried last) is the reply
+	reply, _, err = r.Search(context.Background(), "nope", dnswire.TypeA)
+	if err != nil || reply.Rcode() != 3 || reply.Questions[0].Name != "nope." {
+		t.Fatalf("Search(nope) = %+v, %v; want NXDOMAIN for nope.", reply, err)
+	}
+}
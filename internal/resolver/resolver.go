@@ -1,22 +1,75 @@
 package resolver
 
 import (
-	"encoding/hex"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand"
 	"net"
 	"os"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"dnstom/internal/dnswire"
+	"dnstom/internal/rr"
 )
 
 type Resolver struct {
-	server  string // e.g. "1.1.1.1:53"
-	diagram bool
+	servers    []string  // e.g. "1.1.1.1:53"
+	diagram    bool      // draw every query and reply (see showDiagram)
+	hexdump    bool      // ... as annotated hexdumps rather than RFC boxes
+	diagramOut io.Writer // ... here, or stdout if nil
+	policy     Policy
+	edns       bool    // send an OPT record with our queries
+	config     *Config // search list and ndots, if we came from resolv.conf
+	hosts      *Hosts  // checked before the network for A/AAAA/PTR, if set
+	cache      *Cache  // replies we can reuse, if set
+
+	// roots switches on iterative resolution (see iterative.go) in place of
+	// forwarding to servers
+	roots []NameServer
+
+	// exchangeHook, if set, replaces the network for iterative queries so
+	// tests can point them at fake servers
+	exchangeHook func(ctx context.Context, server string, m *dnswire.Message) (*dnswire.Message, error)
+
+	next atomic.Uint32 // where Policy.Rotate starts the next query
 }
 
 func New(server string, diagram bool) *Resolver {
-	return &Resolver{server: server}
+	return &Resolver{servers: []string{server}, diagram: diagram, policy: DefaultPolicy(), edns: true}
+}
+
+// SetServers replaces the list of upstream servers. They're tried in order
+// (or round robin, with Policy.Rotate) until one gives a usable answer.
+func (r *Resolver) SetServers(servers ...string) {
+	r.servers = append([]string(nil), servers...)
+}
+
+// SetPolicy sets the timeout/retry policy used by every query.
+func (r *Resolver) SetPolicy(p Policy) {
+	r.policy = p
+}
+
+// SetHexdump switches the diagrams (if the resolver was made with them on)
+// from RFC 1035 style boxes to hexdumps of the raw bytes with every field
+// labelled.
+func (r *Resolver) SetHexdump(on bool) {
+	r.hexdump = on
+}
+
+// SetDiagramOutput sends the diagrams (if the resolver was made with them
+// on) to w instead of stdout - stderr, say, when stdout is for something a
+// script will read.
+func (r *Resolver) SetDiagramOutput(w io.Writer) {
+	r.diagramOut = w
+}
+
+// Policy returns the current timeout/retry policy.
+func (r *Resolver) Policy() Policy {
+	return r.policy
 }
 
 // Called from main():
@@ -26,66 +79,552 @@ func New(server string, diagram bool) *Resolver {
 
 // Test data passed in name =  example.com
 
-// LookupA will eventually perform a real DNS query to r.server and return
-// IPv4 addresses (A records) for the given name.
-// Right now, EncodeQuery is called; UDP send/receive and response parsing
-// are left as detailed TODOs for you.
+// maxCNAMEChain is how many CNAMEs we'll follow through an answer section
+// before deciding it's a loop.
+const maxCNAMEChain = 16
+
+// RcodeError is returned when the server answers, but with something other
+// than NOERROR (NXDOMAIN, SERVFAIL, REFUSED...).
+type RcodeError struct {
+	Name  string
+	Rcode uint16
+}
+
+func (e *RcodeError) Error() string {
+	return fmt.Sprintf("lookup %s: rcode %d", e.Name, e.Rcode)
+}
+
+// ReplyInfo says how a reply got to us, for showing alongside it (dig's
+// footer). It's all zero for a reply that came from the cache.
+type ReplyInfo struct {
+	Server   string // host:port that sent it
+	Protocol string // "UDP" or "TCP" - TCP after a truncated UDP reply, too
+	Size     int    // bytes received
+	Hosts    string // the hosts file that answered instead, if one did
+}
+
+// replyInfoKey is the context key for the *ReplyInfo noteReply fills in.
+type replyInfoKey struct{}
 
-func (r *Resolver) LookupA(name string) ([]net.IP, error) {
-	// Step 1: Build a raw DNS query packet (header + question).
-	query, err := dnswire.EncodeQuery(name, dnswire.TypeA)
+// noteReply records how the reply being returned came, if whoever's asking
+// wants to know (see query). The last one noted wins, so for an iterative
+// lookup it's the server that gave the final answer.
+func noteReply(ctx context.Context, info ReplyInfo) {
+	if p, ok := ctx.Value(replyInfoKey{}).(*ReplyInfo); ok {
+		*p = info
+	}
+}
+
+// LookupA sends an A query for name upstream and returns the IPv4
+// addresses in the answer, following any CNAMEs the server included.
+// A name that exists but has no A records gives (nil, nil). If there's a
+// hosts file and it has IPv4 addresses for name, those win.
+func (r *Resolver) LookupA(ctx context.Context, name string) ([]net.IP, error) {
+
+	if r.hosts != nil {
+		if ips := r.hosts.LookupHost(name, true); len(ips) > 0 {
+			return ips, nil
+		}
+	}
+
+	records, err := r.lookup(ctx, name, dnswire.TypeA)
 	if err != nil {
-		return nil, fmt.Errorf("build DNS query: %w", err)
+		return nil, err
+	}
+
+	var ips []net.IP
+	for _, d := range records {
+		ips = append(ips, d.(*rr.A).Address)
+	}
+
+	return ips, nil
+}
+
+// Exchange sends m upstream and returns the reply. It's the one place that
+// actually talks to the network - the Lookup methods are all built on it -
+// so use it directly for anything they don't cover (other types, other
+// classes, flags, EDNS options...).
+//
+// Servers are tried according to the resolver's Policy: each attempt gets
+// Policy.Timeout, and a server that times out or answers SERVFAIL/REFUSED
+// moves us on to the next one. ctx bounds the whole thing.
+//
+// The reply is checked against m (ID, QR and question) but its RCODE isn't:
+// an NXDOMAIN comes back as a reply, not an error.
+func (r *Resolver) Exchange(ctx context.Context, m *dnswire.Message) (*dnswire.Message, error) {
+
+	if len(r.servers) == 0 {
+		return nil, fmt.Errorf("exchange: no servers configured")
+	}
+
+	p := r.policy.withDefaults()
+
+	start := 0
+	if p.Rotate {
+		start = int(r.next.Add(1)-1) % len(r.servers)
+	}
+
+	var lastReply *dnswire.Message
+	var lastInfo ReplyInfo
+	var lastErr error
+	backoff := p.Backoff
+
+	for attempt := 0; attempt < p.Attempts; attempt++ {
+		if attempt > 0 && backoff > 0 {
+			if err := sleep(ctx, backoff); err != nil {
+				return nil, fmt.Errorf("exchange: %w", err)
+			}
+			backoff *= 2
+		}
+
+		for i := range r.servers {
+			server := withDefaultPort(r.servers[(start+i)%len(r.servers)])
+
+			reply, info, err := r.exchangeOnce(ctx, p, server, m)
+
+			if err != nil {
+				// Our caller giving up isn't worth retrying
+				if ctx.Err() != nil {
+					return nil, fmt.Errorf("exchange with %s: %w", server, ctx.Err())
+				}
+				lastErr = err
+				continue
+			}
+
+			if retryable(reply) {
+				lastReply, lastInfo = reply, info
+				continue
+			}
+
+			noteReply(ctx, info)
+			return reply, nil
+		}
+	}
+
+	// Every server told us it couldn't help - that's still an answer
+	if lastReply != nil {
+		noteReply(ctx, lastInfo)
+		return lastReply, nil
+	}
+	return nil, lastErr
+}
+
+// exchangeOnce is a single attempt against one server: UDP, then TCP if
+// the reply came back truncated (TC=1) - or straight to TCP if the policy
+// says so. Each leg gets its own Policy.Timeout. The ReplyInfo is for the
+// leg that got the reply.
+func (r *Resolver) exchangeOnce(ctx context.Context, p Policy, server string, m *dnswire.Message) (*dnswire.Message, ReplyInfo, error) {
+
+	if !p.TCPOnly {
+		attemptCtx, cancel := context.WithTimeout(ctx, p.Timeout)
+		reply, size, err := r.exchangeUDP(attemptCtx, server, m)
+		cancel()
+
+		if err != nil || !reply.Header.TC {
+			return reply, ReplyInfo{Server: server, Protocol: "UDP", Size: size}, err
+		}
+		// Too big for UDP - the server sent what fitted and set TC, so ask
+		// again over TCP where there's no such limit
 	}
 
-	// Query message output (for testing)
-	fmt.Println("Hex dumper")
-	d := hex.Dumper(os.Stdout)
-	d.Write(query)
-	d.Close()
+	attemptCtx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+	reply, size, err := r.exchangeTCP(attemptCtx, server, m)
+	return reply, ReplyInfo{Server: server, Protocol: "TCP", Size: size}, err
+}
 
-	// Send query to name server
+// exchangeTCP is one query to one server over TCP, using the 2 byte length
+// framing from RFC 1035 4.2.2. It returns the reply and how big it was.
+func (r *Resolver) exchangeTCP(ctx context.Context, server string, m *dnswire.Message) (*dnswire.Message, int, error) {
 
-	nameserver := "8.8.8.8:53" // Replace with variable for parameter injection
+	query, err := dnswire.EncodeMessage(m)
+	if err != nil {
+		return nil, 0, fmt.Errorf("build DNS query: %w", err)
+	}
 
-	connection, err := net.Dial("udp", nameserver)
+	var dialer net.Dialer
+	connection, err := dialer.DialContext(ctx, "tcp", server)
 	if err != nil {
-		panic(err)
+		return nil, 0, fmt.Errorf("exchange with %s over tcp: %w", server, err)
 	}
 
 	defer connection.Close()
 
-	// Timeout in case we don't get a response (prevent hanging)
-	connection.SetDeadline((time.Now().Add(2 * time.Second)))
+	if d, ok := ctx.Deadline(); ok {
+		if err := connection.SetDeadline(d); err != nil {
+			return nil, 0, fmt.Errorf("exchange with %s over tcp: %w", server, err)
+		}
+	}
+	stop := context.AfterFunc(ctx, func() { connection.SetDeadline(time.Now()) })
+	defer stop()
 
-	// Send query packet
-	_, err = connection.Write(query)
-	if err != nil {
-		panic(err)
+	r.showDiagram(ctx, "sent to", server, query)
+	if err := dnswire.WriteTCPMessage(connection, query); err != nil {
+		return nil, 0, fmt.Errorf("exchange with %s over tcp: %w", server, err)
 	}
 
-	// Buffer for the response - 512 is the max for DNS over UDP
-	response := make([]byte, 512)
+	// TCP can't be spoofed the way UDP can, but a server could still be
+	// pipelining, so the same ID/question check applies.
+	for {
+		response, err := dnswire.ReadTCPMessage(connection)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, 0, fmt.Errorf("exchange with %s over tcp: %w", server, ctx.Err())
+			}
+			return nil, 0, fmt.Errorf("exchange with %s over tcp: %w", server, err)
+		}
+
+		reply, err := dnswire.DecodeMessage(response)
+		if err != nil {
+			return nil, 0, fmt.Errorf("exchange with %s over tcp: %w", server, err)
+		}
+		if !isReplyTo(&reply, m) {
+			continue
+		}
 
-	n, err := connection.Read(response)
+		r.showDiagram(ctx, "received from", server, response)
+		return &reply, len(response), nil
+	}
+}
+
+// exchangeUDP is one query to one server over UDP. It returns the reply and
+// how big it was.
+func (r *Resolver) exchangeUDP(ctx context.Context, server string, m *dnswire.Message) (*dnswire.Message, int, error) {
+
+	query, err := dnswire.EncodeMessage(m)
+	if err != nil {
+		return nil, 0, fmt.Errorf("build DNS query: %w", err)
+	}
+
+	var dialer net.Dialer
+	connection, err := dialer.DialContext(ctx, "udp", server)
 	if err != nil {
-		panic(err)
+		return nil, 0, fmt.Errorf("exchange with %s: %w", server, err)
+	}
+
+	defer connection.Close()
+
+	// Timeout in case we don't get a response (prevent hanging). Cancelling
+	// the context unblocks the read too.
+	if d, ok := ctx.Deadline(); ok {
+		if err := connection.SetDeadline(d); err != nil {
+			return nil, 0, fmt.Errorf("exchange with %s: %w", server, err)
+		}
+	}
+	stop := context.AfterFunc(ctx, func() { connection.SetDeadline(time.Now()) })
+	defer stop()
+
+	r.showDiagram(ctx, "sent to", server, query)
+	if _, err := connection.Write(query); err != nil {
+		return nil, 0, fmt.Errorf("exchange with %s: %w", server, err)
+	}
+
+	// Anything that doesn't match our ID and question (a late answer to an
+	// earlier query, or someone trying to spoof us) is dropped and we keep
+	// listening until the deadline.
+	response := make([]byte, 65535)
+
+	for {
+		n, err := connection.Read(response)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, 0, fmt.Errorf("exchange with %s: %w", server, ctx.Err())
+			}
+			return nil, 0, fmt.Errorf("exchange with %s: %w", server, err)
+		}
+
+		reply, err := dnswire.DecodeMessage(response[:n])
+		if err != nil {
+			continue
+		}
+		if !isReplyTo(&reply, m) {
+			continue
+		}
+
+		r.showDiagram(ctx, "received from", server, response[:n])
+		return &reply, n, nil
+	}
+}
+
+// showDiagram draws a packet we sent or got back, if the resolver was made
+// with diagram set. It's for watching what a lookup does from a terminal,
+// so it goes straight out (see SetDiagramOutput) - and only for lookups
+// someone is waiting on, not background ones like prefetches.
+func (r *Resolver) showDiagram(ctx context.Context, direction, server string, wire []byte) {
+	if !r.diagram || ctx.Value(backgroundKey{}) != nil {
+		return
+	}
+	w := r.diagramOut
+	if w == nil {
+		w = os.Stdout
+	}
+	fmt.Fprintf(w, "\n;; %s %s, %d bytes\n", direction, server, len(wire))
+	draw := dnswire.PrintDNSWireDiagram
+	if r.hexdump {
+		draw = dnswire.PrintHexdumpDiagram
+	}
+	if err := draw(wire, w); err != nil {
+		fmt.Fprintf(w, ";; can't draw it: %v\n", err)
+	}
+}
+
+// backgroundKey marks the context of a lookup nobody's waiting on, which
+// showDiagram keeps quiet about.
+type backgroundKey struct{}
+
+// Resolve finds name/qtype - by iterating from the roots if the resolver
+// has root hints, otherwise by sending a recursive query upstream - and
+// returns the reply. Like Exchange, a negative RCODE is a reply, not an
+// error.
+//
+// With a cache (SetCache), a fresh enough cached reply is returned without
+// asking anyone, and new replies are added to it. If asking fails and the
+// cache allows it, an expired reply is returned instead (see cache.go).
+func (r *Resolver) Resolve(ctx context.Context, name string, qtype uint16) (*dnswire.Message, error) {
+
+	if r.cache == nil {
+		return r.resolve(ctx, name, qtype)
+	}
+
+	cached, state := r.cache.lookup(name, qtype, dnswire.ClassIN, true)
+	switch state {
+	case cachePrefetch:
+		go r.prefetch(name, qtype)
+		return cached, nil
+	case cacheFresh:
+		return cached, nil
 	}
 
-	// err, answer := dnswire.DecodeMessage(response)
+	return r.cache.share(ctx, name, qtype, dnswire.ClassIN, func() (*dnswire.Message, error) {
+
+		reply, err := r.resolve(ctx, name, qtype)
+
+		// Upstream's gone (or broken): an old answer beats no answer
+		if err != nil || retryable(reply) {
+			if stale, ok := r.cache.GetStale(name, qtype, dnswire.ClassIN); ok {
+				return stale, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		r.cache.Put(reply)
 
-	// To test
+		return reply, nil
+	})
+}
+
+// prefetch refreshes a cache entry in the background. Nobody's waiting on
+// it, so it gets as long as the policy would give a lookup and no more. If
+// it doesn't work the entry just expires as normal - unless another hit
+// comes along first and has another go.
+func (r *Resolver) prefetch(name string, qtype uint16) {
 
-	fmt.Printf("Bytes received: %d   -    ", n)
-	// fmt.Printf("%x\n", answer)
+	ctx := context.WithValue(context.Background(), backgroundKey{}, true)
+	ctx, cancel := context.WithTimeout(ctx, r.policy.withDefaults().budget(len(r.servers)))
+	defer cancel()
 
-	// For now, just return an explicit “not implemented” error so you know
-	// this code path is still a work in progress when it’s hit.
-	return nil, fmt.Errorf("LookupA: DNS over UDP + response parsing not implemented yet")
+	reply, err := r.resolve(ctx, name, qtype)
+	if err != nil || retryable(reply) {
+		r.cache.prefetchFailed(name, qtype, dnswire.ClassIN)
+		return
+	}
+	r.cache.Put(reply)
 }
 
-// Optionally, later you might add a timeout configuration or helper:
+// resolve is Resolve without the cache.
+func (r *Resolver) resolve(ctx context.Context, name string, qtype uint16) (*dnswire.Message, error) {
+
+	if r.roots != nil {
+		return r.resolveIterative(ctx, name, qtype)
+	}
+
+	q := &dnswire.Message{
+		Header: dnswire.Header{
+			ID: uint16(rand.Intn(65536)),
+			RD: true,
+		},
+		Questions: []dnswire.Question{{Name: fqdn(name), Type: qtype, Class: dnswire.ClassIN}},
+	}
+
+	// EDNS so the server knows it can send us more than 512 bytes
+	if r.edns {
+		q.OPT = dnswire.NewOPT()
+	}
+
+	return r.Exchange(ctx, q)
+}
+
+// query resolves name/qtype and turns a non-NOERROR reply into an
+// *RcodeError - handing back the reply too, for Search to show. The
+// ReplyInfo says how the reply came.
+func (r *Resolver) query(ctx context.Context, name string, qtype uint16) (*dnswire.Message, ReplyInfo, error) {
+
+	var info ReplyInfo
+	reply, err := r.Resolve(context.WithValue(ctx, replyInfoKey{}, &info), name, qtype)
+	if err != nil {
+		return nil, info, fmt.Errorf("lookup %s: %w", name, err)
+	}
+
+	if rcode := reply.Rcode(); rcode != 0 {
+		return reply, info, &RcodeError{Name: name, Rcode: rcode}
+	}
+
+	return reply, info, nil
+}
+
+// search tries each name from the search list in turn (just name, if the
+// resolver has no resolv.conf behind it) and returns the first reply that
+// has qtype answers, along with the name that got it and how it came.
 //
-// func (r *Resolver) timeout() time.Duration {
-//     return 2 * time.Second
-// }
+// Like glibc, NXDOMAIN and "exists but no records of that type" both move on
+// to the next candidate; if nothing turns up, a NODATA reply beats an
+// NXDOMAIN error. An *RcodeError comes with the reply that caused it.
+func (r *Resolver) search(ctx context.Context, name string, qtype uint16) (*dnswire.Message, string, ReplyInfo, error) {
+
+	candidates := []string{fqdn(name)}
+	if r.config != nil {
+		candidates = r.config.NameList(name)
+	}
+
+	var noData, nxDomain *dnswire.Message
+	var noDataName, nxDomainName string
+	var noDataInfo, nxDomainInfo ReplyInfo
+	var lastErr error
+
+	for _, candidate := range candidates {
+		reply, info, err := r.query(ctx, candidate, qtype)
+		if err != nil {
+			var rcodeErr *RcodeError
+			if errors.As(err, &rcodeErr) && rcodeErr.Rcode == 3 {
+				nxDomain, nxDomainName, nxDomainInfo = reply, candidate, info
+				lastErr = err
+				continue
+			}
+			return reply, candidate, info, err
+		}
+
+		if len(answersFor(reply, candidate, qtype)) > 0 {
+			return reply, candidate, info, nil
+		}
+		if noData == nil {
+			noData, noDataName, noDataInfo = reply, candidate, info
+		}
+	}
+
+	if noData != nil {
+		return noData, noDataName, noDataInfo, nil
+	}
+	return nxDomain, nxDomainName, nxDomainInfo, lastErr
+}
+
+// Search resolves name/qtype the way the Lookup methods do - from the hosts
+// file if that has the answer (A, AAAA and PTR only), otherwise trying each
+// name from the search list - and returns the whole reply, and how it came.
+// Like Resolve, a negative RCODE is a reply, not an error: if no name on
+// the list exists, that's the last NXDOMAIN.
+func (r *Resolver) Search(ctx context.Context, name string, qtype uint16) (*dnswire.Message, ReplyInfo, error) {
+
+	if r.hosts != nil {
+		if reply := r.hosts.reply(name, qtype); reply != nil {
+			return reply, ReplyInfo{Hosts: r.hosts.path}, nil
+		}
+	}
+
+	reply, _, info, err := r.search(ctx, name, qtype)
+	var rcodeErr *RcodeError
+	if reply != nil && errors.As(err, &rcodeErr) {
+		return reply, info, nil
+	}
+	return reply, info, err
+}
+
+// lookup searches for name/qtype and returns the typed RDATA of the
+// matching answers (after following CNAMEs).
+func (r *Resolver) lookup(ctx context.Context, name string, qtype uint16) ([]rr.RData, error) {
+
+	reply, found, _, err := r.search(ctx, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []rr.RData
+	for _, a := range answersFor(reply, found, qtype) {
+		d, err := rr.Decode(a)
+		if err != nil {
+			return nil, fmt.Errorf("lookup %s: %w", name, err)
+		}
+		records = append(records, d)
+	}
+
+	return records, nil
+}
+
+// isReplyTo reports whether reply answers query: same ID, QR set, and the
+// question echoed back (names compared case-insensitively, with or without
+// the trailing dot).
+func isReplyTo(reply, query *dnswire.Message) bool {
+
+	if !reply.Header.QR || reply.Header.ID != query.Header.ID {
+		return false
+	}
+	if len(reply.Questions) != len(query.Questions) {
+		return false
+	}
+	for i, q := range query.Questions {
+		rq := reply.Questions[i]
+		if rq.Type != q.Type || rq.Class != q.Class || !strings.EqualFold(fqdn(rq.Name), fqdn(q.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// answersFor returns the records of type qtype for name in the answer
+// section, following a CNAME chain from name if there is one.
+func answersFor(m *dnswire.Message, name string, qtype uint16) []dnswire.ResourceRecord {
+
+	current := fqdn(name)
+
+	for hops := 0; hops <= maxCNAMEChain; hops++ {
+		var found []dnswire.ResourceRecord
+		var cname string
+
+		for _, a := range m.Answers {
+			if !strings.EqualFold(a.Name, current) {
+				continue
+			}
+			switch a.Type {
+			case qtype:
+				found = append(found, a)
+			case dnswire.TypeCNAME:
+				if d, err := rr.Decode(a); err == nil {
+					cname = d.(*rr.CNAME).Target
+				}
+			}
+		}
+
+		if len(found) > 0 || cname == "" || qtype == dnswire.TypeCNAME {
+			return found
+		}
+		current = cname
+	}
+
+	return nil
+}
+
+// fqdn adds the trailing dot if name doesn't already have one.
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// withDefaultPort adds :53 to a server given as a bare address.
+func withDefaultPort(server string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(strings.Trim(server, "[]"), "53")
+}
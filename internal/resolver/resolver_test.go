@@ -0,0 +1,198 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"dnstom/internal/dnswire"
+	"dnstom/internal/rr"
+)
+
+// fakeServer answers UDP queries on 127.0.0.1 with whatever handler returns.
+// Returning more than one message sends them all, in order - handy for
+// checking that junk in front of the real reply is ignored.
+func fakeServer(t *testing.T, handler func(q *dnswire.Message) []*dnswire.Message) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			q, err := dnswire.DecodeMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, m := range handler(&q) {
+				wire, err := dnswire.EncodeMessage(m)
+				if err != nil {
+					t.Errorf("fake server: encode reply: %v", err)
+					return
+				}
+				conn.WriteTo(wire, from)
+			}
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+// reply starts a response to q with the question copied over.
+func reply(q *dnswire.Message, answers ...dnswire.ResourceRecord) *dnswire.Message {
+	return &dnswire.Message{
+		Header:    dnswire.Header{ID: q.Header.ID, QR: true, RD: true, RA: true},
+		Questions: q.Questions,
+		Answers:   answers,
+	}
+}
+
+func mustRR(t *testing.T, name string, d rr.RData) dnswire.ResourceRecord {
+	t.Helper()
+	r, err := rr.New(name, 300, d)
+	if err != nil {
+		t.Fatalf("rr.New: %v", err)
+	}
+	return r
+}
+
+func TestLookupA_FollowsCNAMEChain(t *testing.T) {
+	server := fakeServer(t, func(q *dnswire.Message) []*dnswire.Message {
+		return []*dnswire.Message{reply(q,
+			mustRR(t, "www.example.com.", &rr.CNAME{Target: "edge.example.net."}),
+			mustRR(t, "edge.example.net.", &rr.CNAME{Target: "pop1.example.net."}),
+			mustRR(t, "pop1.example.net.", &rr.A{Address: net.IPv4(192, 0, 2, 10)}),
+			mustRR(t, "pop1.example.net.", &rr.A{Address: net.IPv4(192, 0, 2, 11)}),
+			// Not on the chain, must not be returned
+			mustRR(t, "other.example.net.", &rr.A{Address: net.IPv4(198, 51, 100, 1)}),
+		)}
+	})
+
+	ips, err := New(server, false).LookupA(context.Background(), "www.example.com")
+	if err != nil {
+		t.Fatalf("LookupA error: %v", err)
+	}
+
+	want := []net.IP{net.IPv4(192, 0, 2, 10), net.IPv4(192, 0, 2, 11)}
+	if len(ips) != len(want) {
+		t.Fatalf("LookupA = %v, want %v", ips, want)
+	}
+	for i := range want {
+		if !ips[i].Equal(want[i]) {
+			t.Fatalf("LookupA = %v, want %v", ips, want)
+		}
+	}
+}
+
+func TestLookupA_IgnoresMismatchedReplies(t *testing.T) {
+	server := fakeServer(t, func(q *dnswire.Message) []*dnswire.Message {
+		wrongID := reply(q, mustRR(t, "www.example.com.", &rr.A{Address: net.IPv4(6, 6, 6, 6)}))
+		wrongID.Header.ID++
+
+		wrongQuestion := reply(q, mustRR(t, "evil.example.", &rr.A{Address: net.IPv4(6, 6, 6, 7)}))
+		wrongQuestion.Questions = []dnswire.Question{{Name: "evil.example.", Type: dnswire.TypeA, Class: dnswire.ClassIN}}
+
+		good := reply(q, mustRR(t, "www.example.com.", &rr.A{Address: net.IPv4(192, 0, 2, 1)}))
+
+		return []*dnswire.Message{wrongID, wrongQuestion, good}
+	})
+
+	ips, err := New(server, false).LookupA(context.Background(), "WWW.Example.COM.")
+	if err != nil {
+		t.Fatalf("LookupA error: %v", err)
+	}
+	if len(ips) != 1 || !ips[0].Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Fatalf("LookupA = %v, want [192.0.2.1]", ips)
+	}
+}
+
+func TestExchange_NameWithoutTrailingDot(t *testing.T) {
+	server := fakeServer(t, func(q *dnswire.Message) []*dnswire.Message {
+		return []*dnswire.Message{reply(q, mustRR(t, "example.com.", &rr.A{Address: net.IPv4(192, 0, 2, 1)}))}
+	})
+
+	// The server echoes "example.com." - that's still our question
+	q := &dnswire.Message{
+		Header:    dnswire.Header{ID: 99, RD: true},
+		Questions: []dnswire.Question{{Name: "example.com", Type: dnswire.TypeA, Class: dnswire.ClassIN}},
+	}
+	r := New(server, false)
+	r.SetPolicy(Policy{Timeout: time.Second, Attempts: 1})
+	m, err := r.Exchange(context.Background(), q)
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if len(m.Answers) != 1 {
+		t.Fatalf("Exchange answers = %+v, want 1", m.Answers)
+	}
+}
+
+func TestLookupA_NXDOMAIN(t *testing.T) {
+	server := fakeServer(t, func(q *dnswire.Message) []*dnswire.Message {
+		m := reply(q)
+		m.Header.Rcode = 3
+		return []*dnswire.Message{m}
+	})
+
+	_, err := New(server, false).LookupA(context.Background(), "nope.example.com")
+
+	var rcodeErr *RcodeError
+	if !errors.As(err, &rcodeErr) {
+		t.Fatalf("got %v, want *RcodeError", err)
+	}
+	if rcodeErr.Rcode != 3 {
+		t.Fatalf("Rcode = %d, want 3", rcodeErr.Rcode)
+	}
+}
+
+func TestLookupA_NoData(t *testing.T) {
+	server := fakeServer(t, func(q *dnswire.Message) []*dnswire.Message {
+		return []*dnswire.Message{reply(q)}
+	})
+
+	ips, err := New(server, false).LookupA(context.Background(), "ipv6only.example.com")
+	if err != nil || len(ips) != 0 {
+		t.Fatalf("LookupA = %v, %v; want no addresses and no error", ips, err)
+	}
+}
+
+func TestLookupA_ServerUnreachable(t *testing.T) {
+	// Nothing listening: either the read times out or we get ICMP port
+	// unreachable back - either way an error, not a panic.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := conn.LocalAddr().String()
+	conn.Close()
+
+	if _, err := New(addr, false).LookupA(context.Background(), "www.example.com"); err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+}
+
+func TestWithDefaultPort(t *testing.T) {
+	tests := map[string]string{
+		"8.8.8.8":       "8.8.8.8:53",
+		"8.8.8.8:5353":  "8.8.8.8:5353",
+		"2001:db8::1":   "[2001:db8::1]:53",
+		"[2001:db8::1]": "[2001:db8::1]:53",
+		"[::1]:5300":    "[::1]:5300",
+		"dns.google":    "dns.google:53",
+	}
+	for in, want := range tests {
+		if got := withDefaultPort(in); got != want {
+			t.Errorf("withDefaultPort(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
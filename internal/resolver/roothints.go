@@ -0,0 +1,157 @@
+package resolver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// Root hints - where iterative resolution starts. The file is the one IANA
+// publishes as named.root (https://www.internic.net/domain/named.root), in
+// zone file syntax:
+//
+//	.                        3600000      NS    A.ROOT-SERVERS.NET.
+//	A.ROOT-SERVERS.NET.      3600000      A     198.41.0.4
+//	A.ROOT-SERVERS.NET.      3600000      AAAA  2001:503:ba3e::2:30
+//
+// Only the NS records for "." and the A/AAAA records for their targets
+// matter; everything else is skipped.
+
+// NameServer is one server for a zone and whatever addresses we know for it.
+type NameServer struct {
+	Name  string // absolute, e.g. "a.root-servers.net."
+	Addrs []net.IP
+}
+
+// RootHints is the list of root servers.
+type RootHints struct {
+	Servers []NameServer
+}
+
+// builtinRootHints is named.root as of 2024 (b.root moved in 2023).
+const builtinRootHints = `
+.                    3600000  NS    A.ROOT-SERVERS.NET.
+A.ROOT-SERVERS.NET.  3600000  A     198.41.0.4
+A.ROOT-SERVERS.NET.  3600000  AAAA  2001:503:ba3e::2:30
+.                    3600000  NS    B.ROOT-SERVERS.NET.
+B.ROOT-SERVERS.NET.  3600000  A     170.247.170.2
+B.ROOT-SERVERS.NET.  3600000  AAAA  2801:1b8:10::b
+.                    3600000  NS    C.ROOT-SERVERS.NET.
+C.ROOT-SERVERS.NET.  3600000  A     192.33.4.12
+C.ROOT-SERVERS.NET.  3600000  AAAA  2001:500:2::c
+.                    3600000  NS    D.ROOT-SERVERS.NET.
+D.ROOT-SERVERS.NET.  3600000  A     199.7.91.13
+D.ROOT-SERVERS.NET.  3600000  AAAA  2001:500:2d::d
+.                    3600000  NS    E.ROOT-SERVERS.NET.
+E.ROOT-SERVERS.NET.  3600000  A     192.203.230.10
+E.ROOT-SERVERS.NET.  3600000  AAAA  2001:500:a8::e
+.                    3600000  NS    F.ROOT-SERVERS.NET.
+F.ROOT-SERVERS.NET.  3600000  A     192.5.5.241
+F.ROOT-SERVERS.NET.  3600000  AAAA  2001:500:2f::f
+.                    3600000  NS    G.ROOT-SERVERS.NET.
+G.ROOT-SERVERS.NET.  3600000  A     192.112.36.4
+G.ROOT-SERVERS.NET.  3600000  AAAA  2001:500:12::d0d
+.                    3600000  NS    H.ROOT-SERVERS.NET.
+H.ROOT-SERVERS.NET.  3600000  A     198.97.190.53
+H.ROOT-SERVERS.NET.  3600000  AAAA  2001:500:1::53
+.                    3600000  NS    I.ROOT-SERVERS.NET.
+I.ROOT-SERVERS.NET.  3600000  A     192.36.148.17
+I.ROOT-SERVERS.NET.  3600000  AAAA  2001:7fe::53
+.                    3600000  NS    J.ROOT-SERVERS.NET.
+J.ROOT-SERVERS.NET.  3600000  A     192.58.128.30
+J.ROOT-SERVERS.NET.  3600000  AAAA  2001:503:c27::2:30
+.                    3600000  NS    K.ROOT-SERVERS.NET.
+K.ROOT-SERVERS.NET.  3600000  A     193.0.14.129
+K.ROOT-SERVERS.NET.  3600000  AAAA  2001:7fd::1
+.                    3600000  NS    L.ROOT-SERVERS.NET.
+L.ROOT-SERVERS.NET.  3600000  A     199.7.83.42
+L.ROOT-SERVERS.NET.  3600000  AAAA  2001:500:9f::42
+.                    3600000  NS    M.ROOT-SERVERS.NET.
+M.ROOT-SERVERS.NET.  3600000  A     202.12.27.33
+M.ROOT-SERVERS.NET.  3600000  AAAA  2001:dc3::35
+`
+
+// DefaultRootHints returns the built-in copy of named.root.
+func DefaultRootHints() *RootHints {
+	h, err := ParseRootHints(strings.NewReader(builtinRootHints))
+	if err != nil {
+		panic(err) // it's a constant, so this is a bug
+	}
+	return h
+}
+
+// LoadRootHints reads and parses the root hints file at path.
+func LoadRootHints(path string) (*RootHints, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h, err := ParseRootHints(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return h, nil
+}
+
+// ParseRootHints parses named.root syntax. It's an error for the file not
+// to name at least one root server with an address.
+func ParseRootHints(r io.Reader) (*RootHints, error) {
+
+	var names []string
+	addrs := make(map[string][]net.IP)
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.IndexByte(text, ';'); i >= 0 {
+			text = text[:i]
+		}
+
+		// owner [ttl] [class] type data - find the type, the data follows it
+		fields := strings.Fields(text)
+		if len(fields) < 3 {
+			continue
+		}
+		owner := strings.ToLower(fqdn(fields[0]))
+
+		for i := 1; i < len(fields)-1; i++ {
+			rrtype, data := strings.ToUpper(fields[i]), fields[i+1]
+
+			switch rrtype {
+			case "NS":
+				if owner == "." {
+					names = append(names, strings.ToLower(fqdn(data)))
+				}
+			case "A", "AAAA":
+				ip := net.ParseIP(data)
+				if ip == nil || (rrtype == "A") != (ip.To4() != nil) {
+					return nil, fmt.Errorf("line %d: bad %s address %q", line, rrtype, data)
+				}
+				addrs[owner] = append(addrs[owner], ip)
+			default:
+				continue
+			}
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	h := &RootHints{}
+	for _, name := range names {
+		if len(addrs[name]) > 0 {
+			h.Servers = append(h.Servers, NameServer{Name: name, Addrs: addrs[name]})
+		}
+	}
+	if len(h.Servers) == 0 {
+		return nil, fmt.Errorf("no root servers with addresses")
+	}
+
+	return h, nil
+}
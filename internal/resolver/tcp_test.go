@@ -0,0 +1,119 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"dnstom/internal/dnswire"
+	"dnstom/internal/rr"
+)
+
+// fakeTCPServer answers framed queries on a TCP listener at addr (normally
+// the same host:port as a fakeServer, so UDP and TCP line up like a real
+// server's).
+func fakeTCPServer(t *testing.T, addr string, handler func(q *dnswire.Message) *dnswire.Message) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("can't get the matching TCP port: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					wire, err := dnswire.ReadTCPMessage(conn)
+					if err != nil {
+						return
+					}
+					q, err := dnswire.DecodeMessage(wire)
+					if err != nil {
+						return
+					}
+					out, err := dnswire.EncodeMessage(handler(&q))
+					if err != nil {
+						t.Errorf("fake tcp server: encode reply: %v", err)
+						return
+					}
+					if err := dnswire.WriteTCPMessage(conn, out); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+}
+
+// bigTXT is comfortably over the 1232 bytes we advertise over UDP.
+func bigTXT(t *testing.T, q *dnswire.Message) *dnswire.Message {
+	m := reply(q)
+	for i := 0; i < 10; i++ {
+		m.Answers = append(m.Answers, mustRR(t, q.Questions[0].Name, &rr.TXT{Strings: []string{strings.Repeat("x", 200)}}))
+	}
+	return m
+}
+
+func TestExchange_FallsBackToTCPOnTruncation(t *testing.T) {
+	var udpHits atomic.Int32
+
+	addr := fakeServer(t, func(q *dnswire.Message) []*dnswire.Message {
+		udpHits.Add(1)
+		m := reply(q)
+		m.Header.TC = true
+		return []*dnswire.Message{m}
+	})
+	fakeTCPServer(t, addr, func(q *dnswire.Message) *dnswire.Message { return bigTXT(t, q) })
+
+	txts, err := New(addr, false).LookupTXT(context.Background(), "big.example.com")
+	if err != nil {
+		t.Fatalf("LookupTXT error: %v", err)
+	}
+	if len(txts) != 10 {
+		t.Fatalf("got %d TXT records, want all 10 from the TCP answer", len(txts))
+	}
+	if udpHits.Load() != 1 {
+		t.Fatalf("UDP saw %d queries, want 1", udpHits.Load())
+	}
+
+	// The reply that counts came over TCP, and was the big one
+	_, info, err := New(addr, false).Search(context.Background(), "big.example.com", dnswire.TypeTXT)
+	if err != nil || info.Protocol != "TCP" || info.Size < 2000 {
+		t.Fatalf("Search info = %+v, %v; want the TCP reply", info, err)
+	}
+}
+
+func TestExchange_TCPOnly(t *testing.T) {
+	var udpHits atomic.Int32
+
+	addr := fakeServer(t, func(q *dnswire.Message) []*dnswire.Message {
+		udpHits.Add(1)
+		return []*dnswire.Message{reply(q)}
+	})
+	fakeTCPServer(t, addr, func(q *dnswire.Message) *dnswire.Message { return bigTXT(t, q) })
+
+	r := New(addr, false)
+	p := DefaultPolicy()
+	p.TCPOnly = true
+	r.SetPolicy(p)
+
+	txts, err := r.LookupTXT(context.Background(), "big.example.com")
+	if err != nil {
+		t.Fatalf("LookupTXT error: %v", err)
+	}
+	if len(txts) != 10 {
+		t.Fatalf("got %d TXT records, want 10", len(txts))
+	}
+	if udpHits.Load() != 0 {
+		t.Fatalf("UDP saw %d queries in TCP only mode", udpHits.Load())
+	}
+}
@@ -0,0 +1,170 @@
+package rr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+
+	"dnstom/internal/dnswire"
+)
+
+// The DNSSEC record types (RFC 4034). We don't validate anything - these are
+// here so signed zones and answers with DO set can be read, printed and
+// passed along.
+
+// DNSKEY is a zone's public key (RFC 4034 2).
+type DNSKEY struct {
+	Flags     uint16 // 256 = zone key, 257 = zone key + secure entry point (a KSK)
+	Protocol  uint8  // always 3
+	Algorithm uint8
+	PublicKey []byte
+}
+
+// RRSIG is the signature over one RRset (RFC 4034 3).
+type RRSIG struct {
+	TypeCovered uint16
+	Algorithm   uint8
+	Labels      uint8 // labels in the owner name, not counting a leading "*"
+	OrigTTL     uint32
+	Expiration  uint32 // seconds since 1970, mod 2^32 (RFC 4034 3.1.5)
+	Inception   uint32
+	KeyTag      uint16
+	SignerName  string
+	Signature   []byte
+}
+
+// NSEC points to the next name in the zone and lists the types that exist
+// at this one (RFC 4034 4), which is how a signed zone proves a negative.
+type NSEC struct {
+	NextDomain string
+	Types      []uint16
+}
+
+func (k *DNSKEY) Pack() ([]byte, error) {
+	b := binary.BigEndian.AppendUint16(nil, k.Flags)
+	b = append(b, k.Protocol, k.Algorithm)
+	return append(b, k.PublicKey...), nil
+}
+
+func (s *RRSIG) Pack() ([]byte, error) {
+	b := binary.BigEndian.AppendUint16(nil, s.TypeCovered)
+	b = append(b, s.Algorithm, s.Labels)
+	b = binary.BigEndian.AppendUint32(b, s.OrigTTL)
+	b = binary.BigEndian.AppendUint32(b, s.Expiration)
+	b = binary.BigEndian.AppendUint32(b, s.Inception)
+	b = binary.BigEndian.AppendUint16(b, s.KeyTag)
+	name, err := dnswire.EncodeName(s.SignerName)
+	if err != nil {
+		return nil, err
+	}
+	b = append(b, name...)
+	return append(b, s.Signature...), nil
+}
+
+func (n *NSEC) Pack() ([]byte, error) {
+	name, err := dnswire.EncodeName(n.NextDomain)
+	if err != nil {
+		return nil, err
+	}
+	return appendTypeBitmap(name, n.Types), nil
+}
+
+func unpackDNSKEY(rdata []byte) (*DNSKEY, error) {
+	if len(rdata) < 4 {
+		return nil, fmt.Errorf("rr: DNSKEY rdata is %d bytes, too short", len(rdata))
+	}
+	return &DNSKEY{
+		Flags:     binary.BigEndian.Uint16(rdata),
+		Protocol:  rdata[2],
+		Algorithm: rdata[3],
+		PublicKey: bytes.Clone(rdata[4:]),
+	}, nil
+}
+
+func unpackRRSIG(rdata []byte) (*RRSIG, error) {
+	if len(rdata) < 18 {
+		return nil, fmt.Errorf("rr: RRSIG rdata is %d bytes, too short", len(rdata))
+	}
+	signer, off, err := dnswire.DecodeName(rdata, 18)
+	if err != nil {
+		return nil, fmt.Errorf("rr: RRSIG signer name: %w", err)
+	}
+	return &RRSIG{
+		TypeCovered: binary.BigEndian.Uint16(rdata),
+		Algorithm:   rdata[2],
+		Labels:      rdata[3],
+		OrigTTL:     binary.BigEndian.Uint32(rdata[4:]),
+		Expiration:  binary.BigEndian.Uint32(rdata[8:]),
+		Inception:   binary.BigEndian.Uint32(rdata[12:]),
+		KeyTag:      binary.BigEndian.Uint16(rdata[16:]),
+		SignerName:  signer,
+		Signature:   bytes.Clone(rdata[off:]),
+	}, nil
+}
+
+func unpackNSEC(rdata []byte) (*NSEC, error) {
+	next, off, err := dnswire.DecodeName(rdata, 0)
+	if err != nil {
+		return nil, fmt.Errorf("rr: NSEC next domain: %w", err)
+	}
+	types, err := unpackTypeBitmap(rdata[off:])
+	if err != nil {
+		return nil, err
+	}
+	return &NSEC{NextDomain: next, Types: types}, nil
+}
+
+// Type bitmaps (RFC 4034 4.1.2) split the 16 bit type space into 256
+// windows of 256 types. Each window that has any types in it is written as
+// its number, the length of its bitmap (1-32 bytes, trailing zero bytes
+// left off), then the bitmap with the top bit of the first byte for the
+// window's type 0.
+//
+//	A NS SOA MX RRSIG NSEC  ->  00 06 62 01 00 00 00 03
+
+func appendTypeBitmap(b []byte, types []uint16) []byte {
+
+	types = slices.Clone(types)
+	slices.Sort(types)
+	types = slices.Compact(types)
+
+	for i := 0; i < len(types); {
+		window := types[i] >> 8
+		var bitmap [32]byte
+		length := 0
+		for ; i < len(types) && types[i]>>8 == window; i++ {
+			low := types[i] & 0xff
+			bitmap[low/8] |= 0x80 >> (low % 8)
+			length = int(low/8) + 1
+		}
+		b = append(b, byte(window), byte(length))
+		b = append(b, bitmap[:length]...)
+	}
+	return b
+}
+
+func unpackTypeBitmap(b []byte) ([]uint16, error) {
+
+	var types []uint16
+	last := -1
+	for pos := 0; pos < len(b); {
+		if pos+2 > len(b) {
+			return nil, fmt.Errorf("rr: type bitmap window at offset %d is cut off", pos)
+		}
+		window, length := int(b[pos]), int(b[pos+1])
+		if window <= last || length < 1 || length > 32 || pos+2+length > len(b) {
+			return nil, fmt.Errorf("rr: bad type bitmap window at offset %d", pos)
+		}
+		last = window
+		for i, bits := range b[pos+2 : pos+2+length] {
+			for bit := range 8 {
+				if bits&(0x80>>bit) != 0 {
+					types = append(types, uint16(window<<8|i*8+bit))
+				}
+			}
+		}
+		pos += 2 + length
+	}
+	return types, nil
+}
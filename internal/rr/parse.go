@@ -0,0 +1,423 @@
+package rr
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"dnstom/internal/dnswire"
+)
+
+// Parsing RDATA from presentation format - the bit of a zone file line after
+// the type:
+//
+//	www  3600  IN  MX  10 mail      <- fields ["10", "mail"]
+//
+// Fields have already been split (and any quotes taken off) by whoever read
+// the line, and escapes resolved - all but "\." and "\\", which a name needs
+// to tell a dot inside a label from one between labels. Strings resolve
+// those two here. Names that don't end in a dot are relative to origin, and
+// "@" is origin itself.
+//
+// Any type can also be written in the RFC 3597 generic form, which is how
+// we'd write it if we didn't know the type:
+//
+//	\# 4 c0000201               <- fields ["\#", "4", "c0000201"]
+
+// ParseRData parses the RDATA fields of a record of type rrtype.
+func ParseRData(rrtype uint16, fields []string, origin string) (RData, error) {
+
+	p := &rdataParser{typ: dnswire.TypeToString(rrtype), fields: fields, origin: origin}
+
+	if len(fields) > 0 && fields[0] == `\#` {
+		return p.generic(rrtype)
+	}
+
+	var d RData
+	switch rrtype {
+	case dnswire.TypeA:
+		ip := p.ip()
+		if ip != nil && ip.To4() == nil {
+			p.fail("%s is not an IPv4 address", ip)
+		}
+		d = &A{Address: ip.To4()}
+
+	case dnswire.TypeAAAA:
+		d = &AAAA{Address: p.ip6()}
+
+	case dnswire.TypeNS:
+		d = &NS{Host: p.name()}
+
+	case dnswire.TypeCNAME:
+		d = &CNAME{Target: p.name()}
+
+	case dnswire.TypePTR:
+		d = &PTR{Target: p.name()}
+
+	case dnswire.TypeMX:
+		d = &MX{Preference: p.uint16(), Exchange: p.name()}
+
+	case dnswire.TypeTXT:
+		d = &TXT{Strings: p.texts()}
+
+	case dnswire.TypeSPF:
+		d = &SPF{Strings: p.texts()}
+
+	case dnswire.TypeSOA:
+		d = &SOA{
+			MName:   p.name(),
+			RName:   p.name(),
+			Serial:  p.uint32(),
+			Refresh: p.ttl(),
+			Retry:   p.ttl(),
+			Expire:  p.ttl(),
+			Minimum: p.ttl(),
+		}
+
+	case dnswire.TypeSRV:
+		d = &SRV{Priority: p.uint16(), Weight: p.uint16(), Port: p.uint16(), Target: p.name()}
+
+	case dnswire.TypeCAA:
+		d = &CAA{Flags: uint8(p.uint(8)), Tag: p.next(), Value: p.str()}
+
+	case dnswire.TypeDNAME:
+		d = &DNAME{Target: p.name()}
+
+	case dnswire.TypeDS:
+		d = &DS{KeyTag: p.uint16(), Algorithm: uint8(p.uint(8)), DigestType: uint8(p.uint(8)), Digest: p.hex()}
+
+	case dnswire.TypeSSHFP:
+		d = &SSHFP{Algorithm: uint8(p.uint(8)), FPType: uint8(p.uint(8)), Fingerprint: p.hex()}
+
+	case dnswire.TypeTLSA:
+		d = &TLSA{Usage: uint8(p.uint(8)), Selector: uint8(p.uint(8)), MatchingType: uint8(p.uint(8)), Data: p.hex()}
+
+	case dnswire.TypeHINFO:
+		d = &HINFO{CPU: p.text(), OS: p.text()}
+
+	case dnswire.TypeRP:
+		d = &RP{Mbox: p.name(), Txt: p.name()}
+
+	case dnswire.TypeAFSDB:
+		d = &AFSDB{Subtype: p.uint16(), Hostname: p.name()}
+
+	case dnswire.TypeNAPTR:
+		d = &NAPTR{
+			Order:       p.uint16(),
+			Preference:  p.uint16(),
+			Flags:       p.text(),
+			Services:    p.text(),
+			Regexp:      p.text(),
+			Replacement: p.name(),
+		}
+
+	case dnswire.TypeURI:
+		d = &URI{Priority: p.uint16(), Weight: p.uint16(), Target: p.str()}
+
+	case dnswire.TypeDNSKEY:
+		d = &DNSKEY{Flags: p.uint16(), Protocol: uint8(p.uint(8)), Algorithm: uint8(p.uint(8)), PublicKey: p.base64()}
+
+	case dnswire.TypeRRSIG:
+		d = &RRSIG{
+			TypeCovered: p.rrtype(),
+			Algorithm:   uint8(p.uint(8)),
+			Labels:      uint8(p.uint(8)),
+			OrigTTL:     p.ttl(),
+			Expiration:  p.time(),
+			Inception:   p.time(),
+			KeyTag:      p.uint16(),
+			SignerName:  p.name(),
+			Signature:   p.base64(),
+		}
+
+	case dnswire.TypeNSEC:
+		n := &NSEC{NextDomain: p.name()}
+		for len(p.fields) > 0 && p.err == nil {
+			n.Types = append(n.Types, p.rrtype())
+		}
+		d = n
+
+	default:
+		return nil, fmt.Errorf("rr: don't know how to parse %s records", p.typ)
+	}
+
+	if p.err == nil && len(p.fields) > 0 {
+		p.fail("unexpected %q at the end", strings.Join(p.fields, " "))
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return d, nil
+}
+
+// AbsoluteName makes name absolute: "@" is origin, a name ending in "." is
+// left alone, anything else has origin appended.
+func AbsoluteName(name, origin string) string {
+	switch {
+	case name == "@":
+		return origin
+	case dnswire.IsAbsolute(name):
+		return name
+	case origin == "." || origin == "":
+		return name + "."
+	default:
+		return name + "." + origin
+	}
+}
+
+// ParseTTL parses a TTL, either plain seconds or BIND style with units:
+// "1h30m", "2d", "1W". The units are s, m, h, d and w, in either case.
+func ParseTTL(s string) (uint32, error) {
+
+	if v, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(v), nil
+	}
+
+	var total, n uint64
+	digits := false
+	for _, c := range strings.ToLower(s) {
+		if c >= '0' && c <= '9' {
+			n = n*10 + uint64(c-'0')
+			digits = true
+			if n > 1<<32 {
+				return 0, fmt.Errorf("rr: TTL %q is too big", s)
+			}
+			continue
+		}
+		unit, ok := ttlUnits[c]
+		if !ok || !digits {
+			return 0, fmt.Errorf("rr: bad TTL %q", s)
+		}
+		total += n * unit
+		n, digits = 0, false
+	}
+	if digits || s == "" {
+		// "1h30" - a number with no unit after some with one
+		return 0, fmt.Errorf("rr: bad TTL %q", s)
+	}
+	if total > 1<<32-1 {
+		return 0, fmt.Errorf("rr: TTL %q is too big", s)
+	}
+	return uint32(total), nil
+}
+
+var ttlUnits = map[rune]uint64{'s': 1, 'm': 60, 'h': 3600, 'd': 86400, 'w': 7 * 86400}
+
+// rdataParser takes fields off the front one at a time. The first problem
+// sticks in err and everything after it is a no-op, so the cases above can
+// read straight through.
+type rdataParser struct {
+	typ    string
+	fields []string
+	origin string
+	err    error
+}
+
+func (p *rdataParser) fail(format string, args ...any) {
+	if p.err == nil {
+		p.err = fmt.Errorf("rr: %s rdata: %s", p.typ, fmt.Sprintf(format, args...))
+	}
+}
+
+func (p *rdataParser) next() string {
+	if p.err != nil {
+		return ""
+	}
+	if len(p.fields) == 0 {
+		p.fail("too few fields")
+		return ""
+	}
+	f := p.fields[0]
+	p.fields = p.fields[1:]
+	return f
+}
+
+func (p *rdataParser) name() string {
+	f := p.next()
+	if p.err != nil {
+		return ""
+	}
+	name := AbsoluteName(f, p.origin)
+	if _, err := dnswire.EncodeName(name); err != nil {
+		p.fail("%v", err)
+	}
+	return name
+}
+
+func (p *rdataParser) ip() net.IP {
+	f := p.next()
+	if p.err != nil {
+		return nil
+	}
+	ip := net.ParseIP(f)
+	if ip == nil {
+		p.fail("%q is not an address", f)
+	}
+	return ip
+}
+
+// ip6 is an IPv6 address, IPv4-mapped ones (::ffff:192.0.2.1) included -
+// only a bare dotted quad is turned away.
+func (p *rdataParser) ip6() net.IP {
+	if p.err == nil && len(p.fields) > 0 && !strings.Contains(p.fields[0], ":") {
+		p.fail("%s is not an IPv6 address", p.fields[0])
+		return nil
+	}
+	return p.ip().To16()
+}
+
+func (p *rdataParser) uint(bits int) uint64 {
+	f := p.next()
+	if p.err != nil {
+		return 0
+	}
+	v, err := strconv.ParseUint(f, 10, bits)
+	if err != nil {
+		p.fail("%q is not a %d bit number", f, bits)
+	}
+	return v
+}
+
+func (p *rdataParser) uint16() uint16 { return uint16(p.uint(16)) }
+func (p *rdataParser) uint32() uint32 { return uint32(p.uint(32)) }
+
+func (p *rdataParser) ttl() uint32 {
+	f := p.next()
+	if p.err != nil {
+		return 0
+	}
+	v, err := ParseTTL(f)
+	if err != nil {
+		p.fail("%q is not a TTL", f)
+	}
+	return v
+}
+
+// unescapeText resolves the two escapes fields keep for names.
+var unescapeText = strings.NewReplacer(`\\`, `\`, `\.`, `.`)
+
+// str is a string field with no length limit, like CAA's value.
+func (p *rdataParser) str() string {
+	return unescapeText.Replace(p.next())
+}
+
+// text is one <character-string>.
+func (p *rdataParser) text() string {
+	f := p.str()
+	if len(f) > 255 {
+		p.fail("string of %d bytes is longer than 255", len(f))
+	}
+	return f
+}
+
+// texts is all the remaining fields as <character-string>s, for TXT.
+func (p *rdataParser) texts() []string {
+	if len(p.fields) == 0 {
+		p.fail("needs at least one string")
+	}
+	var strs []string
+	for len(p.fields) > 0 && p.err == nil {
+		strs = append(strs, p.text())
+	}
+	return strs
+}
+
+func (p *rdataParser) rrtype() uint16 {
+	f := p.next()
+	if p.err != nil {
+		return 0
+	}
+	t, ok := dnswire.TypeFromString(f)
+	if !ok {
+		p.fail("unknown type %q", f)
+	}
+	return t
+}
+
+// time is an RRSIG timestamp: YYYYMMDDHHmmSS in UTC, or plain seconds since
+// 1970 (RFC 4034 3.2). Either way it's kept mod 2^32.
+func (p *rdataParser) time() uint32 {
+	f := p.next()
+	if p.err != nil {
+		return 0
+	}
+	if len(f) == 14 {
+		t, err := time.Parse("20060102150405", f)
+		if err != nil {
+			p.fail("%q is not a YYYYMMDDHHmmSS time", f)
+		}
+		return uint32(t.Unix())
+	}
+	v, err := strconv.ParseUint(f, 10, 32)
+	if err != nil {
+		p.fail("%q is not a time", f)
+	}
+	return uint32(v)
+}
+
+// base64 takes all the remaining fields as one base64 string, for keys and
+// signatures (which are usually split over several lines).
+func (p *rdataParser) base64() []byte {
+	if p.err != nil {
+		return nil
+	}
+	if len(p.fields) == 0 {
+		p.fail("too few fields")
+		return nil
+	}
+	s := strings.Join(p.fields, "")
+	p.fields = nil
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		p.fail("%q is not base64", s)
+	}
+	return b
+}
+
+// hex takes all the remaining fields as one hex string - zone files often
+// split long digests up with spaces.
+func (p *rdataParser) hex() []byte {
+	if p.err != nil {
+		return nil
+	}
+	if len(p.fields) == 0 {
+		p.fail("too few fields")
+		return nil
+	}
+	s := strings.Join(p.fields, "")
+	p.fields = nil
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		p.fail("%q is not hex", s)
+	}
+	return b
+}
+
+// generic parses the RFC 3597 form: \# then the length, then the data as
+// hex. A length of 0 has no data.
+func (p *rdataParser) generic(rrtype uint16) (RData, error) {
+
+	p.next() // the \#
+	length := int(p.uint(16))
+	var data []byte
+	if length > 0 {
+		data = p.hex()
+	}
+	if p.err == nil && len(data) != length {
+		p.fail("\\# says %d bytes, but there are %d", length, len(data))
+	}
+	if p.err == nil && len(p.fields) > 0 {
+		p.fail("unexpected %q at the end", strings.Join(p.fields, " "))
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	// Known types still come back typed, so "\# 4 c0000201" for an A record
+	// is the same as 192.0.2.1
+	return Unpack(rrtype, data)
+}
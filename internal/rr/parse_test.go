@@ -0,0 +1,132 @@
+package rr
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"dnstom/internal/dnswire"
+)
+
+func TestParseRData(t *testing.T) {
+	tests := []struct {
+		rrtype uint16
+		fields string
+		want   RData
+	}{
+		{dnswire.TypeA, "192.0.2.1", &A{Address: net.ParseIP("192.0.2.1").To4()}},
+		{dnswire.TypeAAAA, "2001:db8::1", &AAAA{Address: net.ParseIP("2001:db8::1")}},
+		{dnswire.TypeAAAA, "::ffff:192.0.2.1", &AAAA{Address: net.ParseIP("::ffff:192.0.2.1")}},
+		{dnswire.TypeNS, "ns1", &NS{Host: "ns1.example.com."}},
+		{dnswire.TypeCNAME, "@", &CNAME{Target: "example.com."}},
+		{dnswire.TypePTR, "host.example.net.", &PTR{Target: "host.example.net."}},
+		{dnswire.TypeMX, "10 mail", &MX{Preference: 10, Exchange: "mail.example.com."}},
+		{dnswire.TypeSOA, "ns1 hostmaster.example.com. 2024010101 7200 3600 1209600 300",
+			&SOA{MName: "ns1.example.com.", RName: "hostmaster.example.com.", Serial: 2024010101, Refresh: 7200, Retry: 3600, Expire: 1209600, Minimum: 300}},
+		{dnswire.TypeSRV, "10 60 5060 sip", &SRV{Priority: 10, Weight: 60, Port: 5060, Target: "sip.example.com."}},
+		{dnswire.TypeCAA, "0 issue letsencrypt.org", &CAA{Flags: 0, Tag: "issue", Value: "letsencrypt.org"}},
+		{dnswire.TypeSOA, "ns1 hostmaster 1 2h 1h 2w 5m",
+			&SOA{MName: "ns1.example.com.", RName: "hostmaster.example.com.", Serial: 1, Refresh: 7200, Retry: 3600, Expire: 1209600, Minimum: 300}},
+		{dnswire.TypeDNAME, "example.net.", &DNAME{Target: "example.net."}},
+		{dnswire.TypeDS, "20326 8 2 E06D44B8 0C8F1D39", &DS{KeyTag: 20326, Algorithm: 8, DigestType: 2,
+			Digest: []byte{0xe0, 0x6d, 0x44, 0xb8, 0x0c, 0x8f, 0x1d, 0x39}}},
+		{dnswire.TypeSSHFP, "4 2 deadbeef", &SSHFP{Algorithm: 4, FPType: 2, Fingerprint: []byte{0xde, 0xad, 0xbe, 0xef}}},
+		{dnswire.TypeTLSA, "3 1 1 0102", &TLSA{Usage: 3, Selector: 1, MatchingType: 1, Data: []byte{1, 2}}},
+
+		// RFC 3597 generic form, for a type we know and one we don't
+		{dnswire.TypeA, `\# 4 c0000201`, &A{Address: net.ParseIP("192.0.2.1").To4()}},
+		{dnswire.TypeLOC, `\# 3 0102 03`, &Unknown{RRType: dnswire.TypeLOC, Data: []byte{1, 2, 3}}},
+		{dnswire.TypeNULL, `\# 0`, &Unknown{RRType: dnswire.TypeNULL}},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRData(tt.rrtype, strings.Fields(tt.fields), "example.com.")
+		if err != nil {
+			t.Errorf("ParseRData(%d, %q) error: %v", tt.rrtype, tt.fields, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseRData(%d, %q) = %+v, want %+v", tt.rrtype, tt.fields, got, tt.want)
+		}
+	}
+
+	// TXT fields come already split and unquoted
+	got, err := ParseRData(dnswire.TypeTXT, []string{"v=spf1 -all", ""}, "example.com.")
+	if err != nil || !reflect.DeepEqual(got, &TXT{Strings: []string{"v=spf1 -all", ""}}) {
+		t.Errorf("ParseRData(TXT) = %+v, %v", got, err)
+	}
+}
+
+func TestParseRData_Errors(t *testing.T) {
+	tests := []struct {
+		rrtype uint16
+		fields string
+		want   string
+	}{
+		{dnswire.TypeA, "2001:db8::1", "not an IPv4 address"},
+		{dnswire.TypeAAAA, "192.0.2.1", "not an IPv6 address"},
+		{dnswire.TypeA, "192.0.2.1 192.0.2.2", "at the end"},
+		{dnswire.TypeMX, "10", "too few fields"},
+		{dnswire.TypeMX, "70000 mail", "not a 16 bit number"},
+		{dnswire.TypeSRV, "1 2 3 " + strings.Repeat("a", 64), "label"},
+		{dnswire.TypeLOC, "52 22 23.000 N", "don't know how"},
+		{dnswire.TypeDS, "1 8 2", "too few fields"},
+		{dnswire.TypeSSHFP, "4 2 xyz", "not hex"},
+		{dnswire.TypeSOA, "ns1 hostmaster 1 2 3 4 5x", "not a TTL"},
+		{dnswire.TypeA, `\# 4 c00002`, "says 4 bytes"},
+		{dnswire.TypeA, `\# 0 c0000201`, "at the end"},
+		{dnswire.TypeA, `\# 3 c00002`, "A rdata"},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseRData(tt.rrtype, strings.Fields(tt.fields), "example.com.")
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseRData(%d, %q) error = %v, want one mentioning %q", tt.rrtype, tt.fields, err, tt.want)
+		}
+	}
+}
+
+func TestAbsoluteName(t *testing.T) {
+	tests := []struct{ name, origin, want string }{
+		{"www", "example.com.", "www.example.com."},
+		{"@", "example.com.", "example.com."},
+		{"www.example.net.", "example.com.", "www.example.net."},
+		{"com", ".", "com."},
+	}
+	for _, tt := range tests {
+		if got := AbsoluteName(tt.name, tt.origin); got != tt.want {
+			t.Errorf("AbsoluteName(%q, %q) = %q, want %q", tt.name, tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestParseTTL(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint32
+		ok   bool
+	}{
+		{"3600", 3600, true},
+		{"0", 0, true},
+		{"1h", 3600, true},
+		{"1h30m", 5400, true},
+		{"1W2D", 777600, true},
+		{"90s", 90, true},
+		{"4294967295", 4294967295, true},
+		{"", 0, false},
+		{"h", 0, false},
+		{"1h30", 0, false},
+		{"1y", 0, false},
+		{"-1", 0, false},
+		{"4294967296", 0, false},
+		{"1000000w", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseTTL(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseTTL(%q) = %d, %v; want %d, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
@@ -1,11 +1,672 @@
 package rr
 
-// Later: typed representations for A, AAAA, NS, MX, SOA, etc.
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"dnstom/internal/dnswire"
+)
+
+// Typed representations of RDATA.
+//
+// dnswire deals in raw bytes (ResourceRecord.RData); this package turns those
+// bytes into something you can actually read - an *MX with a Preference and an
+// Exchange rather than "000a046d61696c..." - and back again.
+//
+//	for _, ans := range msg.Answers {
+//		d, err := rr.Decode(ans)
+//		...
+//		if mx, ok := d.(*rr.MX); ok {
+//			fmt.Println(mx.Preference, mx.Exchange)
+//		}
+//	}
+
+// RData is the typed form of a record's RDATA.
+type RData interface {
+	// Type is the RR type this RDATA belongs to (dnswire.TypeA etc.).
+	Type() uint16
+
+	// Pack returns the RDATA in uncompressed wire format.
+	Pack() ([]byte, error)
+
+	// String is the RDATA in presentation format, as it'd be written in a
+	// zone file: "10 mail.example.com." for an MX.
+	String() string
+}
 
 type A struct {
-	Address string // you might use net.IP later
+	Address net.IP
+}
+
+type AAAA struct {
+	Address net.IP
+}
+
+type NS struct {
+	Host string
+}
+
+type CNAME struct {
+	Target string
+}
+
+type PTR struct {
+	Target string
+}
+
+type MX struct {
+	Preference uint16
+	Exchange   string
+}
+
+// TXT holds one or more character-strings (each up to 255 octets on the wire).
+type TXT struct {
+	Strings []string
+}
+
+type SOA struct {
+	MName   string // primary name server
+	RName   string // mailbox of the person responsible, with the @ as a dot
+	Serial  uint32
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+	Minimum uint32 // also the negative caching TTL (RFC 2308)
+}
+
+type SRV struct {
+	Priority uint16
+	Weight   uint16
+	Port     uint16
+	Target   string
+}
+
+// CAA is a Certification Authority Authorization record (RFC 8659).
+type CAA struct {
+	Flags uint8
+	Tag   string // e.g. "issue", "issuewild", "iodef"
+	Value string
+}
+
+// DNAME redirects a whole subtree (RFC 6672).
+type DNAME struct {
+	Target string
+}
+
+// DS is a delegation signer (RFC 4034 5), the parent's hash of a child's key.
+type DS struct {
+	KeyTag     uint16
+	Algorithm  uint8
+	DigestType uint8
+	Digest     []byte
+}
+
+// SSHFP is an SSH host key fingerprint (RFC 4255).
+type SSHFP struct {
+	Algorithm   uint8
+	FPType      uint8 // 1 = SHA-1, 2 = SHA-256
+	Fingerprint []byte
+}
+
+// TLSA pins a TLS certificate or key for DANE (RFC 6698).
+type TLSA struct {
+	Usage        uint8
+	Selector     uint8
+	MatchingType uint8
+	Data         []byte // certificate association data
+}
+
+// HINFO is the host's CPU and operating system (RFC 1035 3.3.2). Rarely
+// published, but it's what RFC 8482 servers answer ANY queries with.
+type HINFO struct {
+	CPU string
+	OS  string
+}
+
+// RP is the responsible person for a name (RFC 1183 2.2): their mailbox, in
+// the same form as SOA's RName, and a name with TXT records about them.
+type RP struct {
+	Mbox string
+	Txt  string
+}
+
+// AFSDB locates an AFS or DCE database server (RFC 1183 1).
+type AFSDB struct {
+	Subtype  uint16
+	Hostname string
+}
+
+// SPF is the old SPF record type. It's the same format as TXT; RFC 7208
+// 3.1 retired it in favour of TXT, but zones still have them.
+type SPF struct {
+	Strings []string
+}
+
+// NAPTR is a naming authority pointer (RFC 3403), the rewrite rules behind
+// ENUM and SIP service discovery.
+type NAPTR struct {
+	Order       uint16
+	Preference  uint16
+	Flags       string
+	Services    string
+	Regexp      string
+	Replacement string
+}
+
+// URI maps a service name to a URI (RFC 7553).
+type URI struct {
+	Priority uint16
+	Weight   uint16
+	Target   string
+}
+
+// Unknown holds the RDATA of any type this package doesn't have a struct for.
+type Unknown struct {
+	RRType uint16
+	Data   []byte
+}
+
+func (*A) Type() uint16         { return dnswire.TypeA }
+func (*AAAA) Type() uint16      { return dnswire.TypeAAAA }
+func (*NS) Type() uint16        { return dnswire.TypeNS }
+func (*CNAME) Type() uint16     { return dnswire.TypeCNAME }
+func (*PTR) Type() uint16       { return dnswire.TypePTR }
+func (*MX) Type() uint16        { return dnswire.TypeMX }
+func (*TXT) Type() uint16       { return dnswire.TypeTXT }
+func (*SOA) Type() uint16       { return dnswire.TypeSOA }
+func (*SRV) Type() uint16       { return dnswire.TypeSRV }
+func (*CAA) Type() uint16       { return dnswire.TypeCAA }
+func (*DNAME) Type() uint16     { return dnswire.TypeDNAME }
+func (*DS) Type() uint16        { return dnswire.TypeDS }
+func (*SSHFP) Type() uint16     { return dnswire.TypeSSHFP }
+func (*TLSA) Type() uint16      { return dnswire.TypeTLSA }
+func (*HINFO) Type() uint16     { return dnswire.TypeHINFO }
+func (*RP) Type() uint16        { return dnswire.TypeRP }
+func (*AFSDB) Type() uint16     { return dnswire.TypeAFSDB }
+func (*SPF) Type() uint16       { return dnswire.TypeSPF }
+func (*NAPTR) Type() uint16     { return dnswire.TypeNAPTR }
+func (*URI) Type() uint16       { return dnswire.TypeURI }
+func (*DNSKEY) Type() uint16    { return dnswire.TypeDNSKEY }
+func (*RRSIG) Type() uint16     { return dnswire.TypeRRSIG }
+func (*NSEC) Type() uint16      { return dnswire.TypeNSEC }
+func (u *Unknown) Type() uint16 { return u.RRType }
+
+// The String methods all go through the wire format, so there's one
+// presentation formatter (dnswire.FormatRData) rather than one per type.
+func (a *A) String() string       { return format(a) }
+func (a *AAAA) String() string    { return format(a) }
+func (n *NS) String() string      { return format(n) }
+func (c *CNAME) String() string   { return format(c) }
+func (p *PTR) String() string     { return format(p) }
+func (m *MX) String() string      { return format(m) }
+func (t *TXT) String() string     { return format(t) }
+func (s *SOA) String() string     { return format(s) }
+func (s *SRV) String() string     { return format(s) }
+func (c *CAA) String() string     { return format(c) }
+func (d *DNAME) String() string   { return format(d) }
+func (d *DS) String() string      { return format(d) }
+func (s *SSHFP) String() string   { return format(s) }
+func (t *TLSA) String() string    { return format(t) }
+func (h *HINFO) String() string   { return format(h) }
+func (r *RP) String() string      { return format(r) }
+func (a *AFSDB) String() string   { return format(a) }
+func (s *SPF) String() string     { return format(s) }
+func (n *NAPTR) String() string   { return format(n) }
+func (u *URI) String() string     { return format(u) }
+func (k *DNSKEY) String() string  { return format(k) }
+func (s *RRSIG) String() string   { return format(s) }
+func (n *NSEC) String() string    { return format(n) }
+func (u *Unknown) String() string { return format(u) }
+
+func format(d RData) string {
+	b, err := d.Pack()
+	if err != nil {
+		// Something that can't go on the wire can't go in a zone file either
+		return fmt.Sprintf("<bad %s: %v>", dnswire.TypeToString(d.Type()), err)
+	}
+	return dnswire.FormatRData(d.Type(), b, "")
+}
+
+// Decode returns the typed RDATA of r. Compressed names have already been
+// expanded by dnswire.DecodeMessage, so this only needs the record itself.
+func Decode(r dnswire.ResourceRecord) (RData, error) {
+	return Unpack(r.Type, r.RData)
+}
+
+// Unpack parses uncompressed wire-format RDATA of the given type. Types
+// without a struct in this package come back as *Unknown.
+func Unpack(rrtype uint16, rdata []byte) (RData, error) {
+
+	switch rrtype {
+	case dnswire.TypeA:
+		if len(rdata) != net.IPv4len {
+			return nil, fmt.Errorf("rr: A rdata is %d bytes, want %d", len(rdata), net.IPv4len)
+		}
+		return &A{Address: net.IP(append([]byte(nil), rdata...))}, nil
+
+	case dnswire.TypeAAAA:
+		if len(rdata) != net.IPv6len {
+			return nil, fmt.Errorf("rr: AAAA rdata is %d bytes, want %d", len(rdata), net.IPv6len)
+		}
+		return &AAAA{Address: net.IP(append([]byte(nil), rdata...))}, nil
+
+	case dnswire.TypeNS:
+		name, err := unpackSingleName("NS", rdata)
+		if err != nil {
+			return nil, err
+		}
+		return &NS{Host: name}, nil
+
+	case dnswire.TypeCNAME:
+		name, err := unpackSingleName("CNAME", rdata)
+		if err != nil {
+			return nil, err
+		}
+		return &CNAME{Target: name}, nil
+
+	case dnswire.TypePTR:
+		name, err := unpackSingleName("PTR", rdata)
+		if err != nil {
+			return nil, err
+		}
+		return &PTR{Target: name}, nil
+
+	case dnswire.TypeMX:
+		if len(rdata) < 2 {
+			return nil, fmt.Errorf("rr: MX rdata is %d bytes, too short for preference", len(rdata))
+		}
+		name, err := unpackSingleName("MX", rdata[2:])
+		if err != nil {
+			return nil, err
+		}
+		return &MX{Preference: binary.BigEndian.Uint16(rdata), Exchange: name}, nil
+
+	case dnswire.TypeTXT:
+		return unpackTXT(rdata)
+
+	case dnswire.TypeSOA:
+		return unpackSOA(rdata)
+
+	case dnswire.TypeSRV:
+		if len(rdata) < 6 {
+			return nil, fmt.Errorf("rr: SRV rdata is %d bytes, too short", len(rdata))
+		}
+		name, err := unpackSingleName("SRV", rdata[6:])
+		if err != nil {
+			return nil, err
+		}
+		return &SRV{
+			Priority: binary.BigEndian.Uint16(rdata[0:2]),
+			Weight:   binary.BigEndian.Uint16(rdata[2:4]),
+			Port:     binary.BigEndian.Uint16(rdata[4:6]),
+			Target:   name,
+		}, nil
+
+	case dnswire.TypeCAA:
+		// flags (1) | tag length (1) | tag | value (rest)
+		if len(rdata) < 2 || len(rdata) < 2+int(rdata[1]) {
+			return nil, fmt.Errorf("rr: CAA rdata is %d bytes, too short", len(rdata))
+		}
+		tagEnd := 2 + int(rdata[1])
+		return &CAA{
+			Flags: rdata[0],
+			Tag:   string(rdata[2:tagEnd]),
+			Value: string(rdata[tagEnd:]),
+		}, nil
+
+	case dnswire.TypeDNAME:
+		name, err := unpackSingleName("DNAME", rdata)
+		if err != nil {
+			return nil, err
+		}
+		return &DNAME{Target: name}, nil
+
+	case dnswire.TypeDS:
+		if len(rdata) < 4 {
+			return nil, fmt.Errorf("rr: DS rdata is %d bytes, too short", len(rdata))
+		}
+		return &DS{
+			KeyTag:     binary.BigEndian.Uint16(rdata),
+			Algorithm:  rdata[2],
+			DigestType: rdata[3],
+			Digest:     bytes.Clone(rdata[4:]),
+		}, nil
+
+	case dnswire.TypeSSHFP:
+		if len(rdata) < 2 {
+			return nil, fmt.Errorf("rr: SSHFP rdata is %d bytes, too short", len(rdata))
+		}
+		return &SSHFP{Algorithm: rdata[0], FPType: rdata[1], Fingerprint: bytes.Clone(rdata[2:])}, nil
+
+	case dnswire.TypeTLSA:
+		if len(rdata) < 3 {
+			return nil, fmt.Errorf("rr: TLSA rdata is %d bytes, too short", len(rdata))
+		}
+		return &TLSA{Usage: rdata[0], Selector: rdata[1], MatchingType: rdata[2], Data: bytes.Clone(rdata[3:])}, nil
+
+	case dnswire.TypeHINFO:
+		strs, err := unpackStrings("HINFO", rdata, 2)
+		if err != nil {
+			return nil, err
+		}
+		return &HINFO{CPU: strs[0], OS: strs[1]}, nil
+
+	case dnswire.TypeRP:
+		mbox, off, err := dnswire.DecodeName(rdata, 0)
+		if err != nil {
+			return nil, fmt.Errorf("rr: RP mbox: %w", err)
+		}
+		txt, err := unpackSingleName("RP", rdata[off:])
+		if err != nil {
+			return nil, err
+		}
+		return &RP{Mbox: mbox, Txt: txt}, nil
+
+	case dnswire.TypeAFSDB:
+		if len(rdata) < 2 {
+			return nil, fmt.Errorf("rr: AFSDB rdata is %d bytes, too short for subtype", len(rdata))
+		}
+		name, err := unpackSingleName("AFSDB", rdata[2:])
+		if err != nil {
+			return nil, err
+		}
+		return &AFSDB{Subtype: binary.BigEndian.Uint16(rdata), Hostname: name}, nil
+
+	case dnswire.TypeSPF:
+		t, err := unpackTXT(rdata)
+		if err != nil {
+			return nil, err
+		}
+		return &SPF{Strings: t.Strings}, nil
+
+	case dnswire.TypeNAPTR:
+		return unpackNAPTR(rdata)
+
+	case dnswire.TypeURI:
+		if len(rdata) < 4 {
+			return nil, fmt.Errorf("rr: URI rdata is %d bytes, too short", len(rdata))
+		}
+		return &URI{
+			Priority: binary.BigEndian.Uint16(rdata),
+			Weight:   binary.BigEndian.Uint16(rdata[2:]),
+			Target:   string(rdata[4:]),
+		}, nil
+
+	case dnswire.TypeDNSKEY:
+		return unpackDNSKEY(rdata)
+
+	case dnswire.TypeRRSIG:
+		return unpackRRSIG(rdata)
+
+	case dnswire.TypeNSEC:
+		return unpackNSEC(rdata)
+
+	default:
+		return &Unknown{RRType: rrtype, Data: append([]byte(nil), rdata...)}, nil
+	}
+}
+
+// New builds a ResourceRecord (class IN) for name with the given TTL and RDATA.
+func New(name string, ttl uint32, d RData) (dnswire.ResourceRecord, error) {
+
+	rdata, err := d.Pack()
+	if err != nil {
+		return dnswire.ResourceRecord{}, err
+	}
+	if len(rdata) > 0xFFFF {
+		return dnswire.ResourceRecord{}, fmt.Errorf("rr: rdata is %d bytes, longer than 65535", len(rdata))
+	}
+
+	return dnswire.ResourceRecord{
+		Name:     name,
+		Type:     d.Type(),
+		Class:    dnswire.ClassIN,
+		TTL:      ttl,
+		RDLength: uint16(len(rdata)),
+		RData:    rdata,
+	}, nil
 }
 
-// You might eventually have:
-// type SOA struct { ... }
-// type MX struct { ... }
+// ---------- Pack ----------
+
+func (a *A) Pack() ([]byte, error) {
+	ip4 := a.Address.To4()
+	if ip4 == nil {
+		return nil, fmt.Errorf("rr: A address %v is not IPv4", a.Address)
+	}
+	return []byte(ip4), nil
+}
+
+func (a *AAAA) Pack() ([]byte, error) {
+	if len(a.Address) != net.IPv6len {
+		return nil, fmt.Errorf("rr: AAAA address %v is not IPv6", a.Address)
+	}
+	return append([]byte(nil), a.Address...), nil
+}
+
+func (n *NS) Pack() ([]byte, error)    { return dnswire.EncodeName(n.Host) }
+func (c *CNAME) Pack() ([]byte, error) { return dnswire.EncodeName(c.Target) }
+func (p *PTR) Pack() ([]byte, error)   { return dnswire.EncodeName(p.Target) }
+
+func (m *MX) Pack() ([]byte, error) {
+	name, err := dnswire.EncodeName(m.Exchange)
+	if err != nil {
+		return nil, err
+	}
+	return append(binary.BigEndian.AppendUint16(nil, m.Preference), name...), nil
+}
+
+func (t *TXT) Pack() ([]byte, error) { return packStrings("TXT", nil, t.Strings...) }
+
+func (s *SOA) Pack() ([]byte, error) {
+	mname, err := dnswire.EncodeName(s.MName)
+	if err != nil {
+		return nil, err
+	}
+	rname, err := dnswire.EncodeName(s.RName)
+	if err != nil {
+		return nil, err
+	}
+	b := append(mname, rname...)
+	for _, v := range []uint32{s.Serial, s.Refresh, s.Retry, s.Expire, s.Minimum} {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return b, nil
+}
+
+func (s *SRV) Pack() ([]byte, error) {
+	name, err := dnswire.EncodeName(s.Target)
+	if err != nil {
+		return nil, err
+	}
+	b := binary.BigEndian.AppendUint16(nil, s.Priority)
+	b = binary.BigEndian.AppendUint16(b, s.Weight)
+	b = binary.BigEndian.AppendUint16(b, s.Port)
+	return append(b, name...), nil
+}
+
+func (c *CAA) Pack() ([]byte, error) {
+	if len(c.Tag) == 0 || len(c.Tag) > 255 {
+		return nil, fmt.Errorf("rr: CAA tag %q must be 1-255 bytes", c.Tag)
+	}
+	b := []byte{c.Flags, byte(len(c.Tag))}
+	b = append(b, c.Tag...)
+	return append(b, c.Value...), nil
+}
+
+func (d *DNAME) Pack() ([]byte, error) { return dnswire.EncodeName(d.Target) }
+
+func (d *DS) Pack() ([]byte, error) {
+	b := binary.BigEndian.AppendUint16(nil, d.KeyTag)
+	b = append(b, d.Algorithm, d.DigestType)
+	return append(b, d.Digest...), nil
+}
+
+func (s *SSHFP) Pack() ([]byte, error) {
+	return append([]byte{s.Algorithm, s.FPType}, s.Fingerprint...), nil
+}
+
+func (t *TLSA) Pack() ([]byte, error) {
+	return append([]byte{t.Usage, t.Selector, t.MatchingType}, t.Data...), nil
+}
+
+func (h *HINFO) Pack() ([]byte, error) {
+	return packStrings("HINFO", nil, h.CPU, h.OS)
+}
+
+func (r *RP) Pack() ([]byte, error) {
+	mbox, err := dnswire.EncodeName(r.Mbox)
+	if err != nil {
+		return nil, err
+	}
+	txt, err := dnswire.EncodeName(r.Txt)
+	if err != nil {
+		return nil, err
+	}
+	return append(mbox, txt...), nil
+}
+
+func (a *AFSDB) Pack() ([]byte, error) {
+	name, err := dnswire.EncodeName(a.Hostname)
+	if err != nil {
+		return nil, err
+	}
+	return append(binary.BigEndian.AppendUint16(nil, a.Subtype), name...), nil
+}
+
+func (s *SPF) Pack() ([]byte, error) { return packStrings("SPF", nil, s.Strings...) }
+
+func (n *NAPTR) Pack() ([]byte, error) {
+	b := binary.BigEndian.AppendUint16(nil, n.Order)
+	b = binary.BigEndian.AppendUint16(b, n.Preference)
+	b, err := packStrings("NAPTR", b, n.Flags, n.Services, n.Regexp)
+	if err != nil {
+		return nil, err
+	}
+	name, err := dnswire.EncodeName(n.Replacement)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, name...), nil
+}
+
+func (u *URI) Pack() ([]byte, error) {
+	b := binary.BigEndian.AppendUint16(nil, u.Priority)
+	b = binary.BigEndian.AppendUint16(b, u.Weight)
+	return append(b, u.Target...), nil
+}
+
+func (u *Unknown) Pack() ([]byte, error) {
+	return append([]byte(nil), u.Data...), nil
+}
+
+// ---------- Unpack helpers ----------
+
+// unpackSingleName reads RDATA that is exactly one domain name.
+func unpackSingleName(what string, rdata []byte) (string, error) {
+	name, end, err := dnswire.DecodeName(rdata, 0)
+	if err != nil {
+		return "", fmt.Errorf("rr: %s rdata: %w", what, err)
+	}
+	if end != len(rdata) {
+		return "", fmt.Errorf("rr: %s rdata has %d trailing bytes", what, len(rdata)-end)
+	}
+	return name, nil
+}
+
+func unpackTXT(rdata []byte) (*TXT, error) {
+	t := &TXT{}
+	for pos := 0; pos < len(rdata); {
+		l := int(rdata[pos])
+		pos++
+		if pos+l > len(rdata) {
+			return nil, fmt.Errorf("rr: TXT string at offset %d (length %d) runs past end of rdata", pos-1, l)
+		}
+		t.Strings = append(t.Strings, string(rdata[pos:pos+l]))
+		pos += l
+	}
+	return t, nil
+}
+
+// packStrings appends each of strs to b as a <character-string>.
+func packStrings(what string, b []byte, strs ...string) ([]byte, error) {
+	for _, s := range strs {
+		if len(s) > 255 {
+			return nil, fmt.Errorf("rr: %s string of %d bytes is longer than 255", what, len(s))
+		}
+		b = append(b, byte(len(s)))
+		b = append(b, s...)
+	}
+	return b, nil
+}
+
+// unpackStrings reads exactly n <character-string>s.
+func unpackStrings(what string, rdata []byte, n int) ([]string, error) {
+	var strs []string
+	pos := 0
+	for range n {
+		if pos >= len(rdata) {
+			return nil, fmt.Errorf("rr: %s rdata has %d strings, want %d", what, len(strs), n)
+		}
+		l := int(rdata[pos])
+		pos++
+		if pos+l > len(rdata) {
+			return nil, fmt.Errorf("rr: %s string at offset %d (length %d) runs past end of rdata", what, pos-1, l)
+		}
+		strs = append(strs, string(rdata[pos:pos+l]))
+		pos += l
+	}
+	if pos != len(rdata) {
+		return nil, fmt.Errorf("rr: %s rdata has %d trailing bytes", what, len(rdata)-pos)
+	}
+	return strs, nil
+}
+
+func unpackNAPTR(rdata []byte) (*NAPTR, error) {
+	if len(rdata) < 4 {
+		return nil, fmt.Errorf("rr: NAPTR rdata is %d bytes, too short", len(rdata))
+	}
+	n := &NAPTR{Order: binary.BigEndian.Uint16(rdata), Preference: binary.BigEndian.Uint16(rdata[2:])}
+
+	// Three strings, then the replacement name takes the rest
+	pos := 4
+	for _, s := range []*string{&n.Flags, &n.Services, &n.Regexp} {
+		if pos >= len(rdata) || pos+1+int(rdata[pos]) > len(rdata) {
+			return nil, fmt.Errorf("rr: NAPTR string at offset %d runs past end of rdata", pos)
+		}
+		*s = string(rdata[pos+1 : pos+1+int(rdata[pos])])
+		pos += 1 + int(rdata[pos])
+	}
+	name, err := unpackSingleName("NAPTR", rdata[pos:])
+	if err != nil {
+		return nil, err
+	}
+	n.Replacement = name
+	return n, nil
+}
+
+func unpackSOA(rdata []byte) (*SOA, error) {
+	mname, off, err := dnswire.DecodeName(rdata, 0)
+	if err != nil {
+		return nil, fmt.Errorf("rr: SOA mname: %w", err)
+	}
+	rname, off, err := dnswire.DecodeName(rdata, off)
+	if err != nil {
+		return nil, fmt.Errorf("rr: SOA rname: %w", err)
+	}
+	if len(rdata)-off != 20 {
+		return nil, fmt.Errorf("rr: SOA has %d bytes after the names, want 20", len(rdata)-off)
+	}
+	u32 := func(i int) uint32 { return binary.BigEndian.Uint32(rdata[off+4*i:]) }
+	return &SOA{
+		MName:   mname,
+		RName:   rname,
+		Serial:  u32(0),
+		Refresh: u32(1),
+		Retry:   u32(2),
+		Expire:  u32(3),
+		Minimum: u32(4),
+	}, nil
+}
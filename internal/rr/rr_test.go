@@ -0,0 +1,184 @@
+package rr
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"dnstom/internal/dnswire"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	out := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case ' ', '\n', '\r', '\t':
+		default:
+			out = append(out, s[i])
+		}
+	}
+	b, err := hex.DecodeString(string(out))
+	if err != nil {
+		t.Fatalf("bad hex fixture: %v", err)
+	}
+	return b
+}
+
+func TestPackUnpack_RoundTrip(t *testing.T) {
+	tests := []RData{
+		&A{Address: net.IPv4(93, 184, 216, 34).To4()},
+		&AAAA{Address: net.ParseIP("2606:2800:220:1:248:1893:25c8:1946")},
+		&NS{Host: "a.iana-servers.net."},
+		&CNAME{Target: "www.example.com."},
+		&PTR{Target: "dns.google."},
+		&MX{Preference: 10, Exchange: "mail.example.com."},
+		&TXT{Strings: []string{"v=spf1 -all", ""}},
+		&SOA{MName: "ns.icann.org.", RName: "noc.dns.icann.org.", Serial: 2024081234, Refresh: 7200, Retry: 3600, Expire: 1209600, Minimum: 3600},
+		&SRV{Priority: 5, Weight: 0, Port: 5060, Target: "sip.example.com."},
+		&CAA{Flags: 0, Tag: "issue", Value: "letsencrypt.org"},
+		&DNAME{Target: "example.net."},
+		&DS{KeyTag: 20326, Algorithm: 8, DigestType: 2, Digest: []byte{0xe0, 0x6d, 0x44}},
+		&SSHFP{Algorithm: 4, FPType: 2, Fingerprint: []byte{0xde, 0xad, 0xbe, 0xef}},
+		&TLSA{Usage: 3, Selector: 1, MatchingType: 1, Data: []byte{0x01, 0x02}},
+		&HINFO{CPU: "x86_64", OS: "Linux"},
+		&RP{Mbox: "admin.example.com.", Txt: "."},
+		&AFSDB{Subtype: 1, Hostname: "afs.example.com."},
+		&SPF{Strings: []string{"v=spf1 -all"}},
+		&NAPTR{Order: 100, Preference: 10, Flags: "S", Services: "SIP+D2U", Regexp: "", Replacement: "_sip._udp.example.com."},
+		&URI{Priority: 10, Weight: 1, Target: "ftp://ftp.example.com/public"},
+		&DNSKEY{Flags: 256, Protocol: 3, Algorithm: 8, PublicKey: []byte{1, 2, 3}},
+		&RRSIG{TypeCovered: dnswire.TypeMX, Algorithm: 8, Labels: 2, OrigTTL: 3600, Expiration: 2, Inception: 1,
+			KeyTag: 999, SignerName: "example.com.", Signature: []byte{4, 5, 6}},
+		&NSEC{NextDomain: "host.example.com.", Types: []uint16{dnswire.TypeA, dnswire.TypeMX, dnswire.TypeRRSIG, dnswire.TypeNSEC, dnswire.TypeURI}},
+		&Unknown{RRType: 65280, Data: []byte{1, 2, 3}},
+	}
+
+	for _, want := range tests {
+		b, err := want.Pack()
+		if err != nil {
+			t.Fatalf("%T.Pack: %v", want, err)
+		}
+		got, err := Unpack(want.Type(), b)
+		if err != nil {
+			t.Fatalf("Unpack(%d): %v", want.Type(), err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("round trip %T: got %+v want %+v", want, got, want)
+		}
+	}
+}
+
+func TestDecode_CompressedMXFromMessage(t *testing.T) {
+	// Response for: MX example.com - the exchange is "mail" + pointer to QNAME
+	wire := mustHex(t, `
+		abcd 8180 0001 0001 0000 0000
+		0765 7861 6d70 6c65 0363 6f6d 00
+		000f 0001
+		c00c 000f 0001 0000 0e10 0009 000a 046d 6169 6c c00c
+	`)
+
+	m, err := dnswire.DecodeMessage(wire)
+	if err != nil {
+		t.Fatalf("DecodeMessage: %v", err)
+	}
+
+	d, err := Decode(m.Answers[0])
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	mx, ok := d.(*MX)
+	if !ok {
+		t.Fatalf("got %T want *MX", d)
+	}
+	if mx.Preference != 10 || mx.Exchange != "mail.example.com." {
+		t.Fatalf("MX = %d %q, want 10 %q", mx.Preference, mx.Exchange, "mail.example.com.")
+	}
+}
+
+func TestUnpack_Malformed(t *testing.T) {
+	tests := []struct {
+		name   string
+		rrtype uint16
+		rdata  []byte
+	}{
+		{"A too short", dnswire.TypeA, []byte{1, 2, 3}},
+		{"AAAA too short", dnswire.TypeAAAA, []byte{1, 2, 3, 4}},
+		{"MX no name", dnswire.TypeMX, []byte{0, 10}},
+		{"TXT overrun", dnswire.TypeTXT, []byte{5, 'a'}},
+		{"SOA short timers", dnswire.TypeSOA, []byte{0, 0, 0, 0, 0}},
+		{"CAA tag overrun", dnswire.TypeCAA, []byte{0, 9, 'i'}},
+		{"CNAME trailing bytes", dnswire.TypeCNAME, []byte{0, 1}},
+		{"DS too short", dnswire.TypeDS, []byte{0, 1, 8}},
+		{"TLSA too short", dnswire.TypeTLSA, []byte{3, 1}},
+		{"HINFO one string", dnswire.TypeHINFO, []byte("\x03x86")},
+		{"HINFO three strings", dnswire.TypeHINFO, []byte("\x01a\x01b\x01c")},
+		{"NAPTR string overrun", dnswire.TypeNAPTR, []byte{0, 1, 0, 1, 5, 'U'}},
+		{"RRSIG too short", dnswire.TypeRRSIG, make([]byte, 17)},
+		{"NSEC window out of order", dnswire.TypeNSEC, []byte{0, 1, 0, 1, 0x40, 0, 1, 0x40}},
+		{"NSEC window too long", dnswire.TypeNSEC, append([]byte{0, 0, 33}, make([]byte, 33)...)},
+	}
+
+	for _, tt := range tests {
+		if _, err := Unpack(tt.rrtype, tt.rdata); err == nil {
+			t.Errorf("%s: expected error, got nil", tt.name)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	r, err := New("www.example.com.", 300, &A{Address: net.IPv4(192, 0, 2, 1)})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if r.Type != dnswire.TypeA || r.Class != dnswire.ClassIN || r.TTL != 300 || r.RDLength != 4 {
+		t.Fatalf("New: got %+v", r)
+	}
+}
+
+func TestTypeBitmap(t *testing.T) {
+	// RFC 4034 4.3's example: A MX RRSIG NSEC TYPE1234
+	types := []uint16{dnswire.TypeA, dnswire.TypeMX, dnswire.TypeRRSIG, dnswire.TypeNSEC, 1234}
+	want := []byte{
+		0x00, 0x06, 0x40, 0x01, 0x00, 0x00, 0x00, 0x03,
+		0x04, 0x1b, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20,
+	}
+
+	// Order and duplicates don't matter going in
+	got := appendTypeBitmap(nil, []uint16{1234, dnswire.TypeNSEC, dnswire.TypeA, dnswire.TypeMX, dnswire.TypeRRSIG, dnswire.TypeA})
+	if !bytes.Equal(got, want) {
+		t.Errorf("appendTypeBitmap = % x, want % x", got, want)
+	}
+
+	back, err := unpackTypeBitmap(want)
+	if err != nil || !reflect.DeepEqual(back, types) {
+		t.Errorf("unpackTypeBitmap = %v, %v; want %v", back, err, types)
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		d    RData
+		want string
+	}{
+		{&MX{Preference: 10, Exchange: "mail.example.com."}, "10 mail.example.com."},
+		{&TXT{Strings: []string{"a b", `c"d`}}, `"a b" "c\"d"`},
+		{&HINFO{CPU: "RFC8482", OS: ""}, `"RFC8482" ""`},
+		{&NSEC{NextDomain: "b.example.", Types: []uint16{dnswire.TypeNSEC, dnswire.TypeA}}, "b.example. A NSEC"},
+		{&RRSIG{TypeCovered: dnswire.TypeA, Algorithm: 13, Labels: 2, OrigTTL: 300, Expiration: 1735689600,
+			Inception: 1704067200, KeyTag: 1, SignerName: "example.", Signature: []byte{0xff}},
+			"A 13 2 300 20250101000000 20240101000000 1 example. /w=="},
+		{&Unknown{RRType: 65280, Data: []byte{0xab}}, `\# 1 AB`},
+		{&MX{Preference: 10, Exchange: strings.Repeat("a", 64) + ".com."}, "<bad MX: encode name"},
+	}
+	for _, tt := range tests {
+		if got := tt.d.String(); !strings.HasPrefix(got, tt.want) {
+			t.Errorf("%T.String() = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ACL is a list of client networks, e.g. who is allowed to use a
+// resolver for recursion. An empty ACL allows nobody.
+type ACL struct {
+	nets []*net.IPNet
+}
+
+// DefaultACL is this host only - an open resolver on the internet is a
+// DDoS amplifier waiting to happen.
+const DefaultACL = "127.0.0.0/8,::1/128"
+
+// ParseACL parses a comma separated list of networks ("192.0.2.0/24") and
+// addresses ("192.0.2.1", the same as a /32). "any" allows everyone.
+func ParseACL(s string) (*ACL, error) {
+
+	acl := &ACL{}
+
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		switch item {
+		case "":
+			continue
+		case "any":
+			item = "0.0.0.0/0,::/0"
+			more, _ := ParseACL(item)
+			acl.nets = append(acl.nets, more.nets...)
+			continue
+		}
+
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("acl: %q is not an address or network", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			acl.nets = append(acl.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("acl: %w", err)
+		}
+		acl.nets = append(acl.nets, n)
+	}
+
+	return acl, nil
+}
+
+// Allows reports whether client (a *net.UDPAddr or *net.TCPAddr) is on one
+// of the ACL's networks.
+func (a *ACL) Allows(client net.Addr) bool {
+
+	var ip net.IP
+	switch c := client.(type) {
+	case *net.UDPAddr:
+		ip = c.IP
+	case *net.TCPAddr:
+		ip = c.IP
+	default:
+		return false
+	}
+
+	for _, n := range a.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// String lists the networks, comma separated - the same format ParseACL takes.
+func (a *ACL) String() string {
+	s := make([]string, len(a.nets))
+	for i, n := range a.nets {
+		s[i] = n.String()
+	}
+	return strings.Join(s, ",")
+}
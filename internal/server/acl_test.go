@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net"
+	"testing"
+)
+
+func TestACL(t *testing.T) {
+	acl, err := ParseACL("192.0.2.0/24, 198.51.100.7 ,2001:db8::/32")
+	if err != nil {
+		t.Fatalf("ParseACL error: %v", err)
+	}
+
+	tests := []struct {
+		client net.Addr
+		want   bool
+	}{
+		{&net.UDPAddr{IP: net.ParseIP("192.0.2.200"), Port: 5353}, true},
+		{&net.TCPAddr{IP: net.ParseIP("198.51.100.7"), Port: 5353}, true},
+		{&net.UDPAddr{IP: net.ParseIP("198.51.100.8"), Port: 5353}, false},
+		{&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5353}, true},
+		{&net.UDPAddr{IP: net.ParseIP("2001:db9::1"), Port: 5353}, false},
+		{&net.UnixAddr{Name: "/tmp/sock"}, false},
+	}
+	for _, tt := range tests {
+		if got := acl.Allows(tt.client); got != tt.want {
+			t.Errorf("Allows(%s) = %v, want %v", tt.client, got, tt.want)
+		}
+	}
+
+	if got, want := acl.String(), "192.0.2.0/24,198.51.100.7/32,2001:db8::/32"; got != want {
+		t.Errorf("String = %q, want %q", got, want)
+	}
+
+	any, err := ParseACL("any")
+	if err != nil || !any.Allows(&net.UDPAddr{IP: net.ParseIP("203.0.113.1")}) || !any.Allows(&net.UDPAddr{IP: net.ParseIP("2001:db8::1")}) {
+		t.Errorf("ParseACL(any) = %v, %v; want everyone allowed", any, err)
+	}
+
+	for _, bad := range []string{"192.0.2.0/33", "example.com"} {
+		if _, err := ParseACL(bad); err == nil {
+			t.Errorf("ParseACL(%q) succeeded, want error", bad)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net"
+	"time"
+
+	"dnstom/internal/dnswire"
+	"dnstom/internal/resolver"
+)
+
+// Recursive is the Handler for a recursive (caching, if the resolver has a
+// cache) name server: clients the ACL allows get their question answered
+// by the resolver, forwarding or iterating - whichever it's set up for.
+//
+// What goes wrong comes back as an RCODE rather than silence:
+//
+//	REFUSED   client isn't in the ACL, asked for something a recursive
+//	          server doesn't do (zone transfers, classes other than IN), or
+//	          said not to recurse (RD=0) for something that isn't cached
+//	NOTIMP    opcode other than QUERY
+//	FORMERR   not exactly one question
+//	SERVFAIL  the resolver couldn't get an answer
+//
+// and when the client sent EDNS, REFUSED and SERVFAIL carry an Extended DNS
+// Error (RFC 8914) saying why.
+type Recursive struct {
+	Resolver *resolver.Resolver
+	ACL      *ACL
+
+	// QueryLog, if set, gets a line per query: who asked what, and what
+	// they got.
+	QueryLog *log.Logger
+
+	// ErrorLog gets why the resolver failed, which clients only hear as a
+	// SERVFAIL. nil means the log package's standard logger.
+	ErrorLog *log.Logger
+}
+
+// NewRecursive returns a Recursive handler answering clients in acl from r.
+func NewRecursive(r *resolver.Resolver, acl *ACL) *Recursive {
+	return &Recursive{Resolver: r, ACL: acl}
+}
+
+// Extended DNS Error INFO-CODEs we use (RFC 8914 4).
+const (
+	edeNotSupported         = 21
+	edeNoReachableAuthority = 22
+	edeProhibited           = 18
+	edeNotAuthoritative     = 20
+)
+
+func (h *Recursive) ServeDNS(ctx context.Context, client net.Addr, query *dnswire.Message) *dnswire.Message {
+
+	start := time.Now()
+
+	resp := &dnswire.Message{
+		Header: dnswire.Header{
+			ID:     query.Header.ID,
+			QR:     true,
+			Opcode: query.Header.Opcode,
+			RD:     query.Header.RD,
+			RA:     true,
+			CD:     query.Header.CD,
+		},
+		Questions: query.Questions,
+	}
+	if query.OPT != nil {
+		resp.OPT = dnswire.NewOPT()
+	}
+
+	h.answer(ctx, client, query, resp)
+
+	if h.QueryLog != nil {
+		name, qtype := "-", "-"
+		if len(query.Questions) > 0 {
+			name, qtype = query.Questions[0].Name, dnswire.TypeToString(query.Questions[0].Type)
+		}
+		h.QueryLog.Printf("%s %s %s -> rcode %d, %d answers (%s)", client, name, qtype, resp.Rcode(), len(resp.Answers), time.Since(start).Round(time.Microsecond))
+	}
+
+	return resp
+}
+
+// answer fills in resp for query.
+func (h *Recursive) answer(ctx context.Context, client net.Addr, query, resp *dnswire.Message) {
+
+	if query.Header.Opcode != dnswire.OpcodeQuery {
+		resp.Header.Rcode = dnswire.RcodeNotImp
+		return
+	}
+	if len(query.Questions) != 1 {
+		resp.Header.Rcode = dnswire.RcodeFormErr
+		return
+	}
+
+	if h.ACL == nil || !h.ACL.Allows(client) {
+		refuse(resp, edeProhibited, "recursion not allowed for "+client.String())
+		return
+	}
+
+	q := query.Questions[0]
+	switch {
+	case q.Class != dnswire.ClassIN:
+		refuse(resp, edeNotSupported, "only class IN")
+		return
+	case q.Type == dnswire.TypeAXFR || q.Type == dnswire.TypeIXFR:
+		refuse(resp, edeNotSupported, "zone transfers aren't done by a recursive server")
+		return
+	}
+
+	// RD=0 is "don't go asking on my behalf": what's in the cache or nothing
+	if !query.Header.RD {
+		reply, ok := h.Resolver.Cached(q.Name, q.Type)
+		if !ok {
+			refuse(resp, edeNotAuthoritative, "not cached, and recursion not desired")
+			return
+		}
+		resp.Header.Rcode = reply.Header.Rcode
+		resp.Answers = reply.Answers
+		resp.Authority = reply.Authority
+		return
+	}
+
+	reply, err := h.Resolver.Resolve(ctx, q.Name, q.Type)
+	if err != nil {
+		// The error can say which upstreams we use and how they failed -
+		// that's for our log, not the client
+		h.logf("resolving %s %s for %s: %v", q.Name, dnswire.TypeToString(q.Type), client, err)
+		resp.Header.Rcode = dnswire.RcodeServFail
+		addEDE(resp, edeNoReachableAuthority, "no answer from upstream")
+		return
+	}
+
+	resp.Header.Rcode = reply.Header.Rcode
+	resp.Answers = reply.Answers
+	resp.Authority = reply.Authority
+}
+
+func (h *Recursive) logf(format string, args ...any) {
+	if h.ErrorLog != nil {
+		h.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+func refuse(resp *dnswire.Message, code uint16, text string) {
+	resp.Header.Rcode = dnswire.RcodeRefused
+	addEDE(resp, code, text)
+}
+
+// addEDE attaches an Extended DNS Error, if the client speaks EDNS.
+func addEDE(resp *dnswire.Message, code uint16, text string) {
+	if resp.OPT != nil {
+		resp.OPT.Options = append(resp.OPT.Options, &dnswire.ExtendedError{InfoCode: code, ExtraText: text})
+	}
+}
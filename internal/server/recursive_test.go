@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"dnstom/internal/dnswire"
+	"dnstom/internal/resolver"
+	"dnstom/internal/rr"
+)
+
+// upstream is a stand-in for the server the resolver forwards to: A
+// records for anything under example.com, NXDOMAIN for everything else.
+func upstream(t *testing.T) string {
+	return startServer(t, HandlerFunc(func(ctx context.Context, client net.Addr, q *dnswire.Message) *dnswire.Message {
+		resp := &dnswire.Message{
+			Header:    dnswire.Header{ID: q.Header.ID, QR: true, RD: q.Header.RD, RA: true},
+			Questions: q.Questions,
+		}
+		if q.Questions[0].Name != "www.example.com." {
+			resp.Header.Rcode = dnswire.RcodeNXDomain
+			return resp
+		}
+		a, _ := rr.New("www.example.com.", 300, &rr.A{Address: net.ParseIP("192.0.2.80")})
+		resp.Answers = append(resp.Answers, a)
+		return resp
+	}))
+}
+
+func recursiveServer(t *testing.T, upstreamAddr, allow string) string {
+	t.Helper()
+
+	acl, err := ParseACL(allow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := resolver.New(upstreamAddr, false)
+	r.SetPolicy(resolver.Policy{Timeout: 100 * time.Millisecond, Attempts: 1})
+
+	return startServer(t, NewRecursive(r, acl))
+}
+
+// ede returns the first Extended DNS Error in m, if any.
+func ede(m *dnswire.Message) *dnswire.ExtendedError {
+	if m.OPT == nil {
+		return nil
+	}
+	for _, o := range m.OPT.Options {
+		if e, ok := o.(*dnswire.ExtendedError); ok {
+			return e
+		}
+	}
+	return nil
+}
+
+func TestRecursive_Answers(t *testing.T) {
+	addr := recursiveServer(t, upstream(t), "127.0.0.1")
+
+	resp := exchange(t, "udp", addr, newQuery("www.example.com.", dnswire.TypeA))
+	if resp == nil || resp.Header.Rcode != dnswire.RcodeSuccess || !resp.Header.RA || !resp.Header.RD || len(resp.Answers) != 1 {
+		t.Fatalf("www.example.com = %+v", resp)
+	}
+
+	resp = exchange(t, "tcp", addr, newQuery("nope.example.com.", dnswire.TypeA))
+	if resp == nil || resp.Header.Rcode != dnswire.RcodeNXDomain {
+		t.Fatalf("nope.example.com = %+v, want NXDOMAIN", resp)
+	}
+}
+
+func TestRecursive_Errors(t *testing.T) {
+	// Nobody listening upstream
+	dead, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadAddr := dead.LocalAddr().String()
+	dead.Close()
+
+	allowed := recursiveServer(t, deadAddr, "127.0.0.0/8")
+	noRecursion := newQuery("www.example.com.", dnswire.TypeA)
+	noRecursion.Header.RD = false
+	refused := recursiveServer(t, upstream(t), "192.0.2.0/24")
+
+	withEDNS := func(q *dnswire.Message) *dnswire.Message {
+		q.OPT = dnswire.NewOPT()
+		return q
+	}
+	notify := newQuery("example.com.", dnswire.TypeSOA)
+	notify.Header.Opcode = dnswire.OpcodeNotify
+	chaos := newQuery("version.bind.", dnswire.TypeTXT)
+	chaos.Questions[0].Class = dnswire.ClassCH
+	twoQuestions := newQuery("www.example.com.", dnswire.TypeA)
+	twoQuestions.Questions = append(twoQuestions.Questions, twoQuestions.Questions[0])
+
+	tests := []struct {
+		name  string
+		addr  string
+		query *dnswire.Message
+		rcode uint8
+		ede   uint16 // 0 for none expected
+	}{
+		{"upstream down", allowed, withEDNS(newQuery("www.example.com.", dnswire.TypeA)), dnswire.RcodeServFail, edeNoReachableAuthority},
+		{"not in ACL", refused, withEDNS(newQuery("www.example.com.", dnswire.TypeA)), dnswire.RcodeRefused, edeProhibited},
+		{"not in ACL, no EDNS", refused, newQuery("www.example.com.", dnswire.TypeA), dnswire.RcodeRefused, 0},
+		{"AXFR", allowed, withEDNS(newQuery("example.com.", dnswire.TypeAXFR)), dnswire.RcodeRefused, edeNotSupported},
+		{"CHAOS", allowed, chaos, dnswire.RcodeRefused, 0},
+		{"NOTIFY", allowed, notify, dnswire.RcodeNotImp, 0},
+		{"two questions", allowed, twoQuestions, dnswire.RcodeFormErr, 0},
+		{"RD=0, not in ACL", refused, withEDNS(noRecursion), dnswire.RcodeRefused, edeProhibited},
+		{"RD=0, not cached", allowed, withEDNS(noRecursion), dnswire.RcodeRefused, edeNotAuthoritative},
+	}
+
+	for _, tt := range tests {
+		resp := exchange(t, "udp", tt.addr, tt.query)
+		if resp == nil {
+			t.Errorf("%s: no reply", tt.name)
+			continue
+		}
+		if resp.Header.Rcode != tt.rcode {
+			t.Errorf("%s: rcode %d, want %d", tt.name, resp.Header.Rcode, tt.rcode)
+		}
+		e := ede(resp)
+		switch {
+		case tt.ede == 0 && e != nil:
+			t.Errorf("%s: unexpected EDE %v", tt.name, e)
+		case tt.ede != 0 && (e == nil || e.InfoCode != tt.ede):
+			t.Errorf("%s: EDE = %v, want code %d", tt.name, e, tt.ede)
+		case e != nil && strings.Contains(e.ExtraText, deadAddr):
+			t.Errorf("%s: EDE text %q gives away the upstream", tt.name, e.ExtraText)
+		}
+	}
+}
+
+func TestRecursive_NoRecursionDesired(t *testing.T) {
+	acl, err := ParseACL("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := resolver.New(upstream(t), false)
+	r.SetCache(resolver.NewCache(resolver.CachePolicy{}))
+	addr := startServer(t, NewRecursive(r, acl))
+
+	q := newQuery("www.example.com.", dnswire.TypeA)
+	q.Header.RD = false
+
+	// Not cached yet, and we mustn't go and get it
+	resp := exchange(t, "udp", addr, q)
+	if resp == nil || resp.Header.Rcode != dnswire.RcodeRefused || resp.Header.RD {
+		t.Fatalf("RD=0 before caching = %+v, want REFUSED", resp)
+	}
+	if _, ok := r.Cached("www.example.com.", dnswire.TypeA); ok {
+		t.Fatalf("RD=0 query was resolved anyway")
+	}
+
+	// Once somebody has asked with RD=1, the cached answer is fair game
+	if resp := exchange(t, "udp", addr, newQuery("www.example.com.", dnswire.TypeA)); resp == nil || len(resp.Answers) != 1 {
+		t.Fatalf("RD=1 = %+v", resp)
+	}
+	resp = exchange(t, "udp", addr, q)
+	if resp == nil || resp.Header.Rcode != dnswire.RcodeSuccess || len(resp.Answers) != 1 {
+		t.Fatalf("RD=0 after caching = %+v, want the cached answer", resp)
+	}
+}
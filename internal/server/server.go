@@ -0,0 +1,372 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"dnstom/internal/dnswire"
+)
+
+// A DNS server: reads queries off UDP and TCP, hands each one to a Handler
+// and writes back whatever it returns. The server deals with the transport
+// (framing, truncation to what the client can take over UDP, messages that
+// don't decode); what the answer *is* is entirely up to the Handler.
+//
+//	srv := &server.Server{Handler: server.NewRecursive(r, acl)}
+//	err := srv.ListenAndServe(ctx, "127.0.0.1:5353")
+
+// Handler answers one query. client is who sent it. Returning nil sends
+// nothing back at all.
+type Handler interface {
+	ServeDNS(ctx context.Context, client net.Addr, query *dnswire.Message) *dnswire.Message
+}
+
+// HandlerFunc lets an ordinary function be a Handler.
+type HandlerFunc func(ctx context.Context, client net.Addr, query *dnswire.Message) *dnswire.Message
+
+func (f HandlerFunc) ServeDNS(ctx context.Context, client net.Addr, query *dnswire.Message) *dnswire.Message {
+	return f(ctx, client, query)
+}
+
+// Limits and timeouts when the Server fields are left at zero.
+const (
+	DefaultQueryTimeout = 10 * time.Second
+	DefaultIdleTimeout  = 10 * time.Second // RFC 7766 suggests "at least a few seconds"
+
+	DefaultMaxConcurrent = 1000
+	DefaultMaxTCPConns   = 100
+
+	// minUDPSize is the most a client without EDNS can take (RFC 1035 4.2.1).
+	minUDPSize = 512
+)
+
+type Server struct {
+	Handler Handler
+
+	QueryTimeout time.Duration // how long the handler gets for each query
+	IdleTimeout  time.Duration // how long a TCP connection can sit with no query
+
+	// MaxConcurrent is how many queries get handled at once. Past that a
+	// UDP query is dropped (the client will try again) and one over TCP
+	// gets a SERVFAIL. MaxTCPConns is how many TCP connections can be open
+	// at once; any more are closed as soon as they're accepted.
+	MaxConcurrent int
+	MaxTCPConns   int
+
+	// ErrorLog gets anything that goes wrong that there's no client to tell
+	// about. nil means the log package's standard logger.
+	ErrorLog *log.Logger
+
+	wg sync.WaitGroup
+
+	// Semaphores for MaxConcurrent and MaxTCPConns, made by the first Serve
+	once    sync.Once
+	queries chan struct{}
+	conns   chan struct{}
+}
+
+// ListenAndServe listens on addr over both UDP and TCP and serves until ctx
+// is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	// Same port for TCP - which matters if addr asked for port 0
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		return err
+	}
+
+	return s.Serve(ctx, pc, l)
+}
+
+// Serve answers queries arriving on pc (UDP) and l (TCP) until ctx is
+// cancelled, then closes both and waits for queries in flight to finish.
+// Either can be nil to serve only the other.
+func (s *Server) Serve(ctx context.Context, pc net.PacketConn, l net.Listener) error {
+
+	s.once.Do(func() {
+		s.queries = make(chan struct{}, s.maxConcurrent())
+		s.conns = make(chan struct{}, s.maxTCPConns())
+	})
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, 2)
+	running := 0
+
+	if pc != nil {
+		running++
+		go func() { errs <- s.serveUDP(ctx, pc) }()
+	}
+	if l != nil {
+		running++
+		go func() { errs <- s.serveTCP(ctx, l) }()
+	}
+
+	// Shutting down closes the sockets, which is what gets the read loops out
+	stop := context.AfterFunc(ctx, func() {
+		if pc != nil {
+			pc.Close()
+		}
+		if l != nil {
+			l.Close()
+		}
+	})
+	defer stop()
+
+	var err error
+	for ; running > 0; running-- {
+		if e := <-errs; e != nil && err == nil && ctx.Err() == nil {
+			// One side failing takes the other down with it
+			err = e
+			cancel()
+		}
+	}
+
+	s.wg.Wait()
+
+	return err
+}
+
+func (s *Server) serveUDP(ctx context.Context, pc net.PacketConn) error {
+
+	buf := make([]byte, 65535)
+
+	for {
+		n, client, err := pc.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		select {
+		case s.queries <- struct{}{}:
+		default:
+			// Too busy: drop it and let the client try again
+			continue
+		}
+
+		packet := append([]byte(nil), buf[:n]...)
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() { <-s.queries }()
+
+			query, reply := s.handle(ctx, client, packet)
+			if reply == nil {
+				return
+			}
+
+			b, err := encodeForUDP(query, reply)
+			if err != nil {
+				s.logf("server: reply to %s: %v", client, err)
+				return
+			}
+			if _, err := pc.WriteTo(b, client); err != nil && ctx.Err() == nil {
+				s.logf("server: reply to %s: %v", client, err)
+			}
+		}()
+	}
+}
+
+func (s *Server) serveTCP(ctx context.Context, l net.Listener) error {
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		select {
+		case s.conns <- struct{}{}:
+		default:
+			conn.Close()
+			continue
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() { <-s.conns }()
+			s.serveConn(ctx, conn)
+		}()
+	}
+}
+
+// serveConn answers queries on one TCP connection, one after another,
+// until the client closes it, goes quiet for IdleTimeout, or we shut down.
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	for {
+		conn.SetDeadline(time.Now().Add(s.idleTimeout()))
+
+		packet, err := dnswire.ReadTCPMessage(conn)
+		if err != nil {
+			return
+		}
+
+		var reply *dnswire.Message
+		select {
+		case s.queries <- struct{}{}:
+			_, reply = s.handle(ctx, conn.RemoteAddr(), packet)
+			<-s.queries
+		default:
+			reply = busy(packet)
+		}
+		if reply == nil {
+			continue
+		}
+
+		b, err := dnswire.EncodeMessage(reply)
+		if err != nil {
+			s.logf("server: reply to %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+		if err := dnswire.WriteTCPMessage(conn, b); err != nil {
+			return
+		}
+	}
+}
+
+// handle decodes packet and asks the handler what to say. Something that
+// doesn't decode gets a FORMERR if there's at least a header to reply to,
+// and nothing otherwise; so does anything that's already a response.
+func (s *Server) handle(ctx context.Context, client net.Addr, packet []byte) (*dnswire.Message, *dnswire.Message) {
+
+	query, err := dnswire.DecodeMessage(packet)
+	if err != nil {
+		var short *dnswire.TruncatedHeaderError
+		if errors.As(err, &short) || query.Header.QR {
+			return nil, nil
+		}
+		return nil, &dnswire.Message{
+			Header: dnswire.Header{
+				ID:     query.Header.ID,
+				QR:     true,
+				Opcode: query.Header.Opcode,
+				Rcode:  dnswire.RcodeFormErr,
+			},
+		}
+	}
+
+	// Never answer an answer - that's how reflection loops start
+	if query.Header.QR {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.queryTimeout())
+	defer cancel()
+
+	return &query, s.Handler.ServeDNS(ctx, client, &query)
+}
+
+// busy is the SERVFAIL for a query there's no room to handle right now.
+func busy(packet []byte) *dnswire.Message {
+	query, err := dnswire.DecodeMessage(packet)
+	if err != nil || query.Header.QR {
+		return nil
+	}
+	return &dnswire.Message{
+		Header: dnswire.Header{
+			ID:     query.Header.ID,
+			QR:     true,
+			Opcode: query.Header.Opcode,
+			RD:     query.Header.RD,
+			Rcode:  dnswire.RcodeServFail,
+		},
+		Questions: query.Questions,
+	}
+}
+
+// encodeForUDP encodes reply, and if it's bigger than the client said it
+// can take (512 bytes without EDNS), sends just the header, question and
+// OPT with TC set so the client retries over TCP.
+func encodeForUDP(query, reply *dnswire.Message) ([]byte, error) {
+
+	b, err := dnswire.EncodeMessage(reply)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := minUDPSize
+	if query != nil && query.OPT != nil {
+		limit = max(limit, int(query.OPT.UDPSize))
+	}
+	if len(b) <= limit {
+		return b, nil
+	}
+
+	truncated := &dnswire.Message{
+		Header:    reply.Header,
+		Questions: reply.Questions,
+		OPT:       reply.OPT,
+	}
+	truncated.Header.TC = true
+
+	return dnswire.EncodeMessage(truncated)
+}
+
+func (s *Server) queryTimeout() time.Duration {
+	if s.QueryTimeout > 0 {
+		return s.QueryTimeout
+	}
+	return DefaultQueryTimeout
+}
+
+func (s *Server) idleTimeout() time.Duration {
+	if s.IdleTimeout > 0 {
+		return s.IdleTimeout
+	}
+	return DefaultIdleTimeout
+}
+
+func (s *Server) maxConcurrent() int {
+	if s.MaxConcurrent > 0 {
+		return s.MaxConcurrent
+	}
+	return DefaultMaxConcurrent
+}
+
+func (s *Server) maxTCPConns() int {
+	if s.MaxTCPConns > 0 {
+		return s.MaxTCPConns
+	}
+	return DefaultMaxTCPConns
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
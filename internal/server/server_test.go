@@ -0,0 +1,281 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"dnstom/internal/dnswire"
+	"dnstom/internal/rr"
+)
+
+// startServer serves h on a random loopback port (UDP and TCP) for the
+// rest of the test and returns the address.
+func startServer(t *testing.T, h Handler) string {
+	t.Helper()
+	return serve(t, &Server{Handler: h})
+}
+
+// serve is startServer for a Server with more than a Handler set.
+func serve(t *testing.T, srv *Server) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen udp: %v", err)
+	}
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		t.Fatalf("listen tcp: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ctx, pc, l) }()
+
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	})
+
+	return pc.LocalAddr().String()
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("bad hex: %v", err)
+	}
+	return b
+}
+
+func newQuery(name string, qtype uint16) *dnswire.Message {
+	return &dnswire.Message{
+		Header:    dnswire.Header{ID: 0x1234, RD: true},
+		Questions: []dnswire.Question{{Name: name, Type: qtype, Class: dnswire.ClassIN}},
+	}
+}
+
+// exchangeRaw sends b over network ("udp" or "tcp") and returns the reply,
+// or nil if nothing came back within a short wait.
+func exchangeRaw(t *testing.T, network, addr string, b []byte) *dnswire.Message {
+	t.Helper()
+
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(500 * time.Millisecond))
+
+	var resp []byte
+	if network == "tcp" {
+		if err := dnswire.WriteTCPMessage(conn, b); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		resp, err = dnswire.ReadTCPMessage(conn)
+	} else {
+		if _, err := conn.Write(b); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		buf := make([]byte, 65535)
+		var n int
+		n, err = conn.Read(buf)
+		resp = buf[:n]
+	}
+	if err != nil {
+		return nil
+	}
+
+	m, err := dnswire.DecodeMessage(resp)
+	if err != nil {
+		t.Fatalf("decode reply: %v", err)
+	}
+	return &m
+}
+
+func exchange(t *testing.T, network, addr string, q *dnswire.Message) *dnswire.Message {
+	t.Helper()
+	b, err := dnswire.EncodeMessage(q)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	return exchangeRaw(t, network, addr, b)
+}
+
+// txtHandler answers every query with n TXT records of 200 bytes each.
+func txtHandler(t *testing.T, n int) Handler {
+	return HandlerFunc(func(ctx context.Context, client net.Addr, q *dnswire.Message) *dnswire.Message {
+		resp := &dnswire.Message{
+			Header:    dnswire.Header{ID: q.Header.ID, QR: true, RD: q.Header.RD},
+			Questions: q.Questions,
+		}
+		for i := 0; i < n; i++ {
+			rec, err := rr.New(q.Questions[0].Name, 300, &rr.TXT{Strings: []string{strings.Repeat("x", 200)}})
+			if err != nil {
+				t.Errorf("rr.New: %v", err)
+			}
+			resp.Answers = append(resp.Answers, rec)
+		}
+		if q.OPT != nil {
+			resp.OPT = dnswire.NewOPT()
+		}
+		return resp
+	})
+}
+
+func TestServer_UDPAndTCP(t *testing.T) {
+	addr := startServer(t, txtHandler(t, 1))
+
+	for _, network := range []string{"udp", "tcp"} {
+		resp := exchange(t, network, addr, newQuery("www.example.com.", dnswire.TypeTXT))
+		if resp == nil {
+			t.Fatalf("%s: no reply", network)
+		}
+		if resp.Header.ID != 0x1234 || !resp.Header.QR || len(resp.Answers) != 1 {
+			t.Errorf("%s: reply = %+v", network, resp.Header)
+		}
+	}
+}
+
+func TestServer_TCPConnectionReuse(t *testing.T) {
+	addr := startServer(t, txtHandler(t, 1))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	for id := uint16(1); id <= 3; id++ {
+		q := newQuery("www.example.com.", dnswire.TypeTXT)
+		q.Header.ID = id
+		b, _ := dnswire.EncodeMessage(q)
+		if err := dnswire.WriteTCPMessage(conn, b); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := dnswire.ReadTCPMessage(conn)
+		if err != nil {
+			t.Fatalf("query %d: %v", id, err)
+		}
+		m, _ := dnswire.DecodeMessage(resp)
+		if m.Header.ID != id {
+			t.Fatalf("query %d: reply ID %d", id, m.Header.ID)
+		}
+	}
+}
+
+func TestServer_Truncation(t *testing.T) {
+	addr := startServer(t, txtHandler(t, 4)) // ~850 bytes
+
+	// No EDNS: 512 bytes is all the client can take
+	resp := exchange(t, "udp", addr, newQuery("big.example.com.", dnswire.TypeTXT))
+	if resp == nil || !resp.Header.TC || len(resp.Answers) != 0 || len(resp.Questions) != 1 {
+		t.Fatalf("UDP without EDNS = %+v, want TC with the question and nothing else", resp)
+	}
+
+	// With EDNS it fits
+	q := newQuery("big.example.com.", dnswire.TypeTXT)
+	q.OPT = dnswire.NewOPT()
+	if resp := exchange(t, "udp", addr, q); resp == nil || resp.Header.TC || len(resp.Answers) != 4 {
+		t.Fatalf("UDP with EDNS = %+v, want all 4 answers", resp)
+	}
+
+	// TCP has no limit
+	if resp := exchange(t, "tcp", addr, newQuery("big.example.com.", dnswire.TypeTXT)); resp == nil || resp.Header.TC || len(resp.Answers) != 4 {
+		t.Fatalf("TCP = %+v, want all 4 answers", resp)
+	}
+}
+
+func TestServer_BadQueries(t *testing.T) {
+	addr := startServer(t, txtHandler(t, 1))
+
+	// Header fine, question runs off the end
+	resp := exchangeRaw(t, "udp", addr, mustHex(t, "abcd0100000100000000000003777777"))
+	if resp == nil || resp.Header.ID != 0xabcd || resp.Header.Rcode != dnswire.RcodeFormErr {
+		t.Fatalf("truncated question: reply = %+v, want FORMERR", resp)
+	}
+
+	// Not even a header, or a response rather than a query: silence
+	if resp := exchangeRaw(t, "udp", addr, []byte{0xab, 0xcd, 0x01}); resp != nil {
+		t.Errorf("short packet got a reply: %+v", resp.Header)
+	}
+	q := newQuery("www.example.com.", dnswire.TypeA)
+	q.Header.QR = true
+	if resp := exchange(t, "udp", addr, q); resp != nil {
+		t.Errorf("response got a reply: %+v", resp.Header)
+	}
+}
+
+func TestServer_Limits(t *testing.T) {
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	h := txtHandler(t, 1)
+	addr := serve(t, &Server{
+		Handler: HandlerFunc(func(ctx context.Context, client net.Addr, q *dnswire.Message) *dnswire.Message {
+			started <- struct{}{}
+			<-release
+			return h.ServeDNS(ctx, client, q)
+		}),
+		MaxConcurrent: 1,
+		MaxTCPConns:   1,
+	})
+	t.Cleanup(func() { close(release) })
+
+	q := newQuery("www.example.com.", dnswire.TypeA)
+	b, err := dnswire.EncodeMessage(q)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	// One query in the handler uses up MaxConcurrent
+	udp, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer udp.Close()
+	if _, err := udp.Write(b); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	<-started
+
+	if resp := exchange(t, "udp", addr, q); resp != nil {
+		t.Errorf("UDP query over the limit got a reply: %+v", resp.Header)
+	}
+
+	// The first TCP connection is the only one allowed...
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	if resp := exchange(t, "tcp", addr, q); resp != nil {
+		t.Errorf("second TCP connection got a reply: %+v", resp.Header)
+	}
+
+	// ... and a query on it gets a SERVFAIL while the handler's busy
+	if err := dnswire.WriteTCPMessage(conn, b); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	packet, err := dnswire.ReadTCPMessage(conn)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	resp, err := dnswire.DecodeMessage(packet)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Header.ID != q.Header.ID || resp.Header.Rcode != dnswire.RcodeServFail {
+		t.Errorf("busy TCP reply = %+v, want SERVFAIL", resp.Header)
+	}
+}
@@ -0,0 +1,215 @@
+package zone
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Breaking a master file up into entries (RFC 1035 5.1). An entry is
+// usually one line, but parentheses carry it on over line ends:
+//
+//	@  IN SOA ns1 hostmaster (   <- one entry, starting on this line
+//	          2024010101 ; serial
+//	          2h 1h 2w 5m )
+//
+// Tokens are separated by white space. ";" starts a comment, "..." is one
+// token however many spaces are in it, and a backslash escapes the next
+// character ("\;", "\ ") or, followed by three digits, is that byte ("\032").
+//
+// Names are dotted strings all the way through dnstom, with a dot inside a
+// label written "\." (see dnswire's name.go). So that one escape - and "\\",
+// so a backslash before a dot can't be mistaken for it - is kept as it is
+// in the token's text, and everything else is resolved. Whatever takes a
+// token as something other than a name resolves those two as well (with
+// plain).
+
+// token is one field of an entry.
+type token struct {
+	text   string // escapes resolved (bar "\." and "\\"), quotes taken off
+	raw    string // as written (but without the quotes)
+	quoted bool
+}
+
+// entry is one record or directive.
+type entry struct {
+	tokens []token
+	line   int  // where it started
+	blank  bool // started with white space, so it has no owner name
+}
+
+type lexer struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newLexer(r io.Reader) *lexer {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	return &lexer{scanner: s}
+}
+
+// next returns the next entry, or nil at the end of the input. Errors are
+// for l.line.
+func (l *lexer) next() (*entry, error) {
+
+	var e *entry
+	depth := 0
+
+	for l.scanner.Scan() {
+		l.line++
+		text := l.scanner.Text()
+		if e == nil {
+			e = &entry{line: l.line, blank: text != "" && (text[0] == ' ' || text[0] == '\t')}
+		}
+
+		var err error
+		if depth, err = split(e, text, depth); err != nil {
+			return nil, err
+		}
+
+		if depth == 0 {
+			if len(e.tokens) > 0 {
+				return e, nil
+			}
+			e = nil // nothing but space and comments
+		}
+	}
+	if err := l.scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if e != nil {
+		return nil, fmt.Errorf("missing \")\" for the \"(\" on line %d", e.line)
+	}
+	return nil, nil
+}
+
+// split adds the tokens on one line to e. depth is how many parentheses are
+// open; it returns how many are open at the end of the line.
+func split(e *entry, line string, depth int) (int, error) {
+
+	for i := 0; i < len(line); {
+		switch c := line[i]; c {
+		case ' ', '\t', '\r':
+			i++
+
+		case ';':
+			return depth, nil
+
+		case '(':
+			depth++
+			i++
+
+		case ')':
+			if depth == 0 {
+				return 0, errors.New("\")\" without a \"(\"")
+			}
+			depth--
+			i++
+
+		case '"':
+			end, err := scanToken(line, i+1, true)
+			if err != nil {
+				return 0, err
+			}
+			if end == len(line) {
+				return 0, errors.New("unterminated quoted string")
+			}
+			raw := line[i+1 : end]
+			text, err := unescape(raw)
+			if err != nil {
+				return 0, err
+			}
+			e.tokens = append(e.tokens, token{text: text, raw: raw, quoted: true})
+			i = end + 1
+
+		default:
+			end, err := scanToken(line, i, false)
+			if err != nil {
+				return 0, err
+			}
+			raw := line[i:end]
+			text, err := unescape(raw)
+			if err != nil {
+				return 0, err
+			}
+			e.tokens = append(e.tokens, token{text: text, raw: raw})
+			i = end
+		}
+	}
+	return depth, nil
+}
+
+// scanToken returns where the token starting at line[start] ends: the
+// closing quote for a quoted one, otherwise the first unescaped space or
+// special character.
+func scanToken(line string, start int, quoted bool) (int, error) {
+	i := start
+	for i < len(line) {
+		c := line[i]
+		switch {
+		case c == '\\':
+			if i+1 == len(line) {
+				return 0, errors.New("\\ at the end of a line")
+			}
+			i += 2
+			continue
+		case quoted && c == '"':
+			return i, nil
+		case !quoted && strings.IndexByte(" \t\r;()\"", c) >= 0:
+			return i, nil
+		}
+		i++
+	}
+	return i, nil
+}
+
+// unescape resolves "\X" and "\DDD", apart from a dot or backslash, which
+// come out as "\." and "\\" whichever way they were written (see the top of
+// the file).
+func unescape(s string) (string, error) {
+
+	if strings.IndexByte(s, '\\') < 0 {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		if i+1 == len(s) {
+			return "", errors.New("\\ at the end of a token")
+		}
+
+		i++
+		c = s[i]
+		if isDigit(c) {
+			if i+3 > len(s) || !isDigit(s[i+1]) || !isDigit(s[i+2]) {
+				return "", fmt.Errorf("bad escape in %q: want \\ and three digits", s)
+			}
+			v := int(c-'0')*100 + int(s[i+1]-'0')*10 + int(s[i+2]-'0')
+			if v > 255 {
+				return "", fmt.Errorf("bad escape in %q: \\%s is more than 255", s, s[i:i+3])
+			}
+			c = byte(v)
+			i += 2
+		}
+		if c == '.' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	return b.String(), nil
+}
+
+// plain resolves the escapes unescape leaves in, for tokens that aren't
+// names.
+var plain = strings.NewReplacer(`\\`, `\`, `\.`, `.`)
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
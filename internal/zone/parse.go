@@ -0,0 +1,481 @@
+package zone
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"dnstom/internal/dnswire"
+	"dnstom/internal/rr"
+)
+
+// Master files (RFC 1035 5), the format zones are written in:
+//
+//	$ORIGIN example.com.
+//	$TTL 1h
+//	@        IN SOA ns1 hostmaster ( 2024010101 2h 1h 2w 5m )
+//	         IN NS  ns1                 ; no owner: same as the line above
+//	ns1         A   192.0.2.53
+//	www   300 IN A  192.0.2.80
+//	$GENERATE 1-4 host$ A 192.0.2.$     ; host1 to host4
+//
+// Each record is [owner] [TTL] [class] type rdata, with the TTL and class
+// in either order and both optional:
+//
+//   - no owner (the line starts with white space) means the previous
+//     record's owner
+//   - no class means the previous record's class, IN to start with
+//   - no TTL means $TTL if there's been one, otherwise the last TTL that was
+//     given explicitly (RFC 2308 4), otherwise - for the SOA itself - its
+//     MINIMUM
+//
+// TTLs can have units (1h30m, 2d, 1w). Names without a trailing dot are
+// relative to the current origin and "@" is the origin.
+//
+// Directives:
+//
+//	$ORIGIN name              names after this are relative to name
+//	$TTL ttl                  the default TTL
+//	$INCLUDE file [origin]    read file here, optionally with another origin;
+//	                          the origin goes back to what it was afterwards
+//	$GENERATE range lhs [ttl] [class] type rhs
+//
+// $INCLUDE paths are relative to the file doing the including. $GENERATE is
+// BIND's: range is start-stop[/step], and in lhs and rhs "$" is the counter
+// and "${offset,width,base}" is the counter plus offset, zero padded to
+// width, in base d, o, x or X. "\$" is a literal dollar.
+
+// maxInclude is how deep $INCLUDEs can nest, so a file including itself
+// fails rather than running out of file descriptors.
+const maxInclude = 8
+
+// maxGenerate is the most records one $GENERATE can make.
+const maxGenerate = 65536
+
+// ParseError is a problem with a particular line of a zone file.
+type ParseError struct {
+	File string // "" when reading from an io.Reader
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// Parse reads a zone file from r. origin is where relative names start from
+// until a $ORIGIN says otherwise. $INCLUDE paths are relative to the current
+// directory.
+func Parse(r io.Reader, origin string) ([]dnswire.ResourceRecord, error) {
+	p := newParser(origin)
+	if err := p.parse(r, ""); err != nil {
+		return nil, err
+	}
+	return p.records, nil
+}
+
+// ParseFile reads the zone file at path (see Parse).
+func ParseFile(path, origin string) ([]dnswire.ResourceRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p := newParser(origin)
+	if err := p.parse(f, path); err != nil {
+		return nil, err
+	}
+	return p.records, nil
+}
+
+// ParseRR parses a single record in presentation format, the way
+// ResourceRecord.String writes it:
+//
+//	www.example.com.	3600	IN	A	192.0.2.1
+//
+// The owner and TTL have to be there; the class defaults to IN. Relative
+// names are taken as relative to the root. Directives aren't allowed - it's
+// one record, not a zone file.
+func ParseRR(s string) (dnswire.ResourceRecord, error) {
+
+	lx := newLexer(strings.NewReader(strings.TrimLeft(s, " \t")))
+	e, err := lx.next()
+	switch {
+	case err != nil:
+		return dnswire.ResourceRecord{}, &ParseError{Line: lx.line, Err: err}
+	case e == nil:
+		return dnswire.ResourceRecord{}, errors.New("zone: no record")
+	case !e.tokens[0].quoted && strings.HasPrefix(e.tokens[0].raw, "$"):
+		return dnswire.ResourceRecord{}, fmt.Errorf("zone: %s: want a record, not a directive", e.tokens[0].text)
+	}
+
+	p := newParser(".")
+	if err := p.entry(e, ""); err != nil {
+		return dnswire.ResourceRecord{}, &ParseError{Line: e.line, Err: err}
+	}
+
+	if more, err := lx.next(); more != nil || err != nil {
+		return dnswire.ResourceRecord{}, errors.New("zone: more than one record")
+	}
+	return p.records[0], nil
+}
+
+// parser is the state that carries on from one record to the next.
+type parser struct {
+	origin string
+	owner  string // previous record's
+	class  uint16 // previous record's
+
+	defaultTTL uint32 // from $TTL
+	hasDefault bool
+	lastTTL    uint32 // the last one given explicitly
+	hasLast    bool
+
+	includes int // how deep in $INCLUDEs we are
+	records  []dnswire.ResourceRecord
+}
+
+func newParser(origin string) *parser {
+	return &parser{origin: rr.AbsoluteName(origin, "."), class: dnswire.ClassIN}
+}
+
+func (p *parser) parse(r io.Reader, file string) error {
+
+	lx := newLexer(r)
+	for {
+		e, err := lx.next()
+		if err != nil {
+			return &ParseError{File: file, Line: lx.line, Err: err}
+		}
+		if e == nil {
+			return nil
+		}
+
+		if err := p.entry(e, file); err != nil {
+			// Errors from inside an $INCLUDE already say where they are
+			var pe *ParseError
+			if errors.As(err, &pe) {
+				return err
+			}
+			return &ParseError{File: file, Line: e.line, Err: err}
+		}
+	}
+}
+
+func (p *parser) entry(e *entry, file string) error {
+
+	first := e.tokens[0]
+	if !e.blank && !first.quoted && strings.HasPrefix(first.raw, "$") {
+		return p.directive(strings.ToUpper(first.text), e.tokens[1:], file)
+	}
+
+	if e.blank {
+		if p.owner == "" {
+			return errors.New("no owner name, and no record before this one to take it from")
+		}
+		return p.record(p.owner, e.tokens)
+	}
+
+	owner, err := p.name(first.text)
+	if err != nil {
+		return err
+	}
+	return p.record(owner, e.tokens[1:])
+}
+
+// record parses everything after the owner name.
+func (p *parser) record(owner string, tokens []token) error {
+
+	var ttl uint32
+	hasTTL, hasClass := false, false
+	class := p.class
+
+	for len(tokens) > 0 && !(hasTTL && hasClass) {
+		t := tokens[0].text
+		if !hasTTL && t != "" && isDigit(t[0]) {
+			v, err := rr.ParseTTL(t)
+			if err != nil {
+				return err
+			}
+			ttl, hasTTL = v, true
+			tokens = tokens[1:]
+			continue
+		}
+		// "ANY" and friends are types as well as classes - they're only a
+		// class if they can't be the type
+		if _, isType := dnswire.TypeFromString(t); !hasClass && !isType {
+			if c, ok := dnswire.ClassFromString(t); ok {
+				class, hasClass = c, true
+				tokens = tokens[1:]
+				continue
+			}
+		}
+		break
+	}
+
+	if len(tokens) == 0 {
+		return errors.New("no type")
+	}
+	rrtype, ok := dnswire.TypeFromString(tokens[0].text)
+	if !ok {
+		return fmt.Errorf("unknown type %q", tokens[0].text)
+	}
+
+	fields := make([]string, len(tokens)-1)
+	for i, t := range tokens[1:] {
+		fields[i] = t.text
+		if !t.quoted && t.raw == `\#` {
+			fields[i] = t.raw // RFC 3597 generic RDATA, see rr.ParseRData
+		}
+	}
+	d, err := rr.ParseRData(rrtype, fields, p.origin)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case hasTTL:
+		p.lastTTL, p.hasLast = ttl, true
+	case p.hasDefault:
+		ttl = p.defaultTTL
+	case p.hasLast:
+		ttl = p.lastTTL
+	case rrtype == dnswire.TypeSOA:
+		if soa, ok := d.(*rr.SOA); ok {
+			ttl = soa.Minimum
+			p.lastTTL, p.hasLast = ttl, true
+			break
+		}
+		fallthrough
+	default:
+		return errors.New("no TTL, and no $TTL or earlier TTL to use instead")
+	}
+
+	record, err := rr.New(owner, ttl, d)
+	if err != nil {
+		return err
+	}
+	record.Class = class
+
+	p.records = append(p.records, record)
+	p.owner, p.class = owner, class
+	return nil
+}
+
+func (p *parser) directive(name string, args []token, file string) error {
+
+	switch name {
+	case "$ORIGIN":
+		if len(args) != 1 {
+			return errors.New("$ORIGIN wants one name")
+		}
+		origin, err := p.name(args[0].text)
+		if err != nil {
+			return err
+		}
+		p.origin = origin
+
+	case "$TTL":
+		if len(args) != 1 {
+			return errors.New("$TTL wants one TTL")
+		}
+		ttl, err := rr.ParseTTL(args[0].text)
+		if err != nil {
+			return err
+		}
+		p.defaultTTL, p.hasDefault = ttl, true
+
+	case "$INCLUDE":
+		if len(args) < 1 || len(args) > 2 {
+			return errors.New("$INCLUDE wants a file name and maybe an origin")
+		}
+		return p.include(args, file)
+
+	case "$GENERATE":
+		return p.generate(args)
+
+	default:
+		return fmt.Errorf("unknown directive %s", name)
+	}
+	return nil
+}
+
+func (p *parser) include(args []token, file string) error {
+
+	if p.includes >= maxInclude {
+		return fmt.Errorf("$INCLUDEs nested more than %d deep", maxInclude)
+	}
+
+	path := plain.Replace(args[0].text)
+	if !filepath.IsAbs(path) && file != "" {
+		path = filepath.Join(filepath.Dir(file), path)
+	}
+
+	saved := p.origin
+	defer func() { p.origin = saved }()
+	if len(args) == 2 {
+		origin, err := p.name(args[1].text)
+		if err != nil {
+			return err
+		}
+		p.origin = origin
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	p.includes++
+	defer func() { p.includes-- }()
+	return p.parse(f, path)
+}
+
+// generate is $GENERATE range lhs [ttl] [class] type rhs.
+func (p *parser) generate(args []token) error {
+
+	if len(args) < 4 {
+		return errors.New("$GENERATE wants a range, owner, type and rdata")
+	}
+	start, stop, step, err := parseRange(args[0].text)
+	if err != nil {
+		return err
+	}
+
+	lhs, middle, rhs := args[1], args[2:len(args)-1], args[len(args)-1]
+	for i := start; i <= stop; i += step {
+		owner, err := expand(lhs.raw, i)
+		if err != nil {
+			return err
+		}
+		if owner, err = p.name(owner); err != nil {
+			return err
+		}
+		rdata, err := expand(rhs.raw, i)
+		if err != nil {
+			return err
+		}
+
+		tokens := append(append([]token(nil), middle...), token{text: rdata, raw: rdata, quoted: rhs.quoted})
+		if err := p.record(owner, tokens); err != nil {
+			return fmt.Errorf("$GENERATE %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// parseRange parses a $GENERATE range, "start-stop" or "start-stop/step".
+func parseRange(s string) (start, stop, step int, err error) {
+
+	bad := fmt.Errorf("bad $GENERATE range %q, want start-stop[/step]", s)
+
+	r, stepText, hasStep := strings.Cut(s, "/")
+	startText, stopText, ok := strings.Cut(r, "-")
+	if !ok {
+		return 0, 0, 0, bad
+	}
+	step = 1
+	if start, err = strconv.Atoi(startText); err != nil || start < 0 {
+		return 0, 0, 0, bad
+	}
+	if stop, err = strconv.Atoi(stopText); err != nil || stop < start {
+		return 0, 0, 0, bad
+	}
+	if hasStep {
+		if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+			return 0, 0, 0, bad
+		}
+	}
+	if (stop-start)/step >= maxGenerate {
+		return 0, 0, 0, fmt.Errorf("$GENERATE range %q makes more than %d records", s, maxGenerate)
+	}
+	return start, stop, step, nil
+}
+
+// expand fills in the counter in a $GENERATE template (as written, escapes
+// and all) and then resolves the escapes.
+func expand(template string, n int) (string, error) {
+
+	var b strings.Builder
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		switch {
+		case c == '\\' && i+1 < len(template):
+			// Leave escapes for unescape, but step over the escaped
+			// character so "\$" isn't taken as the counter
+			b.WriteString(template[i : i+2])
+			i++
+
+		case c == '$' && strings.HasPrefix(template[i+1:], "{"):
+			end := strings.IndexByte(template[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("missing } in %q", template)
+			}
+			s, err := modified(template[i+2:i+end], n)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(s)
+			i += end
+
+		case c == '$':
+			b.WriteString(strconv.Itoa(n))
+
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return unescape(b.String())
+}
+
+// modified does "${offset,width,base}" - any of them can be left off from
+// the end.
+func modified(spec string, n int) (string, error) {
+
+	parts := strings.Split(spec, ",")
+	if len(parts) > 3 {
+		return "", fmt.Errorf("bad $GENERATE modifier {%s}", spec)
+	}
+
+	offset, width, base := 0, 0, "d"
+	var err error
+	if parts[0] != "" {
+		if offset, err = strconv.Atoi(parts[0]); err != nil {
+			return "", fmt.Errorf("bad $GENERATE offset in {%s}", spec)
+		}
+	}
+	if len(parts) > 1 {
+		if width, err = strconv.Atoi(parts[1]); err != nil || width < 0 || width > 255 {
+			return "", fmt.Errorf("bad $GENERATE width in {%s}", spec)
+		}
+	}
+	if len(parts) > 2 {
+		base = parts[2]
+	}
+
+	verb := map[string]string{"d": "d", "o": "o", "x": "x", "X": "X"}[base]
+	if verb == "" {
+		return "", fmt.Errorf("bad $GENERATE base %q in {%s}, want d, o, x or X", base, spec)
+	}
+	return fmt.Sprintf("%0*"+verb, width, n+offset), nil
+}
+
+// name makes a name from a zone file absolute and checks it's a name.
+func (p *parser) name(s string) (string, error) {
+	name := rr.AbsoluteName(s, p.origin)
+	if _, err := dnswire.EncodeName(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
@@ -0,0 +1,283 @@
+package zone
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dnstom/internal/dnswire"
+	"dnstom/internal/rr"
+)
+
+// show renders records as "name ttl class type rdata" lines, to compare
+// against.
+func show(records []dnswire.ResourceRecord) []string {
+	out := make([]string, len(records))
+	for i, r := range records {
+		out[i] = fmt.Sprintf("%s %d %s %s %s", r.Name, r.TTL, dnswire.ClassToString(r.Class),
+			dnswire.TypeToString(r.Type), dnswire.FormatRData(r.Type, r.RData, ""))
+	}
+	return out
+}
+
+func checkRecords(t *testing.T, got []dnswire.ResourceRecord, want []string) {
+	t.Helper()
+	lines := show(got)
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	records, err := ParseFile("testdata/example.com.zone", "example.org.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkRecords(t, records, []string{
+		"example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 2024010101 7200 3600 1209600 300",
+		"example.com. 3600 IN NS ns1.example.com.",
+		"example.com. 3600 IN NS ns.example.net.",
+		"example.com. 3600 IN MX 10 mail.example.com.",
+		"ns1.example.com. 3600 IN A 192.0.2.53",
+		"mail.example.com. 300 IN A 192.0.2.25",
+		"www.example.com. 300 IN A 192.0.2.80",
+		"www.example.com. 3600 IN AAAA 2001:db8::80",
+		`www.example.com. 3600 IN TXT "hello world" "semi;colon" "quote\"d" "ABC"`,
+		"_sip._udp.example.com. 3600 IN SRV 10 60 5060 www.example.com.",
+		`example.com. 3600 IN CAA 0 issue "letsencrypt.org"`,
+		"secure.example.com. 3600 IN DS 20326 8 2 E06D44B80C8F1D39",
+		`old.example.com. 3600 IN TYPE65534 \# 3 010203`,
+		"host1.example.com. 3600 IN A 192.0.2.101",
+		"host2.example.com. 3600 IN A 192.0.2.102",
+		"host3.example.com. 3600 IN A 192.0.2.103",
+		`000.gen.example.com. 3600 IN CNAME host\$.example.com.`,
+		`002.gen.example.com. 3600 IN CNAME host\$.example.com.`,
+		"sub.example.com. 3600 IN A 192.0.2.10",
+		"a.sub.example.com. 3600 IN A 192.0.2.11",
+		"after.example.com. 3600 IN A 192.0.2.200",
+	})
+}
+
+func TestParse_TTLs(t *testing.T) {
+	tests := []struct {
+		name string
+		zone string
+		want []string
+	}{
+		{
+			"no $TTL: the SOA's minimum, then the last explicit one",
+			"@ IN SOA ns1 hostmaster 1 2 3 4 300\n" +
+				"a A 192.0.2.1\n" +
+				"b 60 A 192.0.2.2\n" +
+				"c A 192.0.2.3\n",
+			[]string{
+				"example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 1 2 3 4 300",
+				"a.example.com. 300 IN A 192.0.2.1",
+				"b.example.com. 60 IN A 192.0.2.2",
+				"c.example.com. 60 IN A 192.0.2.3",
+			},
+		},
+		{
+			"$TTL beats the last explicit one",
+			"a 60 A 192.0.2.1\n" +
+				"$TTL 1d\n" +
+				"b A 192.0.2.2\n",
+			[]string{
+				"a.example.com. 60 IN A 192.0.2.1",
+				"b.example.com. 86400 IN A 192.0.2.2",
+			},
+		},
+		{
+			"TTL and class either way round, class carries on",
+			"a IN 60 A 192.0.2.1\n" +
+				"b 60 CH TXT x\n" +
+				"c 60 TXT y\n",
+			[]string{
+				"a.example.com. 60 IN A 192.0.2.1",
+				"b.example.com. 60 CH TXT \"x\"",
+				"c.example.com. 60 CH TXT \"y\"",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		records, err := Parse(strings.NewReader(tt.zone), "example.com.")
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		t.Run(tt.name, func(t *testing.T) { checkRecords(t, records, tt.want) })
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		zone string
+		want string
+	}{
+		{"$TTL 1h\nwww A\n", "line 2: rr: A rdata: too few fields"},
+		{"$TTL 1h\nwww IN BOGUS x\n", `line 2: unknown type "BOGUS"`},
+		{"$TTL 1h\nwww TXT \"unterminated\n", "line 2: unterminated"},
+		{"$TTL 1h\nwww A 192.0.2.1 )\n", "without a"},
+		{"$TTL 1h\nwww A ( 192.0.2.1\n\n", "line 3: missing \")\" for the \"(\" on line 2"},
+		{"www A 192.0.2.1\n", "no TTL"},
+		{"$TTL 1h\n  A 192.0.2.1\n", "no owner"},
+		{"$TTL 1x\n", "bad TTL"},
+		{"$TTL 1h\nw\\99 A 192.0.2.1\n", "three digits"},
+		{"$TTL 1h\nw\\999 A 192.0.2.1\n", "more than 255"},
+		{"$BOGUS x\n", "unknown directive $BOGUS"},
+		{"$ORIGIN\n", "$ORIGIN wants one name"},
+		{"$TTL 1h\n$GENERATE 5-1 h$ A 192.0.2.$\n", "bad $GENERATE range"},
+		{"$TTL 1h\n$GENERATE 1-3 h$ A 192.0.2.${1,2,q}\n", "bad $GENERATE base"},
+		{"$TTL 1h\n$GENERATE 0-70000 h$ A 192.0.2.1\n", "more than 65536"},
+		{"$TTL 1h\n$GENERATE 254-256 h$ A 192.0.2.$\n", "$GENERATE 256: rr: A rdata"},
+		{"$INCLUDE testdata/nonexistent\n", "line 1: open testdata/nonexistent"},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(strings.NewReader(tt.zone), "example.com.")
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) error = %v, want one mentioning %q", tt.zone, err, tt.want)
+		}
+		var pe *ParseError
+		if err != nil && !errors.As(err, &pe) {
+			t.Errorf("Parse(%q) error %T isn't a *ParseError", tt.zone, err)
+		}
+	}
+}
+
+// A dot inside a label, however it's written, makes it to the wire as one
+// label - and comes back out the way it went in.
+func TestParse_EscapedDot(t *testing.T) {
+	zone := "$TTL 1h\n" +
+		"w\\.w        A     192.0.2.1\n" +
+		"x\\046y      CNAME w\\.w\n" +
+		"back\\\\slash TXT   \"a\\.b\" c\\\\d\n"
+
+	records, err := Parse(strings.NewReader(zone), "example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRecords(t, records, []string{
+		`w\.w.example.com. 3600 IN A 192.0.2.1`,
+		`x\.y.example.com. 3600 IN CNAME w\.w.example.com.`,
+		`back\\slash.example.com. 3600 IN TXT "a.b" "c\\d"`,
+	})
+
+	wire, err := dnswire.EncodeName(records[0].Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\x03w.w\x07example\x03com\x00"; string(wire) != want {
+		t.Errorf("EncodeName(%q) = %q, want %q", records[0].Name, wire, want)
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, records, WriteOptions{Origin: "example.com.", Relative: true}); err != nil {
+		t.Fatal(err)
+	}
+	again, err := Parse(&buf, ".")
+	if err != nil {
+		t.Fatalf("reading back: %v", err)
+	}
+	checkRecords(t, again, show(records))
+}
+
+func TestParseFile_IncludeErrors(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	// An error inside an included file says which file and line
+	write("bad.include", "$TTL 1h\nok A 192.0.2.1\nbad A 192.0.2.x\n")
+	path := write("main.zone", "; main\n$INCLUDE bad.include\n")
+	_, err := ParseFile(path, "example.com.")
+	if want := filepath.Join(dir, "bad.include") + ":3:"; err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("error = %v, want one starting %q", err, want)
+	}
+
+	// A file including itself stops eventually
+	path = write("loop.zone", "$INCLUDE loop.zone\n")
+	if _, err := ParseFile(path, "example.com."); err == nil || !strings.Contains(err.Error(), "nested more than") {
+		t.Errorf("error = %v, want an $INCLUDE depth error", err)
+	}
+}
+
+// Every typed RDATA comes back the same from its String form, which checks
+// dnswire's formatter and rr's parser agree with each other.
+func TestParseRR_RoundTrip(t *testing.T) {
+	all := []rr.RData{
+		&rr.A{Address: net.ParseIP("192.0.2.1").To4()},
+		&rr.AAAA{Address: net.ParseIP("2001:db8::1")},
+		&rr.AAAA{Address: net.ParseIP("::ffff:192.0.2.1")},
+		&rr.NS{Host: "ns1.example.com."},
+		&rr.CNAME{Target: "www.example.com."},
+		&rr.PTR{Target: "host.example.com."},
+		&rr.MX{Preference: 10, Exchange: "mail.example.com."},
+		&rr.TXT{Strings: []string{"v=spf1 -all", "", `"quoted" \ ;`, "\x00\xff"}},
+		&rr.SOA{MName: "ns1.example.com.", RName: "hostmaster.example.com.", Serial: 1, Refresh: 2, Retry: 3, Expire: 4, Minimum: 5},
+		&rr.SRV{Priority: 10, Weight: 60, Port: 5060, Target: "sip.example.com."},
+		&rr.CAA{Flags: 128, Tag: "iodef", Value: "mailto:security@example.com"},
+		&rr.DNAME{Target: "example.net."},
+		&rr.DS{KeyTag: 20326, Algorithm: 8, DigestType: 2, Digest: []byte{0xe0, 0x6d, 0x44, 0xb8}},
+		&rr.SSHFP{Algorithm: 4, FPType: 2, Fingerprint: []byte{0xde, 0xad, 0xbe, 0xef}},
+		&rr.TLSA{Usage: 3, Selector: 1, MatchingType: 1, Data: []byte{1, 2, 3}},
+		&rr.HINFO{CPU: "RFC8482", OS: ""},
+		&rr.RP{Mbox: "admin.example.com.", Txt: "info.example.com."},
+		&rr.AFSDB{Subtype: 1, Hostname: "afs.example.com."},
+		&rr.SPF{Strings: []string{"v=spf1 mx -all"}},
+		&rr.NAPTR{Order: 100, Preference: 10, Flags: "U", Services: "E2U+sip", Regexp: "!^.*$!sip:info@example.com!", Replacement: "."},
+		&rr.URI{Priority: 10, Weight: 1, Target: "https://www.example.com/"},
+		&rr.DNSKEY{Flags: 257, Protocol: 3, Algorithm: 13, PublicKey: []byte("not really a key")},
+		&rr.RRSIG{TypeCovered: dnswire.TypeA, Algorithm: 13, Labels: 3, OrigTTL: 3600, Expiration: 1735689600,
+			Inception: 1704067200, KeyTag: 12345, SignerName: "example.com.", Signature: []byte("not really a signature")},
+		&rr.NSEC{NextDomain: "b.example.com.", Types: []uint16{dnswire.TypeA, dnswire.TypeMX, dnswire.TypeRRSIG, dnswire.TypeNSEC, dnswire.TypeCAA}},
+		&rr.Unknown{RRType: 65280, Data: []byte{1, 2, 3}},
+	}
+
+	for _, d := range all {
+		want, err := rr.New("a.example.com.", 300, d)
+		if err != nil {
+			t.Fatalf("rr.New(%T): %v", d, err)
+		}
+
+		got, err := ParseRR(want.String())
+		if err != nil {
+			t.Errorf("ParseRR(%q): %v", want.String(), err)
+			continue
+		}
+		if got.Name != want.Name || got.TTL != want.TTL || got.Type != want.Type || got.Class != want.Class ||
+			!bytes.Equal(got.RData, want.RData) {
+			t.Errorf("ParseRR(%q) = %s", want.String(), got)
+		}
+	}
+}
+
+func TestParseRR_Errors(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"", "no record"},
+		{"$TTL 1h", "not a directive"},
+		{"www.example.com. IN A 192.0.2.1", "no TTL"},
+		{"www.example.com. 300 IN A 192.0.2.1\nwww.example.com. 300 IN A 192.0.2.2", "more than one"},
+		{"www.example.com. 300 IN RRSIG A 13 3 300 2025-01-01 2024 1 example.com. AAAA", "not a time"},
+		{"www.example.com. 300 IN NSEC next.example.com. A BOGUS", `unknown type "BOGUS"`},
+		{"www.example.com. 300 IN DNSKEY 257 3 13 !!!", "not base64"},
+	}
+	for _, tt := range tests {
+		_, err := ParseRR(tt.in)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseRR(%q) error = %v, want one mentioning %q", tt.in, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package zone
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"dnstom/internal/dnswire"
+)
+
+// Writing records back out as a zone file, for normalising zones in review
+// and for exporting ones that came from somewhere else. What comes out can
+// always be read back in with Parse and gives the same records - whatever
+// origin Parse is given, since relative names come with their $ORIGIN.
+//
+// With every option on it looks like:
+//
+//	$ORIGIN example.com.
+//	$TTL 3600
+//	@       IN SOA ns1 hostmaster 2024010101 7200 3600 1209600 300
+//	@       IN NS  ns1
+//	ns1     IN A   192.0.2.53
+//	www 300 IN A   192.0.2.80
+
+// WriteOptions says how Write lays the zone out. The zero value writes one
+// absolute, tab separated record per line, in the order given.
+type WriteOptions struct {
+	// Origin is what Relative makes names relative to, and what the $ORIGIN
+	// header says.
+	Origin string
+
+	// TTL is the $TTL header. Records with this TTL leave theirs out. 0 for
+	// no $TTL. Only used with Headers.
+	TTL uint32
+
+	Headers  bool // start with $ORIGIN and $TTL lines
+	Relative bool // write names at or below Origin relative to it (and $ORIGIN, even without Headers)
+	Align    bool // pad the owner, TTL, class and type out into columns
+	Sort     bool // canonical order (RFC 4034 6.1), with the SOA first
+}
+
+// Write writes records to w as a zone file.
+func Write(w io.Writer, records []dnswire.ResourceRecord, opts WriteOptions) error {
+
+	origin := ""
+	if opts.Origin != "" {
+		origin = absolute(opts.Origin)
+	}
+	if opts.Sort {
+		records = slices.Clone(records)
+		slices.SortStableFunc(records, compareRecords)
+	}
+
+	bw := bufio.NewWriter(w)
+
+	// Relative names mean nothing without the origin they're relative to,
+	// so they get their $ORIGIN headers or not
+	if origin != "" && (opts.Headers || opts.Relative) {
+		fmt.Fprintf(bw, "$ORIGIN %s\n", dnswire.FormatName(origin, ""))
+	}
+	if opts.Headers && opts.TTL != 0 {
+		fmt.Fprintf(bw, "$TTL %d\n", opts.TTL)
+	}
+
+	relativeTo := ""
+	if opts.Relative {
+		relativeTo = origin
+	}
+
+	// owner, TTL, class, type, rdata
+	rows := make([][5]string, len(records))
+	for i, r := range records {
+		ttl := strconv.FormatUint(uint64(r.TTL), 10)
+		if opts.Headers && opts.TTL != 0 && r.TTL == opts.TTL {
+			ttl = ""
+		}
+		rows[i] = [5]string{
+			dnswire.FormatName(r.Name, relativeTo),
+			ttl,
+			dnswire.ClassToString(r.Class),
+			dnswire.TypeToString(r.Type),
+			dnswire.FormatRData(r.Type, r.RData, relativeTo),
+		}
+	}
+
+	var widths [4]int
+	if opts.Align {
+		for _, row := range rows {
+			for c := range widths {
+				widths[c] = max(widths[c], len(row[c]))
+			}
+		}
+	}
+
+	for _, row := range rows {
+		var line strings.Builder
+		for c, field := range row {
+			if c < len(widths) && opts.Align {
+				if widths[c] == 0 {
+					continue // a column that's empty all the way down
+				}
+				fmt.Fprintf(&line, "%-*s ", widths[c], field)
+				continue
+			}
+			if field == "" {
+				continue
+			}
+			line.WriteString(field)
+			if c < len(row)-1 {
+				line.WriteByte('\t')
+			}
+		}
+		bw.WriteString(line.String())
+		bw.WriteByte('\n')
+	}
+
+	return bw.Flush()
+}
+
+// compareRecords is the order Sort puts records in: SOA first, then by owner
+// in canonical order, then by type, class and RDATA.
+func compareRecords(a, b dnswire.ResourceRecord) int {
+
+	if soaA, soaB := a.Type == dnswire.TypeSOA, b.Type == dnswire.TypeSOA; soaA != soaB {
+		if soaA {
+			return -1
+		}
+		return 1
+	}
+	if c := CompareNames(a.Name, b.Name); c != 0 {
+		return c
+	}
+	if a.Type != b.Type {
+		return int(a.Type) - int(b.Type)
+	}
+	if a.Class != b.Class {
+		return int(a.Class) - int(b.Class)
+	}
+	return bytes.Compare(a.RData, b.RData)
+}
+
+// CompareNames orders names canonically (RFC 4034 6.1): by their labels
+// from the right, case-insensitively, so a name sorts straight after its
+// parent and before its parent's next sibling:
+//
+//	example.  a.example.  z.a.example.  b.example.
+func CompareNames(a, b string) int {
+
+	la := dnswire.Labels(a)
+	lb := dnswire.Labels(b)
+	for i := 1; i <= len(la) && i <= len(lb); i++ {
+		x := strings.ToLower(la[len(la)-i])
+		y := strings.ToLower(lb[len(lb)-i])
+		if c := strings.Compare(x, y); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
+
+func absolute(name string) string {
+	if dnswire.IsAbsolute(name) {
+		return name
+	}
+	return name + "."
+}
@@ -0,0 +1,127 @@
+package zone
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const writeZone = `
+$TTL 3600
+www      300 IN A     192.0.2.80
+@            IN NS    ns1
+ns1          IN A     192.0.2.53
+@            IN SOA   ns1 hostmaster 1 7200 3600 1209600 300
+a.www        IN TXT   "x y"
+WWW          IN AAAA  2001:db8::80
+elsewhere.example.net. IN CNAME www
+`
+
+func TestWrite(t *testing.T) {
+	records, err := Parse(strings.NewReader(writeZone), "example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts WriteOptions
+		want string
+	}{
+		{
+			"defaults",
+			WriteOptions{},
+			"www.example.com.\t300\tIN\tA\t192.0.2.80\n" +
+				"example.com.\t3600\tIN\tNS\tns1.example.com.\n" +
+				"ns1.example.com.\t3600\tIN\tA\t192.0.2.53\n" +
+				"example.com.\t3600\tIN\tSOA\tns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300\n" +
+				"a.www.example.com.\t3600\tIN\tTXT\t\"x y\"\n" +
+				"WWW.example.com.\t3600\tIN\tAAAA\t2001:db8::80\n" +
+				"elsewhere.example.net.\t3600\tIN\tCNAME\twww.example.com.\n",
+		},
+		{
+			"everything",
+			WriteOptions{Origin: "example.com", TTL: 3600, Headers: true, Relative: true, Align: true, Sort: true},
+			"$ORIGIN example.com.\n" +
+				"$TTL 3600\n" +
+				"@                          IN SOA   ns1 hostmaster 1 7200 3600 1209600 300\n" +
+				"@                          IN NS    ns1\n" +
+				"ns1                        IN A     192.0.2.53\n" +
+				"www                    300 IN A     192.0.2.80\n" +
+				"WWW                        IN AAAA  2001:db8::80\n" +
+				"a.www                      IN TXT   \"x y\"\n" +
+				"elsewhere.example.net.     IN CNAME www\n",
+		},
+		{
+			"no $TTL means every TTL is written, but relative names still get $ORIGIN",
+			WriteOptions{Origin: "example.com.", TTL: 3600, Relative: true, Sort: true},
+			"$ORIGIN example.com.\n" +
+				"@\t3600\tIN\tSOA\tns1 hostmaster 1 7200 3600 1209600 300\n" +
+				"@\t3600\tIN\tNS\tns1\n" +
+				"ns1\t3600\tIN\tA\t192.0.2.53\n" +
+				"www\t300\tIN\tA\t192.0.2.80\n" +
+				"WWW\t3600\tIN\tAAAA\t2001:db8::80\n" +
+				"a.www\t3600\tIN\tTXT\t\"x y\"\n" +
+				"elsewhere.example.net.\t3600\tIN\tCNAME\twww\n",
+		},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := Write(&buf, records, tt.opts); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, buf.String(), tt.want)
+		}
+	}
+}
+
+// Whatever the options, what Write writes reads back as the same records -
+// without needing to be told the origin.
+func TestWrite_RoundTrip(t *testing.T) {
+	records, err := ParseFile("testdata/example.com.zone", "example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opts := range []WriteOptions{
+		{},
+		{Origin: "example.com.", TTL: 3600, Headers: true, Relative: true, Align: true},
+		{Origin: "example.com.", Relative: true},
+	} {
+		var buf bytes.Buffer
+		if err := Write(&buf, records, opts); err != nil {
+			t.Fatal(err)
+		}
+		again, err := Parse(&buf, ".")
+		if err != nil {
+			t.Errorf("%+v: reading back: %v", opts, err)
+			continue
+		}
+		checkRecords(t, again, show(records))
+	}
+}
+
+func TestCompareNames(t *testing.T) {
+	// RFC 4034 6.1's example, in order
+	names := []string{
+		"example.",
+		"a.example.",
+		"yljkjljk.a.example.",
+		"Z.a.example.",
+		"zABC.a.EXAMPLE.",
+		"z.example.",
+		"\001.z.example.",
+		"*.z.example.",
+		"\200.z.example.",
+	}
+	for i := range names {
+		for j := range names {
+			got := CompareNames(names[i], names[j])
+			if (got < 0) != (i < j) || (got == 0) != (i == j) {
+				t.Errorf("CompareNames(%q, %q) = %d", names[i], names[j], got)
+			}
+		}
+	}
+}